@@ -12,6 +12,7 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/lxc/lxd/lxd/db"
+	"github.com/lxc/lxd/lxd/network"
 	"github.com/lxc/lxd/lxd/operations"
 	projecthelpers "github.com/lxc/lxd/lxd/project"
 	"github.com/lxc/lxd/lxd/response"
@@ -557,6 +558,16 @@ func projectValidateConfig(config map[string]string) error {
 			continue
 		}
 
+		// Network defaults are validated by the network drivers
+		if strings.HasPrefix(key, network.ProjectDefaultsPrefix) {
+			err := network.ValidateProjectDefault(strings.TrimPrefix(key, network.ProjectDefaultsPrefix), v)
+			if err != nil {
+				return errors.Wrapf(err, "Invalid project configuration key %q", k)
+			}
+
+			continue
+		}
+
 		// Then validate
 		validator, ok := projectConfigKeys[key]
 		if !ok {
@@ -7,6 +7,7 @@ import (
 	"io"
 	"os/exec"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/kballard/go-shellquote"
@@ -124,6 +125,26 @@ func (c *Config) MAASController() (string, string) {
 	return url, key
 }
 
+// NetworkAllowUnknownKeys returns whether network config keys that the network's driver doesn't recognise are
+// accepted with a warning rather than rejected.
+func (c *Config) NetworkAllowUnknownKeys() bool {
+	return c.m.GetBool("network.allow_unknown_keys")
+}
+
+// NetworkProtectedNames returns the names of the networks that can't be deleted while the default profile of any
+// project references them.
+func (c *Config) NetworkProtectedNames() []string {
+	names := []string{}
+	for _, name := range strings.Split(c.m.GetString("network.protected_names"), ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
 // OfflineThreshold returns the configured heartbeat threshold, i.e. the
 // number of seconds before after which an unresponsive node is considered
 // offline..
@@ -262,6 +283,8 @@ var ConfigSchema = config.Schema{
 	"images.remote_cache_expiry":     {Type: config.Int64, Default: "10"},
 	"maas.api.key":                   {},
 	"maas.api.url":                   {},
+	"network.allow_unknown_keys":     {Type: config.Bool},
+	"network.protected_names":        {Default: "lxdbr0"},
 	"rbac.agent.url":                 {},
 	"rbac.agent.username":            {},
 	"rbac.agent.private_key":         {},
@@ -70,6 +70,36 @@ var updates = map[int]schema.Update{
 	31: updateFromV30,
 	32: updateFromV31,
 	33: updateFromV32,
+	34: updateFromV33,
+}
+
+// Add networks_snapshots and networks_snapshots_config tables.
+func updateFromV33(tx *sql.Tx) error {
+	stmts := `
+CREATE TABLE networks_snapshots (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    network_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    description TEXT,
+    creation_date DATETIME NOT NULL DEFAULT 0,
+    UNIQUE (network_id, name),
+    FOREIGN KEY (network_id) REFERENCES networks (id) ON DELETE CASCADE
+);
+CREATE TABLE networks_snapshots_config (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    network_snapshot_id INTEGER NOT NULL,
+    key TEXT NOT NULL,
+    value TEXT,
+    UNIQUE (network_snapshot_id, key),
+    FOREIGN KEY (network_snapshot_id) REFERENCES networks_snapshots (id) ON DELETE CASCADE
+);
+`
+	_, err := tx.Exec(stmts)
+	if err != nil {
+		return errors.Wrap(err, "Failed to create network snapshot tables")
+	}
+
+	return nil
 }
 
 // Add type field to networks.
@@ -6,6 +6,7 @@ import (
 	"database/sql"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/lxc/lxd/lxd/db/query"
 	"github.com/lxc/lxd/shared"
@@ -83,6 +84,30 @@ func (c *ClusterTx) GetNetworkID(name string) (int64, error) {
 	}
 }
 
+// GetNetworkConfig returns the config of the network with the given ID as seen by this node, that is the global
+// config keys and the node-specific keys of this node.
+func (c *ClusterTx) GetNetworkConfig(networkID int64) (map[string]string, error) {
+	return query.SelectConfig(c.tx, "networks_config", "network_id=? AND (node_id=? OR node_id IS NULL)", networkID, c.nodeID)
+}
+
+// UpdateNetworkConfig applies the given changes to the config of the network with the given ID as seen by this
+// node. A key with an empty value is removed and keys not included in the changes are left as they are.
+func (c *ClusterTx) UpdateNetworkConfig(networkID int64, changes map[string]string) error {
+	for k := range changes {
+		_, err := c.tx.Exec("DELETE FROM networks_config WHERE network_id=? AND (node_id=? OR node_id IS NULL) AND key=?", networkID, c.nodeID, k)
+		if err != nil {
+			return err
+		}
+	}
+
+	return networkConfigAdd(c.tx, networkID, c.nodeID, changes)
+}
+
+// UpdateNetworkDescription updates the description of the network with the given ID.
+func (c *ClusterTx) UpdateNetworkDescription(networkID int64, description string) error {
+	return updateNetworkDescription(c.tx, networkID, description)
+}
+
 // CreateNetworkConfig adds a new entry in the networks_config table
 func (c *ClusterTx) CreateNetworkConfig(networkID, nodeID int64, config map[string]string) error {
 	return networkConfigAdd(c.tx, networkID, nodeID, config)
@@ -343,16 +368,55 @@ func (c *Cluster) getNetwork(name string, onlyCreated bool) (int64, *api.Network
 		return -1, nil, err
 	}
 
-	config, err := c.getNetworkConfig(id)
+	network, err := c.networkFromRow(id, name, description.String, state, netType)
 	if err != nil {
 		return -1, nil, err
 	}
 
+	return id, network, nil
+}
+
+// GetNetworkInAnyStateByID returns the name and details of the network with the given ID.
+//
+// The network can be in any state.
+func (c *Cluster) GetNetworkInAnyStateByID(id int64) (string, *api.Network, error) {
+	description := sql.NullString{}
+	name := ""
+	state := 0
+	var netType NetworkType
+
+	q := "SELECT name, description, state, type FROM networks WHERE id=?"
+	arg1 := []interface{}{id}
+	arg2 := []interface{}{&name, &description, &state, &netType}
+	err := dbQueryRowScan(c, q, arg1, arg2)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil, ErrNoSuchObject
+		}
+
+		return "", nil, err
+	}
+
+	network, err := c.networkFromRow(id, name, description.String, state, netType)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return name, network, nil
+}
+
+// Build the API representation of a network from its row in the networks table.
+func (c *Cluster) networkFromRow(id int64, name string, description string, state int, netType NetworkType) (*api.Network, error) {
+	config, err := c.getNetworkConfig(id)
+	if err != nil {
+		return nil, err
+	}
+
 	network := api.Network{
 		Name:    name,
 		Managed: true,
 	}
-	network.Description = description.String
+	network.Description = description
 	network.Config = config
 
 	switch state {
@@ -379,11 +443,11 @@ func (c *Cluster) getNetwork(name string, onlyCreated bool) (int64, *api.Network
 
 	nodes, err := c.networkNodes(id)
 	if err != nil {
-		return -1, nil, err
+		return nil, err
 	}
 	network.Locations = nodes
 
-	return id, &network, nil
+	return &network, nil
 }
 
 // Return the names of the nodes the given network is defined on.
@@ -567,6 +631,50 @@ func (c *Cluster) UpdateNetwork(name, description string, config map[string]stri
 	return err
 }
 
+// UpdateNetworkConfigKeys updates the description of the network with the given name and applies the given config
+// changes to its config as seen by this node. A key with an empty value is removed and keys not included in the
+// changes are left as they are, so that concurrent changes to other keys aren't overwritten.
+func (c *Cluster) UpdateNetworkConfigKeys(name, description string, changes map[string]string) error {
+	id, netInfo, err := c.GetNetworkInAnyState(name)
+	if err != nil {
+		return err
+	}
+
+	return c.Transaction(func(tx *ClusterTx) error {
+		err := updateNetworkDescription(tx.tx, id, description)
+		if err != nil {
+			return err
+		}
+
+		err = tx.UpdateNetworkConfig(id, changes)
+		if err != nil {
+			return err
+		}
+
+		// Update network status if change applied successfully.
+		if netInfo.Status == api.NetworkStatusErrored {
+			err = tx.NetworkCreated(name)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// UpdateNetworkDescription updates the description of the network with the given name, leaving its config as is.
+func (c *Cluster) UpdateNetworkDescription(name, description string) error {
+	id, _, err := c.GetNetworkInAnyState(name)
+	if err != nil {
+		return err
+	}
+
+	return c.Transaction(func(tx *ClusterTx) error {
+		return updateNetworkDescription(tx.tx, id, description)
+	})
+}
+
 // Update the description of the network with the given ID.
 func updateNetworkDescription(tx *sql.Tx, id int64, description string) error {
 	_, err := tx.Exec("UPDATE networks SET description=? WHERE id=?", description, id)
@@ -644,8 +752,137 @@ func (c *Cluster) RenameNetwork(oldName string, newName string) error {
 	return err
 }
 
+// SwapNetworkNames swaps the names of the two named networks. Both renames happen in a single transaction, so
+// either both networks are renamed or neither is.
+func (c *Cluster) SwapNetworkNames(name1 string, name2 string) error {
+	// Network names can't contain "/", so the intermediate name can't clash with another network.
+	tmpName := name1 + "/swap"
+
+	return c.Transaction(func(tx *ClusterTx) error {
+		for _, rename := range [][2]string{{name1, tmpName}, {name2, name1}, {tmpName, name2}} {
+			result, err := tx.tx.Exec("UPDATE networks SET name=? WHERE name=?", rename[1], rename[0])
+			if err != nil {
+				return err
+			}
+
+			n, err := result.RowsAffected()
+			if err != nil {
+				return err
+			}
+
+			if n != 1 {
+				return ErrNoSuchObject
+			}
+		}
+
+		return nil
+	})
+}
+
+// CreateNetworkSnapshot stores a named snapshot of the description and
+// config of the network with the given ID.
+func (c *Cluster) CreateNetworkSnapshot(networkID int64, name string, description string, config map[string]string) error {
+	return c.Transaction(func(tx *ClusterTx) error {
+		result, err := tx.tx.Exec("INSERT INTO networks_snapshots (network_id, name, description, creation_date) VALUES (?, ?, ?, ?)", networkID, name, description, time.Now().UTC().Unix())
+		if err != nil {
+			return err
+		}
+
+		snapshotID, err := result.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("Error inserting %q into database", name)
+		}
+
+		stmt, err := tx.tx.Prepare("INSERT INTO networks_snapshots_config (network_snapshot_id, key, value) VALUES(?, ?, ?)")
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for k, v := range config {
+			if v == "" {
+				continue
+			}
+
+			_, err = stmt.Exec(snapshotID, k, v)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// GetNetworkSnapshotNames returns the names of the snapshots of the network
+// with the given ID, oldest first.
+func (c *Cluster) GetNetworkSnapshotNames(networkID int64) ([]string, error) {
+	var names []string
+	err := c.Transaction(func(tx *ClusterTx) error {
+		var err error
+		names, err = query.SelectStrings(tx.tx, "SELECT name FROM networks_snapshots WHERE network_id=? ORDER BY creation_date, id", networkID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
+
+// GetNetworkSnapshot returns the description and config stored in the named
+// snapshot of the network with the given ID.
+func (c *Cluster) GetNetworkSnapshot(networkID int64, name string) (string, map[string]string, error) {
+	var description string
+	var config map[string]string
+	err := c.Transaction(func(tx *ClusterTx) error {
+		var snapshotID int64
+		var desc sql.NullString
+		err := tx.tx.QueryRow("SELECT id, description FROM networks_snapshots WHERE network_id=? AND name=?", networkID, name).Scan(&snapshotID, &desc)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrNoSuchObject
+			}
+
+			return err
+		}
+
+		description = desc.String
+		config, err = query.SelectConfig(tx.tx, "networks_snapshots_config", "network_snapshot_id=?", snapshotID)
+		return err
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	return description, config, nil
+}
+
+// DeleteNetworkSnapshot deletes the named snapshot of the network with the
+// given ID.
+func (c *Cluster) DeleteNetworkSnapshot(networkID int64, name string) error {
+	return c.Transaction(func(tx *ClusterTx) error {
+		result, err := tx.tx.Exec("DELETE FROM networks_snapshots WHERE network_id=? AND name=?", networkID, name)
+		if err != nil {
+			return err
+		}
+
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if n == 0 {
+			return ErrNoSuchObject
+		}
+
+		return nil
+	})
+}
+
 // NodeSpecificNetworkConfig lists all network config keys which are node-specific.
 var NodeSpecificNetworkConfig = []string{
 	"bridge.external_interfaces",
 	"parent",
+	"volatile.bridge.hwaddr",
 }
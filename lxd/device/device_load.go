@@ -6,9 +6,15 @@ import (
 	deviceConfig "github.com/lxc/lxd/lxd/device/config"
 	"github.com/lxc/lxd/lxd/device/nictype"
 	"github.com/lxc/lxd/lxd/instance"
+	"github.com/lxc/lxd/lxd/network"
 	"github.com/lxc/lxd/lxd/state"
 )
 
+func init() {
+	// Expose nicBridgedUpdateFilters to the network package, to avoid circular imports.
+	network.UpdateInstanceNICFilters = nicBridgedUpdateFilters
+}
+
 // load instantiates a device and initialises its internal state. It does not validate the config supplied.
 func load(inst instance.Instance, state *state.State, name string, conf deviceConfig.Device, volatileGet VolatileGetter, volatileSet VolatileSetter) (device, error) {
 	if conf["type"] == "" {
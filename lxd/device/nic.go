@@ -1,6 +1,7 @@
 package device
 
 import (
+	"github.com/lxc/lxd/lxd/network/validate"
 	"github.com/lxc/lxd/shared"
 )
 
@@ -12,7 +13,7 @@ func nicValidationRules(requiredFields []string, optionalFields []string) map[st
 		"parent":                  shared.IsAny,
 		"network":                 shared.IsAny,
 		"mtu":                     shared.IsAny,
-		"vlan":                    networkValidVLAN,
+		"vlan":                    validate.IsNetworkVLAN,
 		"hwaddr":                  networkValidMAC,
 		"host_name":               shared.IsAny,
 		"limits.ingress":          shared.IsAny,
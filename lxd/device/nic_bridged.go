@@ -26,7 +26,9 @@ import (
 	"github.com/lxc/lxd/lxd/instance/instancetype"
 	"github.com/lxc/lxd/lxd/network"
 	"github.com/lxc/lxd/lxd/network/openvswitch"
+	"github.com/lxc/lxd/lxd/network/validate"
 	"github.com/lxc/lxd/lxd/revert"
+	"github.com/lxc/lxd/lxd/state"
 	"github.com/lxc/lxd/lxd/util"
 	"github.com/lxc/lxd/shared"
 	"github.com/lxc/lxd/shared/api"
@@ -95,40 +97,16 @@ func (d *nicBridged) validateConfig(instConf instance.ConfigReader) error {
 
 		netConfig := n.Config()
 
-		if d.config["ipv4.address"] != "" {
-			// Check that DHCPv4 is enabled on parent network (needed to use static assigned IPs).
-			if !n.HasDHCPv4() {
-				return fmt.Errorf("Cannot specify %q when %q is disabled on network %q", "ipv4.address", "ipv4.dhcp", d.config["network"])
-			}
-
-			_, subnet, err := net.ParseCIDR(netConfig["ipv4.address"])
-			if err != nil {
-				return errors.Wrapf(err, "Invalid network ipv4.address")
-			}
-
-			// Check the static IP supplied is valid for the linked network. It should be part of the
-			// network's subnet, but not necessarily part of the dynamic allocation ranges.
-			if !d.networkDHCPValidIP(subnet, nil, net.ParseIP(d.config["ipv4.address"])) {
-				return fmt.Errorf("Device IP address %q not within network %q subnet", d.config["ipv4.address"], d.config["network"])
-			}
+		// Check the static IPs and MAC address of the NIC can be used on the network. The NIC's generated MAC is
+		// used when it doesn't set one, so that its own allocations aren't treated as conflicts.
+		nicConfig := d.config.Clone()
+		if nicConfig["hwaddr"] == "" {
+			nicConfig["hwaddr"] = instConf.LocalConfig()[fmt.Sprintf("volatile.%s.hwaddr", d.name)]
 		}
 
-		if d.config["ipv6.address"] != "" {
-			// Check that DHCPv6 is enabled on parent network (needed to use static assigned IPs).
-			if !n.HasDHCPv6() || !shared.IsTrue(netConfig["ipv6.dhcp.stateful"]) {
-				return fmt.Errorf("Cannot specify %q when %q or %q are disabled on network %q", "ipv6.address", "ipv6.dhcp", "ipv6.dhcp.stateful", d.config["network"])
-			}
-
-			_, subnet, err := net.ParseCIDR(netConfig["ipv6.address"])
-			if err != nil {
-				return errors.Wrapf(err, "Invalid network ipv6.address")
-			}
-
-			// Check the static IP supplied is valid for the linked network. It should be part of the
-			// network's subnet, but not necessarily part of the dynamic allocation ranges.
-			if !d.networkDHCPValidIP(subnet, nil, net.ParseIP(d.config["ipv6.address"])) {
-				return fmt.Errorf("Device IP address %q not within network %q subnet", d.config["ipv6.address"], d.config["network"])
-			}
+		err = n.ValidateInstanceNIC(nicConfig)
+		if err != nil {
+			return err
 		}
 
 		// Link device to network bridge.
@@ -146,6 +124,13 @@ func (d *nicBridged) validateConfig(instConf instance.ConfigReader) error {
 				d.config[inheritKey] = netConfig[inheritKey]
 			}
 		}
+
+		// Apply the network's anti-spoofing filtering settings unless the device sets its own.
+		for _, filterKey := range []string{"security.mac_filtering", "security.ipv4_filtering", "security.ipv6_filtering"} {
+			if d.config[filterKey] == "" && netConfig[filterKey] != "" {
+				d.config[filterKey] = netConfig[filterKey]
+			}
+		}
 	} else {
 		// If no network property supplied, then parent property is required.
 		requiredFields = append(requiredFields, "parent")
@@ -166,7 +151,7 @@ func (d *nicBridged) validateConfig(instConf instance.ConfigReader) error {
 			return nil
 		}
 
-		return networkValidVLAN(value)
+		return validate.IsNetworkVLAN(value)
 	}
 
 	// Add bridge specific vlan.tagged validation.
@@ -175,7 +160,7 @@ func (d *nicBridged) validateConfig(instConf instance.ConfigReader) error {
 			return nil
 		}
 
-		err := networkValidVLANList(value)
+		err := validate.IsNetworkVLANList(value)
 		if err != nil {
 			return err
 		}
@@ -536,6 +521,65 @@ func (d *nicBridged) setupHostFilters(oldConfig deviceConfig.Device) error {
 	return nil
 }
 
+// nicBridgedUpdateFilters re-applies the host side filters of a running instance's bridged NIC after the filtering
+// settings of its parent network have changed from oldNetConfig. The NIC inherits the network's current settings
+// when its config is validated, while the settings it had before are derived from oldNetConfig so that the old
+// filters can be removed.
+func nicBridgedUpdateFilters(s *state.State, inst instance.Instance, devName string, oldNetConfig map[string]string) error {
+	prefix := fmt.Sprintf("volatile.%s.", devName)
+	volatileGet := func() map[string]string {
+		volatile := make(map[string]string)
+		for k, v := range inst.LocalConfig() {
+			if strings.HasPrefix(k, prefix) {
+				volatile[strings.TrimPrefix(k, prefix)] = v
+			}
+		}
+
+		return volatile
+	}
+
+	volatileSet := func(save map[string]string) error {
+		volatileSave := make(map[string]string)
+		for k, v := range save {
+			volatileSave[prefix+k] = v
+		}
+
+		return inst.VolatileSet(volatileSave)
+	}
+
+	rawConfig := inst.ExpandedDevices()[devName]
+	dev, err := New(inst, s, devName, rawConfig.Clone(), volatileGet, volatileSet)
+	if err != nil {
+		return err
+	}
+
+	d, ok := dev.(*nicBridged)
+	if !ok {
+		return fmt.Errorf("Device %q isn't a bridged NIC", devName)
+	}
+
+	// Load the most recently added host_name and hwaddr from volatile data if not configured.
+	v := d.volatileGet()
+	for _, key := range []string{"host_name", "hwaddr"} {
+		if d.config[key] == "" {
+			d.config[key] = v[key]
+		}
+	}
+
+	if d.config["host_name"] == "" {
+		return fmt.Errorf("Failed to find host side veth name for device %q", devName)
+	}
+
+	oldConfig := d.config.Clone()
+	for _, filterKey := range []string{"security.mac_filtering", "security.ipv4_filtering", "security.ipv6_filtering"} {
+		if rawConfig[filterKey] == "" {
+			oldConfig[filterKey] = oldNetConfig[filterKey]
+		}
+	}
+
+	return d.setupHostFilters(oldConfig)
+}
+
 // removeFilters removes any network level filters defined for the instance.
 func (d *nicBridged) removeFilters(m deviceConfig.Device) {
 	if m["hwaddr"] == "" {
@@ -692,8 +736,8 @@ func (d *nicBridged) allocateFilterIPs(n network.Network) (net.IP, net.IP, error
 	netConfig := n.Config()
 
 	// Check the conditions required to dynamically allocated IPs.
-	canIPv4Allocate := netConfig["ipv4.address"] != "" && netConfig["ipv4.address"] != "none" && n.HasDHCPv4()
-	canIPv6Allocate := netConfig["ipv6.address"] != "" && netConfig["ipv6.address"] != "none" && n.HasDHCPv6()
+	canIPv4Allocate := n.IPv4Enabled() && n.HasDHCPv4()
+	canIPv6Allocate := n.IPv6Enabled() && n.HasDHCPv6()
 
 	dnsmasq.ConfigMutex.Lock()
 	defer dnsmasq.ConfigMutex.Unlock()
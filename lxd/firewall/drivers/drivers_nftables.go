@@ -258,6 +258,55 @@ func (d Nftables) NetworkSetupDHCPv4Checksum(networkName string) error {
 	return nil
 }
 
+// NetworkSetupRules adds the network's custom firewall rules. They are added to their own chains with a higher
+// priority than the other network chains so that they are evaluated first.
+func (d Nftables) NetworkSetupRules(networkName string, ipVersion uint, rules []NetworkRule) error {
+	family, err := d.getIPFamily(ipVersion)
+	if err != nil {
+		return err
+	}
+
+	chainRules := map[string][]string{}
+	for _, rule := range rules {
+		match := ""
+		if rule.Protocol == "icmp" && ipVersion == 6 {
+			match = "meta l4proto icmpv6"
+		} else if rule.Protocol != "" && rule.Port > 0 {
+			match = fmt.Sprintf("%s dport %d", rule.Protocol, rule.Port)
+		} else if rule.Protocol != "" {
+			match = fmt.Sprintf("meta l4proto %s", rule.Protocol)
+		}
+
+		ifMatches := []string{fmt.Sprintf("iifname %q", networkName)}
+		if rule.Chain == "output" {
+			ifMatches = []string{fmt.Sprintf("oifname %q", networkName)}
+		} else if rule.Chain == "forward" {
+			ifMatches = append(ifMatches, fmt.Sprintf("oifname %q", networkName))
+		}
+
+		for _, ifMatch := range ifMatches {
+			chainRules[rule.Chain] = append(chainRules[rule.Chain], strings.Join(strings.Fields(fmt.Sprintf("%s %s %s", ifMatch, match, rule.Action)), " "))
+		}
+	}
+
+	tplFields := map[string]interface{}{
+		"namespace":      nftablesNamespace,
+		"chainSeparator": nftablesChainSeparator,
+		"networkName":    networkName,
+		"family":         family,
+		"inputRules":     chainRules["input"],
+		"forwardRules":   chainRules["forward"],
+		"outputRules":    chainRules["output"],
+	}
+
+	err = d.applyNftConfig(nftablesNetRules, tplFields)
+	if err != nil {
+		return errors.Wrapf(err, "Failed adding custom rules for network %q (%s)", networkName, tplFields["family"])
+	}
+
+	return nil
+}
+
 // NetworkClear removes the LXD network related chains.
 func (d Nftables) NetworkClear(networkName string, ipVersion uint) error {
 	family, err := d.getIPFamily(ipVersion)
@@ -266,7 +315,7 @@ func (d Nftables) NetworkClear(networkName string, ipVersion uint) error {
 	}
 
 	// Remove chains created by network rules.
-	err = d.removeChains([]string{family}, networkName, "fwd", "pstrt", "in", "out")
+	err = d.removeChains([]string{family}, networkName, "fwd", "pstrt", "in", "out", "rulesin", "rulesfwd", "rulesout")
 	if err != nil {
 		return errors.Wrapf(err, "Failed clearing nftables rules for network %q", networkName)
 	}
@@ -53,6 +53,29 @@ chain out{{.chainSeparator}}{{.networkName}} {
 }
 `))
 
+var nftablesNetRules = template.Must(template.New("nftablesNetRules").Parse(`
+chain rulesin{{.chainSeparator}}{{.networkName}} {
+	type filter hook input priority -1; policy accept;
+	{{- range .inputRules}}
+	{{.}}
+	{{- end}}
+}
+
+chain rulesfwd{{.chainSeparator}}{{.networkName}} {
+	type filter hook forward priority -1; policy accept;
+	{{- range .forwardRules}}
+	{{.}}
+	{{- end}}
+}
+
+chain rulesout{{.chainSeparator}}{{.networkName}} {
+	type filter hook output priority -1; policy accept;
+	{{- range .outputRules}}
+	{{.}}
+	{{- end}}
+}
+`))
+
 var nftablesNetProxyNAT = template.Must(template.New("nftablesNetProxyNAT").Parse(`
 chain prert{{.chainSeparator}}{{.deviceLabel}} {
 	type nat hook prerouting priority -100; policy accept;
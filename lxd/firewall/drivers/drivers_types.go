@@ -0,0 +1,9 @@
+package drivers
+
+// NetworkRule represents a custom firewall rule for a network.
+type NetworkRule struct {
+	Chain    string // One of "input", "forward" or "output".
+	Action   string // One of "accept", "drop" or "reject".
+	Protocol string // One of "tcp", "udp" or "icmp". Empty to match all protocols.
+	Port     uint16 // Destination port for tcp and udp rules. Zero to match all ports.
+}
@@ -248,6 +248,43 @@ func (d Xtables) NetworkSetupDHCPv4Checksum(networkName string) error {
 	return d.iptablesPrepend(4, comment, "mangle", "POSTROUTING", "-o", networkName, "-p", "udp", "--dport", "68", "-j", "CHECKSUM", "--checksum-fill")
 }
 
+// NetworkSetupRules adds the network's custom firewall rules. The rules are prepended in reverse order, so this
+// should be called after the network's other rules have been added so that the custom rules are evaluated first.
+func (d Xtables) NetworkSetupRules(networkName string, ipVersion uint, rules []NetworkRule) error {
+	comment := d.networkIPTablesComment(networkName)
+	chainArgs := map[string][][]string{
+		"input":   {{"INPUT", "-i", networkName}},
+		"forward": {{"FORWARD", "-i", networkName}, {"FORWARD", "-o", networkName}},
+		"output":  {{"OUTPUT", "-o", networkName}},
+	}
+
+	for i := len(rules) - 1; i >= 0; i-- {
+		rule := rules[i]
+
+		var args []string
+		if rule.Protocol == "icmp" && ipVersion == 6 {
+			args = append(args, "-p", "icmpv6")
+		} else if rule.Protocol != "" {
+			args = append(args, "-p", rule.Protocol)
+		}
+
+		if rule.Port > 0 {
+			args = append(args, "--dport", strconv.FormatUint(uint64(rule.Port), 10))
+		}
+
+		args = append(args, "-j", strings.ToUpper(rule.Action))
+
+		for _, chainArg := range chainArgs[rule.Chain] {
+			err := d.iptablesPrepend(ipVersion, comment, "filter", chainArg[0], append(chainArg[1:], args...)...)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
 // NetworkClear removes network rules from filter, mangle and nat tables.
 func (d Xtables) NetworkClear(networkName string, ipVersion uint) error {
 	err := d.iptablesClear(ipVersion, d.networkIPTablesComment(networkName), "filter", "mangle", "nat")
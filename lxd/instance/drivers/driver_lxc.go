@@ -1365,6 +1365,9 @@ func (c *lxc) deviceLoad(deviceName string, rawConfig deviceConfig.Device) (devi
 
 // deviceAdd loads a new device and calls its Add() function.
 func (c *lxc) deviceAdd(deviceName string, rawConfig deviceConfig.Device) error {
+	// Networks may become used or unused by the device.
+	network.InvalidateUsageCache(deviceConfig.Devices{deviceName: rawConfig})
+
 	d, _, err := c.deviceLoad(deviceName, rawConfig)
 	if err != nil {
 		return err
@@ -1522,6 +1525,9 @@ func (c *lxc) deviceAttachNIC(configCopy map[string]string, netIF []deviceConfig
 
 // deviceUpdate loads a new device and calls its Update() function.
 func (c *lxc) deviceUpdate(deviceName string, rawConfig deviceConfig.Device, oldDevices deviceConfig.Devices, isRunning bool) error {
+	// Networks may become used or unused by the device.
+	network.InvalidateUsageCache(deviceConfig.Devices{deviceName: rawConfig})
+
 	d, _, err := c.deviceLoad(deviceName, rawConfig)
 	if err != nil {
 		return err
@@ -1716,6 +1722,9 @@ func (c *lxc) deviceHandleMounts(mounts []deviceConfig.MountEntryItem) error {
 
 // deviceRemove loads a new device and calls its Remove() function.
 func (c *lxc) deviceRemove(deviceName string, rawConfig deviceConfig.Device) error {
+	// Networks may become used or unused by the device.
+	network.InvalidateUsageCache(deviceConfig.Devices{deviceName: rawConfig})
+
 	d, _, err := c.deviceLoad(deviceName, rawConfig)
 
 	// If deviceLoad fails with unsupported device type then return.
@@ -3068,6 +3068,9 @@ func (vm *qemu) updateDevices(removeDevices deviceConfig.Devices, addDevices dev
 
 // deviceUpdate loads a new device and calls its Update() function.
 func (vm *qemu) deviceUpdate(deviceName string, rawConfig deviceConfig.Device, oldDevices deviceConfig.Devices, isRunning bool) error {
+	// Networks may become used or unused by the device.
+	network.InvalidateUsageCache(deviceConfig.Devices{deviceName: rawConfig})
+
 	d, _, err := vm.deviceLoad(deviceName, rawConfig)
 	if err != nil {
 		return err
@@ -3339,6 +3342,9 @@ func (vm *qemu) Delete() error {
 }
 
 func (vm *qemu) deviceAdd(deviceName string, rawConfig deviceConfig.Device) error {
+	// Networks may become used or unused by the device.
+	network.InvalidateUsageCache(deviceConfig.Devices{deviceName: rawConfig})
+
 	d, _, err := vm.deviceLoad(deviceName, rawConfig)
 	if err != nil {
 		return err
@@ -3348,6 +3354,9 @@ func (vm *qemu) deviceAdd(deviceName string, rawConfig deviceConfig.Device) erro
 }
 
 func (vm *qemu) deviceRemove(deviceName string, rawConfig deviceConfig.Device) error {
+	// Networks may become used or unused by the device.
+	network.InvalidateUsageCache(deviceConfig.Devices{deviceName: rawConfig})
+
 	d, _, err := vm.deviceLoad(deviceName, rawConfig)
 
 	// If deviceLoad fails with unsupported device type then return.
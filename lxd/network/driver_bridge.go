@@ -2,6 +2,7 @@ package network
 
 import (
 	"bufio"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io/ioutil"
@@ -9,6 +10,7 @@ import (
 	"os"
 	"os/exec"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -18,8 +20,12 @@ import (
 	"github.com/lxc/lxd/lxd/apparmor"
 	"github.com/lxc/lxd/lxd/cluster"
 	"github.com/lxc/lxd/lxd/daemon"
+	deviceConfig "github.com/lxc/lxd/lxd/device/config"
 	"github.com/lxc/lxd/lxd/dnsmasq"
+	"github.com/lxc/lxd/lxd/instance"
+	"github.com/lxc/lxd/lxd/instance/instancetype"
 	"github.com/lxc/lxd/lxd/network/openvswitch"
+	"github.com/lxc/lxd/lxd/network/validate"
 	"github.com/lxc/lxd/lxd/node"
 	"github.com/lxc/lxd/lxd/revert"
 	"github.com/lxc/lxd/lxd/util"
@@ -38,6 +44,10 @@ const ForkdnsServersListFile = "servers.conf"
 
 var forkdnsServersLock sync.Mutex
 
+// dnsmasqKeys lists the bridge config keys whose changes are applied by restarting dnsmasq. Entries ending in "."
+// match all keys with that prefix.
+var dnsmasqKeys = []string{"dns.", "ipv4.dhcp.", "ipv6.dhcp.", "raw.dnsmasq", "security.dhcp.strict", "volatile.maintenance"}
+
 // bridge represents a LXD bridge network.
 type bridge struct {
 	common
@@ -71,98 +81,215 @@ func (n *bridge) fillConfig(req *api.NetworksPost) error {
 		}
 	}
 
+	// Replace an automatic IPv4 address with one from a newly allocated subnet.
+	err := n.normalizeIPv4Address(req.Config)
+	if err != nil {
+		return err
+	}
+
+	// Replace an automatic IPv6 address with a generated unique local one.
+	err = n.fillIPv6Address(req.Config)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
-// Validate network config.
-func (n *bridge) Validate(config map[string]string) error {
+// bridgeTemplatedKeyRules holds the rules of the bridge's dynamic config keys, keyed by their templated name in which
+// NAME or ADDRESS stands for the part of the key that varies. The validator of the DNS record rule depends on the
+// record name and so is added when the rule is used.
+var bridgeTemplatedKeyRules = map[string]configKeyRule{
+	"dns.record.NAME":               {keyType: "string", description: "Custom DNS record for NAME (must be within dns.domain), as \"TYPE VALUE\" where TYPE is A, AAAA or CNAME"},
+	"ipv4.dhcp.reservation.ADDRESS": {validator: validate.IsAny, keyType: "string", description: "Reserve ADDRESS from the DHCP pool for external allocation (value is a comment)"},
+	"tunnel.NAME.group":             {validator: validate.Optional(validate.IsNetworkAddress), keyType: "string", defaultValue: "239.0.0.1", description: "Multicast address for vxlan (used if local and remote aren't set)"},
+	"tunnel.NAME.id":                {validator: validate.Optional(validate.IsInt64), keyType: "integer", defaultValue: "1", description: "Tunnel ID to use for the vxlan tunnel (must be unique within the network)"},
+	"tunnel.NAME.interface":         {validator: ValidNetworkName, keyType: "string", description: "Specific host interface to use for the tunnel"},
+	"tunnel.NAME.local":             {validator: validate.Optional(validate.IsNetworkAddress), keyType: "string", description: "Local address for the tunnel (not necessary for multicast vxlan)"},
+	"tunnel.NAME.port":              {validator: networkValidPort, keyType: "integer", description: "Specific port to use for the vxlan tunnel (defaults to the kernel's default port)"},
+	"tunnel.NAME.protocol":          {validator: validate.Optional(validate.IsOneOf("gre", "vxlan")), keyType: "string", description: "Tunneling protocol (\"vxlan\" or \"gre\")"},
+	"tunnel.NAME.remote":            {validator: validate.Optional(validate.IsNetworkAddress), keyType: "string", description: "Remote address for the tunnel (not necessary for multicast vxlan)"},
+	"tunnel.NAME.ttl":               {validator: validate.Optional(validate.IsUint8), keyType: "integer", defaultValue: "1", description: "Specific TTL to use for multicast routing topologies"},
+}
+
+// rules returns the driver specific validation rules. Rules for the dynamic tunnel keys present in the supplied
+// config are added, so an error is returned if a tunnel key name is invalid.
+func (n *bridge) rules(config map[string]string) (map[string]configKeyRule, error) {
 	// Build driver specific rules dynamically.
-	rules := map[string]func(value string) error{
-		"bridge.driver": func(value string) error {
-			return shared.IsOneOf(value, []string{"native", "openvswitch"})
-		},
-		"bridge.external_interfaces": func(value string) error {
-			if value == "" {
-				return nil
-			}
+	rules := map[string]configKeyRule{
+		"bridge.driver": {validator: validate.Optional(validate.IsOneOf("native", "openvswitch")), keyType: "string", defaultValue: "native", description: "Bridge driver (\"native\" or \"openvswitch\")"},
+		"bridge.external_interfaces": {
+			keyType:     "string",
+			description: "Comma separated list of unconfigured network interfaces to include in the bridge",
+			validator: func(value string) error {
+				if value == "" {
+					return nil
+				}
 
-			for _, entry := range strings.Split(value, ",") {
-				entry = strings.TrimSpace(entry)
-				if err := ValidNetworkName(entry); err != nil {
-					return errors.Wrapf(err, "Invalid interface name %q", entry)
+				for _, entry := range strings.Split(value, ",") {
+					entry = strings.TrimSpace(entry)
+					if err := ValidNetworkName(entry); err != nil {
+						return errors.Wrapf(err, "Invalid interface name %q", entry)
+					}
 				}
-			}
 
-			return nil
+				return nil
+			},
 		},
-		"bridge.hwaddr": shared.IsAny,
-		"bridge.mtu":    shared.IsInt64,
-		"bridge.mode": func(value string) error {
-			return shared.IsOneOf(value, []string{"standard", "fan"})
+		"bridge.hwaddr": {validator: validHWAddr, keyType: "string", description: "MAC address for the bridge (defaults to a generated address)"},
+		"bridge.mtu":    {validator: validate.Optional(validate.IsInt64), keyType: "integer", defaultValue: "1500", description: "Bridge MTU (default varies if tunnel or fan setup)"},
+		"bridge.mode":   {validator: validate.Optional(validate.IsOneOf("standard", "fan")), keyType: "string", defaultValue: "standard", description: "Bridge operation mode (\"standard\" or \"fan\")"},
+		"bridge.vlan":   {validator: validate.Optional(validate.IsNetworkVLAN), keyType: "integer", description: "Native (untagged) VLAN ID (0-4094, 0 for none) used as the default for new bridge ports (native driver only)"},
+		"bridge.vlan.tagged": {
+			keyType:     "string",
+			description: "Comma separated list of tagged VLAN IDs (1-4094) for the bridge interface itself (native driver only)",
+			validator: func(value string) error {
+				_, err := parseVLANList(value)
+				return err
+			},
 		},
 
-		"fan.overlay_subnet": shared.IsNetworkV4,
-		"fan.underlay_subnet": func(value string) error {
-			if value == "auto" {
-				return nil
-			}
-
-			return shared.IsNetworkV4(value)
+		"fan.overlay_subnet":  {validator: validate.Optional(validate.IsNetworkV4), keyType: "string", defaultValue: "240.0.0.0/8", description: "Subnet to use as the overlay for the FAN (CIDR notation)"},
+		"fan.underlay_subnet": {validator: validate.Optional(validate.Or(validate.IsOneOf("auto"), validate.IsNetworkV4)), keyType: "string", defaultValue: "auto", description: "Subnet to use as the underlay for the FAN (CIDR notation, \"auto\" uses the default gateway subnet)"},
+		"fan.type":            {validator: validate.Optional(validate.IsOneOf("vxlan", "ipip")), keyType: "string", defaultValue: "vxlan", description: "The tunneling type for the FAN (\"vxlan\" or \"ipip\")"},
+
+		"ipv4.address":      {validator: validate.Optional(validate.Or(validate.IsOneOf("none", "auto"), validate.IsNetworkAddressCIDRV4)), keyType: "string", defaultValue: "auto", description: "IPv4 address for the bridge (CIDR notation). Use \"none\" to turn off IPv4 or \"auto\" to generate a new unused /24 within 10.0.0.0/8"},
+		"ipv4.firewall":     {validator: validate.Optional(validate.IsBool), keyType: "boolean", defaultValue: "true", description: "Whether to generate filtering firewall rules for this network"},
+		"ipv4.nat":          {validator: validate.Optional(validate.IsBool), keyType: "boolean", defaultValue: "false", description: "Whether to NAT (will default to true if unset and a random ipv4.address is generated)"},
+		"ipv4.nat.order":    {validator: validate.Optional(validate.IsOneOf("before", "after")), keyType: "string", defaultValue: "before", description: "Whether to add the required NAT rules before or after any pre-existing rules"},
+		"ipv4.nat.address":  {validator: validate.Optional(validate.IsNetworkAddressV4), keyType: "string", description: "The source address used for outbound traffic from the bridge"},
+		"ipv4.dhcp":         {validator: validate.Optional(validate.IsBool), keyType: "boolean", defaultValue: "true", description: "Whether to allocate addresses using DHCP"},
+		"ipv4.dhcp.gateway": {validator: validate.Optional(validate.IsNetworkAddressV4), keyType: "string", description: "Address of the gateway for the subnet (defaults to ipv4.address)"},
+		"ipv4.dhcp.expiry":  {validator: validDHCPExpiry, keyType: "string", defaultValue: "1h", description: "When to expire DHCP leases (\"infinite\" or a number with an optional s, m, h, d or w unit such as 1h, at least 2m)"},
+		"ipv4.dhcp.ranges":  {validator: validDHCPRangesCount, keyType: "string", description: "Comma separated list of IP ranges to use for DHCP (FIRST-LAST format, at most 32 ranges, defaults to all addresses)"},
+		"ipv4.dhcp.exclude": {
+			keyType:     "string",
+			description: "Comma separated list of IPs or IP ranges (FIRST-LAST format) to exclude from the DHCP pool",
+			validator: func(value string) error {
+				_, err := parseIPv4List(value)
+				return err
+			},
 		},
-		"fan.type": func(value string) error {
-			return shared.IsOneOf(value, []string{"vxlan", "ipip"})
+		"ipv4.routes": {validator: validate.Optional(validate.IsListOf(validate.IsNetworkV4)), keyType: "string", description: "Comma separated list of additional IPv4 CIDR subnets to route to the bridge"},
+		"ipv4.routes.external": {
+			keyType:     "string",
+			description: "Comma separated list of external static routes to add on the host in CIDR[ via GATEWAY] format",
+			validator: func(value string) error {
+				_, err := parseStaticRoutes(value, 4, nil)
+				return err
+			},
 		},
+		"ipv4.firewall.rules": {
+			keyType:     "string",
+			description: "Comma separated list of custom firewall rules in CHAIN ACTION[ PROTOCOL[ PORT]] format",
+			validator: func(value string) error {
+				_, err := parseFirewallRules(value)
+				return err
+			},
+		},
+		"ipv4.dhcp.options": {
+			keyType:     "string",
+			description: "Comma separated list of custom DHCP options in NUMBER:VALUE format (multiple values separated by spaces)",
+			validator: func(value string) error {
+				_, err := parseDHCPOptions(value)
+				return err
+			},
+		},
+		"ipv4.routing": {validator: validate.Optional(validate.IsBool), keyType: "boolean", defaultValue: "true", description: "Whether to route traffic in and out of the bridge"},
+
+		"ipv6.address":       {validator: validate.Optional(validate.Or(validate.IsOneOf("none", "auto"), validate.IsNetworkAddressCIDRV6)), keyType: "string", defaultValue: "auto", description: "IPv6 address for the bridge (CIDR notation). Use \"none\" to turn off IPv6 or \"auto\" to generate a new random unique local (fd00::/8) one"},
+		"ipv6.firewall":      {validator: validate.Optional(validate.IsBool), keyType: "boolean", defaultValue: "true", description: "Whether to generate filtering firewall rules for this network"},
+		"ipv6.nat":           {validator: validate.Optional(validate.IsBool), keyType: "boolean", defaultValue: "false", description: "Whether to NAT (will default to true if unset and a random ipv6.address is generated)"},
+		"ipv6.nat.order":     {validator: validate.Optional(validate.IsOneOf("before", "after")), keyType: "string", defaultValue: "before", description: "Whether to add the required NAT rules before or after any pre-existing rules"},
+		"ipv6.nat.address":   {validator: validate.Optional(validate.IsNetworkAddressV6), keyType: "string", description: "The source address used for outbound traffic from the bridge"},
+		"ipv6.dhcp":          {validator: validate.Optional(validate.IsBool), keyType: "boolean", defaultValue: "true", description: "Whether to provide additional network configuration over DHCP"},
+		"ipv6.dhcp.expiry":   {validator: validDHCPExpiry, keyType: "string", defaultValue: "1h", description: "When to expire DHCP leases (\"infinite\" or a number with an optional s, m, h, d or w unit such as 1h, at least 2m)"},
+		"ipv6.dhcp.stateful": {validator: validate.Optional(validate.IsBool), keyType: "boolean", defaultValue: "false", description: "Whether to allocate addresses using DHCP"},
+		"ipv6.dns":           {validator: validate.Optional(validate.IsBool), keyType: "boolean", defaultValue: "true", description: "Whether to advertise the bridge as a DNS server in router advertisements"},
+		"ipv6.dhcp.ranges": {
+			keyType:     "string",
+			description: "Comma separated list of IPv6 ranges to use for DHCP (FIRST-LAST format, at most 32 ranges, defaults to all addresses)",
+			validator: func(value string) error {
+				err := validDHCPRangesCount(value)
+				if err != nil {
+					return err
+				}
 
-		"ipv4.address": func(value string) error {
-			if shared.IsOneOf(value, []string{"none", "auto"}) == nil {
-				return nil
-			}
-
-			return shared.IsNetworkAddressCIDRV4(value)
+				_, err = parseDHCPv6Ranges(value, nil)
+				return err
+			},
 		},
-		"ipv4.firewall": shared.IsBool,
-		"ipv4.nat":      shared.IsBool,
-		"ipv4.nat.order": func(value string) error {
-			return shared.IsOneOf(value, []string{"before", "after"})
+		"ipv6.dhcp.pd.ranges": {
+			keyType:     "string",
+			description: "Comma separated list of IPv6 ranges to delegate prefixes from (FIRST-LAST format)",
+			validator: func(value string) error {
+				_, err := parseDHCPv6Ranges(value, nil)
+				return err
+			},
 		},
-		"ipv4.nat.address":  shared.IsNetworkAddressV4,
-		"ipv4.dhcp":         shared.IsBool,
-		"ipv4.dhcp.gateway": shared.IsNetworkAddressV4,
-		"ipv4.dhcp.expiry":  shared.IsAny,
-		"ipv4.dhcp.ranges":  shared.IsAny,
-		"ipv4.routes":       shared.IsNetworkV4List,
-		"ipv4.routing":      shared.IsBool,
-
-		"ipv6.address": func(value string) error {
-			if shared.IsOneOf(value, []string{"none", "auto"}) == nil {
-				return nil
-			}
+		"ipv6.dhcp.pd.prefix_length": {
+			keyType:      "integer",
+			defaultValue: "64",
+			description:  "Length of the prefixes delegated from the prefix delegation pool",
+			validator: func(value string) error {
+				if value == "" {
+					return nil
+				}
+
+				length, err := strconv.Atoi(value)
+				if err != nil || length < 1 || length > 128 {
+					return fmt.Errorf("Invalid prefix length %q, must be between 1 and 128", value)
+				}
 
-			return shared.IsNetworkAddressCIDRV6(value)
+				return nil
+			},
+		},
+		"ipv6.routes": {validator: validate.Optional(validate.IsListOf(validate.IsNetworkV6)), keyType: "string", description: "Comma separated list of additional IPv6 CIDR subnets to route to the bridge"},
+		"ipv6.routes.external": {
+			keyType:     "string",
+			description: "Comma separated list of external static routes to add on the host in CIDR[ via GATEWAY] format",
+			validator: func(value string) error {
+				_, err := parseStaticRoutes(value, 6, nil)
+				return err
+			},
 		},
-		"ipv6.firewall": shared.IsBool,
-		"ipv6.nat":      shared.IsBool,
-		"ipv6.nat.order": func(value string) error {
-			return shared.IsOneOf(value, []string{"before", "after"})
+		"ipv6.firewall.rules": {
+			keyType:     "string",
+			description: "Comma separated list of custom firewall rules in CHAIN ACTION[ PROTOCOL[ PORT]] format",
+			validator: func(value string) error {
+				_, err := parseFirewallRules(value)
+				return err
+			},
 		},
-		"ipv6.nat.address":   shared.IsNetworkAddressV6,
-		"ipv6.dhcp":          shared.IsBool,
-		"ipv6.dhcp.expiry":   shared.IsAny,
-		"ipv6.dhcp.stateful": shared.IsBool,
-		"ipv6.dhcp.ranges":   shared.IsAny,
-		"ipv6.routes":        shared.IsNetworkV6List,
-		"ipv6.routing":       shared.IsBool,
-
-		"dns.domain": shared.IsAny,
-		"dns.search": shared.IsAny,
-		"dns.mode": func(value string) error {
-			return shared.IsOneOf(value, []string{"dynamic", "managed", "none"})
+		"ipv6.routing": {validator: validate.Optional(validate.IsBool), keyType: "boolean", defaultValue: "true", description: "Whether to route traffic in and out of the bridge"},
+
+		"limits.ingress":  {validator: validBitRate, keyType: "string", description: "I/O limit in bit/s for incoming traffic (supports kbit, Mbit, Gbit suffixes)"},
+		"limits.egress":   {validator: validBitRate, keyType: "string", description: "I/O limit in bit/s for outgoing traffic (supports kbit, Mbit, Gbit suffixes)"},
+		"limits.priority": {validator: validate.Optional(validate.IsUint32), keyType: "integer", description: "Priority of the network's traffic"},
+
+		"dns.domain": {validator: validate.Optional(validate.IsDNSDomain), keyType: "string", defaultValue: "lxd", description: "Domain to advertise to DHCP clients and use for DNS resolution"},
+		"dns.search": {validator: validate.Optional(validate.IsListOf(validate.Optional(validate.IsDNSDomain))), keyType: "string", description: "Full comma separated domain search list, defaulting to dns.domain"},
+		"dns.mode":   {validator: validate.Optional(validate.IsOneOf("dynamic", "managed", "none")), keyType: "string", defaultValue: "managed", description: "DNS registration mode (\"none\" for no DNS record, \"managed\" for LXD generated static records or \"dynamic\" for client generated records)"},
+		"dns.nameservers": {
+			keyType:     "string",
+			description: "Comma separated list of upstream DNS servers to forward queries to (defaults to the host's resolvers)",
+			validator: func(value string) error {
+				_, err := parseDNSUpstreams(value)
+				return err
+			},
 		},
 
-		"raw.dnsmasq": shared.IsAny,
+		"raw.dnsmasq": {validator: validate.IsAny, keyType: "string", description: "Additional dnsmasq configuration to append to the configuration file"},
+
+		"security.dhcp.strict":    {validator: validate.Optional(validate.IsBool), keyType: "boolean", defaultValue: "false", description: "Only give addresses to clients with a static DHCP lease, ignoring unknown clients"},
+		"security.mac_filtering":  {validator: validate.Optional(validate.IsBool), keyType: "boolean", defaultValue: "false", description: "Prevent the instances from spoofing another instance's MAC address (default for the bridged NICs connected to the network)"},
+		"security.ipv4_filtering": {validator: validate.Optional(validate.IsBool), keyType: "boolean", defaultValue: "false", description: "Prevent the instances from spoofing another instance's IPv4 address (requires DHCP or static DHCP leases)"},
+		"security.ipv6_filtering": {validator: validate.Optional(validate.IsBool), keyType: "boolean", defaultValue: "false", description: "Prevent the instances from spoofing another instance's IPv6 address (requires DHCP or static DHCP leases)"},
 
-		"maas.subnet.ipv4": shared.IsAny,
-		"maas.subnet.ipv6": shared.IsAny,
+		"maas.subnet.ipv4": {validator: validate.IsAny, keyType: "string", description: "MAAS IPv4 subnet to register instances in (when using `network` property on nic)"},
+		"maas.subnet.ipv6": {validator: validate.IsAny, keyType: "string", description: "MAAS IPv6 subnet to register instances in (when using `network` property on nic)"},
+
+		"volatile.bridge.hwaddr": {validator: validHWAddr},
 	}
 
 	// Add dynamic validation rules.
@@ -172,40 +299,184 @@ func (n *bridge) Validate(config map[string]string) error {
 			// Validate remote name in key.
 			fields := strings.Split(k, ".")
 			if len(fields) != 3 {
-				return fmt.Errorf("Invalid network configuration key: %s", k)
+				return nil, fmt.Errorf("Invalid network configuration key: %s", k)
 			}
 
 			if len(n.name)+len(fields[1]) > 14 {
-				return fmt.Errorf("Network name too long for tunnel interface: %s-%s", n.name, fields[1])
+				return nil, fmt.Errorf("Network name too long for tunnel interface: %s-%s", n.name, fields[1])
 			}
 
 			tunnelKey := fields[2]
 
 			// Add the correct validation rule for the dynamic field based on last part of key.
-			switch tunnelKey {
-			case "protocol":
-				rules[k] = func(value string) error {
-					return shared.IsOneOf(value, []string{"gre", "vxlan"})
-				}
-			case "local":
-				rules[k] = shared.IsNetworkAddress
-			case "remote":
-				rules[k] = shared.IsNetworkAddress
-			case "port":
-				rules[k] = networkValidPort
-			case "group":
-				rules[k] = shared.IsNetworkAddress
-			case "id":
-				rules[k] = shared.IsInt64
-			case "inteface":
-				rules[k] = ValidNetworkName
-			case "ttl":
-				rules[k] = shared.IsUint8
+			rule, found := bridgeTemplatedKeyRules[fmt.Sprintf("tunnel.NAME.%s", tunnelKey)]
+			if found {
+				rules[k] = rule
+			}
+		}
+
+		// DHCP reservation keys have the reserved IP in their name.
+		if strings.HasPrefix(k, dhcpv4ReservationPrefix) {
+			err := validate.IsNetworkAddressV4(strings.TrimPrefix(k, dhcpv4ReservationPrefix))
+			if err != nil {
+				return nil, fmt.Errorf("Invalid network configuration key: %s", k)
 			}
+
+			rules[k] = bridgeTemplatedKeyRules[dhcpv4ReservationPrefix+"ADDRESS"]
+		}
+
+		// DNS record keys have the record name in their name.
+		if strings.HasPrefix(k, dnsRecordPrefix) {
+			name := strings.TrimPrefix(k, dnsRecordPrefix)
+			if validDNSName(name) != nil {
+				return nil, fmt.Errorf("Invalid network configuration key: %s", k)
+			}
+
+			rule := bridgeTemplatedKeyRules[dnsRecordPrefix+"NAME"]
+			rule.validator = func(value string) error {
+				_, err := parseDNSRecord(name, value)
+				return err
+			}
+
+			rules[k] = rule
 		}
 	}
 
-	err := n.validate(config, rules)
+	return rules, nil
+}
+
+// exclusiveKeys returns the pairs of bridge config keys that can't be set together. The supplied rules are used to
+// find the keys that depend on the bridge mode.
+func (n *bridge) exclusiveKeys(rules map[string]configKeyRule) []exclusiveKeys {
+	pairs := []exclusiveKeys{
+		{"bridge.vlan", "bridge.driver=openvswitch"},
+		{"bridge.vlan.tagged", "bridge.driver=openvswitch"},
+		{"dns.nameservers", "dns.mode=none"},
+		{"ipv4.firewall.rules", "ipv4.firewall=false"},
+		{"ipv4.nat.address", "ipv4.nat=false"},
+		{"ipv6.firewall.rules", "ipv6.firewall=false"},
+		{"ipv6.nat.address", "ipv6.nat=false"},
+	}
+
+	keys := make([]string, 0, len(rules))
+	for key := range rules {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	// In fan mode the IPv4 config is derived from the fan config (apart from the keys that also apply to the fan's
+	// overlay) and IPv6 isn't supported. The fan config only applies in fan mode.
+	fanIPv4Keys := []string{"ipv4.dhcp.expiry", "ipv4.firewall", "ipv4.firewall.rules", "ipv4.nat", "ipv4.nat.order"}
+	for _, key := range keys {
+		if (strings.HasPrefix(key, "ipv4.") && !shared.StringInSlice(key, fanIPv4Keys)) || strings.HasPrefix(key, "ipv6.") {
+			pairs = append(pairs, exclusiveKeys{key, "bridge.mode=fan"})
+		} else if strings.HasPrefix(key, "fan.") {
+			pairs = append(pairs, exclusiveKeys{key, "bridge.mode=standard"})
+		}
+	}
+
+	return pairs
+}
+
+// warningChecks returns the checks for bridge configs that are valid but questionable.
+func (n *bridge) warningChecks() []warningCheck {
+	return []warningCheck{
+		// Check the DHCPv4 pool isn't very small.
+		func(config map[string]string) []Warning {
+			_, _, err := net.ParseCIDR(config["ipv4.address"])
+			if err != nil || !hasDHCPv4(config) {
+				return nil
+			}
+
+			size, err := dhcpv4PoolSize(config)
+			if err != nil || size >= smallDHCPv4PoolSize {
+				return nil
+			}
+
+			return []Warning{{Key: "ipv4.dhcp.ranges", Message: fmt.Sprintf("DHCPv4 range is very small (%d addresses)", size)}}
+		},
+
+		// Check NAT is only used with private subnets.
+		func(config map[string]string) []Warning {
+			warnings := []Warning{}
+			for _, family := range []string{"ipv4", "ipv6"} {
+				natKey := fmt.Sprintf("%s.nat", family)
+				_, subnet, err := net.ParseCIDR(config[fmt.Sprintf("%s.address", family)])
+				if err != nil || !shared.IsTrue(config[natKey]) || isPrivateSubnet(subnet) {
+					continue
+				}
+
+				warnings = append(warnings, Warning{Key: natKey, Message: fmt.Sprintf("NAT enabled without a private subnet (%s)", subnet.String())})
+			}
+
+			return warnings
+		},
+
+		// Check DNS queries can be forwarded somewhere.
+		func(config map[string]string) []Warning {
+			if dnsMode(config) == "none" || config["dns.nameservers"] != "" || (!ipv4Enabled(config) && !ipv6Enabled(config)) {
+				return nil
+			}
+
+			content, err := ioutil.ReadFile(hostResolvConfPath)
+			if err == nil && resolvConfHasNameservers(string(content)) {
+				return nil
+			}
+
+			return []Warning{{Key: "dns.nameservers", Message: fmt.Sprintf("No DNS upstream configured and none found in %q", hostResolvConfPath)}}
+		},
+
+		// Check the MTU leaves room for the encapsulation overhead of tunnels whose underlay MTU isn't known,
+		// assuming a standard underlay. Tunnels with a known underlay MTU are checked when the network is set up.
+		func(config map[string]string) []Warning {
+			mtu, err := strconv.ParseUint(config["bridge.mtu"], 10, 32)
+			if err != nil {
+				return nil
+			}
+
+			maxMTU, limit, found := maxTunnelMTU(parseTunnels(config), func(t tunnel) (uint64, bool) {
+				_, known := tunnelUnderlayMTU(t)
+				return standardUnderlayMTU, !known
+			})
+			if !found || mtu <= maxMTU {
+				return nil
+			}
+
+			return []Warning{{Key: "bridge.mtu", Message: fmt.Sprintf("MTU %d may be too large for tunnel %q (%s adds %d bytes), the maximum safe MTU on a standard %d byte underlay is %d", mtu, limit.Name, limit.Protocol, tunnelOverhead(limit), standardUnderlayMTU, maxMTU)}}
+		},
+
+		// Check the static DHCPv4 leases of the instance NICs don't conflict with the dynamic ranges or each
+		// other, as dnsmasq could then hand the same address to two clients. This is only a warning so that
+		// existing instances with such addresses keep working.
+		func(config map[string]string) []Warning {
+			if n.state == nil {
+				return nil
+			}
+
+			_, err := n.dhcpv4StaticLeases(config)
+			if err != nil {
+				return []Warning{{Key: "ipv4.dhcp.ranges", Message: err.Error()}}
+			}
+
+			return nil
+		},
+	}
+}
+
+// Validate network config.
+func (n *bridge) Validate(config map[string]string) error {
+	rules, err := n.rules(config)
+	if err != nil {
+		return err
+	}
+
+	err = n.validate(config, rules)
+	if err != nil {
+		return err
+	}
+
+	err = n.validateExclusiveKeys(config, rules, n.exclusiveKeys(rules))
 	if err != nil {
 		return err
 	}
@@ -220,18 +491,6 @@ func (n *bridge) Validate(config map[string]string) error {
 
 	for k, v := range config {
 		key := k
-		// Bridge mode checks
-		if bridgeMode == "fan" && strings.HasPrefix(key, "ipv4.") && !shared.StringInSlice(key, []string{"ipv4.dhcp.expiry", "ipv4.firewall", "ipv4.nat", "ipv4.nat.order"}) && v != "" {
-			return fmt.Errorf("IPv4 configuration may not be set when in 'fan' mode")
-		}
-
-		if bridgeMode == "fan" && strings.HasPrefix(key, "ipv6.") && v != "" {
-			return fmt.Errorf("IPv6 configuration may not be set when in 'fan' mode")
-		}
-
-		if bridgeMode != "fan" && strings.HasPrefix(key, "fan.") && v != "" {
-			return fmt.Errorf("FAN configuration may only be set when in 'fan' mode")
-		}
 
 		// MTU checks
 		if key == "bridge.mtu" && v != "" {
@@ -240,13 +499,11 @@ func (n *bridge) Validate(config map[string]string) error {
 				return fmt.Errorf("Invalid value for an integer: %s", v)
 			}
 
-			ipv6 := config["ipv6.address"]
-			if ipv6 != "" && ipv6 != "none" && mtu < 1280 {
+			if ipv6Enabled(config) && mtu < 1280 {
 				return fmt.Errorf("The minimum MTU for an IPv6 network is 1280")
 			}
 
-			ipv4 := config["ipv4.address"]
-			if ipv4 != "" && ipv4 != "none" && mtu < 68 {
+			if ipv4Enabled(config) && mtu < 68 {
 				return fmt.Errorf("The minimum MTU for an IPv4 network is 68")
 			}
 
@@ -264,9 +521,156 @@ func (n *bridge) Validate(config map[string]string) error {
 		}
 	}
 
+	// Check the native VLAN isn't also tagged.
+	if config["bridge.vlan"] != "" || config["bridge.vlan.tagged"] != "" {
+		vlanID, _ := parseVLAN(config["bridge.vlan"])
+		tagged, _ := parseVLANList(config["bridge.vlan.tagged"])
+		for _, taggedID := range tagged {
+			if taggedID == vlanID {
+				return fmt.Errorf("Invalid value for network %q option %q: Tagged VLAN ID %d cannot be the same as the native VLAN ID", n.name, "bridge.vlan.tagged", taggedID)
+			}
+		}
+	}
+
+	// Check the tunnels are complete and consistent.
+	err = validateTunnels(parseTunnels(config))
+	if err != nil {
+		return errors.Wrapf(err, "Invalid value for network %q", n.name)
+	}
+
+	// Check NAT is only enabled for address families that are enabled.
+	for _, family := range []string{"ipv4", "ipv6"} {
+		addressKey := fmt.Sprintf("%s.address", family)
+		natKey := fmt.Sprintf("%s.nat", family)
+
+		// In fan mode the IPv4 address is derived from the fan config.
+		hasAddress := !shared.StringInSlice(config[addressKey], []string{"", "none"}) || (family == "ipv4" && bridgeMode == "fan")
+
+		if shared.IsTrue(config[natKey]) && !hasAddress {
+			return fmt.Errorf("Invalid value for network %q option %q: NAT can't be enabled when %q is not set", n.name, natKey, addressKey)
+		}
+	}
+
+	// Check the domain search list option isn't set when it's generated from "dns.search".
+	if config["dns.search"] != "" {
+		dhcpOptions, _ := parseDHCPOptions(config["ipv4.dhcp.options"])
+		for _, option := range dhcpOptions {
+			if option.Number == 119 {
+				return fmt.Errorf("Invalid value for network %q option %q: DHCP option 119 can't be set when %q is set", n.name, "ipv4.dhcp.options", "dns.search")
+			}
+		}
+	}
+
+	// Check the DHCP ranges are well formed and within the network's subnet (if it has a concrete address).
+	_, ipv4Net, _ := net.ParseCIDR(config["ipv4.address"])
+	ipv4Ranges, err := parseDHCPv4Ranges(config["ipv4.dhcp.ranges"], ipv4Net)
+	if err != nil {
+		return errors.Wrapf(err, "Invalid value for network %q option %q", n.name, "ipv4.dhcp.ranges")
+	}
+
+	rangeA, rangeB, overlap := dhcpRangesOverlap(ipv4Ranges)
+	if overlap {
+		return fmt.Errorf("Invalid value for network %q option %q: IP range %s-%s overlaps with %s-%s", n.name, "ipv4.dhcp.ranges", rangeA.Start, rangeA.End, rangeB.Start, rangeB.End)
+	}
+
+	// Check the excluded addresses are within the DHCP pool.
+	if config["ipv4.dhcp.exclude"] != "" {
+		excludeRanges, err := parseIPv4List(config["ipv4.dhcp.exclude"])
+		if err != nil {
+			return errors.Wrapf(err, "Invalid value for network %q option %q", n.name, "ipv4.dhcp.exclude")
+		}
+
+		for _, exclude := range excludeRanges {
+			// An exclusion is within the pool if it's entirely removed when subtracting the pool from it.
+			if len(subtractDHCPRanges([]DHCPRange{exclude}, effectiveDHCPv4Ranges(config))) > 0 {
+				return fmt.Errorf("Invalid value for network %q option %q: %s-%s is not within the DHCP pool", n.name, "ipv4.dhcp.exclude", exclude.Start, exclude.End)
+			}
+		}
+	}
+
+	_, ipv6Net, _ := net.ParseCIDR(config["ipv6.address"])
+	ipv6Ranges, err := parseDHCPv6Ranges(config["ipv6.dhcp.ranges"], ipv6Net)
+	if err != nil {
+		return errors.Wrapf(err, "Invalid value for network %q option %q", n.name, "ipv6.dhcp.ranges")
+	}
+
+	rangeA, rangeB, overlap = dhcpRangesOverlap(ipv6Ranges)
+	if overlap {
+		return fmt.Errorf("Invalid value for network %q option %q: IP range %s-%s overlaps with %s-%s", n.name, "ipv6.dhcp.ranges", rangeA.Start, rangeA.End, rangeB.Start, rangeB.End)
+	}
+
+	// Check IP filtering is only enabled when the instances' addresses are known.
+	err = n.validateSecurityFiltering(config)
+	if err != nil {
+		return err
+	}
+
+	// Check the external static route gateways are within the network's subnets.
+	_, err = staticRoutes(config)
+	if err != nil {
+		return errors.Wrapf(err, "Invalid value for network %q", n.name)
+	}
+
+	// Check the DHCPv6 settings used for router advertisements are consistent.
+	if shared.IsTrue(config["ipv6.dhcp.stateful"]) && !hasDHCPv6(config) {
+		return fmt.Errorf("Invalid value for network %q option %q: Stateful DHCPv6 can't be enabled when %q is disabled", n.name, "ipv6.dhcp.stateful", "ipv6.dhcp")
+	}
+
+	// Check the DHCPv6 prefix delegation pool and delegated prefix length are consistent.
+	_, err = dhcpv6PDRanges(config)
+	if err != nil {
+		return errors.Wrapf(err, "Invalid value for network %q option %q", n.name, "ipv6.dhcp.pd.ranges")
+	}
+
+	_, err = dhcpv6PDPrefixLength(config)
+	if err != nil {
+		return errors.Wrapf(err, "Invalid value for network %q option %q", n.name, "ipv6.dhcp.pd.prefix_length")
+	}
+
+	// Check the custom DNS records are within the network's DNS domain.
+	records, err := dnsRecords(config)
+	if err != nil {
+		return errors.Wrapf(err, "Invalid value for network %q", n.name)
+	}
+
+	for _, record := range records {
+		if !dnsNameInDomain(record.Name, dnsDomain(config)) {
+			return fmt.Errorf("Invalid value for network %q option %q: Record name is not within the DNS domain %q", n.name, dnsRecordPrefix+record.Name, dnsDomain(config))
+		}
+	}
+
 	return nil
 }
 
+// ConfigSchema returns the schema of the bridge config keys.
+func (n *bridge) ConfigSchema() map[string]ConfigKeySchema {
+	// Rules are only added dynamically for keys in the config, so building the rules for an empty config can't fail.
+	rules, _ := n.rules(map[string]string{})
+
+	// Describe the dynamic keys using their templated names.
+	for key, rule := range bridgeTemplatedKeyRules {
+		rules[key] = rule
+	}
+
+	return n.common.configSchema(rules)
+}
+
+// ValidateWithWarnings validates the bridge config and returns any warnings about questionable but valid settings.
+func (n *bridge) ValidateWithWarnings(config map[string]string) ([]Warning, error) {
+	return n.common.validateWithWarnings(config, n.Validate, n.warningChecks())
+}
+
+// ValidateKey validates a single config key and value. Composite checks (such as DHCP range overlaps or fan mode
+// requirements) are performed when the full config is validated.
+func (n *bridge) ValidateKey(key string, value string) error {
+	rules, err := n.rules(map[string]string{key: value})
+	if err != nil {
+		return err
+	}
+
+	return n.validateKey(key, value, rules)
+}
+
 // isRunning returns whether the network is up.
 func (n *bridge) isRunning() bool {
 	return shared.PathExists(fmt.Sprintf("/sys/class/net/%s", n.name))
@@ -276,37 +680,21 @@ func (n *bridge) isRunning() bool {
 func (n *bridge) Delete(clusterNotification bool) error {
 	n.logger.Debug("Delete", log.Ctx{"clusterNotification": clusterNotification})
 
-	// Bring the network down.
-	if n.isRunning() {
-		err := n.Stop()
-		if err != nil {
-			return err
-		}
-	}
-
-	// Delete apparmor profiles.
-	err := apparmor.NetworkDelete(n.state, n)
+	err := n.validateNotFrozen(clusterNotification)
 	if err != nil {
 		return err
 	}
 
-	return n.common.delete(clusterNotification)
-}
-
-// Rename renames a network.
-func (n *bridge) Rename(newName string) error {
-	n.logger.Debug("Rename", log.Ctx{"newName": newName})
-
-	// Sanity checks.
-	inUse, err := n.IsUsed()
+	err = n.validateNotProtected(clusterNotification)
 	if err != nil {
 		return err
 	}
 
-	if inUse {
-		return fmt.Errorf("The network is currently in use")
-	}
+	return n.common.delete(clusterNotification, n.teardown)
+}
 
+// teardown brings the network down and removes its apparmor profiles.
+func (n *bridge) teardown() error {
 	// Bring the network down.
 	if n.isRunning() {
 		err := n.Stop()
@@ -315,21 +703,58 @@ func (n *bridge) Rename(newName string) error {
 		}
 	}
 
-	// Rename forkdns log file.
-	forkDNSLogPath := fmt.Sprintf("forkdns.%s.log", n.name)
-	if shared.PathExists(shared.LogPath(forkDNSLogPath)) {
-		err := os.Rename(forkDNSLogPath, shared.LogPath(fmt.Sprintf("forkdns.%s.log", newName)))
+	// Delete apparmor profiles (pending networks have not been created on this node so have none).
+	if !n.IsPending() {
+		err := apparmor.NetworkDelete(n.state, n)
 		if err != nil {
 			return err
 		}
 	}
 
-	// Rename common steps.
-	err = n.common.rename(newName)
+	return nil
+}
+
+// Rename renames a network. Accepts notification boolean indicating if this rename request is coming from a
+// cluster notification, in which case do not notify other nodes or update the database, just rename locally.
+func (n *bridge) Rename(newName string, clusterNotification bool) error {
+	n.logger.Debug("Rename", log.Ctx{"newName": newName, "clusterNotification": clusterNotification})
+
+	// Sanity checks.
+	err := n.validateNotFrozen(clusterNotification)
+	if err != nil {
+		return err
+	}
+
+	// Rename common steps, which checks the network isn't in use before it is brought down.
+	err = n.common.rename(newName, clusterNotification, func() error {
+		// Bring the network down.
+		if n.isRunning() {
+			err := n.Stop()
+			if err != nil {
+				return err
+			}
+		}
+
+		// Rename forkdns log file.
+		forkDNSLogPath := fmt.Sprintf("forkdns.%s.log", n.name)
+		if shared.PathExists(shared.LogPath(forkDNSLogPath)) {
+			err := os.Rename(forkDNSLogPath, shared.LogPath(fmt.Sprintf("forkdns.%s.log", newName)))
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
+	// Pending networks have not been created on this node, so there is nothing to bring up.
+	if n.IsPending() {
+		return nil
+	}
+
 	// Bring the network up.
 	err = n.Start()
 	if err != nil {
@@ -346,6 +771,8 @@ func (n *bridge) Start() error {
 
 // setup restarts the network.
 func (n *bridge) setup(oldConfig map[string]string) error {
+	config := n.currentConfig()
+
 	// If we are in mock mode, just no-op.
 	if n.state.OS.MockMode {
 		return nil
@@ -353,7 +780,7 @@ func (n *bridge) setup(oldConfig map[string]string) error {
 
 	n.logger.Debug("Setting up network")
 
-	if n.status == api.NetworkStatusPending {
+	if n.IsPending() {
 		return fmt.Errorf("Cannot start pending network")
 	}
 
@@ -367,7 +794,7 @@ func (n *bridge) setup(oldConfig map[string]string) error {
 
 	// Create the bridge interface
 	if !n.isRunning() {
-		if n.config["bridge.driver"] == "openvswitch" {
+		if config["bridge.driver"] == "openvswitch" {
 			ovs := openvswitch.NewOVS()
 			if !ovs.Installed() {
 				return fmt.Errorf("Open vSwitch isn't installed on this system")
@@ -388,8 +815,28 @@ func (n *bridge) setup(oldConfig map[string]string) error {
 	// Get a list of tunnels
 	tunnels := n.getTunnels()
 
+	// Check the MTU leaves room for the encapsulation overhead of the tunnels whose underlay MTU is known.
+	if config["bridge.mtu"] != "" {
+		mtu, err := strconv.ParseUint(config["bridge.mtu"], 10, 32)
+		if err != nil {
+			return err
+		}
+
+		maxMTU, limit, found := maxTunnelMTU(parseTunnels(config), tunnelUnderlayMTU)
+		if found && mtu > maxMTU {
+			underlayMTU, _ := tunnelUnderlayMTU(limit)
+			return fmt.Errorf("MTU %d is too large for tunnel %q (%s adds %d bytes to its underlay MTU of %d), the maximum safe MTU is %d", mtu, limit.Name, limit.Protocol, tunnelOverhead(limit), underlayMTU, maxMTU)
+		}
+	}
+
+	// Get the external static routes.
+	staticRoutes, err := n.StaticRoutes()
+	if err != nil {
+		return err
+	}
+
 	// IPv6 bridge configuration
-	if !shared.StringInSlice(n.config["ipv6.address"], []string{"", "none"}) {
+	if n.IPv6Enabled() {
 		if !shared.PathExists("/proc/sys/net/ipv6") {
 			return fmt.Errorf("Network has ipv6.address but kernel IPv6 support is missing")
 		}
@@ -423,12 +870,12 @@ func (n *bridge) setup(oldConfig map[string]string) error {
 
 	// Set the MTU
 	mtu := ""
-	if n.config["bridge.mtu"] != "" {
-		mtu = n.config["bridge.mtu"]
+	if config["bridge.mtu"] != "" {
+		mtu = config["bridge.mtu"]
 	} else if len(tunnels) > 0 {
 		mtu = "1400"
-	} else if n.config["bridge.mode"] == "fan" {
-		if n.config["fan.type"] == "ipip" {
+	} else if config["bridge.mode"] == "fan" {
+		if config["fan.type"] == "ipip" {
 			mtu = "1480"
 		} else {
 			mtu = "1450"
@@ -436,7 +883,7 @@ func (n *bridge) setup(oldConfig map[string]string) error {
 	}
 
 	// Attempt to add a dummy device to the bridge to force the MTU
-	if mtu != "" && n.config["bridge.driver"] != "openvswitch" {
+	if mtu != "" && config["bridge.driver"] != "openvswitch" {
 		_, err = shared.RunCommand("ip", "link", "add", "dev", fmt.Sprintf("%s-mtu", n.name), "mtu", mtu, "type", "dummy")
 		if err == nil {
 			_, err = shared.RunCommand("ip", "link", "set", "dev", fmt.Sprintf("%s-mtu", n.name), "up")
@@ -456,25 +903,64 @@ func (n *bridge) setup(oldConfig map[string]string) error {
 		return err
 	}
 
-	// Set the MAC address
-	if n.config["bridge.hwaddr"] != "" {
-		_, err = shared.RunCommand("ip", "link", "set", "dev", n.name, "address", n.config["bridge.hwaddr"])
+	// Set the MAC address if configured or previously generated.
+	hwAddr, err := n.BridgeHWAddr(false)
+	if err != nil {
+		return err
+	}
+
+	if hwAddr != nil {
+		_, err = shared.RunCommand("ip", "link", "set", "dev", n.name, "address", hwAddr.String())
 		if err != nil {
 			return err
 		}
 	}
 
 	// Enable VLAN filtering for Linux bridges.
-	if n.config["bridge.driver"] != "openvswitch" {
+	if config["bridge.driver"] != "openvswitch" {
 		err = BridgeVLANFilterSetStatus(n.name, "1")
 		if err != nil {
 			n.logger.Warn(fmt.Sprintf("%v", err))
 		}
 
-		// Set the default PVID for new ports to 1.
-		err = BridgeVLANSetDefaultPVID(n.name, "1")
+		// Set the default PVID for new ports to the native VLAN (or 1 if not configured).
+		vlanID, err := n.VLAN()
 		if err != nil {
-			n.logger.Warn(fmt.Sprintf("%v", err))
+			return err
+		}
+
+		if vlanID == 0 {
+			vlanID = 1
+		}
+
+		err = BridgeVLANSetDefaultPVID(n.name, fmt.Sprintf("%d", vlanID))
+		if err != nil {
+			n.logger.Warn(fmt.Sprintf("%v", err))
+		}
+
+		// Add the tagged VLAN memberships of the bridge interface itself, removing any that are no longer listed.
+		tagged, err := n.VLANTagged()
+		if err != nil {
+			return err
+		}
+
+		oldTagged, err := parseVLANList(oldConfig["bridge.vlan.tagged"])
+		if err != nil {
+			return err
+		}
+
+		for _, taggedID := range removedVLANs(oldTagged, tagged) {
+			_, err = shared.RunCommand("bridge", "vlan", "del", "dev", n.name, "vid", fmt.Sprintf("%d", taggedID), "self")
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, taggedID := range tagged {
+			_, err = shared.RunCommand("bridge", "vlan", "add", "dev", n.name, "vid", fmt.Sprintf("%d", taggedID), "self")
+			if err != nil {
+				return err
+			}
 		}
 	}
 
@@ -485,8 +971,8 @@ func (n *bridge) setup(oldConfig map[string]string) error {
 	}
 
 	// Add any listed existing external interface
-	if n.config["bridge.external_interfaces"] != "" {
-		for _, entry := range strings.Split(n.config["bridge.external_interfaces"], ",") {
+	if config["bridge.external_interfaces"] != "" {
+		for _, entry := range strings.Split(config["bridge.external_interfaces"], ",") {
 			entry = strings.TrimSpace(entry)
 			iface, err := net.InterfaceByName(entry)
 			if err != nil {
@@ -518,7 +1004,7 @@ func (n *bridge) setup(oldConfig map[string]string) error {
 	}
 
 	// Remove any existing IPv4 firewall rules.
-	if usesIPv4Firewall(n.config) || usesIPv4Firewall(oldConfig) {
+	if usesIPv4Firewall(config) || usesIPv4Firewall(oldConfig) {
 		err = n.state.Firewall.NetworkClear(n.name, 4)
 		if err != nil {
 			return err
@@ -544,7 +1030,7 @@ func (n *bridge) setup(oldConfig map[string]string) error {
 	}
 
 	// Configure IPv4 firewall (includes fan)
-	if n.config["bridge.mode"] == "fan" || !shared.StringInSlice(n.config["ipv4.address"], []string{"", "none"}) {
+	if config["bridge.mode"] == "fan" || n.IPv4Enabled() {
 		if n.HasDHCPv4() && n.hasIPv4Firewall() {
 			// Setup basic iptables overrides for DHCP/DNS
 			err = n.state.Firewall.NetworkSetupDHCPDNSAccess(n.name, 4)
@@ -562,7 +1048,7 @@ func (n *bridge) setup(oldConfig map[string]string) error {
 		}
 
 		// Allow forwarding
-		if n.config["bridge.mode"] == "fan" || n.config["ipv4.routing"] == "" || shared.IsTrue(n.config["ipv4.routing"]) {
+		if config["bridge.mode"] == "fan" || config["ipv4.routing"] == "" || shared.IsTrue(config["ipv4.routing"]) {
 			err = util.SysctlSet("net/ipv4/ip_forward", "1")
 			if err != nil {
 				return err
@@ -582,94 +1068,37 @@ func (n *bridge) setup(oldConfig map[string]string) error {
 				}
 			}
 		}
-	}
-
-	// Start building process using subprocess package
-	command := "dnsmasq"
-	dnsmasqCmd := []string{"--keep-in-foreground", "--strict-order", "--bind-interfaces",
-		"--except-interface=lo",
-		"--pid-file=", // Disable attempt at writing a PID file.
-		"--no-ping",   // --no-ping is very important to prevent delays to lease file updates.
-		fmt.Sprintf("--interface=%s", n.name)}
-
-	dnsmasqVersion, err := dnsmasq.GetVersion()
-	if err != nil {
-		return err
-	}
-
-	// --dhcp-rapid-commit option is only supported on >2.79
-	minVer, _ := version.NewDottedVersion("2.79")
-	if dnsmasqVersion.Compare(minVer) > 0 {
-		dnsmasqCmd = append(dnsmasqCmd, "--dhcp-rapid-commit")
-	}
-
-	if !daemon.Debug {
-		// --quiet options are only supported on >2.67
-		minVer, _ := version.NewDottedVersion("2.67")
 
-		if err == nil && dnsmasqVersion.Compare(minVer) > 0 {
-			dnsmasqCmd = append(dnsmasqCmd, []string{"--quiet-dhcp", "--quiet-dhcp6", "--quiet-ra"}...)
+		// Add the custom firewall rules after the generated ones so they are evaluated first.
+		err = n.setupFirewallRules("ipv4")
+		if err != nil {
+			return err
 		}
 	}
 
 	// Configure IPv4
-	if !shared.StringInSlice(n.config["ipv4.address"], []string{"", "none"}) {
+	if n.IPv4Enabled() {
 		// Parse the subnet
-		ip, subnet, err := net.ParseCIDR(n.config["ipv4.address"])
+		_, subnet, err := net.ParseCIDR(config["ipv4.address"])
 		if err != nil {
 			return err
 		}
 
-		// Update the dnsmasq config
-		dnsmasqCmd = append(dnsmasqCmd, fmt.Sprintf("--listen-address=%s", ip.String()))
-		if n.HasDHCPv4() {
-			if !shared.StringInSlice("--dhcp-no-override", dnsmasqCmd) {
-				dnsmasqCmd = append(dnsmasqCmd, []string{"--dhcp-no-override", "--dhcp-authoritative", fmt.Sprintf("--dhcp-leasefile=%s", shared.VarPath("networks", n.name, "dnsmasq.leases")), fmt.Sprintf("--dhcp-hostsfile=%s", shared.VarPath("networks", n.name, "dnsmasq.hosts"))}...)
-			}
-
-			if n.config["ipv4.dhcp.gateway"] != "" {
-				dnsmasqCmd = append(dnsmasqCmd, fmt.Sprintf("--dhcp-option-force=3,%s", n.config["ipv4.dhcp.gateway"]))
-			}
-
-			if mtu != "1500" {
-				dnsmasqCmd = append(dnsmasqCmd, fmt.Sprintf("--dhcp-option-force=26,%s", mtu))
-			}
-
-			dnsSearch := n.config["dns.search"]
-			if dnsSearch != "" {
-				dnsmasqCmd = append(dnsmasqCmd, fmt.Sprintf("--dhcp-option-force=119,%s", strings.Trim(dnsSearch, " ")))
-			}
-
-			expiry := "1h"
-			if n.config["ipv4.dhcp.expiry"] != "" {
-				expiry = n.config["ipv4.dhcp.expiry"]
-			}
-
-			if n.config["ipv4.dhcp.ranges"] != "" {
-				for _, dhcpRange := range strings.Split(n.config["ipv4.dhcp.ranges"], ",") {
-					dhcpRange = strings.TrimSpace(dhcpRange)
-					dnsmasqCmd = append(dnsmasqCmd, []string{"--dhcp-range", fmt.Sprintf("%s,%s", strings.Replace(dhcpRange, "-", ",", -1), expiry)}...)
-				}
-			} else {
-				dnsmasqCmd = append(dnsmasqCmd, []string{"--dhcp-range", fmt.Sprintf("%s,%s,%s", GetIP(subnet, 2).String(), GetIP(subnet, -2).String(), expiry)}...)
-			}
-		}
-
 		// Add the address
-		_, err = shared.RunCommand("ip", "-4", "addr", "add", "dev", n.name, n.config["ipv4.address"])
+		_, err = shared.RunCommand("ip", "-4", "addr", "add", "dev", n.name, config["ipv4.address"])
 		if err != nil {
 			return err
 		}
 
 		// Configure NAT
-		if shared.IsTrue(n.config["ipv4.nat"]) {
+		if shared.IsTrue(config["ipv4.nat"]) {
 			//If a SNAT source address is specified, use that, otherwise default to using MASQUERADE mode.
 			var srcIP net.IP
-			if n.config["ipv4.nat.address"] != "" {
-				srcIP = net.ParseIP(n.config["ipv4.nat.address"])
+			if config["ipv4.nat.address"] != "" {
+				srcIP = net.ParseIP(config["ipv4.nat.address"])
 			}
 
-			if n.config["ipv4.nat.order"] == "after" {
+			if config["ipv4.nat.order"] == "after" {
 				err = n.state.Firewall.NetworkSetupOutboundNAT(n.name, subnet, srcIP, true)
 				if err != nil {
 					return err
@@ -683,8 +1112,8 @@ func (n *bridge) setup(oldConfig map[string]string) error {
 		}
 
 		// Add additional routes
-		if n.config["ipv4.routes"] != "" {
-			for _, route := range strings.Split(n.config["ipv4.routes"], ",") {
+		if config["ipv4.routes"] != "" {
+			for _, route := range strings.Split(config["ipv4.routes"], ",") {
 				route = strings.TrimSpace(route)
 				_, err = shared.RunCommand("ip", "-4", "route", "add", "dev", n.name, route, "proto", "static")
 				if err != nil {
@@ -693,6 +1122,23 @@ func (n *bridge) setup(oldConfig map[string]string) error {
 			}
 		}
 
+		// Add external static routes.
+		for _, route := range staticRoutes {
+			if route.Destination.IP.To4() == nil {
+				continue
+			}
+
+			args := []string{"-4", "route", "add", "dev", n.name, route.Destination.String()}
+			if route.Gateway != nil {
+				args = append(args, "via", route.Gateway.String())
+			}
+
+			_, err = shared.RunCommand("ip", append(args, "proto", "static")...)
+			if err != nil {
+				return err
+			}
+		}
+
 		// Restore container specific IPv4 routes to interface.
 		err = n.applyBootRoutesV4(ctRoutes)
 		if err != nil {
@@ -701,7 +1147,7 @@ func (n *bridge) setup(oldConfig map[string]string) error {
 	}
 
 	// Remove any existing IPv6 firewall rules.
-	if usesIPv6Firewall(n.config) || usesIPv6Firewall(oldConfig) {
+	if usesIPv6Firewall(config) || usesIPv6Firewall(oldConfig) {
 		err = n.state.Firewall.NetworkClear(n.name, 6)
 		if err != nil {
 			return err
@@ -727,7 +1173,7 @@ func (n *bridge) setup(oldConfig map[string]string) error {
 	}
 
 	// Configure IPv6
-	if !shared.StringInSlice(n.config["ipv6.address"], []string{"", "none"}) {
+	if n.IPv6Enabled() {
 		// Enable IPv6 for the subnet
 		err := util.SysctlSet(fmt.Sprintf("net/ipv6/conf/%s/disable_ipv6", n.name), "0")
 		if err != nil {
@@ -735,7 +1181,7 @@ func (n *bridge) setup(oldConfig map[string]string) error {
 		}
 
 		// Parse the subnet
-		ip, subnet, err := net.ParseCIDR(n.config["ipv6.address"])
+		_, subnet, err := net.ParseCIDR(config["ipv6.address"])
 		if err != nil {
 			return err
 		}
@@ -745,45 +1191,19 @@ func (n *bridge) setup(oldConfig map[string]string) error {
 			n.logger.Warn("IPv6 networks with a prefix larger than 64 aren't properly supported by dnsmasq")
 		}
 
-		// Update the dnsmasq config
-		dnsmasqCmd = append(dnsmasqCmd, []string{fmt.Sprintf("--listen-address=%s", ip.String()), "--enable-ra"}...)
-		if n.HasDHCPv6() {
-			if n.config["ipv6.firewall"] == "" || shared.IsTrue(n.config["ipv6.firewall"]) {
+		raConfig := n.IPv6RAConfig()
+		if raConfig.Mode != RAModeSLAAC {
+			if config["ipv6.firewall"] == "" || shared.IsTrue(config["ipv6.firewall"]) {
 				// Setup basic iptables overrides for DHCP/DNS
 				err = n.state.Firewall.NetworkSetupDHCPDNSAccess(n.name, 6)
 				if err != nil {
 					return err
 				}
 			}
-
-			// Build DHCP configuration
-			if !shared.StringInSlice("--dhcp-no-override", dnsmasqCmd) {
-				dnsmasqCmd = append(dnsmasqCmd, []string{"--dhcp-no-override", "--dhcp-authoritative", fmt.Sprintf("--dhcp-leasefile=%s", shared.VarPath("networks", n.name, "dnsmasq.leases")), fmt.Sprintf("--dhcp-hostsfile=%s", shared.VarPath("networks", n.name, "dnsmasq.hosts"))}...)
-			}
-
-			expiry := "1h"
-			if n.config["ipv6.dhcp.expiry"] != "" {
-				expiry = n.config["ipv6.dhcp.expiry"]
-			}
-
-			if shared.IsTrue(n.config["ipv6.dhcp.stateful"]) {
-				if n.config["ipv6.dhcp.ranges"] != "" {
-					for _, dhcpRange := range strings.Split(n.config["ipv6.dhcp.ranges"], ",") {
-						dhcpRange = strings.TrimSpace(dhcpRange)
-						dnsmasqCmd = append(dnsmasqCmd, []string{"--dhcp-range", fmt.Sprintf("%s,%d,%s", strings.Replace(dhcpRange, "-", ",", -1), subnetSize, expiry)}...)
-					}
-				} else {
-					dnsmasqCmd = append(dnsmasqCmd, []string{"--dhcp-range", fmt.Sprintf("%s,%s,%d,%s", GetIP(subnet, 2), GetIP(subnet, -1), subnetSize, expiry)}...)
-				}
-			} else {
-				dnsmasqCmd = append(dnsmasqCmd, []string{"--dhcp-range", fmt.Sprintf("::,constructor:%s,ra-stateless,ra-names", n.name)}...)
-			}
-		} else {
-			dnsmasqCmd = append(dnsmasqCmd, []string{"--dhcp-range", fmt.Sprintf("::,constructor:%s,ra-only", n.name)}...)
 		}
 
 		// Allow forwarding
-		if n.config["ipv6.routing"] == "" || shared.IsTrue(n.config["ipv6.routing"]) {
+		if config["ipv6.routing"] == "" || shared.IsTrue(config["ipv6.routing"]) {
 			// Get a list of proc entries
 			entries, err := ioutil.ReadDir("/proc/sys/net/ipv6/conf/")
 			if err != nil {
@@ -811,14 +1231,14 @@ func (n *bridge) setup(oldConfig map[string]string) error {
 				}
 			}
 
-			if n.config["ipv6.firewall"] == "" || shared.IsTrue(n.config["ipv6.firewall"]) {
+			if config["ipv6.firewall"] == "" || shared.IsTrue(config["ipv6.firewall"]) {
 				err = n.state.Firewall.NetworkSetupForwardingPolicy(n.name, 6, true)
 				if err != nil {
 					return err
 				}
 			}
 		} else {
-			if n.config["ipv6.firewall"] == "" || shared.IsTrue(n.config["ipv6.firewall"]) {
+			if config["ipv6.firewall"] == "" || shared.IsTrue(config["ipv6.firewall"]) {
 				err = n.state.Firewall.NetworkSetupForwardingPolicy(n.name, 6, false)
 				if err != nil {
 					return err
@@ -826,20 +1246,26 @@ func (n *bridge) setup(oldConfig map[string]string) error {
 			}
 		}
 
+		// Add the custom firewall rules after the generated ones so they are evaluated first.
+		err = n.setupFirewallRules("ipv6")
+		if err != nil {
+			return err
+		}
+
 		// Add the address
-		_, err = shared.RunCommand("ip", "-6", "addr", "add", "dev", n.name, n.config["ipv6.address"])
+		_, err = shared.RunCommand("ip", "-6", "addr", "add", "dev", n.name, config["ipv6.address"])
 		if err != nil {
 			return err
 		}
 
 		// Configure NAT
-		if shared.IsTrue(n.config["ipv6.nat"]) {
+		if shared.IsTrue(config["ipv6.nat"]) {
 			var srcIP net.IP
-			if n.config["ipv6.nat.address"] != "" {
-				srcIP = net.ParseIP(n.config["ipv6.nat.address"])
+			if config["ipv6.nat.address"] != "" {
+				srcIP = net.ParseIP(config["ipv6.nat.address"])
 			}
 
-			if n.config["ipv6.nat.order"] == "after" {
+			if config["ipv6.nat.order"] == "after" {
 				err = n.state.Firewall.NetworkSetupOutboundNAT(n.name, subnet, srcIP, true)
 				if err != nil {
 					return err
@@ -853,8 +1279,8 @@ func (n *bridge) setup(oldConfig map[string]string) error {
 		}
 
 		// Add additional routes
-		if n.config["ipv6.routes"] != "" {
-			for _, route := range strings.Split(n.config["ipv6.routes"], ",") {
+		if config["ipv6.routes"] != "" {
+			for _, route := range strings.Split(config["ipv6.routes"], ",") {
 				route = strings.TrimSpace(route)
 				_, err = shared.RunCommand("ip", "-6", "route", "add", "dev", n.name, route, "proto", "static")
 				if err != nil {
@@ -863,6 +1289,23 @@ func (n *bridge) setup(oldConfig map[string]string) error {
 			}
 		}
 
+		// Add external static routes.
+		for _, route := range staticRoutes {
+			if route.Destination.IP.To4() != nil {
+				continue
+			}
+
+			args := []string{"-6", "route", "add", "dev", n.name, route.Destination.String()}
+			if route.Gateway != nil {
+				args = append(args, "via", route.Gateway.String())
+			}
+
+			_, err = shared.RunCommand("ip", append(args, "proto", "static")...)
+			if err != nil {
+				return err
+			}
+		}
+
 		// Restore container specific IPv6 routes to interface.
 		err = n.applyBootRoutesV6(ctRoutes)
 		if err != nil {
@@ -871,26 +1314,23 @@ func (n *bridge) setup(oldConfig map[string]string) error {
 	}
 
 	// Configure the fan
-	dnsClustered := false
-	dnsClusteredAddress := ""
-	var overlaySubnet *net.IPNet
-	if n.config["bridge.mode"] == "fan" {
+	if config["bridge.mode"] == "fan" {
 		tunName := fmt.Sprintf("%s-fan", n.name)
 
 		// Parse the underlay
-		underlay := n.config["fan.underlay_subnet"]
+		underlay := config["fan.underlay_subnet"]
 		_, underlaySubnet, err := net.ParseCIDR(underlay)
 		if err != nil {
 			return nil
 		}
 
 		// Parse the overlay
-		overlay := n.config["fan.overlay_subnet"]
+		overlay := config["fan.overlay_subnet"]
 		if overlay == "" {
 			overlay = "240.0.0.0/8"
 		}
 
-		_, overlaySubnet, err = net.ParseCIDR(overlay)
+		_, overlaySubnet, err := net.ParseCIDR(overlay)
 		if err != nil {
 			return err
 		}
@@ -902,7 +1342,7 @@ func (n *bridge) setup(oldConfig map[string]string) error {
 		}
 
 		addr := strings.Split(fanAddress, "/")
-		if n.config["fan.type"] == "ipip" {
+		if config["fan.type"] == "ipip" {
 			fanAddress = fmt.Sprintf("%s/24", addr[0])
 		}
 
@@ -910,7 +1350,7 @@ func (n *bridge) setup(oldConfig map[string]string) error {
 		fanMtuInt, err := GetDevMTU(devName)
 		if err == nil {
 			// Apply overhead
-			if n.config["fan.type"] == "ipip" {
+			if config["fan.type"] == "ipip" {
 				fanMtuInt = fanMtuInt - 20
 			} else {
 				fanMtuInt = fanMtuInt - 50
@@ -920,7 +1360,7 @@ func (n *bridge) setup(oldConfig map[string]string) error {
 			fanMtu := fmt.Sprintf("%d", fanMtuInt)
 			if fanMtu != mtu {
 				mtu = fanMtu
-				if n.config["bridge.driver"] != "openvswitch" {
+				if config["bridge.driver"] != "openvswitch" {
 					_, err = shared.RunCommand("ip", "link", "set", "dev", fmt.Sprintf("%s-mtu", n.name), "mtu", mtu)
 					if err != nil {
 						return err
@@ -934,33 +1374,14 @@ func (n *bridge) setup(oldConfig map[string]string) error {
 			}
 		}
 
-		// Parse the host subnet
-		_, hostSubnet, err := net.ParseCIDR(fmt.Sprintf("%s/24", addr[0]))
-		if err != nil {
-			return err
-		}
-
 		// Add the address
 		_, err = shared.RunCommand("ip", "-4", "addr", "add", "dev", n.name, fanAddress)
 		if err != nil {
 			return err
 		}
 
-		// Update the dnsmasq config
-		expiry := "1h"
-		if n.config["ipv4.dhcp.expiry"] != "" {
-			expiry = n.config["ipv4.dhcp.expiry"]
-		}
-
-		dnsmasqCmd = append(dnsmasqCmd, []string{
-			fmt.Sprintf("--listen-address=%s", addr[0]),
-			"--dhcp-no-override", "--dhcp-authoritative",
-			fmt.Sprintf("--dhcp-leasefile=%s", shared.VarPath("networks", n.name, "dnsmasq.leases")),
-			fmt.Sprintf("--dhcp-hostsfile=%s", shared.VarPath("networks", n.name, "dnsmasq.hosts")),
-			"--dhcp-range", fmt.Sprintf("%s,%s,%s", GetIP(hostSubnet, 2).String(), GetIP(hostSubnet, -2).String(), expiry)}...)
-
 		// Setup the tunnel
-		if n.config["fan.type"] == "ipip" {
+		if config["fan.type"] == "ipip" {
 			_, err = shared.RunCommand("ip", "-4", "route", "flush", "dev", "tunl0")
 			if err != nil {
 				return err
@@ -1003,8 +1424,8 @@ func (n *bridge) setup(oldConfig map[string]string) error {
 		}
 
 		// Configure NAT
-		if n.config["ipv4.nat"] == "" || shared.IsTrue(n.config["ipv4.nat"]) {
-			if n.config["ipv4.nat.order"] == "after" {
+		if config["ipv4.nat"] == "" || shared.IsTrue(config["ipv4.nat"]) {
+			if config["ipv4.nat.order"] == "after" {
 				err = n.state.Firewall.NetworkSetupOutboundNAT(n.name, overlaySubnet, nil, true)
 				if err != nil {
 					return err
@@ -1016,28 +1437,12 @@ func (n *bridge) setup(oldConfig map[string]string) error {
 				}
 			}
 		}
-
-		// Setup clustered DNS
-		clusterAddress, err := node.ClusterAddress(n.state.Node)
-		if err != nil {
-			return err
-		}
-
-		// If clusterAddress is non-empty, this indicates the intention for this node to be
-		// part of a cluster and so we should ensure that dnsmasq and forkdns are started
-		// in cluster mode. Note: During LXD initialisation the cluster may not actually be
-		// setup yet, but we want the DNS processes to be ready for when it is.
-		if clusterAddress != "" {
-			dnsClustered = true
-		}
-
-		dnsClusteredAddress = strings.Split(fanAddress, "/")[0]
 	}
 
 	// Configure tunnels
 	for _, tunnel := range tunnels {
 		getConfig := func(key string) string {
-			return n.config[fmt.Sprintf("tunnel.%s.%s", tunnel, key)]
+			return config[fmt.Sprintf("tunnel.%s.%s", tunnel, key)]
 		}
 
 		tunProtocol := getConfig("protocol")
@@ -1131,120 +1536,241 @@ func (n *bridge) setup(oldConfig map[string]string) error {
 		return err
 	}
 
-	// Kill any existing dnsmasq and forkdns daemon for this network
-	err = dnsmasq.Kill(n.name, false)
+	// Start dnsmasq and forkdns (if needed).
+	err = n.startDnsmasq()
 	if err != nil {
 		return err
 	}
 
-	err = n.killForkDNS()
+	// Apply changes to the anti-spoofing filtering settings to the running instances.
+	err = n.setupInstanceFilters(oldConfig)
 	if err != nil {
 		return err
 	}
 
-	// Configure dnsmasq
-	if n.config["bridge.mode"] == "fan" || !shared.StringInSlice(n.config["ipv4.address"], []string{"", "none"}) || !shared.StringInSlice(n.config["ipv6.address"], []string{"", "none"}) {
-		// Setup the dnsmasq domain
-		dnsDomain := n.config["dns.domain"]
-		if dnsDomain == "" {
-			dnsDomain = "lxd"
+	return nil
+}
+
+// dnsmasqArgs returns the dnsmasq command line arguments for the bridge's current config. The bridge interface must
+// already be set up, as the MTU advertised to DHCP clients is read from it and a fan bridge's address is derived
+// from the host's address on the fan underlay. Also returns the address that forkdns should listen on if the
+// bridge's DNS is clustered, or an empty string if it isn't.
+func (n *bridge) dnsmasqArgs() ([]string, string, error) {
+	config := n.currentConfig()
+
+	devMTU, err := GetDevMTU(n.name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	mtu := fmt.Sprintf("%d", devMTU)
+
+	dnsmasqCmd := []string{"--keep-in-foreground", "--strict-order", "--bind-interfaces",
+		"--except-interface=lo",
+		"--pid-file=", // Disable attempt at writing a PID file.
+		"--no-ping",   // --no-ping is very important to prevent delays to lease file updates.
+		fmt.Sprintf("--interface=%s", n.name)}
+
+	dnsmasqVersion, err := dnsmasq.GetVersion()
+	if err != nil {
+		return nil, "", err
+	}
+
+	// --dhcp-rapid-commit option is only supported on >2.79
+	minVer, _ := version.NewDottedVersion("2.79")
+	if dnsmasqVersion.Compare(minVer) > 0 {
+		dnsmasqCmd = append(dnsmasqCmd, "--dhcp-rapid-commit")
+	}
+
+	if !daemon.Debug {
+		// --quiet options are only supported on >2.67
+		minVer, _ := version.NewDottedVersion("2.67")
+
+		if dnsmasqVersion.Compare(minVer) > 0 {
+			dnsmasqCmd = append(dnsmasqCmd, []string{"--quiet-dhcp", "--quiet-dhcp6", "--quiet-ra"}...)
+		}
+	}
+
+	dhcpArgs := []string{"--dhcp-no-override", "--dhcp-authoritative", fmt.Sprintf("--dhcp-leasefile=%s", shared.VarPath("networks", n.name, "dnsmasq.leases")), fmt.Sprintf("--dhcp-hostsfile=%s", shared.VarPath("networks", n.name, "dnsmasq.hosts"))}
+
+	// Configure IPv4
+	if n.IPv4Enabled() {
+		ip, subnet, err := net.ParseCIDR(config["ipv4.address"])
+		if err != nil {
+			return nil, "", err
 		}
 
-		if n.config["dns.mode"] != "none" {
-			if dnsClustered {
-				dnsmasqCmd = append(dnsmasqCmd, "-s", dnsDomain)
-				dnsmasqCmd = append(dnsmasqCmd, "-S", fmt.Sprintf("/%s/%s#1053", dnsDomain, dnsClusteredAddress))
-				dnsmasqCmd = append(dnsmasqCmd, fmt.Sprintf("--rev-server=%s,%s#1053", overlaySubnet, dnsClusteredAddress))
-			} else {
-				dnsmasqCmd = append(dnsmasqCmd, []string{"-s", dnsDomain, "-S", fmt.Sprintf("/%s/", dnsDomain)}...)
+		dnsmasqCmd = append(dnsmasqCmd, fmt.Sprintf("--listen-address=%s", ip.String()))
+		if n.HasDHCPv4() {
+			if !shared.StringInSlice("--dhcp-no-override", dnsmasqCmd) {
+				dnsmasqCmd = append(dnsmasqCmd, dhcpArgs...)
+			}
+
+			args, err := n.dnsmasqDHCPv4Args(subnet, mtu)
+			if err != nil {
+				return nil, "", err
 			}
+
+			dnsmasqCmd = append(dnsmasqCmd, args...)
 		}
+	}
 
-		// Create a config file to contain additional config (and to prevent dnsmasq from reading /etc/dnsmasq.conf)
-		err = ioutil.WriteFile(shared.VarPath("networks", n.name, "dnsmasq.raw"), []byte(fmt.Sprintf("%s\n", n.config["raw.dnsmasq"])), 0644)
+	// Configure IPv6
+	if n.IPv6Enabled() {
+		ip, subnet, err := net.ParseCIDR(config["ipv6.address"])
 		if err != nil {
-			return err
+			return nil, "", err
+		}
+
+		raConfig := n.IPv6RAConfig()
+
+		dnsmasqCmd = append(dnsmasqCmd, []string{fmt.Sprintf("--listen-address=%s", ip.String()), "--enable-ra"}...)
+		if raConfig.Mode != RAModeSLAAC && !shared.StringInSlice("--dhcp-no-override", dnsmasqCmd) {
+			dnsmasqCmd = append(dnsmasqCmd, dhcpArgs...)
 		}
-		dnsmasqCmd = append(dnsmasqCmd, fmt.Sprintf("--conf-file=%s", shared.VarPath("networks", n.name, "dnsmasq.raw")))
 
-		// Attempt to drop privileges
-		if n.state.OS.UnprivUser != "" {
-			dnsmasqCmd = append(dnsmasqCmd, []string{"-u", n.state.OS.UnprivUser}...)
+		args, err := n.dnsmasqDHCPv6Args(subnet, raConfig)
+		if err != nil {
+			return nil, "", err
 		}
 
-		// Create DHCP hosts directory
-		if !shared.PathExists(shared.VarPath("networks", n.name, "dnsmasq.hosts")) {
-			err = os.MkdirAll(shared.VarPath("networks", n.name, "dnsmasq.hosts"), 0755)
-			if err != nil {
-				return err
-			}
+		dnsmasqCmd = append(dnsmasqCmd, args...)
+	}
+
+	// Configure the fan
+	forkdnsAddress := ""
+	var overlaySubnet *net.IPNet
+	if config["bridge.mode"] == "fan" {
+		_, underlaySubnet, err := net.ParseCIDR(config["fan.underlay_subnet"])
+		if err != nil {
+			return nil, "", err
+		}
+
+		overlay := config["fan.overlay_subnet"]
+		if overlay == "" {
+			overlay = "240.0.0.0/8"
 		}
 
-		// Check for dnsmasq
-		_, err := exec.LookPath("dnsmasq")
+		_, overlaySubnet, err = net.ParseCIDR(overlay)
 		if err != nil {
-			return fmt.Errorf("dnsmasq is required for LXD managed bridges")
+			return nil, "", err
 		}
 
-		// Update the static leases
-		err = UpdateDNSMasqStatic(n.state, n.name)
+		fanAddress, _, _, err := n.fanAddress(underlaySubnet, overlaySubnet)
 		if err != nil {
-			return err
+			return nil, "", err
 		}
 
-		// Create subprocess object dnsmasq.
-		p, err := subprocess.NewProcess(command, dnsmasqCmd, "", "")
+		addr := strings.Split(fanAddress, "/")[0]
+		_, hostSubnet, err := net.ParseCIDR(fmt.Sprintf("%s/24", addr))
 		if err != nil {
-			return fmt.Errorf("Failed to create subprocess: %s", err)
+			return nil, "", err
 		}
 
-		// Apply AppArmor confinement.
-		if n.config["raw.dnsmasq"] == "" {
-			p.SetApparmor(apparmor.DnsmasqProfileName(n))
-		} else {
-			n.logger.Warn("Skipping AppArmor for dnsmasq due to raw.dnsmasq being set", log.Ctx{"name": n.name})
+		expiryTime, err := n.DHCPv4ExpiryTime()
+		if err != nil {
+			return nil, "", err
 		}
 
-		// Start dnsmasq.
-		err = p.Start()
+		dnsmasqCmd = append(dnsmasqCmd, fmt.Sprintf("--listen-address=%s", addr))
+		dnsmasqCmd = append(dnsmasqCmd, dhcpArgs...)
+		dnsmasqCmd = append(dnsmasqCmd, "--dhcp-range", fmt.Sprintf("%s,%s,%s", GetIP(hostSubnet, 2).String(), GetIP(hostSubnet, -2).String(), dnsmasqLeaseTime(expiryTime)))
+
+		// If the cluster address is set, this indicates the intention for this node to be part of a cluster
+		// and so we should ensure that dnsmasq and forkdns are started in cluster mode. Note: During LXD
+		// initialisation the cluster may not actually be setup yet, but we want the DNS processes to be ready
+		// for when it is.
+		clusterAddress, err := node.ClusterAddress(n.state.Node)
 		if err != nil {
-			return fmt.Errorf("Failed to run: %s %s: %v", command, strings.Join(dnsmasqCmd, " "), err)
+			return nil, "", err
+		}
+
+		if clusterAddress != "" {
+			forkdnsAddress = addr
+		}
+	}
+
+	// Setup the dnsmasq domain
+	dnsDomain := n.DNSDomain()
+
+	if n.HasDNS() {
+		if forkdnsAddress != "" {
+			dnsmasqCmd = append(dnsmasqCmd, "-s", dnsDomain)
+			dnsmasqCmd = append(dnsmasqCmd, "-S", fmt.Sprintf("/%s/%s#1053", dnsDomain, forkdnsAddress))
+			dnsmasqCmd = append(dnsmasqCmd, fmt.Sprintf("--rev-server=%s,%s#1053", overlaySubnet, forkdnsAddress))
+		} else {
+			dnsmasqCmd = append(dnsmasqCmd, []string{"-s", dnsDomain, "-S", fmt.Sprintf("/%s/", dnsDomain)}...)
 		}
 
-		err = p.Save(shared.VarPath("networks", n.name, "dnsmasq.pid"))
+		// Forward queries to the configured upstream nameservers rather than the host's resolvers.
+		upstreams, err := n.DNSUpstreams()
 		if err != nil {
-			// Kill Process if started, but could not save the file
-			err2 := p.Stop()
-			if err != nil {
-				return fmt.Errorf("Could not kill subprocess while handling saving error: %s: %s", err, err2)
+			return nil, "", err
+		}
+
+		if len(upstreams) > 0 {
+			dnsmasqCmd = append(dnsmasqCmd, "--no-resolv")
+			for _, upstream := range upstreams {
+				dnsmasqCmd = append(dnsmasqCmd, fmt.Sprintf("--server=%s", upstream.String()))
 			}
+		}
 
-			return fmt.Errorf("Failed to save subprocess details: %s", err)
+		// Add the custom DNS records.
+		dnsRecords, err := n.DNSRecords()
+		if err != nil {
+			return nil, "", err
 		}
 
-		// Spawn DNS forwarder if needed (backgrounded to avoid deadlocks during cluster boot)
-		if dnsClustered {
-			// Create forkdns servers directory
-			if !shared.PathExists(shared.VarPath("networks", n.name, ForkdnsServersListPath)) {
-				err = os.MkdirAll(shared.VarPath("networks", n.name, ForkdnsServersListPath), 0755)
-				if err != nil {
-					return err
-				}
+		for _, record := range dnsRecords {
+			if record.Type == "CNAME" {
+				dnsmasqCmd = append(dnsmasqCmd, fmt.Sprintf("--cname=%s,%s", record.Name, strings.TrimSuffix(record.Value, ".")))
+			} else {
+				dnsmasqCmd = append(dnsmasqCmd, fmt.Sprintf("--host-record=%s,%s", record.Name, record.Value))
 			}
+		}
+	}
 
-			// Create forkdns servers.conf file if doesn't exist
-			f, err := os.OpenFile(shared.VarPath("networks", n.name, ForkdnsServersListPath+"/"+ForkdnsServersListFile), os.O_RDONLY|os.O_CREATE, 0666)
-			if err != nil {
-				return err
-			}
-			f.Close()
+	// In strict mode only clients known from the hosts file (those with a static lease) are answered.
+	if n.DHCPStrictMode() && shared.StringInSlice("--dhcp-no-override", dnsmasqCmd) {
+		dnsmasqCmd = append(dnsmasqCmd, "--dhcp-ignore=tag:!known")
 
-			err = n.spawnForkDNS(dnsClusteredAddress)
-			if err != nil {
-				return err
-			}
+		staticLeases, err := n.DHCPv4StaticLeases()
+		if err != nil {
+			n.logger.Warn("Failed checking static DHCP leases for strict mode", log.Ctx{"err": err})
+		} else if len(staticLeases) == 0 {
+			n.logger.Warn("DHCP strict mode is enabled but no static leases are defined, no client will get an address")
 		}
-	} else {
+	}
+
+	// Use a config file to contain additional config (and to prevent dnsmasq from reading /etc/dnsmasq.conf)
+	dnsmasqCmd = append(dnsmasqCmd, fmt.Sprintf("--conf-file=%s", shared.VarPath("networks", n.name, "dnsmasq.raw")))
+
+	// Attempt to drop privileges
+	if n.state.OS.UnprivUser != "" {
+		dnsmasqCmd = append(dnsmasqCmd, []string{"-u", n.state.OS.UnprivUser}...)
+	}
+
+	return dnsmasqCmd, forkdnsAddress, nil
+}
+
+// startDnsmasq (re)starts the bridge's dnsmasq (and forkdns if the bridge's DNS is clustered) with the bridge's
+// current config, without otherwise changing the bridge. If the bridge has no addresses then they are just stopped
+// and the old leases and PID files are removed.
+func (n *bridge) startDnsmasq() error {
+	config := n.currentConfig()
+
+	// Kill any existing dnsmasq and forkdns daemon for this network
+	err := dnsmasq.Kill(n.name, false)
+	if err != nil {
+		return err
+	}
+
+	err = n.killForkDNS()
+	if err != nil {
+		return err
+	}
+
+	if config["bridge.mode"] != "fan" && !n.IPv4Enabled() && !n.IPv6Enabled() {
 		// Clean up old dnsmasq config if exists and we are not starting dnsmasq.
 		leasesPath := shared.VarPath("networks", n.name, "dnsmasq.leases")
 		if shared.PathExists(leasesPath) {
@@ -1262,6 +1788,142 @@ func (n *bridge) setup(oldConfig map[string]string) error {
 				return errors.Wrapf(err, "Failed to remove old dnsmasq pid file '%s'", pidPath)
 			}
 		}
+
+		return nil
+	}
+
+	command := "dnsmasq"
+	dnsmasqCmd, forkdnsAddress, err := n.dnsmasqArgs()
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(shared.VarPath("networks", n.name, "dnsmasq.raw"), []byte(fmt.Sprintf("%s\n", config["raw.dnsmasq"])), 0644)
+	if err != nil {
+		return err
+	}
+
+	// Create DHCP hosts directory
+	if !shared.PathExists(shared.VarPath("networks", n.name, "dnsmasq.hosts")) {
+		err = os.MkdirAll(shared.VarPath("networks", n.name, "dnsmasq.hosts"), 0755)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Check for dnsmasq
+	_, err = exec.LookPath("dnsmasq")
+	if err != nil {
+		return fmt.Errorf("dnsmasq is required for LXD managed bridges")
+	}
+
+	// Update the static leases
+	err = UpdateDNSMasqStatic(n.state, n.name)
+	if err != nil {
+		return err
+	}
+
+	// Create subprocess object dnsmasq.
+	p, err := subprocess.NewProcess(command, dnsmasqCmd, "", "")
+	if err != nil {
+		return fmt.Errorf("Failed to create subprocess: %s", err)
+	}
+
+	// Apply AppArmor confinement.
+	if config["raw.dnsmasq"] == "" {
+		p.SetApparmor(apparmor.DnsmasqProfileName(n))
+	} else {
+		n.logger.Warn("Skipping AppArmor for dnsmasq due to raw.dnsmasq being set", log.Ctx{"name": n.name})
+	}
+
+	// Start dnsmasq.
+	err = p.Start()
+	if err != nil {
+		return fmt.Errorf("Failed to run: %s %s: %v", command, strings.Join(dnsmasqCmd, " "), err)
+	}
+
+	err = p.Save(shared.VarPath("networks", n.name, "dnsmasq.pid"))
+	if err != nil {
+		// Kill Process if started, but could not save the file
+		err2 := p.Stop()
+		if err != nil {
+			return fmt.Errorf("Could not kill subprocess while handling saving error: %s: %s", err, err2)
+		}
+
+		return fmt.Errorf("Failed to save subprocess details: %s", err)
+	}
+
+	// Spawn DNS forwarder if needed (backgrounded to avoid deadlocks during cluster boot)
+	if forkdnsAddress != "" {
+		// Create forkdns servers directory
+		if !shared.PathExists(shared.VarPath("networks", n.name, ForkdnsServersListPath)) {
+			err = os.MkdirAll(shared.VarPath("networks", n.name, ForkdnsServersListPath), 0755)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Create forkdns servers.conf file if doesn't exist
+		f, err := os.OpenFile(shared.VarPath("networks", n.name, ForkdnsServersListPath+"/"+ForkdnsServersListFile), os.O_RDONLY|os.O_CREATE, 0666)
+		if err != nil {
+			return err
+		}
+		f.Close()
+
+		err = n.spawnForkDNS(forkdnsAddress)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setupInstanceFilters updates the MAC and IP anti-spoofing filters of the running instance NICs connected to the
+// bridge when the network's filtering settings have changed. NICs apply the network's settings themselves when
+// they start, so nothing is done when there is no old config. NICs that set all of the filtering options
+// themselves are left alone. The filters are updated by the NIC device, failures are logged and don't fail the
+// network update.
+func (n *bridge) setupInstanceFilters(oldConfig map[string]string) error {
+	if oldConfig == nil {
+		return nil
+	}
+
+	mac, ipv4, ipv6 := n.SecurityFiltering()
+	oldMAC, oldIPv4, oldIPv6 := securityFiltering(oldConfig)
+	if mac == oldMAC && ipv4 == oldIPv4 && ipv6 == oldIPv6 {
+		return nil
+	}
+
+	insts, err := instance.LoadNodeAll(n.state, instancetype.Any)
+	if err != nil {
+		return err
+	}
+
+	for _, inst := range insts {
+		if !inst.IsRunning() {
+			continue
+		}
+
+		for devName, dev := range inst.ExpandedDevices() {
+			if dev["type"] != "nic" || (dev["security.mac_filtering"] != "" && dev["security.ipv4_filtering"] != "" && dev["security.ipv6_filtering"] != "") {
+				continue
+			}
+
+			inUse, err := isInUseByDevices(n.state, deviceConfig.Devices{devName: dev}, n.name)
+			if err != nil {
+				return err
+			}
+
+			if !inUse {
+				continue
+			}
+
+			err = UpdateInstanceNICFilters(n.state, inst, devName, oldConfig)
+			if err != nil {
+				n.logger.Error("Failed updating instance filters", log.Ctx{"project": inst.Project(), "instance": inst.Name(), "device": devName, "err": err})
+			}
+		}
 	}
 
 	return nil
@@ -1269,12 +1931,16 @@ func (n *bridge) setup(oldConfig map[string]string) error {
 
 // Stop stops the network.
 func (n *bridge) Stop() error {
+	config := n.currentConfig()
+
 	if !n.isRunning() {
 		return nil
 	}
 
-	// Destroy the bridge interface
-	if n.config["bridge.driver"] == "openvswitch" {
+	// Destroy the bridge interface (unless it was imported, in which case it is left for the host to manage).
+	if n.imported() {
+		n.logger.Debug("Leaving imported bridge interface in place")
+	} else if config["bridge.driver"] == "openvswitch" {
 		ovs := openvswitch.NewOVS()
 		err := ovs.BridgeDelete(n.name)
 		if err != nil {
@@ -1288,14 +1954,14 @@ func (n *bridge) Stop() error {
 	}
 
 	// Cleanup firewall rules.
-	if usesIPv4Firewall(n.config) {
+	if usesIPv4Firewall(config) {
 		err := n.state.Firewall.NetworkClear(n.name, 4)
 		if err != nil {
 			return err
 		}
 	}
 
-	if usesIPv6Firewall(n.config) {
+	if usesIPv6Firewall(config) {
 		err := n.state.Firewall.NetworkClear(n.name, 6)
 		if err != nil {
 			return err
@@ -1341,8 +2007,16 @@ func (n *bridge) Stop() error {
 // Update updates the network. Accepts notification boolean indicating if this update request is coming from a
 // cluster notification, in which case do not update the database, just apply local changes needed.
 func (n *bridge) Update(newNetwork api.NetworkPut, targetNode string, clusterNotification bool) error {
+	return n.update(newNetwork, targetNode, clusterNotification, nil)
+}
+
+// update applies the new network like Update. If expectedConfig isn't nil then the network is only updated if
+// its config in the database still matches expectedConfig, otherwise ErrConfigConflict is returned.
+func (n *bridge) update(newNetwork api.NetworkPut, targetNode string, clusterNotification bool, expectedConfig map[string]string) error {
 	n.logger.Debug("Update", log.Ctx{"clusterNotification": clusterNotification, "newNetwork": newNetwork})
 
+	newNetwork = n.preserveVolatileKeys(newNetwork)
+
 	// When switching to a fan bridge, auto-detect the underlay if not specified.
 	if newNetwork.Config["bridge.mode"] == "fan" {
 		if newNetwork.Config["fan.underlay_subnet"] == "" {
@@ -1356,7 +2030,17 @@ func (n *bridge) Update(newNetwork api.NetworkPut, targetNode string, clusterNot
 		return err
 	}
 
-	dbUpdateNeeeded, changedKeys, oldNetwork, err := n.common.configChanged(newNetwork)
+	err = n.normalizeIPv4Address(newNetwork.Config)
+	if err != nil {
+		return err
+	}
+
+	err = n.fillIPv6Address(newNetwork.Config)
+	if err != nil {
+		return err
+	}
+
+	dbUpdateNeeeded, changedKeys, _, oldNetwork, err := n.common.configChanged(newNetwork)
 	if err != nil {
 		return err
 	}
@@ -1365,16 +2049,48 @@ func (n *bridge) Update(newNetwork api.NetworkPut, targetNode string, clusterNot
 		return nil // Nothing changed.
 	}
 
+	err = n.validateUpdateNotFrozen(newNetwork, clusterNotification)
+	if err != nil {
+		return err
+	}
+
+	// Pending networks have not been created on this node, so only apply changes to database.
+	if n.IsPending() {
+		err = n.common.update(newNetwork, targetNode, clusterNotification, expectedConfig)
+		if err != nil {
+			return err
+		}
+
+		n.common.updated(changedKeys, clusterNotification)
+
+		return nil
+	}
+
+	restartRequired := n.ChangeRequiresRestart(changedKeys)
+
 	revert := revert.New()
 	defer revert.Fail()
 
+	// Only restore the database if it still has the config stored by a conditional update.
+	var revertConfig map[string]string
+	if expectedConfig != nil {
+		revertConfig = newNetwork.Config
+	}
+
 	// Define a function which reverts everything.
 	revert.Add(func() {
 		// Reset changes to all nodes and database.
-		n.common.update(oldNetwork, targetNode, clusterNotification)
+		err := n.common.update(oldNetwork, targetNode, clusterNotification, revertConfig)
+		if err != nil {
+			n.logger.Error("Failed reverting network config", log.Ctx{"err": err})
+		}
 
 		// Reset any change that was made to local bridge.
-		n.setup(newNetwork.Config)
+		if restartRequired {
+			n.setup(newNetwork.Config)
+		} else {
+			n.applyLiveChanges(newNetwork.Config, changedKeys)
+		}
 	})
 
 	// Bring the bridge down entirely if the driver has changed.
@@ -1408,30 +2124,69 @@ func (n *bridge) Update(newNetwork api.NetworkPut, targetNode string, clusterNot
 		}
 	}
 
-	// Apply changes to database.
-	err = n.common.update(newNetwork, targetNode, clusterNotification)
-	if err != nil {
-		return err
+	// Apply changes to database.
+	err = n.common.update(newNetwork, targetNode, clusterNotification, expectedConfig)
+	if err != nil {
+		return err
+	}
+
+	// Restart the network if needed, otherwise apply the changes live.
+	if restartRequired {
+		n.logger.Info("Restarting network to apply config changes", log.Ctx{"keys": changedKeys})
+
+		err = n.setup(oldNetwork.Config)
+		if err != nil {
+			return err
+		}
+	} else if len(changedKeys) > 0 {
+		n.logger.Info("Applying config changes without restarting network", log.Ctx{"keys": changedKeys})
+
+		err = n.applyLiveChanges(oldNetwork.Config, changedKeys)
+		if err != nil {
+			return err
+		}
+	}
+
+	revert.Success()
+	n.common.updated(changedKeys, clusterNotification)
+
+	return nil
+}
+
+// Patch merges the supplied config keys into the network's config (a key with an empty value is removed) and
+// applies the result using Update.
+func (n *bridge) Patch(applyNetwork api.NetworkPut, targetNode string, clusterNotification bool) error {
+	return n.common.patch(applyNetwork, func(newNetwork api.NetworkPut) error {
+		return n.Update(newNetwork, targetNode, clusterNotification)
+	})
+}
+
+// applyLiveChanges applies changes to the config keys that don't require the bridge to be restarted. dnsmasq is
+// restarted if any of its settings have changed and the filters of the running instance NICs are updated if the
+// security filtering settings have changed.
+func (n *bridge) applyLiveChanges(oldConfig map[string]string, changedKeys []string) error {
+	// If we are in mock mode or the bridge isn't up, there is nothing to apply.
+	if n.state.OS.MockMode || !n.isRunning() {
+		return nil
 	}
 
-	// Restart the network if needed.
-	if len(changedKeys) > 0 {
-		err = n.setup(oldNetwork.Config)
-		if err != nil {
-			return err
+	for _, k := range changedKeys {
+		if configKeyMatches(k, dnsmasqKeys) {
+			err := n.startDnsmasq()
+			if err != nil {
+				return err
+			}
+
+			break
 		}
 	}
 
-	revert.Success()
-	return nil
+	return n.setupInstanceFilters(oldConfig)
 }
 
 func (n *bridge) spawnForkDNS(listenAddress string) error {
 	// Setup the dnsmasq domain
-	dnsDomain := n.config["dns.domain"]
-	if dnsDomain == "" {
-		dnsDomain = "lxd"
-	}
+	dnsDomain := n.DNSDomain()
 
 	// Spawn the daemon using subprocess
 	command := n.state.OS.ExecPath
@@ -1526,21 +2281,288 @@ func (n *bridge) HandleHeartbeat(heartbeatData *cluster.APIHeartbeat) error {
 	return nil
 }
 
-func (n *bridge) getTunnels() []string {
-	tunnels := []string{}
+// FlushDHCPLeases removes the network's dynamic DHCP leases, keeping those of instance NICs with a static address.
+// dnsmasq holds its leases in memory, so if it's running it is stopped before the lease file is changed and then
+// started again, with the existing leases if flushing fails. Running instances keep their addresses until they next
+// renew their lease.
+func (n *bridge) FlushDHCPLeases() error {
+	if n.IsPending() {
+		return fmt.Errorf("Cannot flush the DHCP leases of a pending network")
+	}
+
+	running := false
+	p, err := subprocess.ImportProcess(shared.VarPath("networks", n.name, "dnsmasq.pid"))
+	if err == nil {
+		_, err = p.GetPid()
+		running = err == nil
+	}
+
+	revert := revert.New()
+	defer revert.Fail()
+
+	if running {
+		err = dnsmasq.Kill(n.name, false)
+		if err != nil {
+			return err
+		}
+
+		// Make sure dnsmasq is running again if the leases can't be flushed.
+		revert.Add(func() {
+			err := n.startDnsmasq()
+			if err != nil {
+				n.logger.Error("Failed restarting dnsmasq", log.Ctx{"err": err})
+			}
+		})
+	}
+
+	err = n.common.flushDHCPLeases()
+	if err != nil {
+		return err
+	}
+
+	n.logger.Info("Flushed DHCP leases")
+
+	// Only dnsmasq needs restarting to pick up the flushed lease file.
+	if running {
+		err = n.startDnsmasq()
+		if err != nil {
+			return err
+		}
+	}
+
+	revert.Success()
+	return nil
+}
+
+// ReserveDHCPv4IP reserves the supplied IP so that it isn't allocated using DHCP and applies the change.
+func (n *bridge) ReserveDHCPv4IP(ip net.IP, comment string) error {
+	newNetwork, err := n.common.reserveDHCPv4IP(ip, comment)
+	if err != nil {
+		return err
+	}
+
+	return n.Update(newNetwork, "", false)
+}
+
+// ReleaseDHCPv4IP removes the reservation for the supplied IP and applies the change.
+func (n *bridge) ReleaseDHCPv4IP(ip net.IP) error {
+	newNetwork, err := n.common.releaseDHCPv4IP(ip)
+	if err != nil {
+		return err
+	}
+
+	return n.Update(newNetwork, "", false)
+}
+
+// SetKeys merges the supplied config keys into the network's config, validates the result and applies it.
+func (n *bridge) SetKeys(changes map[string]string, targetNode string) error {
+	return n.common.setKeys(changes, targetNode, func(newNetwork api.NetworkPut, expectedConfig map[string]string) error {
+		return n.update(newNetwork, targetNode, false, expectedConfig)
+	})
+}
+
+// CompareAndUpdate applies the new network config only if the network's current config matches the expected one.
+func (n *bridge) CompareAndUpdate(expected api.NetworkPut, newNetwork api.NetworkPut, ignoreUserKeys bool) error {
+	return n.common.compareAndUpdate(expected, newNetwork, ignoreUserKeys, func(newNetwork api.NetworkPut, expectedConfig map[string]string) error {
+		return n.update(newNetwork, "", false, expectedConfig)
+	})
+}
 
-	for k := range n.config {
-		if !strings.HasPrefix(k, "tunnel.") {
+// SetMaintenance enables or disables maintenance mode and applies the change. In maintenance mode no new DHCP
+// leases are handed out, while static leases and the rest of the network keep working.
+func (n *bridge) SetMaintenance(on bool) error {
+	return n.Update(n.common.setMaintenance(on), "", false)
+}
+
+// Renumber moves the bridge to the subnet of the supplied CIDR address, optionally shifting its DHCP ranges into
+// the new subnet, and moves the static addresses of the instance NICs connected to it to match.
+func (n *bridge) Renumber(newCIDR string, shiftRanges bool) error {
+	return n.common.renumber(newCIDR, shiftRanges, func(newNetwork api.NetworkPut) error {
+		return n.Update(newNetwork, "", false)
+	})
+}
+
+// RestoreSnapshot rolls the bridge back to the config stored in the named snapshot and applies it.
+func (n *bridge) RestoreSnapshot(name string) error {
+	newNetwork, err := n.common.restoreSnapshot(name)
+	if err != nil {
+		return err
+	}
+
+	return n.Update(newNetwork, "", false)
+}
+
+// AddDNSRecord adds a custom record to the network's DNS server and applies the change.
+func (n *bridge) AddDNSRecord(record api.NetworkDNSRecord) error {
+	newNetwork, err := n.common.addDNSRecord(record)
+	if err != nil {
+		return err
+	}
+
+	return n.Update(newNetwork, "", false)
+}
+
+// DeleteDNSRecord removes the custom DNS record with the supplied name and applies the change.
+func (n *bridge) DeleteDNSRecord(name string) error {
+	newNetwork, err := n.common.deleteDNSRecord(name)
+	if err != nil {
+		return err
+	}
+
+	return n.Update(newNetwork, "", false)
+}
+
+// Interface returns the name of the bridge's host interface, which is always named after the network.
+func (n *bridge) Interface() string {
+	return n.name
+}
+
+// State returns the runtime state of the bridge interface.
+func (n *bridge) State() (*api.NetworkState, error) {
+	return n.common.interfaceState(n.Interface())
+}
+
+// Statistics returns the traffic counters of the bridge interface.
+func (n *bridge) Statistics() (*api.NetworkStatistics, error) {
+	return n.common.statistics(n.Interface())
+}
+
+// Metrics returns the traffic, link state and DHCPv4 pool metrics of the bridge.
+func (n *bridge) Metrics() ([]Metric, error) {
+	stats, err := n.Statistics()
+	if err != nil {
+		return nil, err
+	}
+
+	return n.common.metrics(stats)
+}
+
+// Health returns the overall health of the network. This checks that the bridge interface exists and is up, has
+// its configured addresses, that the state directory exists and that dnsmasq is running if it is needed.
+func (n *bridge) Health() (HealthStatus, []string) {
+	return n.common.health(n.healthInterface(n.Interface()), n.healthAddresses(n.Interface()), n.healthDirectory, n.healthDnsmasq)
+}
+
+// WaitReady blocks until the bridge is created, its interface is up with its addresses assigned and dnsmasq is
+// running (if needed), or until the context is done.
+func (n *bridge) WaitReady(ctx context.Context) error {
+	return n.common.waitReady(ctx, n.healthInterface(n.Interface()), n.healthAddresses(n.Interface()), n.healthDirectory, n.healthDnsmasq)
+}
+
+// healthDnsmasq checks that dnsmasq is running when the network has an address and DHCP enabled.
+func (n *bridge) healthDnsmasq() (HealthStatus, []string) {
+	config := n.currentConfig()
+
+	hasAddress := config["bridge.mode"] == "fan" || n.IPv4Enabled() || n.IPv6Enabled()
+	if !hasAddress || !(n.HasDHCPv4() || n.HasDHCPv6()) {
+		return HealthStatusHealthy, nil
+	}
+
+	p, err := subprocess.ImportProcess(shared.VarPath("networks", n.name, "dnsmasq.pid"))
+	if err != nil {
+		return HealthStatusDegraded, []string{"dnsmasq isn't running"}
+	}
+
+	_, err = p.GetPid()
+	if err != nil {
+		return HealthStatusDegraded, []string{"dnsmasq isn't running"}
+	}
+
+	return HealthStatusHealthy, nil
+}
+
+// CanPeerWith returns whether the network can be peered with the other network and if not, the reason why.
+func (n *bridge) CanPeerWith(other Network) (bool, string) {
+	return n.common.canPeerWith(n.Capabilities(), other)
+}
+
+// EffectiveConfig returns a copy of the network's config with the defaults applied for keys that aren't set.
+func (n *bridge) EffectiveConfig() map[string]string {
+	return n.common.effectiveConfig(n.configDefaults())
+}
+
+// configDefaults returns the default values of the bridge config keys that apply to the current config. Defaults
+// for an address family are only included when it has an address configured.
+func (n *bridge) configDefaults() map[string]string {
+	config := n.currentConfig()
+
+	defaults := n.common.configDefaults()
+	defaults["bridge.driver"] = "native"
+	defaults["bridge.mode"] = "standard"
+	defaults["dns.domain"] = n.DNSDomain()
+	defaults["dns.mode"] = n.DNSMode()
+
+	mtu, err := n.MTU()
+	if err == nil {
+		defaults["bridge.mtu"] = fmt.Sprintf("%d", mtu)
+	}
+
+	fanMode := config["bridge.mode"] == "fan"
+	if fanMode {
+		defaults["fan.overlay_subnet"] = "240.0.0.0/8"
+		defaults["fan.type"] = "vxlan"
+	}
+
+	for _, family := range []string{"ipv4", "ipv6"} {
+		if shared.StringInSlice(config[fmt.Sprintf("%s.address", family)], []string{"", "none"}) && !(family == "ipv4" && fanMode) {
 			continue
 		}
 
-		fields := strings.Split(k, ".")
-		if !shared.StringInSlice(fields[1], tunnels) {
-			tunnels = append(tunnels, fields[1])
+		hasDHCP := n.HasDHCPv4()
+		if family == "ipv6" {
+			hasDHCP = n.HasDHCPv6()
+			defaults["ipv6.dhcp.stateful"] = "false"
+			defaults["ipv6.dns"] = "true"
+
+			if config["ipv6.dhcp.pd.ranges"] != "" {
+				defaults["ipv6.dhcp.pd.prefix_length"] = "64"
+			}
+		}
+
+		defaults[fmt.Sprintf("%s.dhcp", family)] = strconv.FormatBool(hasDHCP)
+		defaults[fmt.Sprintf("%s.dhcp.expiry", family)] = "1h"
+		defaults[fmt.Sprintf("%s.firewall", family)] = "true"
+		defaults[fmt.Sprintf("%s.nat", family)] = "false"
+		defaults[fmt.Sprintf("%s.nat.order", family)] = "before"
+		defaults[fmt.Sprintf("%s.routing", family)] = "true"
+	}
+
+	return defaults
+}
+
+// Capabilities returns the features supported by the bridge driver.
+func (n *bridge) Capabilities() NetworkCapabilities {
+	capabilities := n.common.Capabilities()
+	capabilities.SupportsDHCPv4 = true
+	capabilities.SupportsDHCPv6 = true
+	capabilities.SupportsDNS = true
+	capabilities.SupportsNAT = true
+	capabilities.SupportsTunnels = true
+	capabilities.SupportsPeering = true
+
+	return capabilities
+}
+
+// MTU returns the network's configured MTU, or if not set the default MTU that accounts for tunnel or fan overhead.
+func (n *bridge) MTU() (uint32, error) {
+	config := n.currentConfig()
+
+	var defaultMTU uint32 = 1500
+	if len(n.getTunnels()) > 0 {
+		defaultMTU = 1400
+	} else if config["bridge.mode"] == "fan" {
+		if config["fan.type"] == "ipip" {
+			defaultMTU = 1480
+		} else {
+			defaultMTU = 1450
 		}
 	}
 
-	return tunnels
+	return n.common.mtu(defaultMTU)
+}
+
+func (n *bridge) getTunnels() []string {
+	return tunnelNames(n.currentConfig())
 }
 
 // bootRoutesV4 returns a list of IPv4 boot routes on the network's device.
@@ -1738,20 +2760,246 @@ func (n *bridge) updateForkdnsServersFile(addresses []string) error {
 	return nil
 }
 
-// hasIPv4Firewall indicates whether the network has IPv4 firewall enabled.
-func (n *bridge) hasIPv4Firewall() bool {
-	if n.config["ipv4.firewall"] == "" || shared.IsTrue(n.config["ipv4.firewall"]) {
-		return true
+// dnsmasqDHCPv4Args returns the dnsmasq arguments for the network's DHCPv4 options, ranges and reservations.
+func (n *bridge) dnsmasqDHCPv4Args(subnet *net.IPNet, mtu string) ([]string, error) {
+	config := n.currentConfig()
+
+	args := []string{}
+
+	if config["ipv4.dhcp.gateway"] != "" {
+		args = append(args, fmt.Sprintf("--dhcp-option-force=3,%s", config["ipv4.dhcp.gateway"]))
+	}
+
+	if mtu != "1500" {
+		args = append(args, fmt.Sprintf("--dhcp-option-force=26,%s", mtu))
+	}
+
+	// Only force the search list option when explicitly configured, otherwise clients get the default domain
+	// via the standard domain option.
+	if config["dns.search"] != "" {
+		args = append(args, fmt.Sprintf("--dhcp-option-force=119,%s", strings.Join(n.DNSSearchDomains(), ",")))
+	}
+
+	dhcpOptions, err := n.DHCPv4Options()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, option := range dhcpOptions {
+		args = append(args, fmt.Sprintf("--dhcp-option=%d,%s", option.Number, strings.Join(option.Values, ",")))
+	}
+
+	expiryTime, err := n.DHCPv4ExpiryTime()
+	if err != nil {
+		return nil, err
+	}
+
+	dhcpRangeArgs, err := n.dnsmasqDHCPv4Ranges(subnet, dnsmasqLeaseTime(expiryTime))
+	if err != nil {
+		return nil, err
+	}
+
+	args = append(args, dhcpRangeArgs...)
+
+	// Reserved addresses are assigned to a client ID that is never used so that dnsmasq doesn't allocate them to
+	// any other client.
+	for _, reservation := range n.DHCPv4Reservations() {
+		args = append(args, fmt.Sprintf("--dhcp-host=id:lxd-reserved-%s,%s", reservation.IP.String(), reservation.IP.String()))
 	}
 
-	return false
+	return args, nil
 }
 
-// hasIPv6Firewall indicates whether the network has IPv6 firewall enabled.
-func (n *bridge) hasIPv6Firewall() bool {
-	if n.config["ipv6.firewall"] == "" || shared.IsTrue(n.config["ipv6.firewall"]) {
-		return true
+// dnsmasqDHCPv6Args returns the dnsmasq arguments for the network's router advertisement mode and DHCPv6 ranges.
+func (n *bridge) dnsmasqDHCPv6Args(subnet *net.IPNet, raConfig RAConfig) ([]string, error) {
+	args := []string{}
+
+	if !raConfig.AdvertiseDNS {
+		// An empty DNS server option stops the bridge being advertised as a DNS server.
+		args = append(args, "--dhcp-option=option6:dns-server")
+	}
+
+	switch raConfig.Mode {
+	case RAModeManaged:
+		expiryTime, err := n.DHCPv6ExpiryTime()
+		if err != nil {
+			return nil, err
+		}
+
+		subnetSize, _ := subnet.Mask.Size()
+		args = append(args, n.dnsmasqDHCPv6Ranges(subnet, subnetSize, dnsmasqLeaseTime(expiryTime))...)
+	case RAModeSLAAC:
+		args = append(args, []string{"--dhcp-range", fmt.Sprintf("::,constructor:%s,ra-only", n.name)}...)
+	default:
+		args = append(args, []string{"--dhcp-range", fmt.Sprintf("::,constructor:%s,ra-stateless,ra-names", n.name)}...)
+	}
+
+	return args, nil
+}
+
+// RenderDHCPConfig returns the DHCP configuration that dnsmasq would be started with for the network's current
+// config, in dnsmasq config file format. This includes the effective ranges, reservations, options and the static
+// leases of instance NICs (which dnsmasq reads from the hosts directory). Nothing is written to disk and dnsmasq
+// isn't restarted.
+func (n *bridge) RenderDHCPConfig() (string, error) {
+	config := n.currentConfig()
+
+	if config["bridge.mode"] == "fan" {
+		return "", fmt.Errorf("Rendering the DHCP config isn't supported for fan bridges")
+	}
+
+	args := []string{}
+
+	if n.IPv4Enabled() && n.HasDHCPv4() {
+		subnet, err := n.DHCPv4Subnet()
+		if err != nil {
+			return "", err
+		}
+
+		mtu, err := n.MTU()
+		if err != nil {
+			return "", err
+		}
+
+		dhcpArgs, err := n.dnsmasqDHCPv4Args(subnet, fmt.Sprintf("%d", mtu))
+		if err != nil {
+			return "", err
+		}
+
+		args = append(args, dhcpArgs...)
+
+		staticLeases, err := n.DHCPv4StaticLeases()
+		if err != nil {
+			return "", err
+		}
+
+		for _, lease := range staticLeases {
+			args = append(args, fmt.Sprintf("--dhcp-host=%s,%s,%s", lease.MAC, lease.IP.String(), lease.Hostname))
+		}
+	}
+
+	if n.IPv6Enabled() {
+		_, subnet, err := net.ParseCIDR(config["ipv6.address"])
+		if err != nil {
+			return "", err
+		}
+
+		raConfig := n.IPv6RAConfig()
+
+		dhcpArgs, err := n.dnsmasqDHCPv6Args(subnet, raConfig)
+		if err != nil {
+			return "", err
+		}
+
+		args = append(args, dhcpArgs...)
+
+		if raConfig.Mode == RAModeManaged {
+			staticLeases, err := n.staticLeases("ipv6")
+			if err != nil {
+				return "", err
+			}
+
+			for _, lease := range staticLeases {
+				args = append(args, fmt.Sprintf("--dhcp-host=%s,[%s],%s", lease.MAC, lease.IP.String(), lease.Hostname))
+			}
+		}
+	}
+
+	if n.DHCPStrictMode() && len(args) > 0 {
+		args = append(args, "--dhcp-ignore=tag:!known")
+	}
+
+	return renderDnsmasqConfig(args), nil
+}
+
+// dnsmasqDHCPv4Ranges returns the dnsmasq arguments for the network's DHCPv4 ranges. In maintenance mode the
+// ranges are replaced by a static range, so that only clients with a static lease are given an address.
+func (n *bridge) dnsmasqDHCPv4Ranges(subnet *net.IPNet, expiry string) ([]string, error) {
+	config := n.currentConfig()
+
+	if n.maintenance() {
+		return []string{"--dhcp-range", fmt.Sprintf("%s,static,%s", subnet.IP.String(), expiry)}, nil
+	}
+
+	args := []string{}
+	if config["ipv4.dhcp.exclude"] != "" {
+		dhcpRanges, err := n.DHCPv4EffectiveRanges()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, dhcpRange := range dhcpRanges {
+			args = append(args, []string{"--dhcp-range", fmt.Sprintf("%s,%s,%s", dhcpRange.Start.String(), dhcpRange.End.String(), expiry)}...)
+		}
+	} else if config["ipv4.dhcp.ranges"] != "" {
+		for _, dhcpRange := range strings.Split(config["ipv4.dhcp.ranges"], ",") {
+			dhcpRange = strings.TrimSpace(dhcpRange)
+			args = append(args, []string{"--dhcp-range", fmt.Sprintf("%s,%s", strings.Replace(dhcpRange, "-", ",", -1), expiry)}...)
+		}
+	} else {
+		args = append(args, []string{"--dhcp-range", fmt.Sprintf("%s,%s,%s", GetIP(subnet, 2).String(), GetIP(subnet, -2).String(), expiry)}...)
+	}
+
+	return args, nil
+}
+
+// dnsmasqDHCPv6Ranges returns the dnsmasq arguments for the network's stateful DHCPv6 ranges. In maintenance mode
+// the ranges are replaced by a static range, so that only clients with a static lease are given an address.
+func (n *bridge) dnsmasqDHCPv6Ranges(subnet *net.IPNet, subnetSize int, expiry string) []string {
+	config := n.currentConfig()
+
+	if n.maintenance() {
+		return []string{"--dhcp-range", fmt.Sprintf("%s,static,%d,%s", subnet.IP.String(), subnetSize, expiry)}
+	}
+
+	args := []string{}
+	if config["ipv6.dhcp.ranges"] != "" {
+		for _, dhcpRange := range strings.Split(config["ipv6.dhcp.ranges"], ",") {
+			dhcpRange = strings.TrimSpace(dhcpRange)
+			args = append(args, []string{"--dhcp-range", fmt.Sprintf("%s,%d,%s", strings.Replace(dhcpRange, "-", ",", -1), subnetSize, expiry)}...)
+		}
+	} else {
+		args = append(args, []string{"--dhcp-range", fmt.Sprintf("%s,%s,%d,%s", GetIP(subnet, 2), GetIP(subnet, -1), subnetSize, expiry)}...)
+	}
+
+	return args
+}
+
+// setupFirewallRules adds the custom firewall rules for the address family ("ipv4" or "ipv6") if any are set and
+// the family's firewall is enabled.
+func (n *bridge) setupFirewallRules(family string) error {
+	if !n.FirewallEnabled(family) {
+		return nil
+	}
+
+	rules, err := n.FirewallRules(family)
+	if err != nil {
+		return err
+	}
+
+	if len(rules) == 0 {
+		return nil
+	}
+
+	ipVersion := uint(4)
+	if family == "ipv6" {
+		ipVersion = 6
 	}
 
-	return false
+	err = n.state.Firewall.NetworkSetupRules(n.name, ipVersion, rules)
+	if err != nil {
+		return errors.Wrapf(err, "Failed adding custom %s firewall rules", family)
+	}
+
+	return nil
+}
+
+// hasIPv4Firewall indicates whether the network has IPv4 firewall enabled.
+func (n *bridge) hasIPv4Firewall() bool {
+	return n.FirewallEnabled("ipv4")
+}
+
+// hasIPv6Firewall indicates whether the network has IPv6 firewall enabled.
+func (n *bridge) hasIPv6Firewall() bool {
+	return n.FirewallEnabled("ipv6")
 }
@@ -0,0 +1,492 @@
+package network
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lxc/lxd/lxd/db"
+	"github.com/lxc/lxd/lxd/endpoints"
+	"github.com/lxc/lxd/lxd/events"
+	"github.com/lxc/lxd/lxd/state"
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/api"
+)
+
+// Test bridge ValidateKey
+func TestBridgeValidateKey(t *testing.T) {
+	n := &bridge{}
+	n.init(nil, 0, "lxdbr0", "bridge", "", map[string]string{}, api.NetworkStatusCreated)
+
+	// Test valid keys.
+	assert.NoError(t, n.ValidateKey("ipv4.nat", "true"))
+	assert.NoError(t, n.ValidateKey("tunnel.foo.protocol", "vxlan"))
+
+	// Test user keys are exempt.
+	assert.NoError(t, n.ValidateKey("user.foo", "anything"))
+
+	// Test invalid value.
+	assert.Error(t, n.ValidateKey("ipv4.nat", "maybe"))
+	assert.Error(t, n.ValidateKey("tunnel.foo.protocol", "ipip"))
+
+	// Test unknown keys.
+	assert.EqualError(t, n.ValidateKey("foo", "bar"), `Invalid option for network "lxdbr0" option "foo"`)
+	assert.Error(t, n.ValidateKey("tunnel.foo", "bar"))
+}
+
+// Test bridge MTU defaults
+func TestBridgeMTU(t *testing.T) {
+	tests := []struct {
+		config map[string]string
+		mtu    uint32
+	}{
+		{map[string]string{}, 1500},
+		{map[string]string{"tunnel.foo.protocol": "vxlan"}, 1400},
+		{map[string]string{"bridge.mode": "fan"}, 1450},
+		{map[string]string{"bridge.mode": "fan", "fan.type": "ipip"}, 1480},
+		{map[string]string{"bridge.mode": "fan", "bridge.mtu": "1300"}, 1300},
+	}
+
+	for _, test := range tests {
+		n := &bridge{}
+		n.init(nil, 0, "lxdbr0", "bridge", "", test.config, api.NetworkStatusCreated)
+
+		mtu, err := n.MTU()
+		assert.NoError(t, err)
+		assert.Equal(t, test.mtu, mtu)
+	}
+}
+
+// Test bridge NAT config validation.
+func TestBridgeValidateNAT(t *testing.T) {
+	tests := []struct {
+		name   string
+		config map[string]string
+		valid  bool
+	}{
+		{"Valid NAT", map[string]string{"ipv4.address": "10.0.0.1/24", "ipv4.nat": "true", "ipv4.nat.address": "192.168.1.1", "ipv6.address": "fd42::1/64", "ipv6.nat": "true"}, true},
+		{"NAT disabled without address", map[string]string{"ipv4.address": "none", "ipv4.nat": "false", "ipv6.address": "none"}, true},
+		{"IPv4 NAT without address", map[string]string{"ipv4.address": "none", "ipv4.nat": "true"}, false},
+		{"IPv6 NAT without address", map[string]string{"ipv6.address": "none", "ipv6.nat": "true"}, false},
+		{"IPv4 NAT address without NAT", map[string]string{"ipv4.address": "10.0.0.1/24", "ipv4.nat.address": "192.168.1.1"}, false},
+		{"IPv6 NAT address with NAT disabled", map[string]string{"ipv6.address": "fd42::1/64", "ipv6.nat": "false", "ipv6.nat.address": "fd43::1"}, false},
+		{"IPv4 NAT address is CIDR", map[string]string{"ipv4.address": "10.0.0.1/24", "ipv4.nat": "true", "ipv4.nat.address": "192.168.1.1/24"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			n := &bridge{}
+			n.init(nil, 0, "lxdbr0", "bridge", "", test.config, api.NetworkStatusCreated)
+
+			err := n.Validate(test.config)
+			if test.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+// Test bridge stateful DHCPv6 validation.
+func TestBridgeValidateDHCPv6Stateful(t *testing.T) {
+	tests := []struct {
+		name   string
+		config map[string]string
+		valid  bool
+	}{
+		{"Stateful DHCPv6", map[string]string{"ipv6.address": "fd42::1/64", "ipv6.dhcp.stateful": "true"}, true},
+		{"Stateful DHCPv6 without DHCPv6", map[string]string{"ipv6.address": "fd42::1/64", "ipv6.dhcp": "false", "ipv6.dhcp.stateful": "true"}, false},
+		{"Stateless DHCPv6 without DHCPv6", map[string]string{"ipv6.address": "fd42::1/64", "ipv6.dhcp": "false", "ipv6.dhcp.stateful": "false"}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			n := &bridge{}
+			n.init(nil, 0, "lxdbr0", "bridge", "", test.config, api.NetworkStatusCreated)
+
+			err := n.Validate(test.config)
+			if test.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+// Test bridge custom firewall rules validation.
+func TestBridgeValidateFirewallRules(t *testing.T) {
+	tests := []struct {
+		name   string
+		config map[string]string
+		valid  bool
+	}{
+		{"Valid rules", map[string]string{"ipv4.address": "10.0.0.1/24", "ipv4.firewall.rules": "input accept tcp 22, forward drop", "ipv6.firewall.rules": "input reject icmp"}, true},
+		{"Rules with firewall enabled", map[string]string{"ipv4.firewall": "true", "ipv4.firewall.rules": "output accept"}, true},
+		{"Rules with firewall disabled", map[string]string{"ipv4.firewall": "false", "ipv4.firewall.rules": "input accept"}, false},
+		{"IPv6 rules with firewall disabled", map[string]string{"ipv6.firewall": "false", "ipv6.firewall.rules": "input accept"}, false},
+		{"Invalid chain", map[string]string{"ipv4.firewall.rules": "prerouting accept"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			n := &bridge{}
+			n.init(nil, 0, "lxdbr0", "bridge", "", test.config, api.NetworkStatusCreated)
+
+			err := n.Validate(test.config)
+			if test.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+// Test bridge MTU warnings for tunnels with an unknown underlay MTU.
+func TestBridgeTunnelMTUWarnings(t *testing.T) {
+	tests := []struct {
+		name   string
+		config map[string]string
+		warn   bool
+	}{
+		{"No tunnels", map[string]string{"bridge.mtu": "9000"}, false},
+		{"Default MTU", map[string]string{"tunnel.foo.protocol": "vxlan", "tunnel.foo.id": "1"}, false},
+		{"VXLAN maximum", map[string]string{"bridge.mtu": "1450", "tunnel.foo.protocol": "vxlan"}, false},
+		{"VXLAN too large", map[string]string{"bridge.mtu": "1500", "tunnel.foo.protocol": "vxlan"}, true},
+		{"GRE maximum", map[string]string{"bridge.mtu": "1462", "tunnel.foo.protocol": "gre", "tunnel.foo.local": "10.0.0.1", "tunnel.foo.remote": "10.0.0.2"}, false},
+		{"GRE too large", map[string]string{"bridge.mtu": "1463", "tunnel.foo.protocol": "gre", "tunnel.foo.local": "10.0.0.1", "tunnel.foo.remote": "10.0.0.2"}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			n := &bridge{}
+			n.init(nil, 0, "lxdbr0", "bridge", "", test.config, api.NetworkStatusCreated)
+
+			// The underlay MTU isn't known so the config is valid either way.
+			require.NoError(t, n.Validate(test.config))
+
+			found := false
+			for _, check := range n.warningChecks() {
+				for _, warning := range check(test.config) {
+					if warning.Key == "bridge.mtu" {
+						assert.Contains(t, warning.Message, "maximum safe MTU")
+						found = true
+					}
+				}
+			}
+
+			assert.Equal(t, test.warn, found)
+		})
+	}
+}
+
+// Test bridge validation warnings.
+func TestBridgeValidateWithWarnings(t *testing.T) {
+	oldResolvConfPath := hostResolvConfPath
+	defer func() { hostResolvConfPath = oldResolvConfPath }()
+	hostResolvConfPath = "/nonexistent/resolv.conf"
+
+	tests := []struct {
+		name   string
+		config map[string]string
+		keys   []string
+	}{
+		{"No warnings", map[string]string{"ipv4.address": "10.0.0.1/24", "ipv4.nat": "true", "ipv6.address": "none", "dns.nameservers": "10.0.0.53"}, []string{}},
+		{"Small DHCP range", map[string]string{"ipv4.address": "10.0.0.1/24", "ipv4.dhcp.ranges": "10.0.0.2-10.0.0.5", "ipv6.address": "none", "dns.nameservers": "10.0.0.53"}, []string{"ipv4.dhcp.ranges"}},
+		{"NAT without private subnet", map[string]string{"ipv4.address": "203.0.113.1/24", "ipv4.nat": "true", "ipv6.address": "2001:db8::1/64", "ipv6.nat": "true", "dns.nameservers": "10.0.0.53"}, []string{"ipv4.nat", "ipv6.nat"}},
+		{"No DNS upstream", map[string]string{"ipv4.address": "10.0.0.1/24", "ipv6.address": "none"}, []string{"dns.nameservers"}},
+		{"DNS disabled", map[string]string{"ipv4.address": "10.0.0.1/24", "ipv6.address": "none", "dns.mode": "none"}, []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			n := &bridge{}
+			n.init(nil, 0, "lxdbr0", "bridge", "", test.config, api.NetworkStatusCreated)
+
+			warnings, err := n.ValidateWithWarnings(test.config)
+			require.NoError(t, err)
+
+			keys := []string{}
+			for _, warning := range warnings {
+				keys = append(keys, warning.Key)
+			}
+
+			assert.Equal(t, test.keys, keys)
+		})
+	}
+
+	// Test invalid configs return an error rather than warnings.
+	n := &bridge{}
+	config := map[string]string{"ipv4.address": "10.0.0.1/24", "ipv4.nat.address": "192.168.1.1"}
+	n.init(nil, 0, "lxdbr0", "bridge", "", config, api.NetworkStatusCreated)
+
+	warnings, err := n.ValidateWithWarnings(config)
+	assert.Error(t, err)
+	assert.Nil(t, warnings)
+}
+
+// Test maintenance mode status transitions and DHCP range generation.
+func TestBridgeMaintenance(t *testing.T) {
+	n := &bridge{common{name: "lxdbr0", status: api.NetworkStatusCreated, config: map[string]string{
+		"ipv4.address":     "10.0.0.1/24",
+		"ipv4.dhcp.ranges": "10.0.0.10-10.0.0.20",
+		"ipv6.address":     "fd42::1/64",
+	}}}
+
+	_, subnetV4, _ := net.ParseCIDR("10.0.0.0/24")
+	_, subnetV6, _ := net.ParseCIDR("fd42::/64")
+
+	assert.Equal(t, api.NetworkStatusCreated, n.Status())
+
+	args, err := n.dnsmasqDHCPv4Ranges(subnetV4, "3600")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"--dhcp-range", "10.0.0.10,10.0.0.20,3600"}, args)
+	assert.Equal(t, []string{"--dhcp-range", "fd42::2,fd42::ffff:ffff:ffff:ffff,64,3600"}, n.dnsmasqDHCPv6Ranges(subnetV6, 64, "3600"))
+
+	// Test enabling maintenance mode replaces the ranges with static ones.
+	newNetwork := n.setMaintenance(true)
+	assert.Equal(t, "true", newNetwork.Config["volatile.maintenance"])
+	assert.Empty(t, n.Config()["volatile.maintenance"]) // Current config isn't modified.
+
+	n.config = newNetwork.Config
+	assert.Equal(t, api.NetworkStatusMaintenance, n.Status())
+
+	args, err = n.dnsmasqDHCPv4Ranges(subnetV4, "3600")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"--dhcp-range", "10.0.0.0,static,3600"}, args)
+	assert.Equal(t, []string{"--dhcp-range", "fd42::,static,64,3600"}, n.dnsmasqDHCPv6Ranges(subnetV6, 64, "3600"))
+
+	// Test pending and errored statuses take precedence.
+	n.status = api.NetworkStatusPending
+	assert.Equal(t, api.NetworkStatusPending, n.Status())
+	n.status = api.NetworkStatusCreated
+
+	// Test disabling maintenance mode removes the key, restoring the ranges.
+	newNetwork = n.setMaintenance(false)
+	n.config = n.preserveVolatileKeys(newNetwork).Config
+	_, found := n.config["volatile.maintenance"]
+	assert.False(t, found)
+	assert.Equal(t, api.NetworkStatusCreated, n.Status())
+
+	args, err = n.dnsmasqDHCPv4Ranges(subnetV4, "3600")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"--dhcp-range", "10.0.0.10,10.0.0.20,3600"}, args)
+}
+
+// Test the generated dnsmasq DHCP arguments.
+func TestBridgeDnsmasqDHCPArgs(t *testing.T) {
+	n := &bridge{common{name: "lxdbr0", status: api.NetworkStatusCreated, config: map[string]string{
+		"ipv4.address":                    "10.0.0.1/24",
+		"ipv4.dhcp.ranges":                "10.0.0.10-10.0.0.20",
+		"ipv4.dhcp.options":               "42:10.0.0.2",
+		"ipv4.dhcp.reservation.10.0.0.15": "printer",
+		"ipv6.address":                    "fd42::1/64",
+		"ipv6.dhcp.stateful":              "true",
+		"ipv6.dns":                        "false",
+	}}}
+
+	_, subnetV4, _ := net.ParseCIDR("10.0.0.0/24")
+	_, subnetV6, _ := net.ParseCIDR("fd42::/64")
+
+	args, err := n.dnsmasqDHCPv4Args(subnetV4, "1400")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"--dhcp-option-force=26,1400",
+		"--dhcp-option=42,10.0.0.2",
+		"--dhcp-range", "10.0.0.10,10.0.0.20,3600",
+		"--dhcp-host=id:lxd-reserved-10.0.0.15,10.0.0.15",
+	}, args)
+
+	args, err = n.dnsmasqDHCPv6Args(subnetV6, n.IPv6RAConfig())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"--dhcp-option=option6:dns-server",
+		"--dhcp-range", "fd42::2,fd42::ffff:ffff:ffff:ffff,64,3600",
+	}, args)
+
+	// Test the arguments are rendered in config file format.
+	assert.Equal(t, "dhcp-option=option6:dns-server\ndhcp-range=fd42::2,fd42::ffff:ffff:ffff:ffff,64,3600\n", renderDnsmasqConfig(args))
+	assert.Equal(t, "enable-ra\ndhcp-ignore=tag:!known\n", renderDnsmasqConfig([]string{"--enable-ra", "--dhcp-ignore=tag:!known"}))
+}
+
+// Test driver capabilities.
+func TestCapabilities(t *testing.T) {
+	// Test the common default doesn't claim any optional features.
+	assert.Equal(t, NetworkCapabilities{}, (&common{}).Capabilities())
+
+	// Test drivers without an override use the common default.
+	assert.Equal(t, NetworkCapabilities{}, (&macvlan{}).Capabilities())
+	assert.Equal(t, NetworkCapabilities{}, (&sriov{}).Capabilities())
+
+	// Test the bridge override builds on the common default.
+	assert.Equal(t, NetworkCapabilities{
+		SupportsDHCPv4:  true,
+		SupportsDHCPv6:  true,
+		SupportsDNS:     true,
+		SupportsNAT:     true,
+		SupportsTunnels: true,
+		SupportsPeering: true,
+	}, (&bridge{}).Capabilities())
+}
+
+// Test host interface names.
+func TestInterface(t *testing.T) {
+	// Test the bridge interface is named after the network.
+	assert.Equal(t, "lxdbr0", (&bridge{common{name: "lxdbr0"}}).Interface())
+
+	// Test drivers using a parent interface don't have a host interface of their own.
+	assert.Equal(t, "", (&macvlan{common{name: "macvlan0", config: map[string]string{"parent": "eth0"}}}).Interface())
+	assert.Equal(t, "", (&sriov{common{name: "sriov0"}}).Interface())
+}
+
+// Test bridge CanPeerWith
+func TestBridgeCanPeerWith(t *testing.T) {
+	newBridge := func(name string, config map[string]string) *bridge {
+		return &bridge{common{name: name, netType: "bridge", config: config}}
+	}
+
+	n := newBridge("br0", map[string]string{"ipv4.address": "10.0.0.1/24", "ipv6.address": "fd42::1/64"})
+
+	ok, reason := n.CanPeerWith(newBridge("br1", map[string]string{"ipv4.address": "10.0.1.1/24", "ipv6.address": "fd43::1/64"}))
+	assert.True(t, ok)
+	assert.Equal(t, "", reason)
+
+	// Test incompatible networks.
+	ok, reason = n.CanPeerWith(n)
+	assert.False(t, ok)
+	assert.Equal(t, "A network can't be peered with itself", reason)
+
+	ok, reason = n.CanPeerWith(newBridge("br1", map[string]string{"ipv4.address": "10.0.0.100/25", "ipv6.address": "fd43::1/64"}))
+	assert.False(t, ok)
+	assert.Contains(t, reason, "overlaps")
+
+	ok, reason = n.CanPeerWith(newBridge("br1", map[string]string{"ipv4.address": "10.0.1.1/24", "ipv6.address": "none"}))
+	assert.False(t, ok)
+	assert.Contains(t, reason, "address families")
+
+	ok, reason = n.CanPeerWith(&macvlan{common{name: "mv0", netType: "macvlan", config: map[string]string{}}})
+	assert.False(t, ok)
+	assert.Equal(t, `Network "mv0" of type "macvlan" doesn't support peering`, reason)
+
+	// Test drivers that don't support peering.
+	ok, _ = (&macvlan{common{name: "mv0", netType: "macvlan"}}).CanPeerWith(n)
+	assert.False(t, ok)
+}
+
+// Test bridge EffectiveConfig
+func TestBridgeEffectiveConfig(t *testing.T) {
+	config := map[string]string{
+		"ipv4.address": "10.0.0.1/24",
+		"ipv4.nat":     "true",
+		"ipv6.address": "none",
+		"user.foo":     "bar",
+	}
+
+	n := &bridge{}
+	n.init(nil, 0, "lxdbr0", "bridge", "", config, api.NetworkStatusCreated)
+
+	effective := n.EffectiveConfig()
+
+	// Test explicitly set keys are unchanged.
+	assert.Equal(t, "true", effective["ipv4.nat"])
+	assert.Equal(t, "none", effective["ipv6.address"])
+	assert.Equal(t, "bar", effective["user.foo"])
+
+	// Test defaults are applied for unset keys.
+	assert.Equal(t, "true", effective["ipv4.dhcp"])
+	assert.Equal(t, "1500", effective["bridge.mtu"])
+	assert.Equal(t, "lxd", effective["dns.domain"])
+	assert.Equal(t, "false", effective["security.frozen"])
+
+	// Test defaults aren't applied for a disabled address family.
+	_, found := effective["ipv6.dhcp"]
+	assert.False(t, found)
+
+	// Test the stored config isn't modified.
+	assert.Equal(t, config, n.Config())
+
+	// Test each default is valid for its key.
+	for k, v := range n.configDefaults() {
+		assert.NoError(t, n.ValidateKey(k, v), k)
+	}
+}
+
+// Test that updating a pending network only updates the database.
+func TestBridgePendingUpdate(t *testing.T) {
+	s, cleanup := state.NewTestState(t)
+	defer cleanup()
+
+	s.Endpoints = &endpoints.Endpoints{}
+	s.Events = events.NewServer(false, false)
+
+	oldLXDDir := os.Getenv("LXD_DIR")
+	os.Setenv("LXD_DIR", s.OS.VarDir)
+	defer os.Setenv("LXD_DIR", oldLXDDir)
+
+	config := map[string]string{"ipv4.address": "10.0.0.1/24"}
+	id, err := s.Cluster.CreateNetwork("testbr0", "", db.NetworkTypeBridge, config)
+	require.NoError(t, err)
+
+	n := &bridge{}
+	n.init(s, id, "testbr0", "bridge", "", config, api.NetworkStatusPending)
+	assert.True(t, n.IsPending())
+
+	err = n.Update(api.NetworkPut{Config: map[string]string{"ipv4.address": "10.0.1.1/24"}}, "", false)
+	assert.NoError(t, err)
+
+	_, netInfo, err := s.Cluster.GetNetworkInAnyState("testbr0")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.1.1/24", netInfo.Config["ipv4.address"])
+
+	// Test no local network directory was created.
+	assert.False(t, shared.PathExists(shared.VarPath("networks", "testbr0")))
+}
+
+// Test that only changes to keys that can't be applied live restart the bridge.
+func TestBridgeUpdateRestartRequired(t *testing.T) {
+	s, cleanup := state.NewTestState(t)
+	defer cleanup()
+
+	s.Endpoints = &endpoints.Endpoints{}
+	s.Events = events.NewServer(false, false)
+	s.OS.MockMode = true
+
+	config := map[string]string{"ipv4.address": "10.0.0.1/24", "ipv6.address": "none"}
+	id, err := s.Cluster.CreateNetwork("testbr0", "", db.NetworkTypeBridge, config)
+	require.NoError(t, err)
+
+	n := &bridge{}
+	n.init(s, id, "testbr0", "bridge", "", config, api.NetworkStatusCreated)
+
+	capture := &captureLogger{}
+	n.logger = capture
+
+	// Test a DNS change is applied live.
+	err = n.Update(api.NetworkPut{Config: map[string]string{"ipv4.address": "10.0.0.1/24", "ipv6.address": "none", "dns.domain": "example"}}, "", false)
+	require.NoError(t, err)
+	assert.Contains(t, capture.messages, "Applying config changes without restarting network")
+	assert.NotContains(t, capture.messages, "Restarting network to apply config changes")
+
+	// Test a user key change doesn't restart the bridge either.
+	capture.messages = nil
+	err = n.Update(api.NetworkPut{Config: map[string]string{"ipv4.address": "10.0.0.1/24", "ipv6.address": "none", "dns.domain": "example", "user.foo": "bar"}}, "", false)
+	require.NoError(t, err)
+	assert.NotContains(t, capture.messages, "Restarting network to apply config changes")
+
+	// Test an address change restarts the bridge.
+	capture.messages = nil
+	err = n.Update(api.NetworkPut{Config: map[string]string{"ipv4.address": "10.0.1.1/24", "ipv6.address": "none", "dns.domain": "example", "user.foo": "bar"}}, "", false)
+	require.NoError(t, err)
+	assert.Contains(t, capture.messages, "Restarting network to apply config changes")
+	assert.NotContains(t, capture.messages, "Applying config changes without restarting network")
+
+	_, netInfo, err := s.Cluster.GetNetworkInAnyState("testbr0")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.1.1/24", netInfo.Config["ipv4.address"])
+	assert.Equal(t, "example", netInfo.Config["dns.domain"])
+}
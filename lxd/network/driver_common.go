@@ -1,23 +1,45 @@
 package network
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
 	"fmt"
+	"io/ioutil"
+	"math"
+	"math/big"
 	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 
 	lxd "github.com/lxc/lxd/client"
 	"github.com/lxc/lxd/lxd/cluster"
 	"github.com/lxc/lxd/lxd/db"
+	deviceConfig "github.com/lxc/lxd/lxd/device/config"
+	"github.com/lxc/lxd/lxd/device/nictype"
+	firewallDrivers "github.com/lxc/lxd/lxd/firewall/drivers"
 	"github.com/lxc/lxd/lxd/instance"
+	"github.com/lxc/lxd/lxd/instance/instancetype"
+	"github.com/lxc/lxd/lxd/network/validate"
+	"github.com/lxc/lxd/lxd/project"
+	"github.com/lxc/lxd/lxd/revert"
 	"github.com/lxc/lxd/lxd/state"
 	"github.com/lxc/lxd/shared"
 	"github.com/lxc/lxd/shared/api"
 	log "github.com/lxc/lxd/shared/log15"
 	"github.com/lxc/lxd/shared/logger"
 	"github.com/lxc/lxd/shared/logging"
+	"github.com/lxc/lxd/shared/units"
+	"github.com/lxc/lxd/shared/version"
 )
 
 // DHCPRange represents a range of IPs from start to end.
@@ -26,6 +48,386 @@ type DHCPRange struct {
 	End   net.IP
 }
 
+// Contains indicates if the IP is within the range (inclusive of the start and end addresses).
+func (r DHCPRange) Contains(ip net.IP) bool {
+	return bytes.Compare(ip.To16(), r.Start.To16()) >= 0 && bytes.Compare(ip.To16(), r.End.To16()) <= 0
+}
+
+// dhcpv4ReservationPrefix is the config key prefix used to store DHCPv4 reservations. The reserved IP is the
+// remainder of the key and the value is a comment describing the reservation.
+const dhcpv4ReservationPrefix = "ipv4.dhcp.reservation."
+
+// dnsRecordPrefix is the config key prefix used to store custom DNS records. The record name is the remainder of
+// the key and the value is the record type followed by the record value, e.g. "A 192.0.2.10".
+const dnsRecordPrefix = "dns.record."
+
+// MaxDHCPRanges is the maximum number of ranges allowed in the "ipv4.dhcp.ranges" and "ipv6.dhcp.ranges" keys.
+const MaxDHCPRanges = 32
+
+// DHCPReservation represents an IP reserved from a network's DHCP pool for external allocation.
+type DHCPReservation struct {
+	IP      net.IP
+	Comment string
+}
+
+// disruptiveKeys lists the config keys per driver that may break connectivity of running instances when changed.
+var disruptiveKeys = map[string][]string{
+	"bridge":  {"bridge.driver", "bridge.mode", "bridge.mtu", "ipv4.address", "ipv6.address", "fan.overlay_subnet", "fan.type"},
+	"macvlan": {"parent"},
+	"sriov":   {"parent"},
+}
+
+// addressKeys lists the config keys per driver that provide addresses or routes instances may depend on. Removing
+// one of these keys takes those addresses away from the instances using the network.
+var addressKeys = map[string][]string{
+	"bridge": {"ipv4.address", "ipv6.address", "ipv4.routes", "ipv6.routes"},
+}
+
+// Initial and maximum delay between the readiness checks performed by WaitReady.
+var waitReadyInterval = 100 * time.Millisecond
+var waitReadyMaxInterval = 2 * time.Second
+
+// smallDHCPv4PoolSize is the number of addresses below which a network's DHCPv4 pool is considered very small.
+const smallDHCPv4PoolSize = 16
+
+// hostResolvConfPath is the host resolver config used by dnsmasq when no upstream nameservers are configured.
+var hostResolvConfPath = "/etc/resolv.conf"
+
+// liveUpdateKeys lists the config keys per driver that can be applied without restarting the network. Entries
+// ending in "." match all keys with that prefix. Changing any other key requires the network to be restarted.
+var liveUpdateKeys = map[string][]string{
+	"bridge":  {"dns.", "ipv4.dhcp.", "ipv6.dhcp.", "limits.", "maas.", "raw.dnsmasq", "security.", "user.", "volatile."},
+	"macvlan": {"maas.", "security.", "user.", "volatile."},
+	"sriov":   {"maas.", "security.", "user.", "volatile."},
+}
+
+// ConfigChangeAction indicates how a config key differs between two configs.
+type ConfigChangeAction string
+
+// ConfigChangeAction types.
+const (
+	ConfigChangeAdded    ConfigChangeAction = "added"
+	ConfigChangeRemoved  ConfigChangeAction = "removed"
+	ConfigChangeModified ConfigChangeAction = "modified"
+)
+
+// ConfigChange represents a single config key difference between two configs.
+type ConfigChange struct {
+	Key      string
+	OldValue string
+	NewValue string
+	Action   ConfigChangeAction
+}
+
+// Warning describes a network config setting that is valid but questionable. Warnings don't prevent the config
+// from being applied.
+type Warning struct {
+	Key     string `json:"key" yaml:"key"`
+	Message string `json:"message" yaml:"message"`
+}
+
+// warningCheck inspects a network config that has passed validation and returns any warnings about it.
+type warningCheck func(config map[string]string) []Warning
+
+// sensitiveValueMask replaces the values of sensitive keys in config diffs.
+const sensitiveValueMask = "********"
+
+// isSensitiveKey returns true if the value of the config key should not be displayed.
+func isSensitiveKey(key string) bool {
+	return strings.HasSuffix(key, ".password")
+}
+
+// diffConfig returns the differences between the old and new config ordered by key. A key set to an empty value
+// is considered modified rather than removed.
+func diffConfig(oldConfig map[string]string, newConfig map[string]string) []ConfigChange {
+	changes := []ConfigChange{}
+
+	for k, oldValue := range oldConfig {
+		newValue, found := newConfig[k]
+		if !found {
+			changes = append(changes, ConfigChange{Key: k, OldValue: oldValue, Action: ConfigChangeRemoved})
+		} else if newValue != oldValue {
+			changes = append(changes, ConfigChange{Key: k, OldValue: oldValue, NewValue: newValue, Action: ConfigChangeModified})
+		}
+	}
+
+	for k, newValue := range newConfig {
+		_, found := oldConfig[k]
+		if !found {
+			changes = append(changes, ConfigChange{Key: k, NewValue: newValue, Action: ConfigChangeAdded})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+
+	return changes
+}
+
+// NetworkCapabilities describes the features supported by a network driver.
+type NetworkCapabilities struct {
+	SupportsDHCPv4  bool
+	SupportsDHCPv6  bool
+	SupportsDNS     bool
+	SupportsNAT     bool
+	SupportsACLs    bool
+	SupportsTunnels bool
+	SupportsPeering bool
+}
+
+// StaticRoute represents a static route to an external destination via the network, with an optional gateway.
+type StaticRoute struct {
+	Destination *net.IPNet
+	Gateway     net.IP
+}
+
+// IPv6 router advertisement modes.
+const (
+	// RAModeNone means no router advertisements are sent as the network has no IPv6 address.
+	RAModeNone = "none"
+
+	// RAModeSLAAC means clients configure their addresses using SLAAC and DHCPv6 isn't provided.
+	RAModeSLAAC = "slaac"
+
+	// RAModeOtherConfig means clients configure their addresses using SLAAC and get other configuration using
+	// stateless DHCPv6 (the "other configuration" flag is set).
+	RAModeOtherConfig = "other-config"
+
+	// RAModeManaged means clients get their addresses using stateful DHCPv6 (the "managed" flag is set).
+	RAModeManaged = "managed"
+)
+
+// RAConfig represents the IPv6 router advertisement settings of a network.
+type RAConfig struct {
+	Mode         string
+	AdvertiseDNS bool
+}
+
+// Valid custom firewall rule chains, actions and protocols.
+var firewallChains = []string{"input", "forward", "output"}
+var firewallActions = []string{"accept", "drop", "reject"}
+var firewallProtocols = []string{"tcp", "udp", "icmp"}
+
+// DHCPOption represents a custom DHCP option sent to clients.
+type DHCPOption struct {
+	Number uint8
+	Values []string
+}
+
+// dhcpOptionFormats maps well known DHCP option numbers to the format of their values. Values of options that aren't
+// listed are passed to dnsmasq as is.
+var dhcpOptionFormats = map[uint8]string{
+	4:   "ipv4",   // Time servers.
+	6:   "ipv4",   // DNS servers.
+	7:   "ipv4",   // Log servers.
+	42:  "ipv4",   // NTP servers.
+	44:  "ipv4",   // NetBIOS name servers.
+	66:  "string", // TFTP server name.
+	67:  "string", // Boot file name.
+	119: "domain", // Domain search list.
+	150: "ipv4",   // TFTP server addresses.
+}
+
+// dhcpOptionsManaged maps the DHCP option numbers that are generated from other network config keys to those keys.
+var dhcpOptionsManaged = map[uint8]string{
+	1:  "ipv4.address",
+	3:  "ipv4.dhcp.gateway",
+	15: "dns.domain",
+	26: "bridge.mtu",
+	51: "ipv4.dhcp.expiry",
+}
+
+// HealthStatus indicates the overall health of a network.
+type HealthStatus string
+
+// HealthStatus types, ordered from best to worst.
+const (
+	HealthStatusHealthy   HealthStatus = "healthy"
+	HealthStatusUnknown   HealthStatus = "unknown"
+	HealthStatusDegraded  HealthStatus = "degraded"
+	HealthStatusUnhealthy HealthStatus = "unhealthy"
+)
+
+// healthSeverity is used to find the worst of several health statuses.
+var healthSeverity = map[HealthStatus]int{
+	HealthStatusHealthy:   0,
+	HealthStatusUnknown:   1,
+	HealthStatusDegraded:  2,
+	HealthStatusUnhealthy: 3,
+}
+
+// healthCheck checks one aspect of a network's health and returns the resulting status and any issues found.
+type healthCheck func() (HealthStatus, []string)
+
+// InconsistencyType indicates how a network's on-disk state differs from the database.
+type InconsistencyType string
+
+// InconsistencyType types.
+const (
+	InconsistencyOrphanedDirectory InconsistencyType = "orphaned-directory"
+	InconsistencyMissingDirectory  InconsistencyType = "missing-directory"
+)
+
+// Inconsistency represents a difference between the networks on disk and those in the database.
+type Inconsistency struct {
+	Type    InconsistencyType
+	Network string
+	Path    string
+}
+
+// directoryDrivers lists the network drivers that keep a directory on disk for each network.
+var directoryDrivers = []string{"bridge"}
+
+// TunnelProbeResult represents the result of probing the remote endpoint of a tunnel.
+type TunnelProbeResult struct {
+	Name      string
+	Remote    string
+	Reachable bool
+	Method    string // The probe that determined the result, either "icmp" or "tcp".
+	Err       error
+}
+
+// StaticLease represents a static DHCP host reservation for an instance NIC.
+type StaticLease struct {
+	MAC      string
+	IP       net.IP
+	Hostname string
+}
+
+// AddressClaimSource indicates where a claim on an IP address comes from.
+type AddressClaimSource string
+
+// AddressClaimSource types.
+const (
+	AddressClaimLease       AddressClaimSource = "lease"
+	AddressClaimStatic      AddressClaimSource = "static"
+	AddressClaimReservation AddressClaimSource = "reservation"
+)
+
+// AddressClaim represents an IP address being claimed by a dynamic DHCP lease, a static DHCP lease or a
+// reservation. The instance is empty if the claimant's MAC doesn't belong to an instance connected to the network.
+type AddressClaim struct {
+	IP       net.IP
+	Source   AddressClaimSource
+	MAC      string
+	Instance string
+}
+
+// AddressConflict represents an IP address claimed by more than one client.
+type AddressConflict struct {
+	IP     net.IP
+	Claims []AddressClaim
+}
+
+// AllocatedAddressSource indicates why an IP address on the network is in use.
+type AllocatedAddressSource string
+
+// AllocatedAddressSource types.
+const (
+	AllocatedAddressGateway  AllocatedAddressSource = "gateway"
+	AllocatedAddressStatic   AllocatedAddressSource = "static"
+	AllocatedAddressDynamic  AllocatedAddressSource = "dynamic"
+	AllocatedAddressReserved AllocatedAddressSource = "reserved"
+)
+
+// AllocatedAddress represents an IP address in use on the network. Family is either 4 or 6. The MAC and instance
+// are empty when not known.
+type AllocatedAddress struct {
+	IP       net.IP
+	Family   int
+	Source   AllocatedAddressSource
+	MAC      string
+	Instance string
+}
+
+// MetricType indicates how the value of a metric behaves, using the OpenMetrics type names.
+type MetricType string
+
+// MetricType types.
+const (
+	MetricTypeCounter MetricType = "counter"
+	MetricTypeGauge   MetricType = "gauge"
+)
+
+// Metric represents a single sample of a network metric, ready to be rendered by a Prometheus exporter.
+type Metric struct {
+	Name   string
+	Type   MetricType
+	Help   string
+	Labels map[string]string
+	Value  float64
+}
+
+// dhcpRangesOverlap checks whether any of the supplied ranges overlap each other. Addresses are compared in their
+// 16 byte integer form so both IPv4 and IPv6 ranges are supported. Returns the first two overlapping ranges found
+// and true, or false if there is no overlap. A range whose start equals its end is a single address range.
+func dhcpRangesOverlap(ranges []DHCPRange) (DHCPRange, DHCPRange, bool) {
+	for i := range ranges {
+		startA := big.NewInt(0).SetBytes(ranges[i].Start.To16())
+		endA := big.NewInt(0).SetBytes(ranges[i].End.To16())
+
+		for j := i + 1; j < len(ranges); j++ {
+			startB := big.NewInt(0).SetBytes(ranges[j].Start.To16())
+			endB := big.NewInt(0).SetBytes(ranges[j].End.To16())
+
+			if startA.Cmp(endB) <= 0 && startB.Cmp(endA) <= 0 {
+				return ranges[i], ranges[j], true
+			}
+		}
+	}
+
+	return DHCPRange{}, DHCPRange{}, false
+}
+
+// usedCacheTTL is how long a cached IsUsed() result is trusted for without an explicit invalidation.
+const usedCacheTTL = 5 * time.Second
+
+// usedCacheEntry is a cached IsUsed() result of a network. It is only valid while gen matches usedCacheGen.
+type usedCacheEntry struct {
+	value  bool
+	gen    uint64
+	expiry time.Time
+}
+
+// usedCache caches the IsUsed() result of each network, keyed by network name. It is kept at package level as a new
+// network object is loaded for each request. Incrementing usedCacheGen invalidates every entry.
+var usedCache = map[string]usedCacheEntry{}
+var usedCacheGen uint64
+var usedCacheMu sync.Mutex
+
+// InvalidateUsageCache discards the cached IsUsed() results of all networks if any of the supplied instance or
+// profile devices is a NIC or proxy device, as adding, changing or removing those can change which networks are
+// in use.
+func InvalidateUsageCache(devices deviceConfig.Devices) {
+	for _, dev := range devices {
+		if shared.StringInSlice(dev["type"], []string{"nic", "proxy"}) {
+			invalidateUsageCache()
+			return
+		}
+	}
+}
+
+// invalidateUsageCache discards the cached IsUsed() results of all networks.
+func invalidateUsageCache() {
+	usedCacheMu.Lock()
+	defer usedCacheMu.Unlock()
+
+	usedCacheGen++
+}
+
+// leaseCountCacheTTL is how long the cached count of active DHCPv4 leases used by Metrics() is trusted for.
+const leaseCountCacheTTL = 5 * time.Second
+
+// leaseCount is a cached count of the active DHCPv4 leases of a network on this node.
+type leaseCount struct {
+	value  int
+	expiry time.Time
+}
+
+// leaseCounts caches the count of active DHCPv4 leases on this node used by Metrics(), keyed by network name. It is
+// kept at package level as a new network object is loaded for each metrics request.
+var leaseCounts = map[string]leaseCount{}
+var leaseCountsMu sync.Mutex
+
 // common represents a generic LXD network.
 type common struct {
 	logger      logger.Logger
@@ -36,6 +438,13 @@ type common struct {
 	description string
 	config      map[string]string
 	status      string
+
+	// Protects description, config and status. The config map is replaced (rather than modified) on update.
+	configLock sync.RWMutex
+
+	// Serialises the load, compare, store and apply steps of setKeys and compareAndUpdate, so that the internal
+	// config set by one isn't replaced by another while it is being applied.
+	updateLock sync.Mutex
 }
 
 // init initialise internal variables.
@@ -44,10 +453,13 @@ func (n *common) init(state *state.State, id int64, name string, netType string,
 	n.id = id
 	n.name = name
 	n.netType = netType
-	n.config = config
 	n.state = state
+
+	n.configLock.Lock()
+	n.config = config
 	n.description = description
 	n.status = status
+	n.configLock.Unlock()
 }
 
 // fillConfig fills requested config with any default values, by default this is a no-op.
@@ -56,26 +468,123 @@ func (n *common) fillConfig(req *api.NetworksPost) error {
 }
 
 // validationRules returns a map of config rules common to all drivers.
-func (n *common) validationRules() map[string]func(string) error {
-	return map[string]func(string) error{}
+func (n *common) validationRules() map[string]configKeyRule {
+	return map[string]configKeyRule{
+		"security.frozen":      {validator: validate.Optional(validate.IsBool), keyType: "boolean", defaultValue: "false", description: "Prevent the network from being changed, renamed or deleted (only disabling this key is allowed)"},
+		"volatile.imported":    {validator: validate.Optional(validate.IsBool)},
+		"volatile.maintenance": {validator: validate.Optional(validate.IsBool)},
+	}
+}
+
+// frozen returns whether the network is frozen, preventing changes to it.
+func (n *common) frozen() bool {
+	return shared.IsTrue(n.currentConfig()["security.frozen"])
+}
+
+// validateNotFrozen returns ErrFrozen if the network is frozen. Cluster notifications are not checked as the
+// originating node has already done so.
+func (n *common) validateNotFrozen(clusterNotification bool) error {
+	if !clusterNotification && n.frozen() {
+		return ErrFrozen
+	}
+
+	return nil
+}
+
+// validateNotProtected returns an error if the network is one of the networks listed in the
+// "network.protected_names" server config key and is referenced by the default profile of any project. Instances
+// launched without explicit profiles use the default profile, so deleting its network would leave new instances
+// unable to launch. Cluster notifications are not checked as the originating node has already done so.
+func (n *common) validateNotProtected(clusterNotification bool) error {
+	if clusterNotification {
+		return nil
+	}
+
+	var protectedNames []string
+	var profiles []db.Profile
+	err := n.state.Cluster.Transaction(func(tx *db.ClusterTx) error {
+		config, err := cluster.ConfigLoad(tx)
+		if err != nil {
+			return err
+		}
+
+		protectedNames = config.NetworkProtectedNames()
+		if !shared.StringInSlice(n.name, protectedNames) {
+			return nil
+		}
+
+		profiles, err = tx.GetProfiles(db.ProfileFilter{})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, profile := range profiles {
+		if profile.Name != "default" {
+			continue
+		}
+
+		inUse, err := IsInUseByProfile(n.state, *db.ProfileToAPI(&profile), n.name)
+		if err != nil {
+			return err
+		}
+
+		if inUse {
+			return fmt.Errorf("The network is protected and used by the %q profile in project %q, update the profile to use another network before deleting it", profile.Name, profile.Project)
+		}
+	}
+
+	return nil
+}
+
+// ValidateDelete checks whether the network can be deleted, returning ErrFrozen if it is frozen or an error if it
+// is a protected network referenced by the default profile.
+func (n *common) ValidateDelete() error {
+	err := n.validateNotFrozen(false)
+	if err != nil {
+		return err
+	}
+
+	return n.validateNotProtected(false)
+}
+
+// validateUpdateNotFrozen returns ErrFrozen if the network is frozen, unless the update only unfreezes it.
+func (n *common) validateUpdateNotFrozen(newNetwork api.NetworkPut, clusterNotification bool) error {
+	err := n.validateNotFrozen(clusterNotification)
+	if err == nil {
+		return nil
+	}
+
+	if newNetwork.Description != n.currentDescription() || shared.IsTrue(newNetwork.Config["security.frozen"]) {
+		return err
+	}
+
+	for _, change := range diffConfig(n.currentConfig(), newNetwork.Config) {
+		if change.Key != "security.frozen" {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // validate a network config against common rules and optional driver specific rules.
-func (n *common) validate(config map[string]string, driverRules map[string]func(value string) error) error {
+func (n *common) validate(config map[string]string, driverRules map[string]configKeyRule) error {
 	checkedFields := map[string]struct{}{}
 
 	// Get rules common for all drivers.
 	rules := n.validationRules()
 
 	// Merge driver specific rules into common rules.
-	for field, validator := range driverRules {
-		rules[field] = validator
+	for field, rule := range driverRules {
+		rules[field] = rule
 	}
 
 	// Run the validator against each field.
-	for k, validator := range rules {
+	for k, rule := range rules {
 		checkedFields[k] = struct{}{} //Mark field as checked.
-		err := validator(config[k])
+		err := rule.validator(config[k])
 		if err != nil {
 			return errors.Wrapf(err, "Invalid value for network %q option %q", n.name, k)
 		}
@@ -93,275 +602,3668 @@ func (n *common) validate(config map[string]string, driverRules map[string]func(
 			continue
 		}
 
-		return fmt.Errorf("Invalid option for network %q option %q", n.name, k)
+		err := n.unknownKey(k, rules)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-// Name returns the network name.
-func (n *common) Name() string {
-	return n.name
+// exclusiveKeyRules returns the pairs of mutually exclusive config keys that apply to all drivers.
+func (n *common) exclusiveKeyRules() []exclusiveKeys {
+	return []exclusiveKeys{}
 }
 
-// Status returns the network status.
-func (n *common) Status() string {
-	return n.status
+// validateExclusiveKeys checks that none of the common or supplied driver specific pairs of mutually exclusive
+// config keys are set together, reporting the first conflicting pair found. The common and supplied driver specific
+// rules provide the defaults of keys that aren't set.
+func (n *common) validateExclusiveKeys(config map[string]string, driverRules map[string]configKeyRule, driverExclusiveKeys []exclusiveKeys) error {
+	rules := n.validationRules()
+	for field, rule := range driverRules {
+		rules[field] = rule
+	}
+
+	pairs := append(n.exclusiveKeyRules(), driverExclusiveKeys...)
+
+	pair, found := findExclusiveKeysConflict(config, rules, pairs)
+	if found {
+		return fmt.Errorf("Invalid value for network %q option %q: Can't be used together with %q", n.name, pair[0], pair[1])
+	}
+
+	return nil
 }
 
-// Type returns the network type.
-func (n *common) Type() string {
-	return n.netType
+// ConfigSchema returns the schema of the config keys common to all drivers.
+func (n *common) ConfigSchema() map[string]ConfigKeySchema {
+	return n.configSchema(nil)
 }
 
-// Config returns the network config.
-func (n *common) Config() map[string]string {
-	return n.config
+// validateKey validates a single config key and value against common rules and the supplied driver specific rules.
+func (n *common) validateKey(key string, value string, driverRules map[string]configKeyRule) error {
+	rules := n.validationRules()
+	for field, rule := range driverRules {
+		rules[field] = rule
+	}
+
+	rule, found := rules[key]
+	if !found {
+		// User keys are not validated.
+		if strings.HasPrefix(key, "user.") {
+			return nil
+		}
+
+		return n.unknownKey(key, rules)
+	}
+
+	err := rule.validator(value)
+	if err != nil {
+		return errors.Wrapf(err, "Invalid value for network %q option %q", n.name, key)
+	}
+
+	return nil
 }
 
-// IsUsed returns whether the network is used by any instances or profiles.
-func (n *common) IsUsed() (bool, error) {
-	// Look for instances using the network.
-	insts, err := instance.LoadFromAllProjects(n.state)
+// unknownKey returns an error for a config key that isn't in the rules, suggesting the closest known key if it
+// looks like a typo. If the "network.allow_unknown_keys" server config key is enabled then keys that don't resemble
+// a known key are only logged. This helps when migrating configs from newer LXD versions.
+func (n *common) unknownKey(key string, rules map[string]configKeyRule) error {
+	knownKeys := make([]string, 0, len(rules))
+	for k := range rules {
+		knownKeys = append(knownKeys, k)
+	}
+
+	suggestion := suggestKey(key, knownKeys)
+	if suggestion != "" {
+		return fmt.Errorf("Invalid option for network %q option %q (did you mean %q?)", n.name, key, suggestion)
+	}
+
+	allowUnknownKeys, err := n.allowUnknownKeys()
 	if err != nil {
-		return false, err
+		return err
 	}
 
-	for _, inst := range insts {
-		inUse, err := IsInUseByInstance(n.state, inst, n.name)
-		if err != nil {
-			return false, err
-		}
+	if allowUnknownKeys {
+		logger.Warn("Ignoring unknown network config key", log.Ctx{"network": n.name, "key": key})
+		return nil
+	}
 
-		if inUse {
-			return true, nil
-		}
+	return fmt.Errorf("Invalid option for network %q option %q", n.name, key)
+}
+
+// allowUnknownKeys returns whether the "network.allow_unknown_keys" server config key is enabled. Unknown keys are
+// never allowed when validating without state.
+func (n *common) allowUnknownKeys() (bool, error) {
+	if n.state == nil {
+		return false, nil
 	}
 
-	// Look for profiles using the network.
-	var profiles []db.Profile
-	err = n.state.Cluster.Transaction(func(tx *db.ClusterTx) error {
-		profiles, err = tx.GetProfiles(db.ProfileFilter{})
+	var allow bool
+	err := n.state.Cluster.Transaction(func(tx *db.ClusterTx) error {
+		config, err := cluster.ConfigLoad(tx)
 		if err != nil {
 			return err
 		}
 
+		allow = config.NetworkAllowUnknownKeys()
 		return nil
 	})
 	if err != nil {
 		return false, err
 	}
 
-	for _, profile := range profiles {
-		inUse, err := IsInUseByProfile(n.state, *db.ProfileToAPI(&profile), n.name)
+	return allow, nil
+}
+
+// ValidateUpdate validates the supplied network update against the network's current config without applying it.
+// Keys missing from the new config are merged in from the current config and keys with an empty value are removed
+// (as happens when an update is applied), so removing an unknown or stale key doesn't fail validation. The driver's
+// full validation, including cross-field checks, is run against the result. When clustered, it also
+// checks that only node-specific keys are changed when a target node is specified and that node-specific keys are
+// not changed when no target node is specified. No database writes, cluster notifications or local changes are
+// performed.
+func (n *common) ValidateUpdate(newNetwork api.NetworkPut, targetNode string) error {
+	curConfig := n.currentConfig()
+
+	return n.validateConfig(curConfig, mergeConfig(curConfig, newNetwork.Config), targetNode)
+}
+
+// validateConfig validates the new config that is to replace the current config when applied to the target node.
+func (n *common) validateConfig(curConfig map[string]string, config map[string]string, targetNode string) error {
+	clustered, err := cluster.Enabled(n.state.Node)
+	if err != nil {
+		return err
+	}
+
+	if clustered {
+		err = validateConfigTarget(curConfig, config, targetNode)
 		if err != nil {
-			return false, err
+			return err
 		}
+	}
 
-		if inUse {
-			return true, nil
-		}
+	err = Validate(n.name, n.netType, config)
+	if err != nil {
+		return err
 	}
 
-	return false, nil
+	return ValidateSubnets(n.state, n.name, config)
 }
 
-// HasDHCPv4 indicates whether the network has DHCPv4 enabled.
+// ValidateWithWarnings is not supported by default.
+func (n *common) ValidateWithWarnings(config map[string]string) ([]Warning, error) {
+	return nil, ErrNotImplemented
+}
+
+// validateWithWarnings validates the config using the supplied validate function and, if it is valid, runs the
+// supplied warning checks against it. The warnings are returned ordered by key.
+func (n *common) validateWithWarnings(config map[string]string, validate func(config map[string]string) error, checks []warningCheck) ([]Warning, error) {
+	err := validate(config)
+	if err != nil {
+		return nil, err
+	}
+
+	warnings := []Warning{}
+	for _, check := range checks {
+		warnings = append(warnings, check(config)...)
+	}
+
+	sort.SliceStable(warnings, func(i, j int) bool { return warnings[i].Key < warnings[j].Key })
+
+	return warnings, nil
+}
+
+// Name returns the network name.
+func (n *common) Name() string {
+	return n.name
+}
+
+// Status returns the network status.
+func (n *common) Status() string {
+	status := n.currentStatus()
+	if status == api.NetworkStatusCreated && n.maintenance() {
+		return api.NetworkStatusMaintenance
+	}
+
+	return status
+}
+
+// maintenance returns whether the network is in maintenance mode, in which no new DHCP leases are handed out.
+func (n *common) maintenance() bool {
+	return shared.IsTrue(n.currentConfig()["volatile.maintenance"])
+}
+
+// IsPending returns whether the network is pending creation on this node.
+func (n *common) IsPending() bool {
+	return n.currentStatus() == api.NetworkStatusPending
+}
+
+// Type returns the network type.
+func (n *common) Type() string {
+	return n.netType
+}
+
+// Config returns the network config.
+func (n *common) Config() map[string]string {
+	config := n.currentConfig()
+
+	// Return a copy so that the caller can't modify the internal config.
+	configCopy := make(map[string]string, len(config))
+	for k, v := range config {
+		configCopy[k] = v
+	}
+
+	return configCopy
+}
+
+// EffectiveConfig returns a copy of the network's config with the defaults applied for keys that aren't set.
+func (n *common) EffectiveConfig() map[string]string {
+	return n.effectiveConfig(n.configDefaults())
+}
+
+// effectiveConfig returns a copy of the network's config with the supplied defaults applied for keys that aren't
+// set.
+func (n *common) effectiveConfig(defaults map[string]string) map[string]string {
+	config := n.Config()
+
+	for k, v := range defaults {
+		if config[k] == "" {
+			config[k] = v
+		}
+	}
+
+	return config
+}
+
+// configDefaults returns the default values of the config keys common to all drivers.
+func (n *common) configDefaults() map[string]string {
+	return map[string]string{
+		"security.frozen": "false",
+	}
+}
+
+// currentConfig returns the network's current config map. The map is replaced rather than modified when the
+// network is updated so it is safe to read after the lock is released, but it must not be modified.
+func (n *common) currentConfig() map[string]string {
+	n.configLock.RLock()
+	defer n.configLock.RUnlock()
+
+	return n.config
+}
+
+// currentDescription returns the network's current description.
+func (n *common) currentDescription() string {
+	n.configLock.RLock()
+	defer n.configLock.RUnlock()
+
+	return n.description
+}
+
+// currentStatus returns the network's current status (without taking maintenance mode into account).
+func (n *common) currentStatus() string {
+	n.configLock.RLock()
+	defer n.configLock.RUnlock()
+
+	return n.status
+}
+
+// IsUsed returns whether the network is used by any instances or profiles. The result is cached for a short time
+// (or until InvalidateUsageCache is called) as it requires loading every instance and profile.
+func (n *common) IsUsed() (bool, error) {
+	usedCacheMu.Lock()
+	cached, found := usedCache[n.name]
+	gen := usedCacheGen
+	usedCacheMu.Unlock()
+
+	if found && cached.gen == gen && time.Now().Before(cached.expiry) {
+		return cached.value, nil
+	}
+
+	// The lock isn't held while checking so that slow database scans don't block other networks. The generation
+	// from before the check is stored so that a result is discarded if the cache was invalidated meanwhile.
+	inUse, err := n.isUsed()
+	if err != nil {
+		return false, err
+	}
+
+	usedCacheMu.Lock()
+	usedCache[n.name] = usedCacheEntry{value: inUse, gen: gen, expiry: time.Now().Add(usedCacheTTL)}
+	usedCacheMu.Unlock()
+
+	return inUse, nil
+}
+
+// isUsed returns whether the network is used by any instances or profiles, bypassing the cache.
+func (n *common) isUsed() (bool, error) {
+	// Look for instances using the network.
+	insts, err := instance.LoadFromAllProjects(n.state)
+	if err != nil {
+		return false, err
+	}
+
+	for _, inst := range insts {
+		inUse, err := n.isInUseByInstance(inst)
+		if err != nil {
+			return false, err
+		}
+
+		if inUse {
+			return true, nil
+		}
+	}
+
+	// Look for profiles using the network.
+	var profiles []db.Profile
+	err = n.state.Cluster.Transaction(func(tx *db.ClusterTx) error {
+		profiles, err = tx.GetProfiles(db.ProfileFilter{})
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	for _, profile := range profiles {
+		inUse, err := n.isInUseByProfile(*db.ProfileToAPI(&profile))
+		if err != nil {
+			return false, err
+		}
+
+		if inUse {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// isInUseByInstance indicates if the network is referenced by an instance's NIC devices or by a NAT proxy device
+// whose listen or connect address is inside one of the network's subnets.
+func (n *common) isInUseByInstance(inst instance.Instance) (bool, error) {
+	inUse, err := IsInUseByInstance(n.state, inst, n.name)
+	if err != nil || inUse {
+		return inUse, err
+	}
+
+	return isInUseByProxyDevices(inst.ExpandedDevices(), n.subnets()), nil
+}
+
+// isInUseByProfile indicates if the network is referenced by a profile's NIC devices or by a NAT proxy device
+// whose listen or connect address is inside one of the network's subnets.
+func (n *common) isInUseByProfile(profile api.Profile) (bool, error) {
+	inUse, err := IsInUseByProfile(n.state, profile, n.name)
+	if err != nil || inUse {
+		return inUse, err
+	}
+
+	return isInUseByProxyDevices(deviceConfig.NewDevices(profile.Devices), n.subnets()), nil
+}
+
+// subnets returns the network's configured IPv4 and IPv6 subnets.
+func (n *common) subnets() []*net.IPNet {
+	return configSubnets(n.currentConfig())
+}
+
+// configSubnets returns the IPv4 and IPv6 subnets configured in "ipv4.address" and "ipv6.address".
+func configSubnets(config map[string]string) []*net.IPNet {
+	subnets := []*net.IPNet{}
+
+	_, subnet, err := parseIPv4Address(config)
+	if err == nil {
+		subnets = append(subnets, subnet)
+	}
+
+	_, subnet, err = net.ParseCIDR(config["ipv6.address"])
+	if err == nil {
+		subnets = append(subnets, subnet)
+	}
+
+	return subnets
+}
+
+// CanPeerWith returns whether the network can be peered with the other network and if not, the reason why. Peering
+// isn't supported by default.
+func (n *common) CanPeerWith(other Network) (bool, string) {
+	return n.canPeerWith(n.Capabilities(), other)
+}
+
+// canPeerWith checks whether the network, whose driver has the supplied capabilities, can be peered with the other
+// network. Both drivers must support peering, the networks must use the same address families and their subnets
+// mustn't overlap. Returns the reason if peering isn't possible.
+func (n *common) canPeerWith(capabilities NetworkCapabilities, other Network) (bool, string) {
+	if other.Name() == n.name {
+		return false, "A network can't be peered with itself"
+	}
+
+	if !capabilities.SupportsPeering {
+		return false, fmt.Sprintf("Network %q of type %q doesn't support peering", n.name, n.netType)
+	}
+
+	if !other.Capabilities().SupportsPeering {
+		return false, fmt.Sprintf("Network %q of type %q doesn't support peering", other.Name(), other.Type())
+	}
+
+	subnets := n.subnets()
+	otherSubnets := configSubnets(other.Config())
+
+	families := func(subnets []*net.IPNet) (bool, bool) {
+		hasIPv4, hasIPv6 := false, false
+		for _, subnet := range subnets {
+			if subnet.IP.To4() != nil {
+				hasIPv4 = true
+			} else {
+				hasIPv6 = true
+			}
+		}
+
+		return hasIPv4, hasIPv6
+	}
+
+	hasIPv4, hasIPv6 := families(subnets)
+	otherHasIPv4, otherHasIPv6 := families(otherSubnets)
+
+	if !hasIPv4 && !hasIPv6 {
+		return false, fmt.Sprintf("Network %q has no subnets", n.name)
+	}
+
+	if hasIPv4 != otherHasIPv4 || hasIPv6 != otherHasIPv6 {
+		return false, fmt.Sprintf("Networks %q and %q don't use the same address families", n.name, other.Name())
+	}
+
+	for _, subnet := range subnets {
+		for _, otherSubnet := range otherSubnets {
+			if subnetsOverlap(subnet, otherSubnet) {
+				return false, fmt.Sprintf("Subnet %q of network %q overlaps with subnet %q of network %q", subnet.String(), n.name, otherSubnet.String(), other.Name())
+			}
+		}
+	}
+
+	return true, ""
+}
+
+// UsedBy returns the API URLs of the instances and profiles referencing the network. Instances and profiles
+// outside of the default project have their project appended to the URL.
+func (n *common) UsedBy() ([]string, error) {
+	usedBy := []string{}
+
+	// Look for instances using the network.
+	insts, err := instance.LoadFromAllProjects(n.state)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, inst := range insts {
+		inUse, err := n.isInUseByInstance(inst)
+		if err != nil {
+			return nil, err
+		}
+
+		if inUse {
+			uri := fmt.Sprintf("/%s/instances/%s", version.APIVersion, inst.Name())
+			if inst.Project() != project.Default {
+				uri += fmt.Sprintf("?project=%s", inst.Project())
+			}
+
+			usedBy = append(usedBy, uri)
+		}
+	}
+
+	// Look for profiles using the network.
+	var profiles []db.Profile
+	err = n.state.Cluster.Transaction(func(tx *db.ClusterTx) error {
+		profiles, err = tx.GetProfiles(db.ProfileFilter{})
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, profile := range profiles {
+		inUse, err := n.isInUseByProfile(*db.ProfileToAPI(&profile))
+		if err != nil {
+			return nil, err
+		}
+
+		if inUse {
+			uri := fmt.Sprintf("/%s/profiles/%s", version.APIVersion, profile.Name)
+			if profile.Project != project.Default {
+				uri += fmt.Sprintf("?project=%s", profile.Project)
+			}
+
+			usedBy = append(usedBy, uri)
+		}
+	}
+
+	return usedBy, nil
+}
+
+// DHCPv4StaticLeases returns the static DHCPv4 reservations configured on bridged instance NICs connected to this
+// network. Returns an error listing any reservations that are outside of the network's subnet, inside of one of its
+// configured DHCP ranges or that use the same IP as another reservation.
+func (n *common) DHCPv4StaticLeases() ([]StaticLease, error) {
+	return n.dhcpv4StaticLeases(n.currentConfig())
+}
+
+// dhcpv4StaticLeases returns the static DHCPv4 reservations of the instance NICs connected to this network,
+// checked against the subnet and DHCP ranges of the supplied config.
+func (n *common) dhcpv4StaticLeases(config map[string]string) ([]StaticLease, error) {
+	leases, err := n.staticLeases("ipv4")
+	if err != nil {
+		return nil, err
+	}
+
+	_, subnet, err := parseIPv4Address(config)
+	if err != nil && err != ErrNoIPv4Address {
+		return nil, err
+	}
+
+	err = validateStaticLeases(leases, subnet, dhcpv4Ranges(config))
+	if err != nil {
+		return nil, err
+	}
+
+	return leases, nil
+}
+
+// staticLeases returns the static DHCP reservations for the IP family ("ipv4" or "ipv6") configured on bridged
+// instance NICs connected to this network.
+func (n *common) staticLeases(family string) ([]StaticLease, error) {
+	insts, err := instance.LoadFromAllProjects(n.state)
+	if err != nil {
+		return nil, err
+	}
+
+	addressKey := fmt.Sprintf("%s.address", family)
+
+	leases := []StaticLease{}
+	for _, inst := range insts {
+		for devName, d := range inst.ExpandedDevices() {
+			if d["type"] != "nic" || d[addressKey] == "" {
+				continue
+			}
+
+			nicType, err := nictype.NICType(n.state, d)
+			if err != nil || nicType != "bridged" {
+				continue
+			}
+
+			if d["network"] != n.name && d["parent"] != n.name {
+				continue
+			}
+
+			mac := d["hwaddr"]
+			if mac == "" {
+				mac = inst.LocalConfig()[fmt.Sprintf("volatile.%s.hwaddr", devName)]
+			}
+
+			lease := StaticLease{
+				MAC:      strings.ToLower(mac),
+				IP:       net.ParseIP(d[addressKey]),
+				Hostname: project.DNS(inst.Project(), inst.Name()),
+			}
+
+			if lease.IP == nil || (lease.IP.To4() != nil) != (family == "ipv4") {
+				return nil, fmt.Errorf("Invalid static %s address %q for instance %q", strings.Replace(family, "ip", "IP", 1), d[addressKey], lease.Hostname)
+			}
+
+			leases = append(leases, lease)
+		}
+	}
+
+	return leases, nil
+}
+
+// Interface returns the name of the network's own host interface, which callers should use rather than assuming
+// the interface is named after the network. By default networks don't have a host interface of their own (macvlan
+// and sriov networks use an existing parent interface) and so an empty name is returned.
+func (n *common) Interface() string {
+	return ""
+}
+
+// State returns the runtime state of the network's host interface. By default networks have no host interface of
+// their own, so a state of "unavailable" is returned.
+func (n *common) State() (*api.NetworkState, error) {
+	return n.interfaceState(n.Interface())
+}
+
+// interfaceState returns the runtime state of the named host interface. If the network is pending or the interface
+// doesn't exist on this node then a state of "unavailable" is returned rather than an error.
+func (n *common) interfaceState(name string) (*api.NetworkState, error) {
+	unavailable := &api.NetworkState{
+		Addresses: []api.NetworkStateAddress{},
+		State:     "unavailable",
+		Type:      "unknown",
+	}
+
+	if n.IsPending() {
+		return unavailable, nil
+	}
+
+	netIf, err := net.InterfaceByName(name)
+	if err != nil {
+		return unavailable, nil
+	}
+
+	netState := GetState(*netIf)
+	return &netState, nil
+}
+
+// sysClassNet is the sysfs directory containing the host's network interfaces.
+var sysClassNet = "/sys/class/net"
+
+// Statistics returns the traffic counters of the network's host interface. By default networks have no host
+// interface of their own, so zero counters with an empty interface name are returned.
+func (n *common) Statistics() (*api.NetworkStatistics, error) {
+	return &api.NetworkStatistics{}, nil
+}
+
+// statistics returns the cumulative traffic counters of the named host interface read from sysfs. If the network
+// is pending or the interface doesn't exist then zero counters with an empty interface name are returned.
+func (n *common) statistics(name string) (*api.NetworkStatistics, error) {
+	stats := &api.NetworkStatistics{}
+
+	statsPath := filepath.Join(sysClassNet, name, "statistics")
+	if n.IsPending() || !shared.PathExists(statsPath) {
+		return stats, nil
+	}
+
+	counters := map[string]*int64{
+		"rx_bytes":   &stats.BytesReceived,
+		"tx_bytes":   &stats.BytesSent,
+		"rx_packets": &stats.PacketsReceived,
+		"tx_packets": &stats.PacketsSent,
+		"rx_errors":  &stats.ErrorsReceived,
+		"tx_errors":  &stats.ErrorsSent,
+		"rx_dropped": &stats.DroppedReceived,
+		"tx_dropped": &stats.DroppedSent,
+	}
+
+	for file, counter := range counters {
+		content, err := ioutil.ReadFile(filepath.Join(statsPath, file))
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed reading %q counter of interface %q", file, name)
+		}
+
+		value, err := strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid %q counter of interface %q", file, name)
+		}
+
+		*counter = value
+	}
+
+	stats.Interface = name
+
+	return stats, nil
+}
+
+// Metrics returns the network's metrics. By default networks have no host interface of their own, so only the
+// DHCPv4 pool metrics are returned.
+func (n *common) Metrics() ([]Metric, error) {
+	return n.metrics(nil)
+}
+
+// metrics returns the traffic counters and link state of the supplied host interface statistics (if any) along
+// with the DHCPv4 pool size, the number of active leases on this node and the pool utilization. Each metric is
+// labelled with the network name and project. The lease count is cached briefly so the metrics are cheap to
+// gather on every scrape.
+func (n *common) metrics(stats *api.NetworkStatistics) ([]Metric, error) {
+	labels := map[string]string{"name": n.name, "project": project.Default}
+	metrics := []Metric{}
+
+	addMetric := func(name string, metricType MetricType, help string, value float64) {
+		metrics = append(metrics, Metric{Name: name, Type: metricType, Help: help, Labels: labels, Value: value})
+	}
+
+	if stats != nil && stats.Interface != "" {
+		up := 0.0
+		netIf, err := net.InterfaceByName(stats.Interface)
+		if err == nil && netIf.Flags&net.FlagUp != 0 {
+			up = 1
+		}
+
+		addMetric("lxd_network_up", MetricTypeGauge, "Whether the network's host interface is up", up)
+		addMetric("lxd_network_receive_bytes_total", MetricTypeCounter, "Bytes received on the network's host interface", float64(stats.BytesReceived))
+		addMetric("lxd_network_transmit_bytes_total", MetricTypeCounter, "Bytes sent on the network's host interface", float64(stats.BytesSent))
+		addMetric("lxd_network_receive_packets_total", MetricTypeCounter, "Packets received on the network's host interface", float64(stats.PacketsReceived))
+		addMetric("lxd_network_transmit_packets_total", MetricTypeCounter, "Packets sent on the network's host interface", float64(stats.PacketsSent))
+	}
+
+	if !n.IPv4Enabled() || !n.HasDHCPv4() {
+		return metrics, nil
+	}
+
+	poolSize, err := n.DHCPv4PoolSize()
+	if err != nil {
+		return nil, err
+	}
+
+	leaseCount, err := n.dhcpv4LeaseCount()
+	if err != nil {
+		return nil, err
+	}
+
+	utilization := 0.0
+	if poolSize > 0 {
+		utilization = float64(leaseCount) / float64(poolSize)
+	}
+
+	addMetric("lxd_network_dhcpv4_pool_size", MetricTypeGauge, "Number of addresses in the network's DHCPv4 pool", float64(poolSize))
+	addMetric("lxd_network_dhcpv4_leases", MetricTypeGauge, "Number of active DHCPv4 leases on this node", float64(leaseCount))
+	addMetric("lxd_network_dhcpv4_pool_utilization", MetricTypeGauge, "Ratio of active DHCPv4 leases to the pool size", utilization)
+
+	return metrics, nil
+}
+
+// dhcpv4LeaseCount returns the number of active DHCPv4 leases in the network's dnsmasq lease file on this node. The
+// count is cached for a short time so frequent callers don't re-read the lease file.
+func (n *common) dhcpv4LeaseCount() (int, error) {
+	leaseCountsMu.Lock()
+	defer leaseCountsMu.Unlock()
+
+	cached, found := leaseCounts[n.name]
+	if found && time.Now().Before(cached.expiry) {
+		return cached.value, nil
+	}
+
+	leases, err := n.localDHCPv4Leases()
+	if err != nil {
+		return -1, err
+	}
+
+	leaseCounts[n.name] = leaseCount{value: len(leases), expiry: time.Now().Add(leaseCountCacheTTL)}
+
+	return len(leases), nil
+}
+
+// Health returns the overall health of the network along with a list of the issues found.
+func (n *common) Health() (HealthStatus, []string) {
+	return n.health()
+}
+
+// WaitReady blocks until the network is operational or the context is done. Networks without a host interface of
+// their own are ready as soon as their database record is in the created state. Returns an error straight away if
+// the network is in the errored state.
+func (n *common) WaitReady(ctx context.Context) error {
+	return n.waitReady(ctx)
+}
+
+// waitReady polls the network's database state and the supplied health check (if any) with a capped backoff until
+// the network is created and healthy. If the context is done first, the last issues found are included in the
+// returned error.
+func (n *common) waitReady(ctx context.Context, checks ...healthCheck) error {
+	issues := []string{}
+
+	err := waitBackoff(ctx, waitReadyInterval, waitReadyMaxInterval, func() (bool, error) {
+		_, netInfo, err := n.state.Cluster.GetNetworkInAnyState(n.name)
+		if err != nil {
+			return false, err
+		}
+
+		if netInfo.Status == api.NetworkStatusErrored {
+			return false, fmt.Errorf("Network is in errored state")
+		}
+
+		if netInfo.Status != api.NetworkStatusCreated {
+			issues = []string{fmt.Sprintf("Network is in %s state", strings.ToLower(netInfo.Status))}
+			return false, nil
+		}
+
+		n.configLock.Lock()
+		n.status = netInfo.Status
+		n.configLock.Unlock()
+
+		status, checkIssues := n.health(checks...)
+		issues = checkIssues
+
+		return status == HealthStatusHealthy, nil
+	})
+	if err != nil && err == ctx.Err() && len(issues) > 0 {
+		return errors.Wrapf(err, "Network not ready (%s)", strings.Join(issues, ", "))
+	}
+
+	return err
+}
+
+// health runs the supplied driver specific health checks along with the common ones and returns the worst status
+// found and the combined list of issues. Pending networks have no local state and so are reported as unknown.
+func (n *common) health(checks ...healthCheck) (HealthStatus, []string) {
+	if n.IsPending() {
+		return HealthStatusUnknown, []string{"Network is pending creation on this node"}
+	}
+
+	status := HealthStatusHealthy
+	issues := []string{}
+
+	if n.currentStatus() == api.NetworkStatusErrored {
+		status = HealthStatusUnhealthy
+		issues = append(issues, "Network is in errored state")
+	}
+
+	for _, check := range checks {
+		checkStatus, checkIssues := check()
+		if healthSeverity[checkStatus] > healthSeverity[status] {
+			status = checkStatus
+		}
+
+		issues = append(issues, checkIssues...)
+	}
+
+	return status, issues
+}
+
+// healthInterface returns a health check that the named host interface exists and is up.
+func (n *common) healthInterface(name string) healthCheck {
+	return func() (HealthStatus, []string) {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return HealthStatusUnhealthy, []string{fmt.Sprintf("Interface %q doesn't exist", name)}
+		}
+
+		if iface.Flags&net.FlagUp == 0 {
+			return HealthStatusUnhealthy, []string{fmt.Sprintf("Interface %q is down", name)}
+		}
+
+		return HealthStatusHealthy, nil
+	}
+}
+
+// healthAddresses returns a health check that the network's configured IPv4 and IPv6 addresses are assigned to
+// the named host interface.
+func (n *common) healthAddresses(name string) healthCheck {
+	return func() (HealthStatus, []string) {
+		config := n.currentConfig()
+
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return HealthStatusUnknown, nil // Reported by the interface check.
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return HealthStatusUnknown, []string{fmt.Sprintf("Failed getting addresses of interface %q: %v", name, err)}
+		}
+
+		status := HealthStatusHealthy
+		issues := []string{}
+		for _, key := range []string{"ipv4.address", "ipv6.address"} {
+			ip, _, err := net.ParseCIDR(config[key])
+			if err != nil {
+				continue // Not set, "none" or "auto".
+			}
+
+			found := false
+			for _, addr := range addrs {
+				ipNet, ok := addr.(*net.IPNet)
+				if ok && ipNet.IP.Equal(ip) {
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				status = HealthStatusDegraded
+				issues = append(issues, fmt.Sprintf("Address %q isn't assigned to interface %q", ip.String(), name))
+			}
+		}
+
+		return status, issues
+	}
+}
+
+// healthDirectory checks that the network's state directory exists.
+func (n *common) healthDirectory() (HealthStatus, []string) {
+	if !shared.PathExists(shared.VarPath("networks", n.name)) {
+		return HealthStatusDegraded, []string{fmt.Sprintf("Network directory %q is missing", shared.VarPath("networks", n.name))}
+	}
+
+	return HealthStatusHealthy, nil
+}
+
+// IPv4Enabled indicates whether the network has IPv4 configured, i.e. "ipv4.address" is set and isn't "none".
+func (n *common) IPv4Enabled() bool {
+	return ipv4Enabled(n.currentConfig())
+}
+
+// ipv4Enabled indicates whether "ipv4.address" is set and isn't "none" in the supplied config.
+func ipv4Enabled(config map[string]string) bool {
+	return !shared.StringInSlice(config["ipv4.address"], []string{"", "none"})
+}
+
+// IPv6Enabled indicates whether the network has IPv6 configured, i.e. "ipv6.address" is set and isn't "none".
+func (n *common) IPv6Enabled() bool {
+	return ipv6Enabled(n.currentConfig())
+}
+
+// ipv6Enabled indicates whether "ipv6.address" is set and isn't "none" in the supplied config.
+func ipv6Enabled(config map[string]string) bool {
+	return !shared.StringInSlice(config["ipv6.address"], []string{"", "none"})
+}
+
+// HasDHCPv4 indicates whether the network has DHCPv4 enabled.
 func (n *common) HasDHCPv4() bool {
-	if n.config["ipv4.dhcp"] == "" || shared.IsTrue(n.config["ipv4.dhcp"]) {
+	return hasDHCPv4(n.currentConfig())
+}
+
+// hasDHCPv4 indicates whether "ipv4.dhcp" is unset or enabled in the supplied config.
+func hasDHCPv4(config map[string]string) bool {
+	if config["ipv4.dhcp"] == "" || shared.IsTrue(config["ipv4.dhcp"]) {
+		return true
+	}
+
+	return false
+}
+
+// HasDHCPv6 indicates whether the network has DHCPv6 enabled (includes stateless SLAAC router advertisement mode).
+// Technically speaking stateless SLAAC RA mode isn't DHCPv6, but for consistency with LXD's config paradigm, DHCP
+// here means "an ability to automatically allocate IPs and routes", rather than stateful DHCP with leases.
+// To check if true stateful DHCPv6 is enabled check the "ipv6.dhcp.stateful" config key.
+func (n *common) HasDHCPv6() bool {
+	return hasDHCPv6(n.currentConfig())
+}
+
+// hasDHCPv6 indicates whether "ipv6.dhcp" is unset or enabled in the supplied config.
+func hasDHCPv6(config map[string]string) bool {
+	if config["ipv6.dhcp"] == "" || shared.IsTrue(config["ipv6.dhcp"]) {
+		return true
+	}
+
+	return false
+}
+
+// Capabilities returns the features supported by the driver. By default no optional features are supported.
+func (n *common) Capabilities() NetworkCapabilities {
+	return NetworkCapabilities{}
+}
+
+// MTU returns the network's configured MTU or the default of 1500 if not set.
+func (n *common) MTU() (uint32, error) {
+	return n.mtu(1500)
+}
+
+// mtu returns the MTU configured in bridge.mtu or the supplied default if not set. Drivers that have their own
+// default (such as to account for tunnel overhead) use this to implement MTU. Returns an error if the configured
+// value isn't a number between 68 and 65535.
+func (n *common) mtu(defaultMTU uint32) (uint32, error) {
+	config := n.currentConfig()
+
+	if config["bridge.mtu"] == "" {
+		return defaultMTU, nil
+	}
+
+	mtu, err := strconv.ParseUint(config["bridge.mtu"], 10, 32)
+	if err != nil {
+		return 0, errors.Wrapf(err, "Invalid MTU %q", config["bridge.mtu"])
+	}
+
+	if mtu < 68 || mtu > 65535 {
+		return 0, fmt.Errorf("Invalid MTU %d, must be between 68 and 65535", mtu)
+	}
+
+	return uint32(mtu), nil
+}
+
+// Limits returns the network's ingress and egress bandwidth limits in bits per second (e.g. "10000000bit") and its
+// traffic priority. Unset limits are returned as empty strings and an unset priority as 0.
+func (n *common) Limits() (string, string, int, error) {
+	config := n.currentConfig()
+
+	var ingress, egress string
+
+	if config["limits.ingress"] != "" {
+		ingressInt, err := units.ParseBitSizeString(config["limits.ingress"])
+		if err != nil {
+			return "", "", 0, errors.Wrapf(err, "Invalid ingress limit %q", config["limits.ingress"])
+		}
+
+		ingress = fmt.Sprintf("%dbit", ingressInt)
+	}
+
+	if config["limits.egress"] != "" {
+		egressInt, err := units.ParseBitSizeString(config["limits.egress"])
+		if err != nil {
+			return "", "", 0, errors.Wrapf(err, "Invalid egress limit %q", config["limits.egress"])
+		}
+
+		egress = fmt.Sprintf("%dbit", egressInt)
+	}
+
+	priority := 0
+	if config["limits.priority"] != "" {
+		var err error
+		priority, err = strconv.Atoi(config["limits.priority"])
+		if err != nil || priority < 0 {
+			return "", "", 0, fmt.Errorf("Invalid priority %q", config["limits.priority"])
+		}
+	}
+
+	return ingress, egress, priority, nil
+}
+
+// HasDNS indicates whether the network has DNS records enabled (dns.mode isn't "none").
+func (n *common) HasDNS() bool {
+	return dnsMode(n.currentConfig()) != "none"
+}
+
+// DNSDomain returns the domain to advertise to DHCP clients and use for DNS resolution (defaults to "lxd").
+func (n *common) DNSDomain() string {
+	return dnsDomain(n.currentConfig())
+}
+
+// dnsDomain returns "dns.domain" from the supplied config, defaulting to "lxd".
+func dnsDomain(config map[string]string) string {
+	if config["dns.domain"] == "" {
+		return "lxd"
+	}
+
+	return config["dns.domain"]
+}
+
+// DNSMode returns the DNS registration mode, one of "managed", "dynamic" or "none" (defaults to "managed").
+func (n *common) DNSMode() string {
+	return dnsMode(n.currentConfig())
+}
+
+// dnsMode returns "dns.mode" from the supplied config, defaulting to "managed".
+func dnsMode(config map[string]string) string {
+	if config["dns.mode"] == "" {
+		return "managed"
+	}
+
+	return config["dns.mode"]
+}
+
+// DNSSearchDomains returns the list of DNS search domains (defaults to the network's DNS domain).
+func (n *common) DNSSearchDomains() []string {
+	config := n.currentConfig()
+
+	if config["dns.search"] == "" {
+		return []string{n.DNSDomain()}
+	}
+
+	searchDomains := []string{}
+	for _, domain := range strings.Split(config["dns.search"], ",") {
+		domain = strings.TrimSpace(domain)
+		if domain == "" {
+			continue
+		}
+
+		searchDomains = append(searchDomains, domain)
+	}
+
+	return searchDomains
+}
+
+// normalizeIPv4Address checks the "ipv4.address" key in the supplied config and replaces an "auto" value with the
+// gateway address of a newly allocated /24 subnet within 10.0.0.0/8 that doesn't overlap the IPv4 subnet of any
+// other network, so that the concrete address is stored. Other addresses are stored in their canonical form and
+// must include a prefix length and be a usable gateway address rather than the subnet's network address.
+func (n *common) normalizeIPv4Address(config map[string]string) error {
+	value := config["ipv4.address"]
+	if shared.StringInSlice(value, []string{"", "none"}) {
+		return nil
+	}
+
+	if value == "auto" {
+		used, err := n.usedIPv4Subnets()
+		if err != nil {
+			return err
+		}
+
+		subnet, err := randomSubnetV4(used)
+		if err != nil {
+			return err
+		}
+
+		config["ipv4.address"] = fmt.Sprintf("%s/24", GetIP(subnet, 1).String())
+
+		return nil
+	}
+
+	if net.ParseIP(value) != nil {
+		return fmt.Errorf("Invalid value for %q: Address %q must include a prefix length (such as %s/24)", "ipv4.address", value, value)
+	}
+
+	ip, subnet, err := net.ParseCIDR(value)
+	if err != nil || ip.To4() == nil {
+		return fmt.Errorf("Invalid value for %q: %q is not an IPv4 address in CIDR format", "ipv4.address", value)
+	}
+
+	ones, _ := subnet.Mask.Size()
+	if ip.Equal(subnet.IP) && ones < 31 {
+		return fmt.Errorf("Invalid value for %q: %q is the network address of the subnet, a gateway address (such as %s/%d) is required", "ipv4.address", value, GetIP(subnet, 1).String(), ones)
+	}
+
+	config["ipv4.address"] = fmt.Sprintf("%s/%d", ip.To4().String(), ones)
+
+	return nil
+}
+
+// usedIPv4Subnets returns the IPv4 subnets of the other networks.
+func (n *common) usedIPv4Subnets() ([]*net.IPNet, error) {
+	used := []*net.IPNet{}
+	if n.state == nil {
+		return used, nil
+	}
+
+	networks, err := n.state.Cluster.GetNetworks()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range networks {
+		if name == n.name {
+			continue
+		}
+
+		_, netInfo, err := n.state.Cluster.GetNetworkInAnyState(name)
+		if err != nil {
+			return nil, err
+		}
+
+		_, subnet, err := net.ParseCIDR(netInfo.Config["ipv4.address"])
+		if err != nil {
+			continue // No concrete IPv4 address configured.
+		}
+
+		used = append(used, subnet)
+	}
+
+	return used, nil
+}
+
+// generateULAPrefix returns a random RFC 4193 unique local /64 prefix (within fd00::/8, using a random 40-bit
+// global ID and a subnet ID of zero). Prefixes that are already routed or that respond to pings are skipped.
+func (n *common) generateULAPrefix() (*net.IPNet, error) {
+	for i := 0; i < 100; i++ {
+		ip := make(net.IP, net.IPv6len)
+		ip[0] = 0xfd
+
+		_, err := rand.Read(ip[1:6])
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed generating random IPv6 global ID")
+		}
+
+		subnet := &net.IPNet{IP: ip, Mask: net.CIDRMask(64, 128)}
+
+		if inRoutingTable(subnet) {
+			continue
+		}
+
+		if pingSubnet(subnet) {
+			continue
+		}
+
+		return subnet, nil
+	}
+
+	return nil, fmt.Errorf("Failed to automatically find an unused IPv6 subnet, manual configuration required")
+}
+
+// fillIPv6Address replaces an "auto" value of the "ipv6.address" key in the supplied config with the first address
+// of a newly generated unique local prefix, so that the concrete address is stored.
+func (n *common) fillIPv6Address(config map[string]string) error {
+	if config["ipv6.address"] != "auto" {
+		return nil
+	}
+
+	subnet, err := n.generateULAPrefix()
+	if err != nil {
+		return err
+	}
+
+	config["ipv6.address"] = fmt.Sprintf("%s/64", GetIP(subnet, 1).String())
+
+	return nil
+}
+
+// FirewallEnabled indicates whether generating firewall rules is enabled for the address family ("ipv4" or "ipv6").
+func (n *common) FirewallEnabled(family string) bool {
+	config := n.currentConfig()
+
+	value := config[fmt.Sprintf("%s.firewall", family)]
+	if value == "" || shared.IsTrue(value) {
 		return true
 	}
 
-	return false
+	return false
+}
+
+// FirewallRules returns the custom firewall rules configured for the address family ("ipv4" or "ipv6"). Returns
+// an error if any of the rules are malformed.
+func (n *common) FirewallRules(family string) ([]firewallDrivers.NetworkRule, error) {
+	config := n.currentConfig()
+
+	return parseFirewallRules(config[fmt.Sprintf("%s.firewall.rules", family)])
+}
+
+// VLAN returns the native (untagged) VLAN ID of the network from "bridge.vlan", or 0 if none is configured.
+func (n *common) VLAN() (uint16, error) {
+	return parseVLAN(n.currentConfig()["bridge.vlan"])
+}
+
+// parseVLAN parses a single VLAN ID, returning 0 if the value is empty.
+func parseVLAN(value string) (uint16, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	err := validate.IsNetworkVLAN(value)
+	if err != nil {
+		return 0, err
+	}
+
+	vlanID, _ := strconv.ParseUint(value, 10, 16)
+
+	return uint16(vlanID), nil
+}
+
+// VLANTagged returns the tagged VLAN IDs of the network from "bridge.vlan.tagged".
+func (n *common) VLANTagged() ([]uint16, error) {
+	return parseVLANList(n.currentConfig()["bridge.vlan.tagged"])
+}
+
+// DHCPv4Options returns the custom DHCP options from "ipv4.dhcp.options". Returns an error if any of the options
+// are malformed.
+func (n *common) DHCPv4Options() ([]DHCPOption, error) {
+	return parseDHCPOptions(n.currentConfig()["ipv4.dhcp.options"])
+}
+
+// DHCPStrictMode indicates whether the network's DHCP server only answers clients that have a static lease.
+func (n *common) DHCPStrictMode() bool {
+	return shared.IsTrue(n.currentConfig()["security.dhcp.strict"])
+}
+
+// SecurityFiltering returns whether MAC, IPv4 and IPv6 anti-spoofing filtering are enabled for the instance NICs
+// connected to the network ("security.mac_filtering", "security.ipv4_filtering" and "security.ipv6_filtering").
+func (n *common) SecurityFiltering() (mac bool, ipv4 bool, ipv6 bool) {
+	return securityFiltering(n.currentConfig())
+}
+
+// securityFiltering returns whether MAC, IPv4 and IPv6 filtering are enabled in the supplied config.
+func securityFiltering(config map[string]string) (mac bool, ipv4 bool, ipv6 bool) {
+	return shared.IsTrue(config["security.mac_filtering"]), shared.IsTrue(config["security.ipv4_filtering"]), shared.IsTrue(config["security.ipv6_filtering"])
+}
+
+// validateSecurityFiltering checks that IP filtering is only enabled for an address family when LXD knows the
+// address each instance is expected to use, either because the network provides DHCP for that family or because
+// the instance NICs connected to the network have static DHCP leases. Static leases are only checked when the
+// network has state available.
+func (n *common) validateSecurityFiltering(config map[string]string) error {
+	for _, family := range []string{"ipv4", "ipv6"} {
+		key := fmt.Sprintf("security.%s_filtering", family)
+		if !shared.IsTrue(config[key]) {
+			continue
+		}
+
+		hasDHCP := ipv4Enabled(config) && hasDHCPv4(config)
+		if family == "ipv6" {
+			hasDHCP = ipv6Enabled(config) && hasDHCPv6(config)
+		}
+
+		if hasDHCP {
+			continue
+		}
+
+		if n.state != nil {
+			leases, err := n.staticLeases(family)
+			if err != nil {
+				return err
+			}
+
+			if len(leases) > 0 {
+				continue
+			}
+		}
+
+		return fmt.Errorf("Invalid value for network %q option %q: IP filtering requires %q or static DHCP leases", n.name, key, fmt.Sprintf("%s.dhcp", family))
+	}
+
+	return nil
+}
+
+// DHCPv4Gateway returns the network's IPv4 gateway address (the address part of "ipv4.address").
+// Returns ErrNoIPv4Address if the network doesn't have an IPv4 address.
+func (n *common) DHCPv4Gateway() (net.IP, error) {
+	gateway, _, err := parseIPv4Address(n.currentConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	return gateway, nil
+}
+
+// DHCPv4Subnet returns the network's IPv4 subnet (the network part of "ipv4.address").
+// Returns ErrNoIPv4Address if the network doesn't have an IPv4 address.
+func (n *common) DHCPv4Subnet() (*net.IPNet, error) {
+	_, subnet, err := parseIPv4Address(n.currentConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	return subnet, nil
+}
+
+// parseIPv4Address parses "ipv4.address" from the supplied config into the gateway address and subnet.
+func parseIPv4Address(config map[string]string) (net.IP, *net.IPNet, error) {
+	if !ipv4Enabled(config) {
+		return nil, nil, ErrNoIPv4Address
+	}
+
+	gateway, subnet, err := net.ParseCIDR(config["ipv4.address"])
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "Invalid IPv4 address %q", config["ipv4.address"])
+	}
+
+	return gateway, subnet, nil
+}
+
+// DHCPv4Ranges returns a parsed set of DHCPv4 ranges for this network.
+func (n *common) DHCPv4Ranges() []DHCPRange {
+	return dhcpv4Ranges(n.currentConfig())
+}
+
+// dhcpv4Ranges parses "ipv4.dhcp.ranges" from the supplied config, skipping malformed ranges.
+func dhcpv4Ranges(config map[string]string) []DHCPRange {
+	dhcpRanges := make([]DHCPRange, 0)
+	if config["ipv4.dhcp.ranges"] != "" {
+		for _, r := range strings.Split(config["ipv4.dhcp.ranges"], ",") {
+			parts := strings.SplitN(strings.TrimSpace(r), "-", 2)
+			if len(parts) == 2 {
+				startIP := net.ParseIP(parts[0])
+				endIP := net.ParseIP(parts[1])
+				dhcpRanges = append(dhcpRanges, DHCPRange{
+					Start: startIP.To4(),
+					End:   endIP.To4(),
+				})
+			}
+		}
+	}
+
+	return dhcpRanges
+}
+
+// DHCPv4RangesValidated returns a parsed set of DHCPv4 ranges for this network. Unlike DHCPv4Ranges() it returns
+// an error describing the first malformed range rather than silently skipping it.
+func (n *common) DHCPv4RangesValidated() ([]DHCPRange, error) {
+	return dhcpv4RangesValidated(n.currentConfig())
+}
+
+// dhcpv4RangesValidated parses "ipv4.dhcp.ranges" from the supplied config, returning an error for malformed ranges.
+func dhcpv4RangesValidated(config map[string]string) ([]DHCPRange, error) {
+	// Ranges are only checked against the subnet if the network has a concrete IPv4 address.
+	_, subnet, _ := parseIPv4Address(config)
+
+	return parseDHCPv4Ranges(config["ipv4.dhcp.ranges"], subnet)
+}
+
+// EffectiveDHCPv4Ranges returns the DHCPv4 ranges in use for this network. If "ipv4.dhcp.ranges" is set then those
+// ranges are returned, otherwise a single range is derived from the "ipv4.address" subnet which starts at the first
+// host after the gateway address and ends at the last usable host (excluding the network and broadcast addresses).
+func (n *common) EffectiveDHCPv4Ranges() []DHCPRange {
+	return effectiveDHCPv4Ranges(n.currentConfig())
+}
+
+// effectiveDHCPv4Ranges returns the DHCPv4 ranges in use for the supplied config.
+func effectiveDHCPv4Ranges(config map[string]string) []DHCPRange {
+	if config["ipv4.dhcp.ranges"] != "" {
+		return dhcpv4Ranges(config)
+	}
+
+	dhcpRanges := make([]DHCPRange, 0)
+
+	gateway, subnet, err := parseIPv4Address(config)
+	if err != nil || gateway.To4() == nil {
+		return dhcpRanges
+	}
+
+	// Work out the offset of the gateway within the subnet so the range can start after it.
+	gatewayOffset := big.NewInt(0).Sub(big.NewInt(0).SetBytes(gateway.To4()), big.NewInt(0).SetBytes(subnet.IP.To4()))
+
+	startIP := GetIP(subnet, gatewayOffset.Int64()+1)
+	endIP := GetIP(subnet, -2)
+
+	// No usable hosts are left after the gateway.
+	if bytes.Compare(startIP, endIP) > 0 {
+		return dhcpRanges
+	}
+
+	return append(dhcpRanges, DHCPRange{Start: startIP, End: endIP})
+}
+
+// DHCPv4EffectiveRanges returns the ranges from EffectiveDHCPv4Ranges with the addresses listed in
+// "ipv4.dhcp.exclude" removed, splitting ranges where needed. Returns an error if the exclude list is malformed.
+func (n *common) DHCPv4EffectiveRanges() ([]DHCPRange, error) {
+	config := n.currentConfig()
+
+	excludeRanges, err := parseIPv4List(config["ipv4.dhcp.exclude"])
+	if err != nil {
+		return nil, err
+	}
+
+	return subtractDHCPRanges(n.EffectiveDHCPv4Ranges(), excludeRanges), nil
+}
+
+// DHCPv4Reservations returns the IPs reserved from the DHCPv4 pool, sorted by IP.
+func (n *common) DHCPv4Reservations() []DHCPReservation {
+	config := n.currentConfig()
+
+	reservations := []DHCPReservation{}
+	for k, v := range config {
+		if !strings.HasPrefix(k, dhcpv4ReservationPrefix) {
+			continue
+		}
+
+		ip := net.ParseIP(strings.TrimPrefix(k, dhcpv4ReservationPrefix)).To4()
+		if ip == nil {
+			continue
+		}
+
+		reservations = append(reservations, DHCPReservation{IP: ip, Comment: v})
+	}
+
+	sort.Slice(reservations, func(i, j int) bool {
+		return bytes.Compare(reservations[i].IP, reservations[j].IP) < 0
+	})
+
+	return reservations
+}
+
+// FindDuplicateAddresses returns the IP addresses that are claimed by more than one client across the network's
+// dynamic DHCP leases on this node, the static DHCP leases of its instance NICs and its DHCPv4 reservations. The
+// MACs of dynamic leases are matched to the local instances connected to the network where possible.
+func (n *common) FindDuplicateAddresses() ([]AddressConflict, error) {
+	claims, err := n.addressClaims()
+	if err != nil {
+		return nil, err
+	}
+
+	return findAddressConflicts(claims), nil
+}
+
+// AllocatedAddresses returns every IP address in use on the network: its gateway addresses, the static DHCP leases
+// of its instance NICs, its dynamic DHCP leases on this node and its DHCPv4 reservations. The network and broadcast
+// addresses of its subnets are always included as reserved. Addresses are ordered by family and then by IP.
+func (n *common) AllocatedAddresses() ([]AllocatedAddress, error) {
+	claims, err := n.addressClaims()
+	if err != nil {
+		return nil, err
+	}
+
+	return allocatedAddresses(n.currentConfig(), claims), nil
+}
+
+// ValidateInstanceNIC checks that the static addresses and MAC address requested by the supplied instance NIC
+// config can be used on the network. A requested "ipv4.address" or "ipv6.address" must be in the network's subnet
+// with DHCP enabled for its family and must not be the network's gateway, network or broadcast address or one of
+// its DHCPv4 reservations. Addresses within the network's dynamic DHCP ranges are accepted, as they always have
+// been, and are reported by the network's warning checks instead. As this is called whenever an instance NIC's
+// config is validated, only the network's config is checked. Use AllocatedAddresses to find conflicts with the
+// addresses used by other instances.
+func (n *common) ValidateInstanceNIC(nic map[string]string) error {
+	err := validHWAddr(nic["hwaddr"])
+	if err != nil {
+		return err
+	}
+
+	config := n.currentConfig()
+
+	var allocated []AllocatedAddress
+	for _, family := range []string{"ipv4", "ipv6"} {
+		addressKey := fmt.Sprintf("%s.address", family)
+		if nic[addressKey] == "" {
+			continue
+		}
+
+		ip := net.ParseIP(nic[addressKey])
+		if ip == nil || (ip.To4() != nil) != (family == "ipv4") {
+			return fmt.Errorf("Invalid %q value %q", addressKey, nic[addressKey])
+		}
+
+		if family == "ipv4" {
+			if !n.HasDHCPv4() {
+				return fmt.Errorf("Cannot specify %q when %q is disabled on network %q", addressKey, "ipv4.dhcp", n.name)
+			}
+		} else if !n.HasDHCPv6() || !shared.IsTrue(config["ipv6.dhcp.stateful"]) {
+			return fmt.Errorf("Cannot specify %q when %q or %q are disabled on network %q", addressKey, "ipv6.dhcp", "ipv6.dhcp.stateful", n.name)
+		}
+
+		_, subnet, err := net.ParseCIDR(config[addressKey])
+		if err != nil || !subnet.Contains(ip) {
+			return fmt.Errorf("IP address %q is not within network %q subnet", nic[addressKey], n.name)
+		}
+
+		if allocated == nil {
+			claims := []AddressClaim{}
+			for _, reservation := range n.DHCPv4Reservations() {
+				claims = append(claims, AddressClaim{IP: reservation.IP, Source: AddressClaimReservation})
+			}
+
+			allocated = allocatedAddresses(config, claims)
+		}
+
+		for _, address := range allocated {
+			if !address.IP.Equal(ip) {
+				continue
+			}
+
+			if address.Source == AllocatedAddressGateway {
+				return fmt.Errorf("IP address %q is the gateway address of network %q", nic[addressKey], n.name)
+			}
+
+			return fmt.Errorf("IP address %q is reserved on network %q", nic[addressKey], n.name)
+		}
+	}
+
+	return nil
+}
+
+// addressClaims returns the claims on IP addresses made by the static DHCP leases of the network's instance NICs,
+// its DHCPv4 reservations and its dynamic DHCP leases on this node. The MACs of dynamic leases are matched to the
+// local instances connected to the network where possible.
+func (n *common) addressClaims() ([]AddressClaim, error) {
+	claims := []AddressClaim{}
+
+	for _, family := range []string{"ipv4", "ipv6"} {
+		leases, err := n.staticLeases(family)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, lease := range leases {
+			claims = append(claims, AddressClaim{IP: lease.IP, Source: AddressClaimStatic, MAC: lease.MAC, Instance: lease.Hostname})
+		}
+	}
+
+	for _, reservation := range n.DHCPv4Reservations() {
+		claims = append(claims, AddressClaim{IP: reservation.IP, Source: AddressClaimReservation})
+	}
+
+	leases, err := n.localDHCPLeases()
+	if err != nil {
+		return nil, err
+	}
+
+	instanceNames, err := n.instanceHwaddrs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, lease := range leases {
+		mac := strings.ToLower(lease.Hwaddr)
+		claims = append(claims, AddressClaim{IP: net.ParseIP(lease.Address), Source: AddressClaimLease, MAC: mac, Instance: instanceNames[mac]})
+	}
+
+	return claims, nil
+}
+
+// NextFreeDHCPv4IP returns the lowest IP in the network's effective DHCPv4 ranges that isn't leased on this node,
+// reserved, statically assigned to an instance NIC or the gateway. Returns ErrDHCPv4PoolExhausted if there are no
+// free IPs.
+func (n *common) NextFreeDHCPv4IP() (net.IP, error) {
+	dhcpRanges, err := n.DHCPv4EffectiveRanges()
+	if err != nil {
+		return nil, err
+	}
+
+	leases, err := n.localDHCPv4Leases()
+	if err != nil {
+		return nil, err
+	}
+
+	used := map[string]struct{}{}
+	for _, lease := range leases {
+		used[lease.Address] = struct{}{}
+	}
+
+	for _, reservation := range n.DHCPv4Reservations() {
+		used[reservation.IP.String()] = struct{}{}
+	}
+
+	// Use the unvalidated static leases so that leases which have ended up inside a DHCP range are still skipped.
+	staticLeases, err := n.staticLeases("ipv4")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, staticLease := range staticLeases {
+		used[staticLease.IP.String()] = struct{}{}
+	}
+
+	gateway, err := n.DHCPv4Gateway()
+	if err == nil {
+		used[gateway.String()] = struct{}{}
+	}
+
+	sort.Slice(dhcpRanges, func(i, j int) bool {
+		return bytes.Compare(dhcpRanges[i].Start.To4(), dhcpRanges[j].Start.To4()) < 0
+	})
+
+	for _, dhcpRange := range dhcpRanges {
+		start := binary.BigEndian.Uint32(dhcpRange.Start.To4())
+		end := binary.BigEndian.Uint32(dhcpRange.End.To4())
+
+		// Each IP checked is either used or returned, so this stops after at most len(used)+1 IPs.
+		for i := uint64(start); i <= uint64(end); i++ {
+			ip := make(net.IP, net.IPv4len)
+			binary.BigEndian.PutUint32(ip, uint32(i))
+
+			_, found := used[ip.String()]
+			if !found {
+				return ip, nil
+			}
+		}
+	}
+
+	return nil, ErrDHCPv4PoolExhausted
+}
+
+// DHCPLeases returns the active DHCPv4 and DHCPv6 leases from the network's dnsmasq lease file on this node, in the
+// order they appear in the file and with their location set to this node. If the lease file doesn't exist (the
+// network has never been started) then no leases are returned.
+func (n *common) DHCPLeases() ([]api.NetworkLease, error) {
+	leases, err := n.localDHCPLeases()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(leases) == 0 {
+		return leases, nil
+	}
+
+	var serverName string
+	err = n.state.Cluster.Transaction(func(tx *db.ClusterTx) error {
+		serverName, err = tx.GetLocalNodeName()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range leases {
+		leases[i].Location = serverName
+	}
+
+	return leases, nil
+}
+
+// DHCPv4Leases returns the active DHCPv4 leases from the network's dnsmasq lease file on this node, sorted by IP.
+// Leases for the NICs of local instances connected to the network use the instance's DNS name as their hostname.
+// If the lease file doesn't exist (the network has never been started) then no leases are returned.
+func (n *common) DHCPv4Leases() ([]api.NetworkLease, error) {
+	allLeases, err := n.DHCPLeases()
+	if err != nil {
+		return nil, err
+	}
+
+	leases := filterDHCPv4Leases(allLeases)
+	if len(leases) == 0 {
+		return leases, nil
+	}
+
+	instanceNames, err := n.instanceHwaddrs()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range leases {
+		name, found := instanceNames[leases[i].Hwaddr]
+		if found {
+			leases[i].Hostname = name
+		}
+	}
+
+	sort.Slice(leases, func(i, j int) bool {
+		return bytes.Compare(net.ParseIP(leases[i].Address).To4(), net.ParseIP(leases[j].Address).To4()) < 0
+	})
+
+	return leases, nil
+}
+
+// localDHCPLeases returns the active DHCPv4 and DHCPv6 leases from the network's dnsmasq lease file on this node as
+// they appear in the file. If the lease file doesn't exist then no leases are returned.
+func (n *common) localDHCPLeases() ([]api.NetworkLease, error) {
+	content, err := ioutil.ReadFile(shared.VarPath("networks", n.name, "dnsmasq.leases"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []api.NetworkLease{}, nil
+		}
+
+		return nil, err
+	}
+
+	return parseDHCPLeases(string(content), time.Now()), nil
+}
+
+// localDHCPv4Leases returns the active DHCPv4 leases from the network's dnsmasq lease file on this node as they
+// appear in the file. If the lease file doesn't exist then no leases are returned.
+func (n *common) localDHCPv4Leases() ([]api.NetworkLease, error) {
+	leases, err := n.localDHCPLeases()
+	if err != nil {
+		return nil, err
+	}
+
+	return filterDHCPv4Leases(leases), nil
+}
+
+// instanceHwaddrs returns a map of the MAC addresses of the NICs of local instances that are connected to the
+// network to the instance's DNS name.
+func (n *common) instanceHwaddrs() (map[string]string, error) {
+	insts, err := instance.LoadNodeAll(n.state, instancetype.Any)
+	if err != nil {
+		return nil, err
+	}
+
+	hwaddrs := map[string]string{}
+	for _, inst := range insts {
+		nicHwaddrs, err := n.instanceNICHwaddrs(inst)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, hwaddr := range nicHwaddrs {
+			if hwaddr != "" {
+				hwaddrs[hwaddr] = project.DNS(inst.Project(), inst.Name())
+			}
+		}
+	}
+
+	return hwaddrs, nil
+}
+
+// instanceNICHwaddrs returns the lower case MAC addresses of the supplied instance's NICs that are connected to the
+// network, keyed by device name. The MAC address is taken from the volatile key if the NIC doesn't specify one, and
+// is empty if neither is set yet.
+func (n *common) instanceNICHwaddrs(inst instance.Instance) (map[string]string, error) {
+	hwaddrs := map[string]string{}
+	for devName, dev := range inst.ExpandedDevices() {
+		if dev["type"] != "nic" {
+			continue
+		}
+
+		inUse, err := isInUseByDevices(n.state, deviceConfig.Devices{devName: dev}, n.name)
+		if err != nil {
+			return nil, err
+		}
+
+		if !inUse {
+			continue
+		}
+
+		// Fill in the hwaddr from volatile.
+		hwaddr := dev["hwaddr"]
+		if hwaddr == "" {
+			hwaddr = inst.LocalConfig()[fmt.Sprintf("volatile.%s.hwaddr", devName)]
+		}
+
+		hwaddrs[devName] = strings.ToLower(hwaddr)
+	}
+
+	return hwaddrs, nil
+}
+
+// InstanceIPs returns the IPv4 and IPv6 addresses of the specified instance's NICs that are connected to the
+// network, sorted with IPv4 addresses first. Addresses come from the NIC's static ipv4.address and ipv6.address
+// settings and from the network's current DHCP leases on this node. If the instance doesn't have a NIC connected to
+// the network then no addresses are returned.
+func (n *common) InstanceIPs(instanceName string, projectName string) ([]net.IP, error) {
+	inst, err := instance.LoadByProjectAndName(n.state, projectName, instanceName)
+	if err != nil {
+		return nil, err
+	}
+
+	nicHwaddrs, err := n.instanceNICHwaddrs(inst)
+	if err != nil {
+		return nil, err
+	}
+
+	ips := []net.IP{}
+	hwaddrs := []string{}
+	devices := inst.ExpandedDevices()
+	for devName, hwaddr := range nicHwaddrs {
+		for _, key := range []string{"ipv4.address", "ipv6.address"} {
+			ip := net.ParseIP(devices[devName][key])
+			if ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+
+		if hwaddr != "" {
+			hwaddrs = append(hwaddrs, hwaddr)
+		}
+	}
+
+	if len(hwaddrs) > 0 {
+		leases, err := n.localDHCPLeases()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, lease := range leases {
+			if shared.StringInSlice(strings.ToLower(lease.Hwaddr), hwaddrs) {
+				ips = append(ips, net.ParseIP(lease.Address))
+			}
+		}
+	}
+
+	return sortIPs(ips), nil
+}
+
+// RenderDHCPConfig is not supported by default.
+func (n *common) RenderDHCPConfig() (string, error) {
+	return "", ErrNotImplemented
+}
+
+// FlushDHCPLeases is not supported by default.
+func (n *common) FlushDHCPLeases() error {
+	return ErrNotImplemented
+}
+
+// flushDHCPLeases removes the dynamic leases from the network's dnsmasq lease file, keeping the leases of instance
+// NICs with a static IPv4 or IPv6 address. A missing lease file is ignored. dnsmasq must not be running, as it would
+// otherwise write the leases it holds in memory back to the file.
+func (n *common) flushDHCPLeases() error {
+	leasesPath := shared.VarPath("networks", n.name, "dnsmasq.leases")
+
+	content, err := ioutil.ReadFile(leasesPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	staticMACs := []string{}
+	for _, family := range []string{"ipv4", "ipv6"} {
+		leases, err := n.staticLeases(family)
+		if err != nil {
+			return err
+		}
+
+		for _, lease := range leases {
+			if lease.MAC != "" {
+				staticMACs = append(staticMACs, lease.MAC)
+			}
+		}
+	}
+
+	return ioutil.WriteFile(leasesPath, []byte(filterDHCPLeases(string(content), staticMACs)), 0644)
+}
+
+// ReserveDHCPv4IP is not supported by default.
+func (n *common) ReserveDHCPv4IP(ip net.IP, comment string) error {
+	return ErrNotImplemented
+}
+
+// ReleaseDHCPv4IP is not supported by default.
+func (n *common) ReleaseDHCPv4IP(ip net.IP) error {
+	return ErrNotImplemented
+}
+
+// SetMaintenance is not supported by default.
+func (n *common) SetMaintenance(on bool) error {
+	return ErrNotImplemented
+}
+
+// setMaintenance returns the network's config with maintenance mode enabled or disabled. The mode is stored in
+// the "volatile.maintenance" key so that it is propagated to all nodes along with the rest of the config.
+func (n *common) setMaintenance(on bool) api.NetworkPut {
+	newNetwork := n.copyNetwork()
+
+	if on {
+		newNetwork.Config["volatile.maintenance"] = "true"
+	} else {
+		// Volatile keys are only removed when explicitly set to an empty value.
+		newNetwork.Config["volatile.maintenance"] = ""
+	}
+
+	return newNetwork
+}
+
+// Renumber is not supported by default.
+func (n *common) Renumber(newCIDR string, shiftRanges bool) error {
+	return ErrNotImplemented
+}
+
+// renumber moves the network to the subnet of the supplied CIDR address. The renumbered config is validated and
+// checked against the other networks, applied by calling the supplied update function and then the static addresses
+// of the instance NICs connected to the network are moved to the corresponding hosts in the new subnet. If any of
+// the instances can't be updated then the instances already updated and the network config are reverted.
+// Running instances are only logged, as they need to be restarted to pick up their new addresses.
+func (n *common) renumber(newCIDR string, shiftRanges bool, update func(newNetwork api.NetworkPut) error) error {
+	oldNetwork := n.copyNetwork()
+
+	newNetwork, oldSubnet, newSubnet, err := n.renumberConfig(newCIDR, shiftRanges)
+	if err != nil {
+		return err
+	}
+
+	// This also checks the new subnet doesn't overlap with other networks.
+	err = n.validateConfig(oldNetwork.Config, newNetwork.Config, "")
+	if err != nil {
+		return err
+	}
+
+	revert := revert.New()
+	defer revert.Fail()
+
+	err = update(newNetwork)
+	if err != nil {
+		return err
+	}
+
+	revert.Add(func() {
+		err := update(oldNetwork)
+		if err != nil {
+			n.logger.Error("Failed reverting renumbered network config", log.Ctx{"err": err})
+		}
+	})
+
+	err = n.renumberStaticLeases(oldSubnet, newSubnet)
+	if err != nil {
+		return err
+	}
+
+	revert.Success()
+	return nil
+}
+
+// renumberConfig returns the network's config with the address of the same family as the supplied CIDR address
+// replaced by it, along with the old and new subnets. If shiftRanges is true the DHCP ranges are moved into the new
+// subnet in proportion to their position in the old one, otherwise they are removed so that the defaults are used.
+// All the other addresses of the family within the old subnet are moved to the corresponding hosts in the new
+// subnet. These are the DHCP gateway, reservations and exclusions, the NAT address, the routes and their gateways
+// and the custom DNS records. Addresses outside of the old subnet are left unchanged.
+func (n *common) renumberConfig(newCIDR string, shiftRanges bool) (api.NetworkPut, *net.IPNet, *net.IPNet, error) {
+	newIP, newSubnet, err := net.ParseCIDR(newCIDR)
+	if err != nil {
+		return api.NetworkPut{}, nil, nil, errors.Wrapf(err, "Invalid subnet %q", newCIDR)
+	}
+
+	family := 4
+	if newIP.To4() == nil {
+		family = 6
+	}
+
+	newNetwork := n.copyNetwork()
+	addressKey := fmt.Sprintf("ipv%d.address", family)
+
+	_, oldSubnet, err := net.ParseCIDR(newNetwork.Config[addressKey])
+	if err != nil {
+		return api.NetworkPut{}, nil, nil, fmt.Errorf("Network %q has no subnet in %q to renumber", n.name, addressKey)
+	}
+
+	newNetwork.Config[addressKey] = newCIDR
+
+	rangesKey := fmt.Sprintf("ipv%d.dhcp.ranges", family)
+	if newNetwork.Config[rangesKey] != "" {
+		if !shiftRanges {
+			delete(newNetwork.Config, rangesKey)
+		} else {
+			dhcpRanges, err := parseDHCPRanges(newNetwork.Config[rangesKey], oldSubnet, family)
+			if err != nil {
+				return api.NetworkPut{}, nil, nil, errors.Wrapf(err, "Invalid value for option %q", rangesKey)
+			}
+
+			values := []string{}
+			for _, dhcpRange := range scaleDHCPRanges(dhcpRanges, oldSubnet, newSubnet) {
+				values = append(values, fmt.Sprintf("%s-%s", dhcpRange.Start.String(), dhcpRange.End.String()))
+			}
+
+			newNetwork.Config[rangesKey] = strings.Join(values, ",")
+		}
+	}
+
+	// renumberValue moves the address in the value to the new subnet if it is within the old one.
+	renumberValue := func(value string) (string, error) {
+		ip := net.ParseIP(value)
+		if ip == nil || !oldSubnet.Contains(ip) {
+			return value, nil
+		}
+
+		newIP, err := renumberIP(ip, oldSubnet, newSubnet)
+		if err != nil {
+			return "", err
+		}
+
+		return newIP.String(), nil
+	}
+
+	// renumberKey applies the supplied function to each comma separated entry of the key's value.
+	renumberKey := func(key string, renumberEntry func(entry string) (string, error)) error {
+		if newNetwork.Config[key] == "" {
+			return nil
+		}
+
+		entries := strings.Split(newNetwork.Config[key], ",")
+		for i, entry := range entries {
+			newEntry, err := renumberEntry(strings.TrimSpace(entry))
+			if err != nil {
+				return errors.Wrapf(err, "Invalid value for option %q", key)
+			}
+
+			entries[i] = newEntry
+		}
+
+		newNetwork.Config[key] = strings.Join(entries, ",")
+		return nil
+	}
+
+	// renumberRoute moves a route within the old subnet and its gateway to the new subnet.
+	renumberRoute := func(entry string) (string, error) {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			return entry, nil
+		}
+
+		_, destination, err := net.ParseCIDR(fields[0])
+		if err != nil {
+			return "", err
+		}
+
+		oldOnes, _ := oldSubnet.Mask.Size()
+		ones, bits := destination.Mask.Size()
+		if ones >= oldOnes && oldSubnet.Contains(destination.IP) {
+			newDestination, err := renumberIP(destination.IP, oldSubnet, newSubnet)
+			if err != nil {
+				return "", err
+			}
+
+			newOnes, _ := newSubnet.Mask.Size()
+			if ones < newOnes {
+				return "", fmt.Errorf("Route %q can't be mapped into subnet %q", fields[0], newSubnet.String())
+			}
+
+			fields[0] = (&net.IPNet{IP: newDestination, Mask: net.CIDRMask(ones, bits)}).String()
+		}
+
+		if len(fields) == 3 {
+			fields[2], err = renumberValue(fields[2])
+			if err != nil {
+				return "", err
+			}
+		}
+
+		return strings.Join(fields, " "), nil
+	}
+
+	keys := map[string]func(entry string) (string, error){
+		fmt.Sprintf("ipv%d.nat.address", family):     renumberValue,
+		fmt.Sprintf("ipv%d.routes", family):          renumberRoute,
+		fmt.Sprintf("ipv%d.routes.external", family): renumberRoute,
+	}
+
+	if family == 4 {
+		keys["ipv4.dhcp.gateway"] = renumberValue
+		keys["ipv4.dhcp.exclude"] = func(entry string) (string, error) {
+			ips := strings.SplitN(entry, "-", 2)
+			for i := range ips {
+				ip, err := renumberValue(strings.TrimSpace(ips[i]))
+				if err != nil {
+					return "", err
+				}
+
+				ips[i] = ip
+			}
+
+			return strings.Join(ips, "-"), nil
+		}
+	}
+
+	for key, renumberEntry := range keys {
+		err = renumberKey(key, renumberEntry)
+		if err != nil {
+			return api.NetworkPut{}, nil, nil, err
+		}
+	}
+
+	// Iterate over the current config rather than the new one, as renumbering a reservation changes its key.
+	config := n.currentConfig()
+	for key := range config {
+		value := newNetwork.Config[key]
+		if family == 4 && strings.HasPrefix(key, dhcpv4ReservationPrefix) {
+			ip, err := renumberValue(strings.TrimPrefix(key, dhcpv4ReservationPrefix))
+			if err != nil {
+				return api.NetworkPut{}, nil, nil, errors.Wrapf(err, "Invalid value for option %q", key)
+			}
+
+			delete(newNetwork.Config, key)
+			newNetwork.Config[dhcpv4ReservationPrefix+ip] = value
+		} else if strings.HasPrefix(key, dnsRecordPrefix) {
+			fields := strings.Fields(value)
+			if len(fields) != 2 || !shared.StringInSlice(strings.ToUpper(fields[0]), []string{"A", "AAAA"}) {
+				continue
+			}
+
+			fields[1], err = renumberValue(fields[1])
+			if err != nil {
+				return api.NetworkPut{}, nil, nil, errors.Wrapf(err, "Invalid value for option %q", key)
+			}
+
+			newNetwork.Config[key] = strings.Join(fields, " ")
+		}
+	}
+
+	return newNetwork, oldSubnet, newSubnet, nil
+}
+
+// renumberStaticLeases moves the static addresses of the bridged instance NICs connected to the network from
+// oldSubnet to the corresponding hosts in newSubnet. Only NICs defined on the instances themselves are changed,
+// NICs inherited from profiles are logged so they can be updated manually. If any instance can't be updated then
+// the instances already updated are reverted and the error is returned.
+func (n *common) renumberStaticLeases(oldSubnet *net.IPNet, newSubnet *net.IPNet) error {
+	insts, err := instance.LoadFromAllProjects(n.state)
+	if err != nil {
+		return err
+	}
+
+	addressKey := "ipv6.address"
+	if newSubnet.IP.To4() != nil {
+		addressKey = "ipv4.address"
+	}
+
+	revert := revert.New()
+	defer revert.Fail()
+
+	for _, inst := range insts {
+		devices := inst.LocalDevices().Clone()
+		changed := false
+
+		for devName, d := range inst.ExpandedDevices() {
+			if d["type"] != "nic" {
+				continue
+			}
+
+			inUse, err := isInUseByDevices(n.state, deviceConfig.Devices{devName: d}, n.name)
+			if err != nil {
+				return err
+			}
+
+			if !inUse {
+				continue
+			}
+
+			if inst.IsRunning() {
+				n.logger.Warn("Instance needs restarting to use the renumbered network", log.Ctx{"project": inst.Project(), "instance": inst.Name(), "device": devName})
+			}
+
+			ip := net.ParseIP(d[addressKey])
+			if ip == nil || !oldSubnet.Contains(ip) {
+				continue
+			}
+
+			_, local := devices[devName]
+			if !local {
+				n.logger.Warn("Static address of profile NIC must be renumbered manually", log.Ctx{"project": inst.Project(), "instance": inst.Name(), "device": devName, "address": ip.String()})
+				continue
+			}
+
+			newIP, err := renumberIP(ip, oldSubnet, newSubnet)
+			if err != nil {
+				return errors.Wrapf(err, "Failed renumbering static address of device %q of instance %q in project %q", devName, inst.Name(), inst.Project())
+			}
+
+			devices[devName][addressKey] = newIP.String()
+			changed = true
+		}
+
+		if !changed {
+			continue
+		}
+
+		args := db.InstanceArgs{
+			Architecture: inst.Architecture(),
+			Config:       inst.LocalConfig(),
+			Description:  inst.Description(),
+			Devices:      devices,
+			Ephemeral:    inst.IsEphemeral(),
+			Profiles:     inst.Profiles(),
+			Project:      inst.Project(),
+			Type:         inst.Type(),
+			Snapshot:     inst.IsSnapshot(),
+		}
+
+		oldArgs := args
+		oldArgs.Devices = inst.LocalDevices().Clone()
+
+		err = inst.Update(args, false)
+		if err != nil {
+			return errors.Wrapf(err, "Failed updating static addresses of instance %q in project %q", inst.Name(), inst.Project())
+		}
+
+		inst := inst
+		revert.Add(func() {
+			err := inst.Update(oldArgs, false)
+			if err != nil {
+				n.logger.Error("Failed reverting static addresses of instance", log.Ctx{"project": inst.Project(), "instance": inst.Name(), "err": err})
+			}
+		})
+	}
+
+	revert.Success()
+	return nil
+}
+
+// reserveDHCPv4IP returns the network's config with a reservation for the supplied IP added. The IP must be within
+// one of the network's effective DHCPv4 ranges and not already reserved.
+func (n *common) reserveDHCPv4IP(ip net.IP, comment string) (api.NetworkPut, error) {
+	config := n.currentConfig()
+
+	ip = ip.To4()
+	if ip == nil {
+		return api.NetworkPut{}, fmt.Errorf("Reserved IP must be an IPv4 address")
+	}
+
+	inRange := false
+	for _, dhcpRange := range n.EffectiveDHCPv4Ranges() {
+		if bytes.Compare(ip, dhcpRange.Start.To4()) >= 0 && bytes.Compare(ip, dhcpRange.End.To4()) <= 0 {
+			inRange = true
+			break
+		}
+	}
+
+	if !inRange {
+		return api.NetworkPut{}, fmt.Errorf("IP %q is not within a DHCP range of network %q", ip.String(), n.name)
+	}
+
+	key := dhcpv4ReservationPrefix + ip.String()
+	_, found := config[key]
+	if found {
+		return api.NetworkPut{}, fmt.Errorf("IP %q is already reserved on network %q", ip.String(), n.name)
+	}
+
+	newNetwork := n.copyNetwork()
+	newNetwork.Config[key] = comment
+
+	return newNetwork, nil
+}
+
+// releaseDHCPv4IP returns the network's config with the reservation for the supplied IP removed.
+func (n *common) releaseDHCPv4IP(ip net.IP) (api.NetworkPut, error) {
+	config := n.currentConfig()
+
+	key := dhcpv4ReservationPrefix + ip.String()
+	_, found := config[key]
+	if !found {
+		return api.NetworkPut{}, fmt.Errorf("IP %q is not reserved on network %q", ip.String(), n.name)
+	}
+
+	newNetwork := n.copyNetwork()
+	delete(newNetwork.Config, key)
+
+	return newNetwork, nil
+}
+
+// DNSUpstreams returns the upstream DNS servers that the network's DNS server forwards queries it isn't
+// authoritative for to (from "dns.nameservers"). An empty list means the host's resolvers are used.
+func (n *common) DNSUpstreams() ([]net.IP, error) {
+	return parseDNSUpstreams(n.currentConfig()["dns.nameservers"])
+}
+
+// parseDNSUpstreams parses a comma separated list of nameserver addresses.
+func parseDNSUpstreams(value string) ([]net.IP, error) {
+	upstreams := []net.IP{}
+	if value == "" {
+		return upstreams, nil
+	}
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return nil, fmt.Errorf("Invalid nameserver address %q", entry)
+		}
+
+		upstreams = append(upstreams, ip)
+	}
+
+	return upstreams, nil
+}
+
+// DNSRecords returns the custom DNS records configured on the network, sorted by name. Returns an error if any of
+// the records are malformed.
+func (n *common) DNSRecords() ([]api.NetworkDNSRecord, error) {
+	return dnsRecords(n.currentConfig())
+}
+
+// dnsRecords returns the custom DNS records in the supplied config, sorted by name.
+func dnsRecords(config map[string]string) ([]api.NetworkDNSRecord, error) {
+	records := []api.NetworkDNSRecord{}
+	for k, v := range config {
+		if !strings.HasPrefix(k, dnsRecordPrefix) {
+			continue
+		}
+
+		record, err := parseDNSRecord(strings.TrimPrefix(k, dnsRecordPrefix), v)
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, record)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Name < records[j].Name
+	})
+
+	return records, nil
+}
+
+// AddDNSRecord is not supported by default.
+func (n *common) AddDNSRecord(record api.NetworkDNSRecord) error {
+	return ErrNotImplemented
+}
+
+// DeleteDNSRecord is not supported by default.
+func (n *common) DeleteDNSRecord(name string) error {
+	return ErrNotImplemented
+}
+
+// addDNSRecord returns the network's config with the supplied DNS record added. The record name must be within the
+// network's DNS domain and mustn't already have a record.
+func (n *common) addDNSRecord(record api.NetworkDNSRecord) (api.NetworkPut, error) {
+	config := n.currentConfig()
+
+	record, err := parseDNSRecord(strings.ToLower(record.Name), fmt.Sprintf("%s %s", record.Type, record.Value))
+	if err != nil {
+		return api.NetworkPut{}, err
+	}
+
+	domain := n.DNSDomain()
+	if !dnsNameInDomain(record.Name, domain) {
+		return api.NetworkPut{}, fmt.Errorf("DNS record name %q is not within the DNS domain %q of network %q", record.Name, domain, n.name)
+	}
+
+	key := dnsRecordPrefix + record.Name
+	_, found := config[key]
+	if found {
+		return api.NetworkPut{}, fmt.Errorf("DNS record %q already exists on network %q", record.Name, n.name)
+	}
+
+	newNetwork := n.copyNetwork()
+	newNetwork.Config[key] = fmt.Sprintf("%s %s", record.Type, record.Value)
+
+	return newNetwork, nil
+}
+
+// deleteDNSRecord returns the network's config with the DNS record for the supplied name removed.
+func (n *common) deleteDNSRecord(name string) (api.NetworkPut, error) {
+	config := n.currentConfig()
+
+	key := dnsRecordPrefix + strings.ToLower(name)
+	_, found := config[key]
+	if !found {
+		return api.NetworkPut{}, fmt.Errorf("DNS record %q doesn't exist on network %q", name, n.name)
+	}
+
+	newNetwork := n.copyNetwork()
+	delete(newNetwork.Config, key)
+
+	return newNetwork, nil
+}
+
+// preserveVolatileKeys returns a copy of the supplied network with the network's existing volatile keys added
+// where the new config doesn't mention them. Volatile keys are set internally so clients shouldn't need to echo
+// them back when replacing the config. A volatile key explicitly set to an empty value is removed.
+func (n *common) preserveVolatileKeys(newNetwork api.NetworkPut) api.NetworkPut {
+	config := n.currentConfig()
+
+	mergedNetwork := api.NetworkPut{
+		Description: newNetwork.Description,
+		Config:      make(map[string]string, len(newNetwork.Config)),
+	}
+
+	for k, v := range newNetwork.Config {
+		mergedNetwork.Config[k] = v
+	}
+
+	for k, v := range config {
+		if !strings.HasPrefix(k, "volatile.") {
+			continue
+		}
+
+		_, found := mergedNetwork.Config[k]
+		if !found {
+			mergedNetwork.Config[k] = v
+		}
+	}
+
+	for k, v := range mergedNetwork.Config {
+		if v == "" && strings.HasPrefix(k, "volatile.") {
+			delete(mergedNetwork.Config, k)
+		}
+	}
+
+	return mergedNetwork
+}
+
+// copyNetwork returns a copy of the network's current description and config.
+func (n *common) copyNetwork() api.NetworkPut {
+	config := n.currentConfig()
+
+	newNetwork := api.NetworkPut{
+		Description: n.currentDescription(),
+		Config:      make(map[string]string, len(config)),
+	}
+
+	for k, v := range config {
+		newNetwork.Config[k] = v
+	}
+
+	return newNetwork
+}
+
+// DHCPv4PoolSize returns the total number of addresses across the network's DHCPv4 ranges (or the derived default
+// range if none are configured), excluding the gateway address if it falls inside a range. Returns an error if any
+// of the configured ranges are malformed.
+func (n *common) DHCPv4PoolSize() (int64, error) {
+	return dhcpv4PoolSize(n.currentConfig())
+}
+
+// dhcpv4PoolSize returns the total number of addresses across the DHCPv4 ranges of the supplied config.
+func dhcpv4PoolSize(config map[string]string) (int64, error) {
+	dhcpRanges, err := dhcpv4RangesValidated(config)
+	if err != nil {
+		return -1, err
+	}
+
+	if len(dhcpRanges) == 0 {
+		dhcpRanges = effectiveDHCPv4Ranges(config)
+	}
+
+	gateway, _, _ := parseIPv4Address(config)
+
+	return dhcpRangesSize(dhcpRanges, gateway).Int64(), nil
+}
+
+// DHCPv6PoolSize returns the total number of addresses across the network's DHCPv6 ranges (or all of the addresses
+// after the gateway in the "ipv6.address" subnet if none are configured), excluding the gateway address if it falls
+// inside a range. As IPv6 pools can be far larger than an int64 can hold, the returned value saturates at
+// math.MaxInt64. Returns an error if any of the configured ranges are malformed.
+func (n *common) DHCPv6PoolSize() (int64, error) {
+	config := n.currentConfig()
+
+	gateway, subnet, _ := net.ParseCIDR(config["ipv6.address"])
+	if gateway != nil && gateway.To4() != nil {
+		gateway = nil
+		subnet = nil
+	}
+
+	dhcpRanges, err := parseDHCPv6Ranges(config["ipv6.dhcp.ranges"], subnet)
+	if err != nil {
+		return -1, err
+	}
+
+	size := big.NewInt(0)
+	if len(dhcpRanges) > 0 {
+		size = dhcpRangesSize(dhcpRanges, gateway)
+	} else if subnet != nil {
+		// Count from the host after the gateway to the last address in the subnet.
+		ones, bits := subnet.Mask.Size()
+		lastIP := big.NewInt(0).SetBytes(subnet.IP.To16())
+		lastIP.Add(lastIP, big.NewInt(0).Lsh(big.NewInt(1), uint(bits-ones)))
+		lastIP.Sub(lastIP, big.NewInt(1))
+
+		size.Sub(lastIP, big.NewInt(0).SetBytes(gateway.To16()))
+		if size.Sign() < 0 {
+			size.SetInt64(0)
+		}
+	}
+
+	if !size.IsInt64() {
+		return math.MaxInt64, nil
+	}
+
+	return size.Int64(), nil
+}
+
+// DHCPv6Ranges returns a parsed set of DHCPv6 ranges for this network.
+func (n *common) DHCPv6Ranges() []DHCPRange {
+	config := n.currentConfig()
+
+	dhcpRanges := make([]DHCPRange, 0)
+	if config["ipv6.dhcp.ranges"] != "" {
+		for _, r := range strings.Split(config["ipv6.dhcp.ranges"], ",") {
+			parts := strings.SplitN(strings.TrimSpace(r), "-", 2)
+			if len(parts) == 2 {
+				startIP := net.ParseIP(parts[0])
+				endIP := net.ParseIP(parts[1])
+				dhcpRanges = append(dhcpRanges, DHCPRange{
+					Start: startIP.To16(),
+					End:   endIP.To16(),
+				})
+			}
+		}
+	}
+
+	return dhcpRanges
+}
+
+// IPv6RAConfig returns the IPv6 router advertisement settings derived from "ipv6.dhcp", "ipv6.dhcp.stateful" and
+// "ipv6.dns". Stateful DHCPv6 is ignored when DHCPv6 itself is disabled, as Validate doesn't allow this combination
+// but networks set up before it was checked may still have it.
+func (n *common) IPv6RAConfig() RAConfig {
+	config := n.currentConfig()
+
+	if !n.IPv6Enabled() {
+		return RAConfig{Mode: RAModeNone}
+	}
+
+	raConfig := RAConfig{
+		Mode:         RAModeSLAAC,
+		AdvertiseDNS: config["ipv6.dns"] == "" || shared.IsTrue(config["ipv6.dns"]),
+	}
+
+	if n.HasDHCPv6() {
+		raConfig.Mode = RAModeOtherConfig
+		if shared.IsTrue(config["ipv6.dhcp.stateful"]) {
+			raConfig.Mode = RAModeManaged
+		}
+	}
+
+	return raConfig
+}
+
+// StaticRoutes returns the external static routes configured in "ipv4.routes.external" and "ipv6.routes.external",
+// with the IPv4 routes first. Returns an error if any route is invalid or has a gateway that isn't within the
+// network's subnet of the same family.
+func (n *common) StaticRoutes() ([]StaticRoute, error) {
+	return staticRoutes(n.currentConfig())
+}
+
+// staticRoutes returns the external static routes configured in the supplied config, with the IPv4 routes first.
+func staticRoutes(config map[string]string) ([]StaticRoute, error) {
+	routes := []StaticRoute{}
+
+	for _, family := range []int{4, 6} {
+		key := fmt.Sprintf("ipv%d.routes.external", family)
+		addressKey := fmt.Sprintf("ipv%d.address", family)
+
+		_, subnet, _ := net.ParseCIDR(config[addressKey])
+		familyRoutes, err := parseStaticRoutes(config[key], family, subnet)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid %q", key)
+		}
+
+		for _, route := range familyRoutes {
+			if route.Gateway != nil && subnet == nil {
+				return nil, fmt.Errorf("Invalid %q: Route gateway %q requires %q to be set", key, route.Gateway.String(), addressKey)
+			}
+		}
+
+		routes = append(routes, familyRoutes...)
+	}
+
+	return routes, nil
+}
+
+// BridgeHWAddr returns the MAC address configured in "bridge.hwaddr", or otherwise the one previously generated
+// for this node and stored in "volatile.bridge.hwaddr". If neither is set and generate is true then a stable
+// locally administered MAC address is generated from the names of this node and of the network, stored in
+// "volatile.bridge.hwaddr" and returned. Otherwise nil is returned and the bridge keeps its existing MAC address.
+func (n *common) BridgeHWAddr(generate bool) (net.HardwareAddr, error) {
+	config := n.currentConfig()
+
+	for _, key := range []string{"bridge.hwaddr", "volatile.bridge.hwaddr"} {
+		if config[key] == "" {
+			continue
+		}
+
+		err := validHWAddr(config[key])
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid %q", key)
+		}
+
+		return net.ParseMAC(config[key])
+	}
+
+	if !generate {
+		return nil, nil
+	}
+
+	// Seed the address with the node name so that each cluster member gets its own address.
+	var serverName string
+	err := n.state.Cluster.Transaction(func(tx *db.ClusterTx) error {
+		var err error
+		serverName, err = tx.GetLocalNodeName()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	hwAddr := stableHWAddr(fmt.Sprintf("%s/%s", serverName, n.name))
+
+	err = n.state.Cluster.Transaction(func(tx *db.ClusterTx) error {
+		return tx.UpdateNetworkConfig(n.id, map[string]string{"volatile.bridge.hwaddr": hwAddr.String()})
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed storing generated MAC address")
+	}
+
+	n.configLock.Lock()
+	newConfig := make(map[string]string, len(n.config)+1)
+	for k, v := range n.config {
+		newConfig[k] = v
+	}
+
+	newConfig["volatile.bridge.hwaddr"] = hwAddr.String()
+	n.config = newConfig
+	n.configLock.Unlock()
+
+	return hwAddr, nil
+}
+
+// ProbeTunnelRemotes checks whether the remote endpoint of each tunnel with a remote address is reachable, waiting
+// up to the supplied timeout for each. An ICMP echo request is tried first, and if that fails then VXLAN tunnels
+// fall back to a TCP connection attempt on the tunnel port (as ping may need privileges that aren't available).
+// The results are advisory only and are returned in tunnel name order.
+func (n *common) ProbeTunnelRemotes(timeout time.Duration) ([]TunnelProbeResult, error) {
+	if timeout <= 0 {
+		return nil, fmt.Errorf("Probe timeout must be positive")
+	}
+
+	tunnels := []tunnel{}
+	for _, tunnel := range parseTunnels(n.currentConfig()) {
+		if tunnel.Remote != "" {
+			tunnels = append(tunnels, tunnel)
+		}
+	}
+
+	results := make([]TunnelProbeResult, len(tunnels))
+	wg := sync.WaitGroup{}
+
+	for i := range tunnels {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = probeTunnelRemote(tunnels[i], timeout)
+		}(i)
+	}
+
+	wg.Wait()
+
+	return results, nil
+}
+
+// DHCPv4ExpiryTime returns the DHCPv4 lease time from "ipv4.dhcp.expiry" (defaults to 1h), or DHCPExpiryInfinite
+// if leases don't expire.
+func (n *common) DHCPv4ExpiryTime() (time.Duration, error) {
+	return parseDHCPExpiry(n.currentConfig()["ipv4.dhcp.expiry"])
+}
+
+// DHCPv6ExpiryTime returns the DHCPv6 lease time from "ipv6.dhcp.expiry" (defaults to 1h), or DHCPExpiryInfinite
+// if leases don't expire.
+func (n *common) DHCPv6ExpiryTime() (time.Duration, error) {
+	return parseDHCPExpiry(n.currentConfig()["ipv6.dhcp.expiry"])
+}
+
+// DHCPv6PDRanges returns the parsed DHCPv6 prefix delegation pool ranges from "ipv6.dhcp.pd.ranges". Returns an
+// error if the ranges are invalid or if prefix delegation is configured without stateful DHCPv6 being enabled.
+func (n *common) DHCPv6PDRanges() ([]DHCPRange, error) {
+	return dhcpv6PDRanges(n.currentConfig())
+}
+
+// dhcpv6PDRanges parses "ipv6.dhcp.pd.ranges" from the supplied config.
+func dhcpv6PDRanges(config map[string]string) ([]DHCPRange, error) {
+	if config["ipv6.dhcp.pd.ranges"] == "" {
+		return []DHCPRange{}, nil
+	}
+
+	if !shared.IsTrue(config["ipv6.dhcp.stateful"]) {
+		return nil, fmt.Errorf("Prefix delegation requires %q to be enabled", "ipv6.dhcp.stateful")
+	}
+
+	return parseDHCPv6Ranges(config["ipv6.dhcp.pd.ranges"], nil)
+}
+
+// DHCPv6PDPrefixLength returns the length of the prefixes delegated from the DHCPv6 prefix delegation pool, which
+// defaults to 64. Returns an error if the length isn't between 1 and 128 or isn't longer than the prefix shared by
+// the start and end of each pool range.
+func (n *common) DHCPv6PDPrefixLength() (int, error) {
+	return dhcpv6PDPrefixLength(n.currentConfig())
+}
+
+// dhcpv6PDPrefixLength returns the delegated prefix length of the supplied config, defaulting to 64.
+func dhcpv6PDPrefixLength(config map[string]string) (int, error) {
+	ranges, err := dhcpv6PDRanges(config)
+	if err != nil {
+		return -1, err
+	}
+
+	length := 64
+	if config["ipv6.dhcp.pd.prefix_length"] != "" {
+		length, err = strconv.Atoi(config["ipv6.dhcp.pd.prefix_length"])
+		if err != nil {
+			return -1, errors.Wrapf(err, "Invalid delegated prefix length")
+		}
+
+		if length < 1 || length > 128 {
+			return -1, fmt.Errorf("Delegated prefix length must be between 1 and 128")
+		}
+	}
+
+	for _, dhcpRange := range ranges {
+		poolLength := commonPrefixLength(dhcpRange.Start, dhcpRange.End)
+		if length <= poolLength {
+			return -1, fmt.Errorf("Delegated prefix length %d must be longer than the /%d prefix of range %s-%s", length, poolLength, dhcpRange.Start, dhcpRange.End)
+		}
+	}
+
+	return length, nil
+}
+
+// update the internal config variables, and if not cluster notification, notifies all nodes and updates database.
+// If expectedConfig isn't nil then the database is only updated if its config still matches expectedConfig,
+// otherwise ErrConfigConflict is returned without anything having been changed. The lifecycle event is emitted by
+// updated() once the drivers have applied the change.
+func (n *common) update(applyNetwork api.NetworkPut, targetNode string, clusterNotification bool, expectedConfig map[string]string) error {
+	applyNetwork = n.preserveVolatileKeys(applyNetwork)
+
+	// Check whether anything persistent has changed before internal config is replaced.
+	dbUpdateNeeded, _, _, _, err := n.configChanged(applyNetwork)
+	if err != nil {
+		return err
+	}
+
+	changes := n.ConfigDiff(applyNetwork)
+
+	// Only the changed keys are written to the database so that concurrent changes to other keys aren't
+	// overwritten.
+	dbChanges := configChanges(n.currentConfig(), applyNetwork.Config)
+
+	// A conditional update is stored before anything else is changed, so that a conflicting update isn't
+	// applied on this or any other node.
+	if dbUpdateNeeded && !clusterNotification && expectedConfig != nil {
+		err = n.storeConfigIfUnchanged(applyNetwork.Description, expectedConfig, configChanges(expectedConfig, applyNetwork.Config))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Update internal config before database has been updated (so that if update is a notification we apply
+	// the config being supplied and not that in the database).
+	n.configLock.Lock()
+	n.description = applyNetwork.Description
+	n.config = applyNetwork.Config
+	n.configLock.Unlock()
+
+	// Nothing to store or notify if the update is identical to the current config.
+	if !dbUpdateNeeded {
+		return nil
+	}
+
+	n.logConfigChanges(changes)
+
+	// If this update isn't coming via a cluster notification itself, then notify all nodes of change and then
+	// update the database.
+	if !clusterNotification {
+		if targetNode == "" {
+			// Notify all other nodes to update the network if no target specified.
+			notifier, err := cluster.NewNotifier(n.state, n.state.Endpoints.NetworkCert(), cluster.NotifyAll)
+			if err != nil {
+				return err
+			}
+
+			sendNetwork := applyNetwork
+			sendNetwork.Config = make(map[string]string)
+			for k, v := range applyNetwork.Config {
+				// Don't forward node specific keys (these will be merged in on recipient node).
+				if shared.StringInSlice(k, db.NodeSpecificNetworkConfig) {
+					continue
+				}
+
+				sendNetwork.Config[k] = v
+			}
+
+			err = notifier(func(client lxd.InstanceServer) error {
+				return client.UpdateNetwork(n.name, sendNetwork, "")
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		// Update the database, unless the update has already been stored conditionally.
+		if expectedConfig == nil {
+			err = n.state.Cluster.UpdateNetworkConfigKeys(n.name, applyNetwork.Description, dbChanges)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// storeConfigIfUnchanged applies the config changes (and the description if it has changed) to the network in the
+// database, provided its stored config still matches expectedConfig, otherwise ErrConfigConflict is returned. The
+// comparison and the write are done in a single transaction.
+func (n *common) storeConfigIfUnchanged(description string, expectedConfig map[string]string, changes map[string]string) error {
+	return n.state.Cluster.Transaction(func(tx *db.ClusterTx) error {
+		config, err := tx.GetNetworkConfig(n.id)
+		if err != nil {
+			return err
+		}
+
+		if len(diffConfig(expectedConfig, config)) > 0 {
+			return ErrConfigConflict
+		}
+
+		if description != n.currentDescription() {
+			err = tx.UpdateNetworkDescription(n.id, description)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = tx.UpdateNetworkConfig(n.id, changes)
+		if err != nil {
+			return err
+		}
+
+		// Update network status if change applied successfully.
+		if n.currentStatus() == api.NetworkStatusErrored {
+			err = tx.NetworkCreated(n.name)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// updated is called by the drivers once a config change has been successfully applied on this node. It emits the
+// updated lifecycle event on the node the change originated from, so that reverting a failed change doesn't emit
+// an event for a change that never took effect. It then notifies the config observers. Observers are local to each
+// node, so this is done on every node the change is applied to. Nothing is done for pending networks as the change
+// has only been stored in the database.
+func (n *common) updated(changedKeys []string, clusterNotification bool) {
+	// The network's subnets may have changed, which affects the proxy devices considered to be using it.
+	invalidateUsageCache()
+
+	if !clusterNotification {
+		n.lifecycle("updated", map[string]interface{}{"changed_keys": changedKeys, "restart_required": n.ChangeRequiresRestart(changedKeys)})
+	}
+
+	if n.IsPending() {
+		return
+	}
+
+	n.notifyConfigObservers(changedKeys)
+}
+
+// SetDescription updates the network's description without changing its config. If not a cluster notification,
+// notifies all nodes with a description only PATCH request, updates the description in the database and emits a
+// network-updated lifecycle event. As no config is sent, concurrent config changes aren't reverted.
+func (n *common) SetDescription(desc string, clusterNotification bool) error {
+	err := n.validateNotFrozen(clusterNotification)
+	if err != nil {
+		return err
+	}
+
+	if clusterNotification {
+		n.configLock.Lock()
+		n.description = desc
+		n.configLock.Unlock()
+
+		return nil
+	}
+
+	err = n.reloadConfig()
+	if err != nil {
+		return err
+	}
+
+	if desc == n.currentDescription() {
+		return nil // Nothing changed.
+	}
+
+	n.configLock.Lock()
+	n.description = desc
+	n.configLock.Unlock()
+
+	notifier, err := cluster.NewNotifier(n.state, n.state.Endpoints.NetworkCert(), cluster.NotifyAll)
+	if err != nil {
+		return err
+	}
+
+	err = notifier(func(client lxd.InstanceServer) error {
+		_, _, err := client.RawQuery("PATCH", fmt.Sprintf("/%s/networks/%s", version.APIVersion, url.PathEscape(n.name)), api.NetworkPut{Description: desc}, "")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	err = n.state.Cluster.UpdateNetworkDescription(n.name, desc)
+	if err != nil {
+		return err
+	}
+
+	n.lifecycle("updated", map[string]interface{}{"changed_keys": []string{}, "restart_required": false})
+
+	return nil
+}
+
+// ChangeRequiresRestart returns whether any of the changed keys can't be applied live and so requires the network
+// to be restarted.
+func (n *common) ChangeRequiresRestart(changedKeys []string) bool {
+	for _, k := range changedKeys {
+		if !configKeyMatches(k, liveUpdateKeys[n.netType]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsCompatibleChange returns whether applying the new network config is safe for the instances using the network,
+// along with the keys that are of concern. A change is of concern if it modifies a key that may break connectivity
+// of running instances or removes addresses that instances depend on. This is advisory only and doesn't apply the
+// change.
+func (n *common) IsCompatibleChange(newNetwork api.NetworkPut) (bool, []string) {
+	_, changedKeys, removedKeys, _, err := n.configChanged(newNetwork)
+	if err != nil {
+		return false, nil
+	}
+
+	keys := n.disruptiveChangedKeys(changedKeys)
+	for _, k := range changedKeys {
+		if shared.StringInSlice(k, keys) || !shared.StringInSlice(k, addressKeys[n.netType]) {
+			continue
+		}
+
+		if shared.StringInSlice(k, removedKeys) || newNetwork.Config[k] == "none" {
+			keys = append(keys, k)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return len(keys) == 0, keys
+}
+
+// disruptiveChangedKeys returns the changed keys that are considered disruptive for the network's driver.
+func (n *common) disruptiveChangedKeys(changedKeys []string) []string {
+	keys := []string{}
+	for _, k := range changedKeys {
+		if shared.StringInSlice(k, disruptiveKeys[n.netType]) {
+			keys = append(keys, k)
+		}
+	}
+
+	return keys
+}
+
+// DisruptiveChangeWarnings returns a warning for each of the changed keys that is disruptive if there are running
+// instances on this node that use the network, listing those instances. The affected instances may lose
+// connectivity until they are restarted.
+func (n *common) DisruptiveChangeWarnings(changedKeys []string) []Warning {
+	warnings := []Warning{}
+
+	keys := n.disruptiveChangedKeys(changedKeys)
+	if len(keys) == 0 || n.IsPending() {
+		return warnings
+	}
+
+	insts, err := instance.LoadNodeAll(n.state, instancetype.Any)
+	if err != nil {
+		n.logger.Warn("Failed loading instances to check for disruptive network changes", log.Ctx{"err": err})
+		return warnings
+	}
+
+	affected := []string{}
+	for _, inst := range insts {
+		if !inst.IsRunning() {
+			continue
+		}
+
+		inUse, err := IsInUseByInstance(n.state, inst, n.name)
+		if err != nil || !inUse {
+			continue
+		}
+
+		affected = append(affected, project.Instance(inst.Project(), inst.Name()))
+	}
+
+	if len(affected) == 0 {
+		return warnings
+	}
+
+	for _, k := range keys {
+		warnings = append(warnings, Warning{Key: k, Message: fmt.Sprintf("Changed while instances are running, restart them to apply: %s", strings.Join(affected, ", "))})
+	}
+
+	return warnings
+}
+
+// patch merges the supplied config keys into the existing config (a key with an empty value is removed) and then
+// passes the result to the apply function, which is expected to be the driver's Update. The description is only
+// changed if a new one is supplied. As with Update, the supplied keys should be validated using ValidateUpdate
+// beforehand.
+func (n *common) patch(applyNetwork api.NetworkPut, apply func(newNetwork api.NetworkPut) error) error {
+	return apply(n.mergeNetwork(applyNetwork))
+}
+
+// mergeNetwork returns a copy of the current network merged with the supplied config keys (a key with an empty
+// value is removed). The description is only changed if a new one is supplied.
+func (n *common) mergeNetwork(applyNetwork api.NetworkPut) api.NetworkPut {
+	n.configLock.RLock()
+	newNetwork := api.NetworkPut{
+		Description: n.description,
+		Config:      mergeConfig(n.config, applyNetwork.Config),
+	}
+	n.configLock.RUnlock()
+
+	if applyNetwork.Description != "" {
+		newNetwork.Description = applyNetwork.Description
+	}
+
+	return newNetwork
+}
+
+// ConfigObserver is a function called after a network's config has been updated on this node, with the name of the
+// network and the list of changed keys.
+type ConfigObserver func(networkName string, changedKeys []string) error
+
+// configObserver is a registered ConfigObserver.
+type configObserver struct {
+	id          int
+	networkName string
+	observer    ConfigObserver
+}
+
+// configObservers holds the registered config observers in registration order.
+var configObservers = []configObserver{}
+var configObserversNextID int
+var configObserversMu sync.Mutex
+
+// RegisterConfigObserver registers a function to be called after the config of the named network (or of any
+// network if the name is empty) has been updated. Observers are called on each node that the update is applied to,
+// whether the update originated on that node or was received as a cluster notification. An error returned by an
+// observer is logged and doesn't fail the update. Returns a function that unregisters the observer.
+func RegisterConfigObserver(networkName string, observer ConfigObserver) func() {
+	configObserversMu.Lock()
+	defer configObserversMu.Unlock()
+
+	configObserversNextID++
+	id := configObserversNextID
+	configObservers = append(configObservers, configObserver{id: id, networkName: networkName, observer: observer})
+
+	return func() {
+		configObserversMu.Lock()
+		defer configObserversMu.Unlock()
+
+		for i, entry := range configObservers {
+			if entry.id == id {
+				configObservers = append(configObservers[:i], configObservers[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// notifyConfigObservers calls the config observers registered for the network with the supplied changed keys.
+// The observers are called without holding the registry lock so that they can register or unregister observers.
+func (n *common) notifyConfigObservers(changedKeys []string) {
+	configObserversMu.Lock()
+	observers := []configObserver{}
+	for _, entry := range configObservers {
+		if entry.networkName == "" || entry.networkName == n.name {
+			observers = append(observers, entry)
+		}
+	}
+	configObserversMu.Unlock()
+
+	for _, entry := range observers {
+		err := entry.observer(n.name, changedKeys)
+		if err != nil {
+			n.logger.Warn("Network config observer failed", log.Ctx{"err": err, "keys": changedKeys})
+		}
+	}
+}
+
+// reloadConfig replaces the network's internal description and config with the latest from the database.
+func (n *common) reloadConfig() error {
+	_, netInfo, err := n.state.Cluster.GetNetworkInAnyState(n.name)
+	if err != nil {
+		return errors.Wrapf(err, "Failed loading network %q", n.name)
+	}
+
+	n.configLock.Lock()
+	n.description = netInfo.Description
+	n.config = netInfo.Config
+	n.configLock.Unlock()
+
+	return nil
+}
+
+// setKeysAttempts is the number of times setKeys merges the changes into the latest config before giving up when
+// the config keeps being changed concurrently.
+const setKeysAttempts = 5
+
+// SetKeys is not supported by default.
+func (n *common) SetKeys(changes map[string]string, targetNode string) error {
+	return ErrNotImplemented
+}
+
+// setKeys merges the supplied config keys into the network's latest config in the database (a key with an empty
+// value is removed), validates the merged result and then applies it using storeAndApply. If the config is changed
+// concurrently, on this or another cluster member, the changes are merged into the new config and validated
+// again, so that concurrent calls changing different keys don't overwrite each other's changes.
+func (n *common) setKeys(changes map[string]string, targetNode string, apply func(newNetwork api.NetworkPut, expectedConfig map[string]string) error) error {
+	n.updateLock.Lock()
+	defer n.updateLock.Unlock()
+
+	for attempt := 1; ; attempt++ {
+		_, netInfo, err := n.state.Cluster.GetNetworkInAnyState(n.name)
+		if err != nil {
+			return errors.Wrapf(err, "Failed loading network %q", n.name)
+		}
+
+		newNetwork := api.NetworkPut{
+			Description: netInfo.Description,
+			Config:      mergeConfig(netInfo.Config, changes),
+		}
+
+		err = n.validateConfig(netInfo.Config, newNetwork.Config, targetNode)
+		if err != nil {
+			return err
+		}
+
+		err = n.storeAndApply(netInfo.NetworkPut, newNetwork, apply)
+		if err == ErrConfigConflict && attempt < setKeysAttempts {
+			continue
+		}
+
+		return err
+	}
+}
+
+// storeAndApply applies the new network on top of the current network loaded from the database, replacing the
+// internal description and config (which may be stale) with the current ones first. The apply function is
+// expected to be the driver's update, which stores the new config in the database only if the stored config still
+// matches the current config, otherwise ErrConfigConflict is returned. Must be called with updateLock held.
+func (n *common) storeAndApply(curNetwork api.NetworkPut, newNetwork api.NetworkPut, apply func(newNetwork api.NetworkPut, expectedConfig map[string]string) error) error {
+	n.configLock.Lock()
+	n.description = curNetwork.Description
+	n.config = curNetwork.Config
+	n.configLock.Unlock()
+
+	return apply(newNetwork, curNetwork.Config)
+}
+
+// CompareAndUpdate is not supported by default.
+func (n *common) CompareAndUpdate(expected api.NetworkPut, newNetwork api.NetworkPut, ignoreUserKeys bool) error {
+	return ErrNotImplemented
+}
+
+// compareAndUpdate compares the network's latest config in the database to the expected config. If they match
+// then the new network is stored and applied using storeAndApply. As the stored config is only replaced if it
+// still matches the config that was compared, only one of several concurrent updates expecting the same config
+// can succeed, on this or another cluster member. If ignoreUserKeys is true then differences in user and volatile
+// keys and the description are ignored when comparing.
+func (n *common) compareAndUpdate(expected api.NetworkPut, newNetwork api.NetworkPut, ignoreUserKeys bool, apply func(newNetwork api.NetworkPut, expectedConfig map[string]string) error) error {
+	n.updateLock.Lock()
+	defer n.updateLock.Unlock()
+
+	_, netInfo, err := n.state.Cluster.GetNetworkInAnyState(n.name)
+	if err != nil {
+		return errors.Wrapf(err, "Failed loading network %q", n.name)
+	}
+
+	if !ignoreUserKeys && netInfo.Description != expected.Description {
+		return ErrConfigConflict
+	}
+
+	for _, change := range diffConfig(netInfo.Config, expected.Config) {
+		if ignoreUserKeys && (strings.HasPrefix(change.Key, "user.") || strings.HasPrefix(change.Key, "volatile.")) {
+			continue
+		}
+
+		return ErrConfigConflict
+	}
+
+	return n.storeAndApply(netInfo.NetworkPut, newNetwork, apply)
 }
 
-// HasDHCPv6 indicates whether the network has DHCPv6 enabled (includes stateless SLAAC router advertisement mode).
-// Technically speaking stateless SLAAC RA mode isn't DHCPv6, but for consistency with LXD's config paradigm, DHCP
-// here means "an ability to automatically allocate IPs and routes", rather than stateful DHCP with leases.
-// To check if true stateful DHCPv6 is enabled check the "ipv6.dhcp.stateful" config key.
-func (n *common) HasDHCPv6() bool {
-	if n.config["ipv6.dhcp"] == "" || shared.IsTrue(n.config["ipv6.dhcp"]) {
-		return true
+// configChanged compares supplied new config with existing config. Returns a boolean indicating if differences in
+// the config or description were found (and the database record needs updating), a list of non-user config keys
+// that have changed, a list of non-user config keys that have been removed entirely (as opposed to being set to an
+// empty value) and a copy of the current internal network config that can be used to revert if needed.
+// Removed keys are also included in the list of changed keys.
+func (n *common) configChanged(newNetwork api.NetworkPut) (bool, []string, []string, api.NetworkPut, error) {
+	config := n.currentConfig()
+
+	// Backup the current state.
+	oldNetwork := api.NetworkPut{
+		Description: n.currentDescription(),
+		Config:      map[string]string{},
 	}
 
-	return false
+	err := shared.DeepCopy(&config, &oldNetwork.Config)
+	if err != nil {
+		return false, nil, nil, oldNetwork, err
+	}
+
+	// Diff the configurations.
+	changedKeys := []string{}
+	removedKeys := []string{}
+	dbUpdateNeeded := false
+
+	if newNetwork.Description != oldNetwork.Description {
+		dbUpdateNeeded = true
+	}
+
+	for _, change := range diffConfig(oldNetwork.Config, newNetwork.Config) {
+		dbUpdateNeeded = true
+
+		// Skip user keys from the lists of changed and removed keys.
+		if strings.HasPrefix(change.Key, "user.") {
+			continue
+		}
+
+		changedKeys = append(changedKeys, change.Key)
+
+		if change.Action == ConfigChangeRemoved {
+			removedKeys = append(removedKeys, change.Key)
+		}
+	}
+
+	return dbUpdateNeeded, changedKeys, removedKeys, oldNetwork, nil
 }
 
-// DHCPv4Ranges returns a parsed set of DHCPv4 ranges for this network.
-func (n *common) DHCPv4Ranges() []DHCPRange {
-	dhcpRanges := make([]DHCPRange, 0)
-	if n.config["ipv4.dhcp.ranges"] != "" {
-		for _, r := range strings.Split(n.config["ipv4.dhcp.ranges"], ",") {
-			parts := strings.SplitN(strings.TrimSpace(r), "-", 2)
-			if len(parts) == 2 {
-				startIP := net.ParseIP(parts[0])
-				endIP := net.ParseIP(parts[1])
-				dhcpRanges = append(dhcpRanges, DHCPRange{
-					Start: startIP.To4(),
-					End:   endIP.To4(),
-				})
-			}
+// logConfigChanges logs each of the supplied config changes with its old and new values (which should already be
+// masked for sensitive keys). User and volatile keys aren't logged.
+func (n *common) logConfigChanges(changes []ConfigChange) {
+	for _, change := range changes {
+		if strings.HasPrefix(change.Key, "user.") || strings.HasPrefix(change.Key, "volatile.") {
+			continue
 		}
+
+		n.logger.Info("Network config changed", log.Ctx{"key": change.Key, "action": change.Action, "old": change.OldValue, "new": change.NewValue})
 	}
+}
 
-	return dhcpRanges
+// ConfigDiff compares supplied new config with existing config and returns the list of differences ordered by
+// key. Values of sensitive keys are masked.
+func (n *common) ConfigDiff(newNetwork api.NetworkPut) []ConfigChange {
+	changes := diffConfig(n.currentConfig(), newNetwork.Config)
+	for i := range changes {
+		if !isSensitiveKey(changes[i].Key) {
+			continue
+		}
+
+		if changes[i].OldValue != "" {
+			changes[i].OldValue = sensitiveValueMask
+		}
+
+		if changes[i].NewValue != "" {
+			changes[i].NewValue = sensitiveValueMask
+		}
+	}
+
+	return changes
 }
 
-// DHCPv6Ranges returns a parsed set of DHCPv6 ranges for this network.
-func (n *common) DHCPv6Ranges() []DHCPRange {
-	dhcpRanges := make([]DHCPRange, 0)
-	if n.config["ipv6.dhcp.ranges"] != "" {
-		for _, r := range strings.Split(n.config["ipv6.dhcp.ranges"], ",") {
-			parts := strings.SplitN(strings.TrimSpace(r), "-", 2)
-			if len(parts) == 2 {
-				startIP := net.ParseIP(parts[0])
-				endIP := net.ParseIP(parts[1])
-				dhcpRanges = append(dhcpRanges, DHCPRange{
-					Start: startIP.To16(),
-					End:   endIP.To16(),
-				})
-			}
+// CheckConsistency compares the network's directory on disk with its record in the database. It returns an
+// orphaned directory inconsistency if the directory exists but the network has no database record, and a missing
+// directory inconsistency if the network is created on this node, uses a driver that keeps a directory and has no
+// directory.
+func (n *common) CheckConsistency() ([]Inconsistency, error) {
+	inconsistencies := []Inconsistency{}
+	path := shared.VarPath("networks", n.name)
+
+	_, netInfo, err := n.state.Cluster.GetNetworkInAnyState(n.name)
+	if err != nil {
+		if err != db.ErrNoSuchObject {
+			return nil, err
+		}
+
+		if shared.PathExists(path) {
+			inconsistencies = append(inconsistencies, Inconsistency{
+				Type:    InconsistencyOrphanedDirectory,
+				Network: n.name,
+				Path:    path,
+			})
 		}
+
+		return inconsistencies, nil
 	}
 
-	return dhcpRanges
+	if netInfo.Status != api.NetworkStatusPending && shared.StringInSlice(netInfo.Type, directoryDrivers) && !shared.PathExists(path) {
+		inconsistencies = append(inconsistencies, Inconsistency{
+			Type:    InconsistencyMissingDirectory,
+			Network: n.name,
+			Path:    path,
+		})
+	}
+
+	return inconsistencies, nil
 }
 
-// update the internal config variables, and if not cluster notification, notifies all nodes and updates database.
-func (n *common) update(applyNetwork api.NetworkPut, targetNode string, clusterNotification bool) error {
-	// Update internal config before database has been updated (so that if update is a notification we apply
-	// the config being supplied and not that in the database).
-	n.description = applyNetwork.Description
-	n.config = applyNetwork.Config
+// Repair fixes the inconsistencies found by CheckConsistency by recreating the network's missing directory.
+// Orphaned directories are only logged and never removed, as they may still hold data that needs recovering.
+// If dryRun is true then the repairs are only logged and not performed. As the inconsistencies are detected afresh
+// each time, repeated calls are safe.
+func (n *common) Repair(dryRun bool) error {
+	inconsistencies, err := n.CheckConsistency()
+	if err != nil {
+		return err
+	}
 
-	// If this update isn't coming via a cluster notification itself, then notify all nodes of change and then
-	// update the database.
-	if !clusterNotification {
-		if targetNode == "" {
-			// Notify all other nodes to update the network if no target specified.
-			notifier, err := cluster.NewNotifier(n.state, n.state.Endpoints.NetworkCert(), cluster.NotifyAll)
+	for _, inconsistency := range inconsistencies {
+		ctx := log.Ctx{"type": inconsistency.Type, "path": inconsistency.Path, "dryRun": dryRun}
+
+		switch inconsistency.Type {
+		case InconsistencyOrphanedDirectory:
+			n.logger.Warn("Leaving orphaned network directory in place", ctx)
+
+		case InconsistencyMissingDirectory:
+			n.logger.Info("Recreating missing network directory", ctx)
+			if dryRun {
+				continue
+			}
+
+			err = os.MkdirAll(inconsistency.Path, 0711)
 			if err != nil {
-				return err
+				return errors.Wrapf(err, "Failed creating missing network directory %q", inconsistency.Path)
 			}
+		}
+	}
 
-			sendNetwork := applyNetwork
-			sendNetwork.Config = make(map[string]string)
-			for k, v := range applyNetwork.Config {
-				// Don't forward node specific keys (these will be merged in on recipient node).
-				if shared.StringInSlice(k, db.NodeSpecificNetworkConfig) {
-					continue
-				}
+	return nil
+}
 
-				sendNetwork.Config[k] = v
+// rename the network directory, notify other nodes and update database record (unless this is a cluster
+// notification) and update internal variables. If notifying or updating the database fails then the directory is
+// moved back to its original name. Networks that are in use can't be renamed, as the instances and profiles
+// referencing them would be left pointing at the old name.
+//
+// Other nodes are notified before the database is updated, so that they can still load the network by its old
+// name. As renaming only touches each node's local directory and interfaces, repeating it on a node that has
+// already been renamed is harmless. The supplied bringDown function is run once the network has been checked to be
+// unused, before anything is renamed, so that drivers can remove the host artifacts named after the old name.
+func (n *common) rename(newName string, clusterNotification bool, bringDown func() error) error {
+	// Bypass the usage cache as acting on a stale result here would leave references to the old name.
+	inUse, err := n.isUsed()
+	if err != nil {
+		return err
+	}
+
+	if inUse {
+		return fmt.Errorf("The network is currently in use")
+	}
+
+	err = bringDown()
+	if err != nil {
+		return err
+	}
+
+	revert := revert.New()
+	defer revert.Fail()
+
+	// Pending networks have not been created on this node and so have no local directory to rename.
+	if !n.IsPending() {
+		oldPath := shared.VarPath("networks", n.name)
+		newPath := shared.VarPath("networks", newName)
+
+		// Rename directory to new name, clearing any existing new directory first. If the old directory is
+		// missing then it has already been renamed and the new directory is left alone.
+		if shared.PathExists(oldPath) {
+			if shared.PathExists(newPath) {
+				os.RemoveAll(newPath)
 			}
 
-			err = notifier(func(client lxd.InstanceServer) error {
-				return client.UpdateNetwork(n.name, sendNetwork, "")
-			})
+			err := os.Rename(oldPath, newPath)
 			if err != nil {
 				return err
 			}
+
+			revert.Add(func() { os.Rename(newPath, oldPath) })
+		}
+	}
+
+	// If this rename isn't coming via a cluster notification itself, then notify all nodes of the rename and
+	// then rename the database entry.
+	if !clusterNotification {
+		notifier, err := cluster.NewNotifier(n.state, n.state.Endpoints.NetworkCert(), cluster.NotifyAll)
+		if err != nil {
+			return err
+		}
+
+		err = notifier(func(client lxd.InstanceServer) error {
+			return client.RenameNetwork(n.name, api.NetworkPost{Name: newName})
+		})
+		if err != nil {
+			return err
 		}
 
-		// Update the database.
-		err := n.state.Cluster.UpdateNetwork(n.name, applyNetwork.Description, applyNetwork.Config)
+		err = n.state.Cluster.RenameNetwork(n.name, newName)
 		if err != nil {
+			// The other nodes can't be asked to rename back as their requests would look the network up
+			// by the new name, which isn't in the database.
+			n.logger.Error("Failed renaming network in database after notifying other nodes", log.Ctx{"newName": newName, "err": err})
 			return err
 		}
 	}
 
+	oldName := n.name
+
+	// Reinitialise internal name variable and logger context with new name.
+	n.init(n.state, n.id, newName, n.netType, n.currentDescription(), n.currentConfig(), n.currentStatus())
+	invalidateUsageCache()
+
+	if !clusterNotification {
+		n.lifecycle("renamed", map[string]interface{}{"old_name": oldName})
+	}
+
+	revert.Success()
 	return nil
 }
 
-// configChanged compares supplied new config with existing config. Returns a boolean indicating if differences in
-// the config or description were found (and the database record needs updating), and a list of non-user config
-// keys that have changed, and a copy of the current internal network config that can be used to revert if needed.
-func (n *common) configChanged(newNetwork api.NetworkPut) (bool, []string, api.NetworkPut, error) {
-	// Backup the current state.
-	oldNetwork := api.NetworkPut{
-		Description: n.description,
-		Config:      map[string]string{},
+// delete runs the supplied teardown function to remove the network's host artifacts and then deletes the network
+// from the database if clusterNotification is false.
+func (n *common) delete(clusterNotification bool, teardown func() error) error {
+	// Remove the host artifacts first. This runs on every node (cluster notifications included) and a failure
+	// doesn't prevent the network being deleted.
+	err := teardown()
+	if err != nil {
+		n.logger.Error("Failed tearing down network", log.Ctx{"err": err})
 	}
 
-	err := shared.DeepCopy(&n.config, &oldNetwork.Config)
-	if err != nil {
-		return false, nil, oldNetwork, err
+	// Only delete database record if not cluster notification.
+	if !clusterNotification {
+		// Remove the network from the database.
+		err = n.state.Cluster.DeleteNetwork(n.name)
+		if err != nil {
+			return err
+		}
+
+		invalidateUsageCache()
+		n.lifecycle("deleted", nil)
 	}
 
-	// Diff the configurations.
-	changedKeys := []string{}
-	dbUpdateNeeded := false
+	return nil
+}
 
-	if newNetwork.Description != n.description {
-		dbUpdateNeeded = true
+// teardown is a no-op as by default networks don't create any host artifacts.
+func (n *common) teardown() error {
+	return nil
+}
+
+// Clone creates a new network with the supplied name using a copy of this network's config with the supplied
+// overrides applied (an override with an empty value removes the key). Node-specific keys and the frozen flag are
+// not copied. The new network is validated (including checking its subnets don't overlap existing networks),
+// created in the database and started. Cloning is not supported when clustered.
+func (n *common) Clone(newName string, overrides map[string]string) (Network, error) {
+	config := n.currentConfig()
+
+	req := api.NetworksPost{
+		Name: newName,
+		Type: n.netType,
+		NetworkPut: api.NetworkPut{
+			Description: n.currentDescription(),
+			Config:      make(map[string]string, len(config)),
+		},
 	}
 
-	for k, v := range oldNetwork.Config {
-		if v != newNetwork.Config[k] {
-			dbUpdateNeeded = true
+	for k, v := range config {
+		if shared.StringInSlice(k, db.NodeSpecificNetworkConfig) || k == "security.frozen" {
+			continue
+		}
 
-			// Add non-user changed key to list of changed keys.
-			if !strings.HasPrefix(k, "user.") && !shared.StringInSlice(k, changedKeys) {
-				changedKeys = append(changedKeys, k)
-			}
+		req.Config[k] = v
+	}
+
+	for k, v := range overrides {
+		if v == "" {
+			delete(req.Config, k)
+			continue
 		}
+
+		req.Config[k] = v
 	}
 
-	for k, v := range newNetwork.Config {
-		if v != oldNetwork.Config[k] {
-			dbUpdateNeeded = true
+	err := FillConfig(n.state, project.Default, &req)
+	if err != nil {
+		return nil, err
+	}
 
-			// Add non-user changed key to list of changed keys.
-			if !strings.HasPrefix(k, "user.") && !shared.StringInSlice(k, changedKeys) {
-				changedKeys = append(changedKeys, k)
-			}
+	return create(n.state, req, map[string]interface{}{"source": n.name})
+}
+
+// Export returns the network's definition as a portable backup. Node-specific and volatile keys are not included.
+func (n *common) Export() (*api.NetworkBackup, error) {
+	config := n.currentConfig()
+
+	backup := &api.NetworkBackup{
+		NetworksPost: api.NetworksPost{
+			Name: n.name,
+			Type: n.netType,
+			NetworkPut: api.NetworkPut{
+				Description: n.currentDescription(),
+				Config:      make(map[string]string, len(config)),
+			},
+		},
+		CreatedAt: time.Now().UTC(),
+	}
+
+	for k, v := range config {
+		if shared.StringInSlice(k, db.NodeSpecificNetworkConfig) || strings.HasPrefix(k, "volatile.") {
+			continue
 		}
+
+		backup.Config[k] = v
 	}
 
-	return dbUpdateNeeded, changedKeys, oldNetwork, nil
+	return backup, nil
 }
 
-// rename the network directory, update database record and update internal variables.
-func (n *common) rename(newName string) error {
-	// Clear new directory if exists.
-	if shared.PathExists(shared.VarPath("networks", newName)) {
-		os.RemoveAll(shared.VarPath("networks", newName))
+// validSnapshotName checks the supplied network snapshot name is usable.
+func validSnapshotName(name string) error {
+	if name == "" {
+		return fmt.Errorf("Snapshot name cannot be empty")
 	}
 
-	// Rename directory to new name.
-	if shared.PathExists(shared.VarPath("networks", n.name)) {
-		err := os.Rename(shared.VarPath("networks", n.name), shared.VarPath("networks", newName))
-		if err != nil {
-			return err
-		}
+	if strings.Contains(name, "/") {
+		return fmt.Errorf("Snapshot name cannot contain \"/\"")
 	}
 
-	// Rename the database entry.
-	err := n.state.Cluster.RenameNetwork(n.name, newName)
+	return nil
+}
+
+// CreateSnapshot stores a named, timestamped copy of the network's description and config in the database so the
+// network can later be rolled back to it with RestoreSnapshot. Node-specific and volatile keys are not included.
+func (n *common) CreateSnapshot(name string) error {
+	err := validSnapshotName(name)
 	if err != nil {
 		return err
 	}
 
-	// Reinitialise internal name variable and logger context with new name.
-	n.init(n.state, n.id, newName, n.netType, n.description, n.config, n.status)
+	names, err := n.Snapshots()
+	if err != nil {
+		return err
+	}
+
+	if shared.StringInSlice(name, names) {
+		return fmt.Errorf("Snapshot %q already exists for network %q", name, n.name)
+	}
+
+	config := n.currentConfig()
+	snapConfig := make(map[string]string, len(config))
+	for k, v := range config {
+		if shared.StringInSlice(k, db.NodeSpecificNetworkConfig) || strings.HasPrefix(k, "volatile.") {
+			continue
+		}
+
+		snapConfig[k] = v
+	}
+
+	err = n.state.Cluster.CreateNetworkSnapshot(n.id, name, n.currentDescription(), snapConfig)
+	if err != nil {
+		return errors.Wrapf(err, "Failed creating snapshot %q for network %q", name, n.name)
+	}
+
+	n.lifecycle("snapshot-created", map[string]interface{}{"snapshot": name})
 
 	return nil
 }
 
-// delete the network from the database if clusterNotification is false.
-func (n *common) delete(clusterNotification bool) error {
-	// Only delete database record if not cluster notification.
-	if !clusterNotification {
-		// Remove the network from the database.
-		err := n.state.Cluster.DeleteNetwork(n.name)
-		if err != nil {
-			return err
+// Snapshots returns the names of the network's snapshots, oldest first.
+func (n *common) Snapshots() ([]string, error) {
+	return n.state.Cluster.GetNetworkSnapshotNames(n.id)
+}
+
+// DeleteSnapshot removes the named snapshot of the network from the database.
+func (n *common) DeleteSnapshot(name string) error {
+	err := n.state.Cluster.DeleteNetworkSnapshot(n.id, name)
+	if err != nil {
+		if err == db.ErrNoSuchObject {
+			return fmt.Errorf("Snapshot %q not found for network %q", name, n.name)
 		}
+
+		return errors.Wrapf(err, "Failed deleting snapshot %q for network %q", name, n.name)
 	}
 
+	n.lifecycle("snapshot-deleted", map[string]interface{}{"snapshot": name})
+
 	return nil
 }
 
+// RestoreSnapshot is not supported by default.
+func (n *common) RestoreSnapshot(name string) error {
+	return ErrNotImplemented
+}
+
+// restoreSnapshot returns the network definition stored in the named snapshot, with the network's current
+// node-specific keys carried over as they aren't part of the snapshot. The snapshotted config is validated as a
+// whole, as it may no longer be valid (e.g. its subnet now overlaps another network), and the caller is expected to
+// apply it through the normal update path.
+func (n *common) restoreSnapshot(name string) (api.NetworkPut, error) {
+	description, config, err := n.state.Cluster.GetNetworkSnapshot(n.id, name)
+	if err != nil {
+		if err == db.ErrNoSuchObject {
+			return api.NetworkPut{}, fmt.Errorf("Snapshot %q not found for network %q", name, n.name)
+		}
+
+		return api.NetworkPut{}, err
+	}
+
+	newNetwork := api.NetworkPut{
+		Description: description,
+		Config:      config,
+	}
+
+	for k, v := range n.currentConfig() {
+		if shared.StringInSlice(k, db.NodeSpecificNetworkConfig) {
+			newNetwork.Config[k] = v
+		}
+	}
+
+	err = Validate(n.name, n.netType, newNetwork.Config)
+	if err != nil {
+		return api.NetworkPut{}, errors.Wrapf(err, "Snapshot %q config is not valid", name)
+	}
+
+	err = ValidateSubnets(n.state, n.name, newNetwork.Config)
+	if err != nil {
+		return api.NetworkPut{}, errors.Wrapf(err, "Snapshot %q config is not valid", name)
+	}
+
+	return newNetwork, nil
+}
+
+// imported indicates whether the network's interface was imported rather than created by LXD.
+func (n *common) imported() bool {
+	return shared.IsTrue(n.currentConfig()["volatile.imported"])
+}
+
+// lifecycle sends a network lifecycle event for the supplied action (e.g. "updated" is sent as "network-updated").
+// The network name and project are always included in the event context.
+func (n *common) lifecycle(action string, ctx map[string]interface{}) {
+	if ctx == nil {
+		ctx = make(map[string]interface{})
+	}
+
+	ctx["name"] = n.name
+	ctx["project"] = project.Default
+
+	n.state.Events.SendLifecycle(project.Default, fmt.Sprintf("network-%s", action), fmt.Sprintf("/%s/networks/%s", version.APIVersion, n.name), ctx)
+}
+
 // HandleHeartbeat is a no-op.
 func (n *common) HandleHeartbeat(heartbeatData *cluster.APIHeartbeat) error {
 	return nil
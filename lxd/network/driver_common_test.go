@@ -0,0 +1,1990 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lxc/lxd/lxd/db"
+	deviceConfig "github.com/lxc/lxd/lxd/device/config"
+	"github.com/lxc/lxd/lxd/endpoints"
+	"github.com/lxc/lxd/lxd/events"
+	"github.com/lxc/lxd/lxd/state"
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/api"
+	log "github.com/lxc/lxd/shared/log15"
+)
+
+// newTestMacvlan returns a macvlan network called "testnet" with the supplied description and config, which is
+// stored in the database of a new test state. The returned function cleans up the test state.
+func newTestMacvlan(t *testing.T, description string, config map[string]string) (*state.State, *macvlan, func()) {
+	s, cleanup := state.NewTestState(t)
+
+	s.Endpoints = &endpoints.Endpoints{}
+	s.Events = events.NewServer(false, false)
+
+	id, err := s.Cluster.CreateNetwork("testnet", description, db.NetworkTypeMacvlan, config)
+	if err != nil {
+		cleanup()
+		require.NoError(t, err)
+	}
+
+	n := &macvlan{}
+	n.init(s, id, "testnet", "macvlan", description, config, api.NetworkStatusCreated)
+
+	return s, n, cleanup
+}
+
+// Test dhcpRangesOverlap
+func TestDHCPRangesOverlap(t *testing.T) {
+	newRange := func(start string, end string) DHCPRange {
+		return DHCPRange{Start: net.ParseIP(start), End: net.ParseIP(end)}
+	}
+
+	// Test overlapping IPv4 ranges.
+	rangeA, rangeB, overlap := dhcpRangesOverlap([]DHCPRange{
+		newRange("10.0.0.10", "10.0.0.50"),
+		newRange("10.0.0.40", "10.0.0.80"),
+	})
+	assert.True(t, overlap)
+	assert.Equal(t, "10.0.0.10", rangeA.Start.String())
+	assert.Equal(t, "10.0.0.40", rangeB.Start.String())
+
+	// Test adjacent IPv4 ranges.
+	_, _, overlap = dhcpRangesOverlap([]DHCPRange{
+		newRange("10.0.0.10", "10.0.0.50"),
+		newRange("10.0.0.51", "10.0.0.80"),
+	})
+	assert.False(t, overlap)
+
+	// Test single address ranges.
+	_, _, overlap = dhcpRangesOverlap([]DHCPRange{
+		newRange("10.0.0.10", "10.0.0.10"),
+		newRange("10.0.0.11", "10.0.0.11"),
+	})
+	assert.False(t, overlap)
+
+	_, _, overlap = dhcpRangesOverlap([]DHCPRange{
+		newRange("10.0.0.5", "10.0.0.20"),
+		newRange("10.0.0.10", "10.0.0.10"),
+	})
+	assert.True(t, overlap)
+
+	// Test IPv6 ranges.
+	_, _, overlap = dhcpRangesOverlap([]DHCPRange{
+		newRange("fd42::10", "fd42::ff"),
+		newRange("fd42::1:0", "fd42::1:ff"),
+	})
+	assert.False(t, overlap)
+
+	_, _, overlap = dhcpRangesOverlap([]DHCPRange{
+		newRange("fd42::10", "fd42::1:10"),
+		newRange("fd42::1:0", "fd42::1:ff"),
+	})
+	assert.True(t, overlap)
+}
+
+// Test DHCPRange.Contains
+func TestDHCPRangeContains(t *testing.T) {
+	dhcpRange := DHCPRange{Start: net.ParseIP("10.0.0.10").To4(), End: net.ParseIP("10.0.0.50").To4()}
+	assert.True(t, dhcpRange.Contains(net.ParseIP("10.0.0.10")))
+	assert.True(t, dhcpRange.Contains(net.ParseIP("10.0.0.50")))
+	assert.False(t, dhcpRange.Contains(net.ParseIP("10.0.0.51")))
+
+	dhcpRange = DHCPRange{Start: net.ParseIP("fd42::10"), End: net.ParseIP("fd42::ff")}
+	assert.True(t, dhcpRange.Contains(net.ParseIP("fd42::20")))
+	assert.False(t, dhcpRange.Contains(net.ParseIP("fd42::1:0")))
+}
+
+// Test VLAN and VLANTagged
+func TestVLAN(t *testing.T) {
+	n := &common{config: map[string]string{}}
+
+	vlanID, err := n.VLAN()
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(0), vlanID)
+
+	tagged, err := n.VLANTagged()
+	assert.NoError(t, err)
+	assert.Len(t, tagged, 0)
+
+	// Test boundary values (0 is a valid native VLAN meaning none, but can't be tagged).
+	for value, valid := range map[string][2]bool{"0": {true, false}, "1": {true, true}, "4094": {true, true}, "4095": {false, false}} {
+		n = &common{config: map[string]string{"bridge.vlan": value, "bridge.vlan.tagged": value}}
+
+		_, err = n.VLAN()
+		assert.Equal(t, valid[0], err == nil, value)
+
+		_, err = n.VLANTagged()
+		assert.Equal(t, valid[1], err == nil, value)
+	}
+
+	n = &common{config: map[string]string{"bridge.vlan": "10", "bridge.vlan.tagged": "20, 30"}}
+
+	vlanID, err = n.VLAN()
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(10), vlanID)
+
+	tagged, err = n.VLANTagged()
+	assert.NoError(t, err)
+	assert.Equal(t, []uint16{20, 30}, tagged)
+}
+
+// Test IPv4Enabled and IPv6Enabled
+func TestIPEnabled(t *testing.T) {
+	for _, family := range []string{"ipv4", "ipv6"} {
+		for value, enabled := range map[string]bool{"": false, "none": false, "auto": true, "10.0.0.1/24": true, "fd42::1/64": true} {
+			n := &common{config: map[string]string{fmt.Sprintf("%s.address", family): value}}
+
+			if family == "ipv4" {
+				assert.Equal(t, enabled, n.IPv4Enabled(), value)
+				assert.False(t, n.IPv6Enabled(), value)
+			} else {
+				assert.Equal(t, enabled, n.IPv6Enabled(), value)
+				assert.False(t, n.IPv4Enabled(), value)
+			}
+		}
+	}
+}
+
+// Test SecurityFiltering
+func TestSecurityFiltering(t *testing.T) {
+	n := &common{name: "testbr0", config: map[string]string{"security.mac_filtering": "true", "security.ipv6_filtering": "false"}}
+
+	mac, ipv4, ipv6 := n.SecurityFiltering()
+	assert.True(t, mac)
+	assert.False(t, ipv4)
+	assert.False(t, ipv6)
+
+	// Test IP filtering is allowed when the network provides DHCP for the address family.
+	assert.NoError(t, n.validateSecurityFiltering(map[string]string{"ipv4.address": "10.0.0.1/24", "security.ipv4_filtering": "true"}))
+	assert.NoError(t, n.validateSecurityFiltering(map[string]string{"ipv6.address": "fd42::1/64", "security.ipv6_filtering": "true"}))
+
+	// Test IP filtering is rejected when the instances' addresses can't be known.
+	assert.EqualError(t, n.validateSecurityFiltering(map[string]string{"ipv4.address": "10.0.0.1/24", "ipv4.dhcp": "false", "security.ipv4_filtering": "true"}), `Invalid value for network "testbr0" option "security.ipv4_filtering": IP filtering requires "ipv4.dhcp" or static DHCP leases`)
+	assert.Error(t, n.validateSecurityFiltering(map[string]string{"ipv6.address": "none", "security.ipv6_filtering": "true"}))
+}
+
+// Test EffectiveDHCPv4Ranges
+func TestEffectiveDHCPv4Ranges(t *testing.T) {
+	n := &common{config: map[string]string{"ipv4.address": "10.0.0.1/24"}}
+
+	// Test derived range with gateway at the start of the subnet.
+	ranges := n.EffectiveDHCPv4Ranges()
+	assert.Len(t, ranges, 1)
+	assert.Equal(t, "10.0.0.2", ranges[0].Start.String())
+	assert.Equal(t, "10.0.0.254", ranges[0].End.String())
+
+	// Test derived range with gateway in the middle of the subnet.
+	n.config["ipv4.address"] = "10.0.0.100/24"
+	ranges = n.EffectiveDHCPv4Ranges()
+	assert.Len(t, ranges, 1)
+	assert.Equal(t, "10.0.0.101", ranges[0].Start.String())
+	assert.Equal(t, "10.0.0.254", ranges[0].End.String())
+
+	// Test no usable hosts after the gateway.
+	n.config["ipv4.address"] = "10.0.0.254/24"
+	assert.Len(t, n.EffectiveDHCPv4Ranges(), 0)
+
+	// Test explicit ranges take precedence.
+	n.config["ipv4.dhcp.ranges"] = "10.0.0.10-10.0.0.20"
+	ranges = n.EffectiveDHCPv4Ranges()
+	assert.Len(t, ranges, 1)
+	assert.Equal(t, "10.0.0.10", ranges[0].Start.String())
+
+	// Test no IPv4 address.
+	n.config = map[string]string{"ipv4.address": "none"}
+	assert.Len(t, n.EffectiveDHCPv4Ranges(), 0)
+}
+
+// Test DHCPv4PoolSize and DHCPv6PoolSize
+func TestDHCPPoolSize(t *testing.T) {
+	n := &common{config: map[string]string{"ipv4.address": "10.0.0.1/24"}}
+
+	// Test derived IPv4 range.
+	size, err := n.DHCPv4PoolSize()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(253), size)
+
+	// Test multiple IPv4 ranges with the gateway inside one of them.
+	n.config["ipv4.dhcp.ranges"] = "10.0.0.1-10.0.0.10,10.0.0.20-10.0.0.29"
+	size, err = n.DHCPv4PoolSize()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(19), size)
+
+	// Test malformed IPv4 range.
+	n.config["ipv4.dhcp.ranges"] = "10.0.0.10=10.0.0.20"
+	_, err = n.DHCPv4PoolSize()
+	assert.Error(t, err)
+
+	// Test IPv6 range.
+	n.config["ipv6.address"] = "fd42::1/64"
+	n.config["ipv6.dhcp.ranges"] = "fd42::10-fd42::1f"
+	size, err = n.DHCPv6PoolSize()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(16), size)
+
+	// Test derived IPv6 range saturates.
+	n.config["ipv6.dhcp.ranges"] = ""
+	size, err = n.DHCPv6PoolSize()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(math.MaxInt64), size)
+
+	// Test derived IPv6 range for a small subnet.
+	n.config["ipv6.address"] = "fd42::1/120"
+	size, err = n.DHCPv6PoolSize()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(254), size)
+}
+
+// Test configChanged
+func TestConfigChanged(t *testing.T) {
+	n := &common{config: map[string]string{
+		"ipv4.firewall": "true",
+		"ipv4.nat":      "true",
+		"user.foo":      "bar",
+	}}
+
+	// Test key removal versus key set to empty value.
+	dbUpdateNeeded, changedKeys, removedKeys, oldNetwork, err := n.configChanged(api.NetworkPut{
+		Config: map[string]string{
+			"ipv4.nat": "",
+		},
+	})
+	assert.NoError(t, err)
+	assert.True(t, dbUpdateNeeded)
+	assert.ElementsMatch(t, []string{"ipv4.firewall", "ipv4.nat"}, changedKeys)
+	assert.Equal(t, []string{"ipv4.firewall"}, removedKeys)
+	assert.Equal(t, n.config, oldNetwork.Config)
+
+	// Test no changes.
+	dbUpdateNeeded, changedKeys, removedKeys, _, err = n.configChanged(api.NetworkPut{Config: n.config})
+	assert.NoError(t, err)
+	assert.False(t, dbUpdateNeeded)
+	assert.Len(t, changedKeys, 0)
+	assert.Len(t, removedKeys, 0)
+}
+
+// Test ConfigDiff
+func TestConfigDiff(t *testing.T) {
+	n := &common{config: map[string]string{
+		"ipv4.address":   "10.0.0.1/24",
+		"ipv4.nat":       "true",
+		"user.foo":       "bar",
+		"tunnel.a.id":    "1",
+		"fake.password":  "secret",
+		"other.password": "secret",
+	}}
+
+	changes := n.ConfigDiff(api.NetworkPut{
+		Config: map[string]string{
+			"ipv4.address":   "10.0.1.1/24",
+			"ipv4.nat":       "true",
+			"user.foo":       "",
+			"ipv6.address":   "none",
+			"fake.password":  "newsecret",
+			"other.password": "secret",
+		},
+	})
+
+	assert.Equal(t, []ConfigChange{
+		{Key: "fake.password", OldValue: "********", NewValue: "********", Action: ConfigChangeModified},
+		{Key: "ipv4.address", OldValue: "10.0.0.1/24", NewValue: "10.0.1.1/24", Action: ConfigChangeModified},
+		{Key: "ipv6.address", NewValue: "none", Action: ConfigChangeAdded},
+		{Key: "tunnel.a.id", OldValue: "1", Action: ConfigChangeRemoved},
+		{Key: "user.foo", OldValue: "bar", NewValue: "", Action: ConfigChangeModified},
+	}, changes)
+
+	// Test no changes.
+	assert.Len(t, n.ConfigDiff(api.NetworkPut{Config: n.config}), 0)
+}
+
+// captureLogger records the messages and contexts logged at info level.
+type captureLogger struct {
+	messages []string
+	contexts []log.Ctx
+}
+
+func (l *captureLogger) Debug(msg string, ctx ...interface{}) {}
+func (l *captureLogger) Warn(msg string, ctx ...interface{})  {}
+func (l *captureLogger) Error(msg string, ctx ...interface{}) {}
+func (l *captureLogger) Crit(msg string, ctx ...interface{})  {}
+func (l *captureLogger) Info(msg string, ctx ...interface{}) {
+	l.messages = append(l.messages, msg)
+	for _, c := range ctx {
+		l.contexts = append(l.contexts, c.(log.Ctx))
+	}
+}
+
+// Test that config changes are logged with their old and new values.
+func TestUpdateLogsConfigChanges(t *testing.T) {
+	config := map[string]string{"parent": "eth0", "fake.password": "secret", "user.foo": "bar", "volatile.foo": "1"}
+	_, n, cleanup := newTestMacvlan(t, "", config)
+	defer cleanup()
+
+	capture := &captureLogger{}
+	n.logger = capture
+
+	newConfig := map[string]string{"parent": "eth1", "fake.password": "newsecret", "user.foo": "baz", "volatile.foo": "2", "mtu": "1400"}
+	err := n.common.update(api.NetworkPut{Config: newConfig}, "", false, nil)
+	require.NoError(t, err)
+
+	// Test user and volatile keys are skipped and sensitive values are masked.
+	assert.Equal(t, []string{"Network config changed", "Network config changed", "Network config changed"}, capture.messages)
+	assert.Equal(t, []log.Ctx{
+		{"key": "fake.password", "action": ConfigChangeModified, "old": "********", "new": "********"},
+		{"key": "mtu", "action": ConfigChangeAdded, "old": "", "new": "1400"},
+		{"key": "parent", "action": ConfigChangeModified, "old": "eth0", "new": "eth1"},
+	}, capture.contexts)
+
+	// Test nothing is logged when nothing has changed.
+	capture.messages = nil
+	err = n.common.update(api.NetworkPut{Config: newConfig}, "", false, nil)
+	require.NoError(t, err)
+	assert.Empty(t, capture.messages)
+}
+
+func TestSnapshots(t *testing.T) {
+	config := map[string]string{"parent": "eth0", "maas.subnet.ipv4": "foo", "volatile.foo": "1"}
+	s, n, cleanup := newTestMacvlan(t, "before", config)
+	defer cleanup()
+
+	assert.Error(t, n.CreateSnapshot(""))
+	assert.Error(t, n.CreateSnapshot("foo/bar"))
+
+	require.NoError(t, n.CreateSnapshot("snap0"))
+	assert.Error(t, n.CreateSnapshot("snap0"))
+
+	// Test node-specific and volatile keys aren't stored in the snapshot.
+	description, snapConfig, err := s.Cluster.GetNetworkSnapshot(n.id, "snap0")
+	require.NoError(t, err)
+	assert.Equal(t, "before", description)
+	assert.Equal(t, map[string]string{"maas.subnet.ipv4": "foo"}, snapConfig)
+
+	err = n.Update(api.NetworkPut{Description: "after", Config: map[string]string{"parent": "eth1", "maas.subnet.ipv6": "bar"}}, "", false)
+	require.NoError(t, err)
+
+	require.NoError(t, n.CreateSnapshot("snap1"))
+
+	names, err := n.Snapshots()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"snap0", "snap1"}, names)
+
+	// Test restoring keeps the current node-specific and volatile keys.
+	require.NoError(t, n.RestoreSnapshot("snap0"))
+	assert.Equal(t, "before", n.description)
+	assert.Equal(t, map[string]string{"parent": "eth1", "maas.subnet.ipv4": "foo", "volatile.foo": "1"}, n.Config())
+
+	assert.Error(t, n.RestoreSnapshot("missing"))
+
+	// Test a snapshotted config that isn't valid is rejected and the network is left unchanged.
+	err = s.Cluster.CreateNetworkSnapshot(n.id, "invalid", "", map[string]string{"foo": "bar"})
+	require.NoError(t, err)
+	assert.Error(t, n.RestoreSnapshot("invalid"))
+	assert.Equal(t, "foo", n.Config()["maas.subnet.ipv4"])
+
+	// Test deleting snapshots.
+	require.NoError(t, n.DeleteSnapshot("invalid"))
+	assert.Error(t, n.DeleteSnapshot("invalid"))
+
+	names, err = n.Snapshots()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"snap0", "snap1"}, names)
+}
+
+// Test that rename restores the network directory when the database update fails.
+func TestRenameRevertOnDBError(t *testing.T) {
+	s, cleanup := state.NewTestState(t)
+	defer cleanup()
+
+	// Not clustered, so no certificate is needed to notify other nodes.
+	s.Endpoints = &endpoints.Endpoints{}
+
+	oldLXDDir := os.Getenv("LXD_DIR")
+	os.Setenv("LXD_DIR", s.OS.VarDir)
+	defer os.Setenv("LXD_DIR", oldLXDDir)
+
+	oldPath := filepath.Join(s.OS.VarDir, "networks", "testbr0")
+	newPath := filepath.Join(s.OS.VarDir, "networks", "testbr1")
+	require.NoError(t, os.MkdirAll(oldPath, 0711))
+
+	// The network has no database record, so the database rename fails.
+	n := &common{}
+	n.init(s, 1, "testbr0", "bridge", "", map[string]string{}, api.NetworkStatusCreated)
+
+	err := n.rename("testbr1", false, n.teardown)
+	assert.Error(t, err)
+	assert.True(t, shared.PathExists(oldPath))
+	assert.False(t, shared.PathExists(newPath))
+	assert.Equal(t, "testbr0", n.name)
+}
+
+// Test that renaming a network referenced by a profile is refused.
+func TestRenameInUse(t *testing.T) {
+	s, cleanup := state.NewTestState(t)
+	defer cleanup()
+
+	s.Endpoints = &endpoints.Endpoints{}
+
+	id, err := s.Cluster.CreateNetwork("testbr0", "", db.NetworkTypeBridge, map[string]string{})
+	require.NoError(t, err)
+
+	err = s.Cluster.Transaction(func(tx *db.ClusterTx) error {
+		_, err := tx.CreateProfile(db.Profile{
+			Project: "default",
+			Name:    "test",
+			Devices: map[string]map[string]string{
+				"eth0": {"type": "nic", "network": "testbr0", "name": "eth0"},
+			},
+		})
+		return err
+	})
+	require.NoError(t, err)
+
+	n := &common{}
+	n.init(s, id, "testbr0", "bridge", "", map[string]string{}, api.NetworkStatusCreated)
+
+	// Test the network isn't brought down when it can't be renamed.
+	err = n.rename("testbr1", false, func() error {
+		t.Error("Network brought down while in use")
+		return nil
+	})
+	assert.EqualError(t, err, "The network is currently in use")
+	assert.Equal(t, "testbr0", n.name)
+
+	_, _, err = s.Cluster.GetNetworkInAnyState("testbr0")
+	assert.NoError(t, err)
+}
+
+// Test that a rename coming from a cluster notification only renames locally and leaves the database alone.
+func TestRenameClusterNotification(t *testing.T) {
+	s, n, cleanup := newTestMacvlan(t, "", map[string]string{})
+	defer cleanup()
+
+	oldLXDDir := os.Getenv("LXD_DIR")
+	os.Setenv("LXD_DIR", s.OS.VarDir)
+	defer os.Setenv("LXD_DIR", oldLXDDir)
+
+	oldPath := filepath.Join(s.OS.VarDir, "networks", "testnet")
+	newPath := filepath.Join(s.OS.VarDir, "networks", "testnet1")
+	require.NoError(t, os.MkdirAll(oldPath, 0711))
+
+	err := n.Rename("testnet1", true)
+	require.NoError(t, err)
+	assert.False(t, shared.PathExists(oldPath))
+	assert.True(t, shared.PathExists(newPath))
+	assert.Equal(t, "testnet1", n.name)
+
+	_, _, err = s.Cluster.GetNetworkInAnyState("testnet")
+	assert.NoError(t, err)
+
+	// Repeating the notification on an already renamed node is harmless.
+	n.init(s, n.id, "testnet", "macvlan", "", map[string]string{}, api.NetworkStatusCreated)
+	err = n.common.rename("testnet1", true, n.Stop)
+	assert.NoError(t, err)
+	assert.True(t, shared.PathExists(newPath))
+}
+
+// Test that IsUsed results are cached until a NIC or proxy device change invalidates them.
+func TestIsUsedCache(t *testing.T) {
+	s, n, cleanup := newTestMacvlan(t, "", map[string]string{})
+	defer cleanup()
+	defer func() {
+		usedCacheMu.Lock()
+		delete(usedCache, "testnet")
+		usedCacheMu.Unlock()
+	}()
+
+	inUse, err := n.IsUsed()
+	require.NoError(t, err)
+	assert.False(t, inUse)
+
+	devices := map[string]map[string]string{
+		"eth0": {"type": "nic", "network": "testnet", "name": "eth0"},
+	}
+
+	err = s.Cluster.Transaction(func(tx *db.ClusterTx) error {
+		_, err := tx.CreateProfile(db.Profile{Project: "default", Name: "test", Devices: devices})
+		return err
+	})
+	require.NoError(t, err)
+
+	inUse, err = n.IsUsed()
+	require.NoError(t, err)
+	assert.False(t, inUse)
+
+	InvalidateUsageCache(deviceConfig.Devices{"root": {"type": "disk", "path": "/", "pool": "default"}})
+	inUse, err = n.IsUsed()
+	require.NoError(t, err)
+	assert.False(t, inUse)
+
+	InvalidateUsageCache(deviceConfig.NewDevices(devices))
+	inUse, err = n.IsUsed()
+	require.NoError(t, err)
+	assert.True(t, inUse)
+}
+
+// Test that deleting a protected network referenced by the default profile is refused.
+func TestValidateDelete(t *testing.T) {
+	s, cleanup := state.NewTestState(t)
+	defer cleanup()
+
+	id, err := s.Cluster.CreateNetwork("testbr0", "", db.NetworkTypeBridge, map[string]string{})
+	require.NoError(t, err)
+
+	err = s.Cluster.Transaction(func(tx *db.ClusterTx) error {
+		return tx.UpdateProfile("default", "default", db.Profile{
+			Project: "default",
+			Name:    "default",
+			Devices: map[string]map[string]string{
+				"eth0": {"type": "nic", "network": "testbr0", "name": "eth0"},
+			},
+		})
+	})
+	require.NoError(t, err)
+
+	n := &common{}
+	n.init(s, id, "testbr0", "bridge", "", map[string]string{}, api.NetworkStatusCreated)
+
+	// Test the network isn't protected by default.
+	assert.NoError(t, n.ValidateDelete())
+
+	err = s.Cluster.Transaction(func(tx *db.ClusterTx) error {
+		return tx.UpdateConfig(map[string]string{"network.protected_names": "lxdbr0, testbr0"})
+	})
+	require.NoError(t, err)
+
+	err = n.ValidateDelete()
+	assert.EqualError(t, err, `The network is protected and used by the "default" profile in project "default", update the profile to use another network before deleting it`)
+
+	// Test cluster notifications aren't checked.
+	assert.NoError(t, n.validateNotProtected(true))
+}
+
+// Test DNS config accessors
+func TestDNSConfig(t *testing.T) {
+	n := &common{config: map[string]string{}}
+
+	// Test defaults.
+	assert.True(t, n.HasDNS())
+	assert.Equal(t, "lxd", n.DNSDomain())
+	assert.Equal(t, "managed", n.DNSMode())
+	assert.Equal(t, []string{"lxd"}, n.DNSSearchDomains())
+
+	// Test configured values.
+	n.config["dns.domain"] = "example.com"
+	n.config["dns.mode"] = "none"
+	n.config["dns.search"] = "example.com, foo.example.com,"
+	assert.False(t, n.HasDNS())
+	assert.Equal(t, "example.com", n.DNSDomain())
+	assert.Equal(t, "none", n.DNSMode())
+	assert.Equal(t, []string{"example.com", "foo.example.com"}, n.DNSSearchDomains())
+}
+
+// Test DNSUpstreams
+func TestDNSUpstreams(t *testing.T) {
+	n := &common{config: map[string]string{}}
+
+	// Test the host's resolvers are used by default.
+	upstreams, err := n.DNSUpstreams()
+	assert.NoError(t, err)
+	assert.Empty(t, upstreams)
+
+	n.config["dns.nameservers"] = "10.0.0.53, fd42::53"
+	upstreams, err = n.DNSUpstreams()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.53", "fd42::53"}, []string{upstreams[0].String(), upstreams[1].String()})
+
+	n.config["dns.nameservers"] = "10.0.0.53,example.com"
+	_, err = n.DNSUpstreams()
+	assert.EqualError(t, err, `Invalid nameserver address "example.com"`)
+
+	// Test upstreams can't be set when the network doesn't provide DNS.
+	b := &bridge{}
+	b.init(nil, 0, "lxdbr0", "bridge", "", map[string]string{}, api.NetworkStatusCreated)
+	assert.NoError(t, b.Validate(map[string]string{"dns.nameservers": "10.0.0.53"}))
+	assert.Error(t, b.Validate(map[string]string{"dns.nameservers": "10.0.0.53", "dns.mode": "none"}))
+	assert.Error(t, b.Validate(map[string]string{"dns.nameservers": "ns1"}))
+}
+
+// Test that an identical update doesn't notify other nodes, write to the database, emit an event or apply anything.
+func TestUpdateNoop(t *testing.T) {
+	config := map[string]string{"parent": "eth0", "user.foo": "bar"}
+	s, n, cleanup := newTestMacvlan(t, "desc", config)
+	defer cleanup()
+
+	// Leave the endpoints and events unset so that any attempt to notify other nodes or emit a lifecycle event
+	// fails.
+	s.Endpoints = nil
+	s.Events = nil
+
+	applied := false
+	unregister := RegisterConfigObserver("testnet", func(networkName string, changedKeys []string) error {
+		applied = true
+		return nil
+	})
+	defer unregister()
+
+	// Change a key in the database behind the network's back, so that any write of the unchanged config is
+	// detected.
+	err := s.Cluster.UpdateNetworkConfigKeys("testnet", "desc", map[string]string{"user.foo": "db"})
+	require.NoError(t, err)
+
+	err = n.common.update(api.NetworkPut{Description: "desc", Config: map[string]string{"parent": "eth0", "user.foo": "bar"}}, "", false, nil)
+	assert.NoError(t, err)
+
+	err = n.Update(api.NetworkPut{Description: "desc", Config: map[string]string{"parent": "eth0", "user.foo": "bar"}}, "", false)
+	assert.NoError(t, err)
+	assert.False(t, applied)
+
+	_, netInfo, err := s.Cluster.GetNetworkInAnyState("testnet")
+	require.NoError(t, err)
+	assert.Equal(t, "desc", netInfo.Description)
+	assert.Equal(t, map[string]string{"parent": "eth0", "user.foo": "db"}, netInfo.Config)
+}
+
+// Test that a frozen network can't be changed until it is unfrozen.
+func TestFrozen(t *testing.T) {
+	config := map[string]string{"parent": "eth0", "security.frozen": "true"}
+	_, n, cleanup := newTestMacvlan(t, "", config)
+	defer cleanup()
+
+	// Test each mutating operation is blocked.
+	err := n.Update(api.NetworkPut{Config: map[string]string{"parent": "eth1", "security.frozen": "true"}}, "", false)
+	assert.Equal(t, ErrFrozen, err)
+
+	err = n.Rename("testnet1", false)
+	assert.Equal(t, ErrFrozen, err)
+
+	err = n.Delete(false)
+	assert.Equal(t, ErrFrozen, err)
+
+	// Test unfreezing can't be combined with other changes.
+	err = n.Update(api.NetworkPut{Description: "desc", Config: map[string]string{"parent": "eth0"}}, "", false)
+	assert.Equal(t, ErrFrozen, err)
+
+	// Test cluster notifications aren't blocked.
+	assert.NoError(t, n.validateNotFrozen(true))
+
+	// Test unfreezing restores normal behavior.
+	err = n.Update(api.NetworkPut{Config: map[string]string{"parent": "eth0"}}, "", false)
+	assert.NoError(t, err)
+	assert.False(t, n.frozen())
+
+	err = n.Update(api.NetworkPut{Config: map[string]string{"parent": "eth1"}}, "", false)
+	assert.NoError(t, err)
+
+	err = n.Delete(false)
+	assert.NoError(t, err)
+}
+
+// Test that volatile keys survive updates that don't mention them.
+func TestUpdatePreservesVolatileKeys(t *testing.T) {
+	config := map[string]string{"parent": "eth0", "volatile.foo": "bar"}
+	s, n, cleanup := newTestMacvlan(t, "", config)
+	defer cleanup()
+
+	err := n.Update(api.NetworkPut{Config: map[string]string{"parent": "eth1"}}, "", false)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"parent": "eth1", "volatile.foo": "bar"}, n.Config())
+
+	_, dbNetwork, err := s.Cluster.GetNetworkInAnyState("testnet")
+	require.NoError(t, err)
+	assert.Equal(t, "bar", dbNetwork.Config["volatile.foo"])
+
+	// Test a volatile key can still be removed explicitly.
+	err = n.Patch(api.NetworkPut{Config: map[string]string{"volatile.foo": ""}}, "", false)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"parent": "eth1"}, n.Config())
+}
+
+// Test that a patch only changes the supplied keys and goes through the driver's update.
+func TestPatch(t *testing.T) {
+	config := map[string]string{"parent": "eth0", "mtu": "1500", "user.foo": "bar"}
+	s, n, cleanup := newTestMacvlan(t, "desc", config)
+	defer cleanup()
+
+	observed := [][]string{}
+	unregister := RegisterConfigObserver("testnet", func(networkName string, changedKeys []string) error {
+		observed = append(observed, changedKeys)
+		return nil
+	})
+	defer unregister()
+
+	// Test supplied keys are merged, empty values remove keys and the description is kept when not supplied.
+	err := n.Patch(api.NetworkPut{Config: map[string]string{"mtu": "9000", "user.foo": ""}}, "", false)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"parent": "eth0", "mtu": "9000"}, n.Config())
+	assert.Equal(t, "desc", n.description)
+	assert.Len(t, observed, 1)
+	assert.Equal(t, []string{"mtu"}, observed[0])
+
+	_, dbNetwork, err := s.Cluster.GetNetworkInAnyState("testnet")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"parent": "eth0", "mtu": "9000"}, dbNetwork.Config)
+	assert.Equal(t, "desc", dbNetwork.Description)
+
+	// Test a patch that doesn't change anything is a no-op.
+	err = n.Patch(api.NetworkPut{Config: map[string]string{"mtu": "9000"}}, "", false)
+	assert.NoError(t, err)
+	assert.Len(t, observed, 1)
+
+	// Test a patch still honours the driver's update checks.
+	err = n.Patch(api.NetworkPut{Config: map[string]string{"security.frozen": "true"}}, "", false)
+	assert.NoError(t, err)
+
+	err = n.Patch(api.NetworkPut{Config: map[string]string{"mtu": "1500"}}, "", false)
+	assert.Equal(t, ErrFrozen, err)
+}
+
+// Test that a patch removing unknown or stale keys passes validation.
+func TestPatchRemoveInvalidKeys(t *testing.T) {
+	config := map[string]string{"parent": "eth0", "bogus.stale": "foo"}
+	_, n, cleanup := newTestMacvlan(t, "", config)
+	defer cleanup()
+
+	// Test unknown keys are still rejected when set.
+	err := n.ValidateUpdate(api.NetworkPut{Config: map[string]string{"bogus.key": "foo", "bogus.stale": ""}}, "")
+	assert.Error(t, err)
+
+	// Test removing a key that isn't set and a key that is no longer valid.
+	req := api.NetworkPut{Config: map[string]string{"bogus.key": "", "bogus.stale": ""}}
+	err = n.ValidateUpdate(req, "")
+	assert.NoError(t, err)
+
+	err = n.Patch(req, "", false)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"parent": "eth0"}, n.Config())
+}
+
+// Test config observers are notified after updates.
+func TestConfigObservers(t *testing.T) {
+	config := map[string]string{"parent": "eth0"}
+	_, n, cleanup := newTestMacvlan(t, "", config)
+	defer cleanup()
+
+	observed := [][]string{}
+	unregister := RegisterConfigObserver("testnet", func(networkName string, changedKeys []string) error {
+		observed = append(observed, changedKeys)
+		return nil
+	})
+
+	// Test observers for other networks aren't called and errors don't fail the update.
+	unregisterOther := RegisterConfigObserver("othernet", func(networkName string, changedKeys []string) error {
+		t.Errorf("Observer for %q called for network %q", "othernet", networkName)
+		return nil
+	})
+	defer unregisterOther()
+
+	unregisterFailing := RegisterConfigObserver("", func(networkName string, changedKeys []string) error {
+		return fmt.Errorf("Failed")
+	})
+	defer unregisterFailing()
+
+	err := n.Update(api.NetworkPut{Config: map[string]string{"parent": "eth1"}}, "", false)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"parent"}}, observed)
+
+	// Test observers aren't called when nothing has changed or after being unregistered.
+	err = n.Update(api.NetworkPut{Config: map[string]string{"parent": "eth1"}}, "", false)
+	assert.NoError(t, err)
+
+	// Test observers aren't called for pending networks as nothing has been applied.
+	n.status = api.NetworkStatusPending
+	err = n.Update(api.NetworkPut{Config: map[string]string{"parent": "eth2"}}, "", false)
+	assert.NoError(t, err)
+	assert.Len(t, observed, 1)
+
+	n.status = api.NetworkStatusCreated
+	unregister()
+	err = n.Update(api.NetworkPut{Config: map[string]string{"parent": "eth3"}}, "", false)
+	assert.NoError(t, err)
+	assert.Len(t, observed, 1)
+}
+
+// Test that concurrent SetKeys calls changing different keys don't overwrite each other.
+func TestSetKeysConcurrent(t *testing.T) {
+	config := map[string]string{"parent": "eth0", "user.c": "foo"}
+	s, testNet, cleanup := newTestMacvlan(t, "", config)
+	defer cleanup()
+
+	// Each call uses its own network as if loaded by separate API requests.
+	errs := make(chan error, 2)
+	for _, key := range []string{"user.a", "user.b"} {
+		go func(key string) {
+			n := &macvlan{}
+			n.init(s, testNet.id, "testnet", "macvlan", "", config, api.NetworkStatusCreated)
+			errs <- n.SetKeys(map[string]string{key: "bar", "user.c": ""}, "")
+		}(key)
+	}
+
+	assert.NoError(t, <-errs)
+	assert.NoError(t, <-errs)
+
+	_, dbNetwork, err := s.Cluster.GetNetworkInAnyState("testnet")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"parent": "eth0", "user.a": "bar", "user.b": "bar"}, dbNetwork.Config)
+
+	n := &macvlan{}
+	n.init(s, testNet.id, "testnet", "macvlan", "", dbNetwork.Config, api.NetworkStatusCreated)
+
+	// Test the merged result is validated before being stored.
+	err = n.SetKeys(map[string]string{"parent": ""}, "")
+	assert.Error(t, err)
+
+	// Test a change stored between loading and storing the config is merged rather than overwritten.
+	attempts := 0
+	err = n.common.setKeys(map[string]string{"user.a": "baz"}, "", func(newNetwork api.NetworkPut, expectedConfig map[string]string) error {
+		attempts++
+		if attempts == 1 {
+			require.NoError(t, s.Cluster.UpdateNetworkConfigKeys("testnet", "", map[string]string{"user.b": "qux"}))
+		}
+
+		return n.update(newNetwork, "", false, expectedConfig)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, attempts)
+
+	_, dbNetwork, err = s.Cluster.GetNetworkInAnyState("testnet")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"parent": "eth0", "user.a": "baz", "user.b": "qux"}, dbNetwork.Config)
+	assert.Equal(t, dbNetwork.Config, n.Config())
+}
+
+// Test that a description-only change leaves the config untouched, including config changed behind its back.
+func TestSetDescription(t *testing.T) {
+	config := map[string]string{"parent": "eth0"}
+	s, n, cleanup := newTestMacvlan(t, "old", config)
+	defer cleanup()
+
+	// Simulate a concurrent config change made elsewhere.
+	require.NoError(t, s.Cluster.UpdateNetwork("testnet", "old", map[string]string{"parent": "eth1", "user.a": "foo"}))
+
+	err := n.SetDescription("new", false)
+	assert.NoError(t, err)
+
+	_, dbNetwork, err := s.Cluster.GetNetworkInAnyState("testnet")
+	require.NoError(t, err)
+	assert.Equal(t, "new", dbNetwork.Description)
+	assert.Equal(t, map[string]string{"parent": "eth1", "user.a": "foo"}, dbNetwork.Config)
+	assert.Equal(t, dbNetwork.Config, n.Config())
+}
+
+// Test that of two concurrent CompareAndUpdate calls expecting the same config exactly one succeeds, whether they
+// use the same network object or their own (as separate requests do).
+func TestCompareAndUpdateConflict(t *testing.T) {
+	config := map[string]string{"parent": "eth0", "user.a": "foo"}
+	s, n, cleanup := newTestMacvlan(t, "", config)
+	defer cleanup()
+
+	expected := api.NetworkPut{Config: map[string]string{"parent": "eth0", "user.a": "foo"}}
+
+	for _, sameObject := range []bool{true, false} {
+		require.NoError(t, s.Cluster.UpdateNetwork("testnet", "", expected.Config))
+		require.NoError(t, n.reloadConfig())
+
+		type result struct {
+			parent string
+			err    error
+		}
+
+		results := make(chan result, 2)
+		for _, parent := range []string{"eth1", "eth2"} {
+			target := n
+			if !sameObject {
+				target = &macvlan{}
+				target.init(s, n.id, "testnet", "macvlan", "", expected.Config, api.NetworkStatusCreated)
+			}
+
+			go func(target *macvlan, parent string) {
+				err := target.CompareAndUpdate(expected, api.NetworkPut{Config: map[string]string{"parent": parent, "user.a": "foo"}}, false)
+				results <- result{parent: parent, err: err}
+			}(target, parent)
+		}
+
+		winners := []string{}
+		for i := 0; i < 2; i++ {
+			r := <-results
+			if r.err == nil {
+				winners = append(winners, r.parent)
+			} else {
+				assert.Equal(t, ErrConfigConflict, r.err)
+			}
+		}
+
+		require.Len(t, winners, 1)
+
+		_, dbNetwork, err := s.Cluster.GetNetworkInAnyState("testnet")
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"parent": winners[0], "user.a": "foo"}, dbNetwork.Config)
+
+		if sameObject {
+			assert.Equal(t, dbNetwork.Config, n.Config())
+		}
+	}
+
+	// Test user key differences can be ignored.
+	require.NoError(t, n.reloadConfig())
+	expected = api.NetworkPut{Config: map[string]string{"parent": n.Config()["parent"], "user.a": "bar"}}
+	assert.Equal(t, ErrConfigConflict, n.CompareAndUpdate(expected, api.NetworkPut{Config: map[string]string{"parent": "eth3"}}, false))
+	assert.NoError(t, n.CompareAndUpdate(expected, api.NetworkPut{Config: map[string]string{"parent": "eth3"}}, true))
+}
+
+// Test that the teardown hook runs before the database record is deleted, even if it fails.
+func TestDeleteTeardown(t *testing.T) {
+	s, cleanup := state.NewTestState(t)
+	defer cleanup()
+
+	s.Events = events.NewServer(false, false)
+
+	id, err := s.Cluster.CreateNetwork("testbr0", "", db.NetworkTypeBridge, map[string]string{})
+	require.NoError(t, err)
+
+	n := &common{}
+	n.init(s, id, "testbr0", "bridge", "", map[string]string{}, api.NetworkStatusCreated)
+
+	called := false
+	err = n.delete(false, func() error {
+		called = true
+
+		_, _, err := s.Cluster.GetNetworkInAnyState("testbr0")
+		assert.NoError(t, err, "Database record deleted before teardown")
+
+		return fmt.Errorf("Teardown failed")
+	})
+	assert.NoError(t, err)
+	assert.True(t, called)
+
+	_, _, err = s.Cluster.GetNetworkInAnyState("testbr0")
+	assert.Error(t, err)
+
+	// Test cluster notifications run the teardown without touching the database.
+	called = false
+	err = n.delete(true, func() error {
+		called = true
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.True(t, called)
+}
+
+// Test DHCPv6 prefix delegation accessors.
+func TestDHCPv6PD(t *testing.T) {
+	n := &common{config: map[string]string{}}
+
+	// Test no prefix delegation configured.
+	ranges, err := n.DHCPv6PDRanges()
+	assert.NoError(t, err)
+	assert.Len(t, ranges, 0)
+
+	// Test prefix delegation requires stateful DHCPv6.
+	n.config = map[string]string{"ipv6.dhcp.pd.ranges": "2001:db8:1::-2001:db8:1:ff00::"}
+	_, err = n.DHCPv6PDRanges()
+	assert.Error(t, err)
+
+	_, err = n.DHCPv6PDPrefixLength()
+	assert.Error(t, err)
+
+	// Test default delegated prefix length.
+	n.config = map[string]string{"ipv6.dhcp.stateful": "true", "ipv6.dhcp.pd.ranges": "2001:db8:1::-2001:db8:1:ff00::"}
+	ranges, err = n.DHCPv6PDRanges()
+	assert.NoError(t, err)
+	assert.Len(t, ranges, 1)
+
+	length, err := n.DHCPv6PDPrefixLength()
+	assert.NoError(t, err)
+	assert.Equal(t, 64, length)
+
+	// Test delegated prefix length longer than the /48 pool prefix.
+	n.config["ipv6.dhcp.pd.prefix_length"] = "56"
+	length, err = n.DHCPv6PDPrefixLength()
+	assert.NoError(t, err)
+	assert.Equal(t, 56, length)
+
+	// Test invalid delegated prefix lengths.
+	for _, value := range []string{"48", "32", "0", "129", "foo"} {
+		n.config["ipv6.dhcp.pd.prefix_length"] = value
+		_, err = n.DHCPv6PDPrefixLength()
+		assert.Error(t, err, value)
+	}
+}
+
+// Test StaticRoutes
+func TestStaticRoutes(t *testing.T) {
+	n := &common{config: map[string]string{
+		"ipv4.address":         "10.0.0.1/24",
+		"ipv4.routes.external": "192.168.0.0/16 via 10.0.0.5",
+		"ipv6.address":         "fd42::1/64",
+		"ipv6.routes.external": "fd43::/64",
+	}}
+
+	// Test IPv4 routes are returned before IPv6 routes.
+	routes, err := n.StaticRoutes()
+	assert.NoError(t, err)
+	assert.Len(t, routes, 2)
+	assert.Equal(t, "192.168.0.0/16", routes[0].Destination.String())
+	assert.Equal(t, "fd43::/64", routes[1].Destination.String())
+
+	// Test gateway outside of the network's subnet.
+	n.config["ipv4.routes.external"] = "192.168.0.0/16 via 10.0.1.5"
+	_, err = n.StaticRoutes()
+	assert.Error(t, err)
+
+	// Test gateway without a network address.
+	n.config = map[string]string{"ipv6.address": "none", "ipv6.routes.external": "fd43::/64 via fd42::5"}
+	_, err = n.StaticRoutes()
+	assert.Error(t, err)
+}
+
+// Test DNS record management
+func TestDNSRecords(t *testing.T) {
+	n := &common{name: "lxdbr0", config: map[string]string{"dns.domain": "example.internal"}}
+
+	// Test adding a record normalises the name and type.
+	newNetwork, err := n.addDNSRecord(api.NetworkDNSRecord{Name: "DB.example.internal", Type: "a", Value: "192.0.2.10"})
+	assert.NoError(t, err)
+	assert.Equal(t, "A 192.0.2.10", newNetwork.Config["dns.record.db.example.internal"])
+
+	n.config = newNetwork.Config
+	newNetwork, err = n.addDNSRecord(api.NetworkDNSRecord{Name: "alias.example.internal", Type: "CNAME", Value: "db.example.internal"})
+	assert.NoError(t, err)
+
+	n.config = newNetwork.Config
+	records, err := n.DNSRecords()
+	assert.NoError(t, err)
+	assert.Equal(t, []api.NetworkDNSRecord{
+		{Name: "alias.example.internal", Type: "CNAME", Value: "db.example.internal"},
+		{Name: "db.example.internal", Type: "A", Value: "192.0.2.10"},
+	}, records)
+
+	// Test duplicate records and records outside of the DNS domain are rejected.
+	_, err = n.addDNSRecord(api.NetworkDNSRecord{Name: "db.example.internal", Type: "AAAA", Value: "fd42::10"})
+	assert.Error(t, err)
+	_, err = n.addDNSRecord(api.NetworkDNSRecord{Name: "db.example.com", Type: "A", Value: "192.0.2.10"})
+	assert.Error(t, err)
+
+	// Test deleting records.
+	newNetwork, err = n.deleteDNSRecord("alias.example.internal")
+	assert.NoError(t, err)
+	assert.NotContains(t, newNetwork.Config, "dns.record.alias.example.internal")
+
+	_, err = n.deleteDNSRecord("missing.example.internal")
+	assert.Error(t, err)
+}
+
+// Test IPv6RAConfig
+func TestIPv6RAConfig(t *testing.T) {
+	n := &common{config: map[string]string{"ipv6.address": "none"}}
+
+	assert.Equal(t, RAConfig{Mode: RAModeNone}, n.IPv6RAConfig())
+
+	// Test stateless DHCPv6 is the default.
+	n.config["ipv6.address"] = "fd42::1/64"
+	assert.Equal(t, RAConfig{Mode: RAModeOtherConfig, AdvertiseDNS: true}, n.IPv6RAConfig())
+
+	n.config["ipv6.dhcp.stateful"] = "true"
+	n.config["ipv6.dns"] = "false"
+	assert.Equal(t, RAConfig{Mode: RAModeManaged, AdvertiseDNS: false}, n.IPv6RAConfig())
+
+	// Test stateful DHCPv6 is ignored without DHCPv6 rather than failing network setup.
+	n.config["ipv6.dhcp"] = "false"
+	assert.Equal(t, RAModeSLAAC, n.IPv6RAConfig().Mode)
+}
+
+// Test BridgeHWAddr
+func TestBridgeHWAddr(t *testing.T) {
+	s, cleanup := state.NewTestState(t)
+	defer cleanup()
+
+	config := map[string]string{"ipv4.address": "none", "ipv6.address": "none"}
+	id, err := s.Cluster.CreateNetwork("lxdbr0", "", db.NetworkTypeBridge, config)
+	require.NoError(t, err)
+
+	n := &common{}
+	n.init(s, id, "lxdbr0", "bridge", "", config, api.NetworkStatusCreated)
+
+	// Test no address is generated unless asked to.
+	hwAddr, err := n.BridgeHWAddr(false)
+	assert.NoError(t, err)
+	assert.Nil(t, hwAddr)
+
+	// Test a generated address is seeded with the node name and stored.
+	hwAddr, err = n.BridgeHWAddr(true)
+	assert.NoError(t, err)
+	assert.Equal(t, stableHWAddr("none/lxdbr0"), hwAddr)
+	assert.Equal(t, hwAddr.String(), n.Config()["volatile.bridge.hwaddr"])
+
+	_, dbNetwork, err := s.Cluster.GetNetworkInAnyState("lxdbr0")
+	require.NoError(t, err)
+	assert.Equal(t, hwAddr.String(), dbNetwork.Config["volatile.bridge.hwaddr"])
+	assert.Equal(t, "none", dbNetwork.Config["ipv4.address"])
+
+	// Test the stored address is reused.
+	storedHWAddr, err := n.BridgeHWAddr(false)
+	assert.NoError(t, err)
+	assert.Equal(t, hwAddr, storedHWAddr)
+
+	// Test the configured address takes precedence.
+	n.config["bridge.hwaddr"] = "00:16:3E:12:34:56"
+	hwAddr, err = n.BridgeHWAddr(true)
+	assert.NoError(t, err)
+	assert.Equal(t, "00:16:3e:12:34:56", hwAddr.String())
+
+	// Test invalid configured address.
+	n.config["bridge.hwaddr"] = "01:16:3e:12:34:56"
+	_, err = n.BridgeHWAddr(false)
+	assert.Error(t, err)
+}
+
+// Test ProbeTunnelRemotes
+func TestProbeTunnelRemotes(t *testing.T) {
+	defer func(ping func(ip net.IP, timeout time.Duration) error) { pingHost = ping }(pingHost)
+
+	// Only 192.0.2.2 answers pings.
+	pingHost = func(ip net.IP, timeout time.Duration) error {
+		if ip.String() == "192.0.2.2" {
+			return nil
+		}
+
+		return fmt.Errorf("No reply from %s", ip)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	require.NoError(t, err)
+
+	n := &common{config: map[string]string{
+		"tunnel.a.protocol": "vxlan",
+		"tunnel.a.remote":   "127.0.0.1",
+		"tunnel.a.local":    "127.0.0.1",
+		"tunnel.a.port":     port,
+		"tunnel.b.protocol": "gre",
+		"tunnel.b.remote":   "192.0.2.1",
+		"tunnel.b.local":    "127.0.0.1",
+		"tunnel.c.protocol": "vxlan",
+		"tunnel.d.protocol": "gre",
+		"tunnel.d.remote":   "192.0.2.2",
+		"tunnel.d.local":    "127.0.0.1",
+	}}
+
+	_, err = n.ProbeTunnelRemotes(0)
+	assert.Error(t, err)
+
+	// Test tunnels without a remote are skipped and each result is reported against its tunnel.
+	results, err := n.ProbeTunnelRemotes(100 * time.Millisecond)
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+
+	// Test VXLAN tunnels fall back to TCP when the remote doesn't answer pings.
+	assert.Equal(t, "a", results[0].Name)
+	assert.Equal(t, "tcp", results[0].Method)
+	assert.True(t, results[0].Reachable)
+	assert.NoError(t, results[0].Err)
+
+	assert.Equal(t, "b", results[1].Name)
+	assert.Equal(t, "192.0.2.1", results[1].Remote)
+	assert.Equal(t, "icmp", results[1].Method)
+	assert.False(t, results[1].Reachable)
+	assert.EqualError(t, results[1].Err, "No reply from 192.0.2.1")
+
+	assert.Equal(t, "d", results[2].Name)
+	assert.Equal(t, "icmp", results[2].Method)
+	assert.True(t, results[2].Reachable)
+	assert.NoError(t, results[2].Err)
+}
+
+// Test MTU
+func TestMTU(t *testing.T) {
+	n := &common{config: map[string]string{}}
+
+	mtu, err := n.MTU()
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(1500), mtu)
+
+	n.config["bridge.mtu"] = "9000"
+	mtu, err = n.MTU()
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(9000), mtu)
+
+	for _, value := range []string{"foo", "-1", "67", "65536"} {
+		n.config["bridge.mtu"] = value
+		_, err = n.MTU()
+		assert.Error(t, err, value)
+	}
+}
+
+// Test Limits
+func TestLimits(t *testing.T) {
+	n := &common{config: map[string]string{}}
+
+	// Test unlimited.
+	ingress, egress, priority, err := n.Limits()
+	assert.NoError(t, err)
+	assert.Equal(t, "", ingress)
+	assert.Equal(t, "", egress)
+	assert.Equal(t, 0, priority)
+
+	// Test unit suffixes are normalised.
+	n.config = map[string]string{"limits.ingress": "10Mbit", "limits.egress": "1Gbit", "limits.priority": "5"}
+	ingress, egress, priority, err = n.Limits()
+	assert.NoError(t, err)
+	assert.Equal(t, "10000000bit", ingress)
+	assert.Equal(t, "1000000000bit", egress)
+	assert.Equal(t, 5, priority)
+
+	// Test bad unit.
+	n.config = map[string]string{"limits.ingress": "10Mfoo"}
+	_, _, _, err = n.Limits()
+	assert.Error(t, err)
+}
+
+// Test State for networks without a host interface.
+func TestStateUnavailable(t *testing.T) {
+	n := &common{name: "testbr0", status: api.NetworkStatusPending}
+
+	netState, err := n.State()
+	assert.NoError(t, err)
+	assert.Equal(t, "unavailable", netState.State)
+
+	n = &common{name: "lxdtestmissing0", status: api.NetworkStatusCreated}
+
+	netState, err = n.State()
+	assert.NoError(t, err)
+	assert.Equal(t, "unavailable", netState.State)
+}
+
+// Test CheckConsistency and Repair
+func TestCheckConsistencyRepair(t *testing.T) {
+	s, cleanup := state.NewTestState(t)
+	defer cleanup()
+
+	oldLXDDir := os.Getenv("LXD_DIR")
+	os.Setenv("LXD_DIR", s.OS.VarDir)
+	defer os.Setenv("LXD_DIR", oldLXDDir)
+
+	id, err := s.Cluster.CreateNetwork("testbr0", "", db.NetworkTypeBridge, map[string]string{})
+	require.NoError(t, err)
+
+	_, err = s.Cluster.CreateNetwork("testbr1", "", db.NetworkTypeBridge, map[string]string{})
+	require.NoError(t, err)
+
+	require.NoError(t, os.MkdirAll(shared.VarPath("networks", "orphan0"), 0711))
+
+	n := &common{}
+	n.init(s, id, "testbr0", "bridge", "", map[string]string{}, api.NetworkStatusCreated)
+
+	expected := []Inconsistency{
+		{Type: InconsistencyMissingDirectory, Network: "testbr0", Path: shared.VarPath("networks", "testbr0")},
+	}
+
+	inconsistencies, err := n.CheckConsistency()
+	require.NoError(t, err)
+	assert.Equal(t, expected, inconsistencies)
+
+	// Test a dry run doesn't change anything.
+	require.NoError(t, n.Repair(true))
+	inconsistencies, err = n.CheckConsistency()
+	require.NoError(t, err)
+	assert.Equal(t, expected, inconsistencies)
+
+	// Test repairing is idempotent.
+	for i := 0; i < 2; i++ {
+		require.NoError(t, n.Repair(false))
+		inconsistencies, err = n.CheckConsistency()
+		require.NoError(t, err)
+		assert.Empty(t, inconsistencies)
+	}
+
+	assert.True(t, shared.PathExists(shared.VarPath("networks", "testbr0")))
+
+	// Test other networks' directories are left alone.
+	assert.False(t, shared.PathExists(shared.VarPath("networks", "testbr1")))
+
+	// Test an orphaned directory is reported but not removed.
+	orphan := &common{}
+	orphan.init(s, -1, "orphan0", "bridge", "", map[string]string{}, api.NetworkStatusCreated)
+
+	inconsistencies, err = orphan.CheckConsistency()
+	require.NoError(t, err)
+	assert.Equal(t, []Inconsistency{
+		{Type: InconsistencyOrphanedDirectory, Network: "orphan0", Path: shared.VarPath("networks", "orphan0")},
+	}, inconsistencies)
+
+	require.NoError(t, orphan.Repair(false))
+	assert.True(t, shared.PathExists(shared.VarPath("networks", "orphan0")))
+}
+
+// Test Health
+func TestHealth(t *testing.T) {
+	n := &common{name: "testbr0", status: api.NetworkStatusPending}
+
+	status, issues := n.Health()
+	assert.Equal(t, HealthStatusUnknown, status)
+	assert.Len(t, issues, 1)
+
+	// Test the worst status of all checks is returned along with all of the issues.
+	n = &common{name: "testbr0", status: api.NetworkStatusCreated}
+	status, issues = n.health(n.healthInterface("lo"), n.healthInterface("lxdtestmissing0"))
+	assert.Equal(t, HealthStatusUnhealthy, status)
+	assert.Equal(t, []string{`Interface "lxdtestmissing0" doesn't exist`}, issues)
+
+	// Test macvlan checks the parent interface.
+	m := &macvlan{common{name: "testnet", status: api.NetworkStatusCreated, config: map[string]string{"parent": "lo"}}}
+	status, issues = m.Health()
+	assert.Equal(t, HealthStatusHealthy, status)
+	assert.Empty(t, issues)
+
+	m = &macvlan{common{name: "testnet", status: api.NetworkStatusErrored, config: map[string]string{"parent": "lo"}}}
+	status, issues = m.Health()
+	assert.Equal(t, HealthStatusUnhealthy, status)
+	assert.Equal(t, []string{"Network is in errored state"}, issues)
+
+	// Test missing configured addresses degrade the network.
+	n = &common{name: "lo", status: api.NetworkStatusCreated, config: map[string]string{"ipv4.address": "127.0.0.1/8", "ipv6.address": "fd42::1/64"}}
+	status, issues = n.health(n.healthAddresses("lo"))
+	assert.Equal(t, HealthStatusDegraded, status)
+	assert.Equal(t, []string{`Address "fd42::1" isn't assigned to interface "lo"`}, issues)
+}
+
+// Test renumberConfig
+func TestRenumberConfig(t *testing.T) {
+	n := &common{name: "testbr0", config: map[string]string{
+		"ipv4.address":                   "10.0.0.1/24",
+		"ipv4.dhcp.ranges":               "10.0.0.100-10.0.0.199",
+		"ipv4.dhcp.gateway":              "10.0.0.254",
+		"ipv4.dhcp.exclude":              "10.0.0.110,10.0.0.120-10.0.0.129",
+		"ipv4.dhcp.reservation.10.0.0.5": "printer",
+		"ipv4.nat.address":               "10.0.0.2",
+		"ipv4.routes":                    "10.0.0.128/28,192.0.2.0/24",
+		"ipv4.routes.external":           "198.51.100.0/24 via 10.0.0.3",
+		"dns.record.printer":             "A 10.0.0.5",
+		"ipv6.address":                   "fd42::1/64",
+		"ipv6.nat.address":               "fd42::2",
+	}}
+
+	// Test the DHCP ranges are shifted and the gateway is moved to the corresponding host.
+	newNetwork, oldSubnet, newSubnet, err := n.renumberConfig("192.168.1.1/24", true)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.0/24", oldSubnet.String())
+	assert.Equal(t, "192.168.1.0/24", newSubnet.String())
+	assert.Equal(t, "192.168.1.1/24", newNetwork.Config["ipv4.address"])
+	assert.Equal(t, "192.168.1.100-192.168.1.199", newNetwork.Config["ipv4.dhcp.ranges"])
+	assert.Equal(t, "192.168.1.254", newNetwork.Config["ipv4.dhcp.gateway"])
+	assert.Equal(t, "fd42::1/64", newNetwork.Config["ipv6.address"])
+
+	// Test the other addresses within the old subnet are moved and those outside of it are left unchanged.
+	assert.Equal(t, "192.168.1.110,192.168.1.120-192.168.1.129", newNetwork.Config["ipv4.dhcp.exclude"])
+	assert.Equal(t, "printer", newNetwork.Config["ipv4.dhcp.reservation.192.168.1.5"])
+	assert.NotContains(t, newNetwork.Config, "ipv4.dhcp.reservation.10.0.0.5")
+	assert.Equal(t, "192.168.1.2", newNetwork.Config["ipv4.nat.address"])
+	assert.Equal(t, "192.168.1.128/28,192.0.2.0/24", newNetwork.Config["ipv4.routes"])
+	assert.Equal(t, "198.51.100.0/24 via 192.168.1.3", newNetwork.Config["ipv4.routes.external"])
+	assert.Equal(t, "A 192.168.1.5", newNetwork.Config["dns.record.printer"])
+	assert.Equal(t, "fd42::2", newNetwork.Config["ipv6.nat.address"])
+
+	// Test the ranges are removed when not shifted and the current config isn't changed.
+	newNetwork, _, _, err = n.renumberConfig("192.168.1.1/24", false)
+	require.NoError(t, err)
+	assert.NotContains(t, newNetwork.Config, "ipv4.dhcp.ranges")
+	assert.Equal(t, "10.0.0.1/24", n.config["ipv4.address"])
+
+	// Test renumbering IPv6.
+	newNetwork, _, _, err = n.renumberConfig("fd43::1/64", true)
+	require.NoError(t, err)
+	assert.Equal(t, "fd43::1/64", newNetwork.Config["ipv6.address"])
+	assert.Equal(t, "fd43::2", newNetwork.Config["ipv6.nat.address"])
+	assert.Equal(t, "10.0.0.1/24", newNetwork.Config["ipv4.address"])
+	assert.Equal(t, "10.0.0.2", newNetwork.Config["ipv4.nat.address"])
+
+	// Test invalid requests.
+	_, _, _, err = n.renumberConfig("192.168.1.1", true)
+	assert.Error(t, err)
+
+	_, _, _, err = n.renumberConfig("192.168.1.1/28", true)
+	assert.Error(t, err)
+
+	n.config["ipv6.address"] = "none"
+	_, _, _, err = n.renumberConfig("fd43::1/64", true)
+	assert.EqualError(t, err, `Network "testbr0" has no subnet in "ipv6.address" to renumber`)
+}
+
+// Test WaitReady
+func TestWaitReady(t *testing.T) {
+	s, n, cleanup := newTestMacvlan(t, "", map[string]string{"parent": "lo"})
+	defer cleanup()
+
+	// Test a created network without failing checks is ready straight away.
+	assert.NoError(t, n.WaitReady(context.Background()))
+
+	// Test the last issues are reported when the context is done before the checks pass.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := n.waitReady(ctx, n.healthInterface("lxdtestmissing0"))
+	assert.EqualError(t, err, `Network not ready (Interface "lxdtestmissing0" doesn't exist): context deadline exceeded`)
+
+	// Test an errored network fails straight away.
+	err = s.Cluster.Transaction(func(tx *db.ClusterTx) error {
+		return tx.NetworkErrored("testnet")
+	})
+	require.NoError(t, err)
+
+	assert.EqualError(t, n.WaitReady(context.Background()), "Network is in errored state")
+}
+
+// Test normalizeIPv4Address
+func TestNormalizeIPv4Address(t *testing.T) {
+	n := &common{}
+
+	// Test valid addresses are stored in canonical form and "none" is left as is.
+	for value, expected := range map[string]string{
+		"10.0.0.1/24":   "10.0.0.1/24",
+		"10.0.0.254/24": "10.0.0.254/24",
+		"10.0.0.0/31":   "10.0.0.0/31",
+		"none":          "none",
+	} {
+		config := map[string]string{"ipv4.address": value}
+		assert.NoError(t, n.normalizeIPv4Address(config), value)
+		assert.Equal(t, expected, config["ipv4.address"])
+	}
+
+	// Test addresses without a prefix length, network addresses and non-IPv4 addresses are rejected.
+	for _, value := range []string{"10.0.0.1", "10.0.0.0/24", "fd42::1/64", "foo"} {
+		err := n.normalizeIPv4Address(map[string]string{"ipv4.address": value})
+		assert.Error(t, err, value)
+	}
+
+	// Test "auto" is replaced by the gateway address of a newly allocated /24 subnet.
+	config := map[string]string{"ipv4.address": "auto"}
+	require.NoError(t, n.normalizeIPv4Address(config))
+
+	ip, subnet, err := net.ParseCIDR(config["ipv4.address"])
+	require.NoError(t, err)
+	assert.Equal(t, GetIP(subnet, 1).String(), ip.String())
+	assert.Equal(t, byte(10), ip.To4()[0])
+
+	ones, _ := subnet.Mask.Size()
+	assert.Equal(t, 24, ones)
+}
+
+// Test randomSubnetV4 skips subnets used by other networks.
+func TestRandomSubnetV4(t *testing.T) {
+	defer func(intn func(n int) int) { randomIntn = intn }(randomIntn)
+	defer func(ping func(ip net.IP, timeout time.Duration) error) { pingHost = ping }(pingHost)
+
+	pingHost = func(ip net.IP, timeout time.Duration) error { return fmt.Errorf("No reply") }
+
+	// Generate 10.0.5.0/24, 10.1.2.0/24 and then 10.3.4.0/24.
+	octets := []int{0, 5, 1, 2, 3, 4}
+	randomIntn = func(n int) int {
+		octet := octets[0]
+		octets = octets[1:]
+		return octet
+	}
+
+	used := []*net.IPNet{}
+	for _, cidr := range []string{"10.0.0.0/16", "10.1.2.0/24"} {
+		_, subnet, err := net.ParseCIDR(cidr)
+		require.NoError(t, err)
+		used = append(used, subnet)
+	}
+
+	subnet, err := randomSubnetV4(used)
+	require.NoError(t, err)
+	assert.Equal(t, "10.3.4.0/24", subnet.String())
+}
+
+// Test generateULAPrefix and fillIPv6Address
+func TestGenerateULAPrefix(t *testing.T) {
+	defer func(ping func(ip net.IP, timeout time.Duration) error) { pingHost = ping }(pingHost)
+
+	pingHost = func(ip net.IP, timeout time.Duration) error { return fmt.Errorf("No reply") }
+
+	n := &common{}
+
+	_, ula, _ := net.ParseCIDR("fd00::/8")
+
+	subnet, err := n.generateULAPrefix()
+	require.NoError(t, err)
+	assert.True(t, ula.Contains(subnet.IP))
+
+	ones, bits := subnet.Mask.Size()
+	assert.Equal(t, 64, ones)
+	assert.Equal(t, 128, bits)
+
+	other, err := n.generateULAPrefix()
+	require.NoError(t, err)
+	assert.NotEqual(t, subnet.String(), other.String())
+
+	// Test "auto" is replaced by a concrete address and other values are left as is.
+	config := map[string]string{"ipv6.address": "auto"}
+	require.NoError(t, n.fillIPv6Address(config))
+
+	ip, subnet, err := net.ParseCIDR(config["ipv6.address"])
+	require.NoError(t, err)
+	assert.True(t, ula.Contains(ip))
+	assert.Equal(t, GetIP(subnet, 1).String(), ip.String())
+
+	config = map[string]string{"ipv6.address": "fd42::1/64"}
+	require.NoError(t, n.fillIPv6Address(config))
+	assert.Equal(t, "fd42::1/64", config["ipv6.address"])
+}
+
+// Test Statistics
+func TestStatistics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lxd-network-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	oldSysClassNet := sysClassNet
+	sysClassNet = dir
+	defer func() { sysClassNet = oldSysClassNet }()
+
+	statsPath := filepath.Join(dir, "lxdbr0", "statistics")
+	require.NoError(t, os.MkdirAll(statsPath, 0755))
+
+	counters := []string{"rx_bytes", "tx_bytes", "rx_packets", "tx_packets", "rx_errors", "tx_errors", "rx_dropped", "tx_dropped"}
+	for i, file := range counters {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(statsPath, file), []byte(fmt.Sprintf("%d\n", i+1)), 0644))
+	}
+
+	n := &bridge{common{name: "lxdbr0", status: api.NetworkStatusCreated}}
+	stats, err := n.Statistics()
+	assert.NoError(t, err)
+	assert.Equal(t, &api.NetworkStatistics{
+		Interface:       "lxdbr0",
+		BytesReceived:   1,
+		BytesSent:       2,
+		PacketsReceived: 3,
+		PacketsSent:     4,
+		ErrorsReceived:  5,
+		ErrorsSent:      6,
+		DroppedReceived: 7,
+		DroppedSent:     8,
+	}, stats)
+
+	// Test networks without a host interface return zero counters.
+	n = &bridge{common{name: "lxdbr1", status: api.NetworkStatusCreated}}
+	stats, err = n.Statistics()
+	assert.NoError(t, err)
+	assert.Equal(t, &api.NetworkStatistics{}, stats)
+
+	m := &macvlan{common{name: "testnet", status: api.NetworkStatusCreated, config: map[string]string{"parent": "lxdbr0"}}}
+	stats, err = m.Statistics()
+	assert.NoError(t, err)
+	assert.Equal(t, &api.NetworkStatistics{}, stats)
+
+	// Test malformed counters.
+	require.NoError(t, ioutil.WriteFile(filepath.Join(statsPath, "rx_bytes"), []byte("invalid\n"), 0644))
+	n = &bridge{common{name: "lxdbr0", status: api.NetworkStatusCreated}}
+	_, err = n.Statistics()
+	assert.Error(t, err)
+}
+
+// Test Metrics
+func TestMetrics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lxd-network-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	oldSysClassNet := sysClassNet
+	sysClassNet = filepath.Join(dir, "sys")
+	defer func() { sysClassNet = oldSysClassNet }()
+
+	oldLXDDir := os.Getenv("LXD_DIR")
+	os.Setenv("LXD_DIR", dir)
+	defer os.Setenv("LXD_DIR", oldLXDDir)
+
+	statsPath := filepath.Join(sysClassNet, "lxdtest0", "statistics")
+	require.NoError(t, os.MkdirAll(statsPath, 0755))
+	for i, file := range []string{"rx_bytes", "tx_bytes", "rx_packets", "tx_packets", "rx_errors", "tx_errors", "rx_dropped", "tx_dropped"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(statsPath, file), []byte(fmt.Sprintf("%d\n", i+1)), 0644))
+	}
+
+	leaseDir := filepath.Join(dir, "networks", "lxdtest0")
+	require.NoError(t, os.MkdirAll(leaseDir, 0711))
+	expiry := time.Now().Add(time.Hour).Unix()
+	content := fmt.Sprintf("%d 00:16:3e:aa:bb:cc 10.0.0.10 c1 *\n%d 00:16:3e:aa:bb:dd 10.0.0.11 c2 *\n", expiry, expiry)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(leaseDir, "dnsmasq.leases"), []byte(content), 0644))
+
+	config := map[string]string{"ipv4.address": "10.0.0.1/24", "ipv4.dhcp.ranges": "10.0.0.10-10.0.0.17"}
+	n := &bridge{}
+	n.init(nil, 0, "lxdtest0", "bridge", "", config, api.NetworkStatusCreated)
+
+	metrics, err := n.Metrics()
+	require.NoError(t, err)
+
+	values := map[string]float64{}
+	for _, metric := range metrics {
+		assert.Equal(t, map[string]string{"name": "lxdtest0", "project": "default"}, metric.Labels)
+		values[metric.Name] = metric.Value
+	}
+
+	assert.Equal(t, map[string]float64{
+		"lxd_network_up":                      0,
+		"lxd_network_receive_bytes_total":     1,
+		"lxd_network_transmit_bytes_total":    2,
+		"lxd_network_receive_packets_total":   3,
+		"lxd_network_transmit_packets_total":  4,
+		"lxd_network_dhcpv4_pool_size":        8,
+		"lxd_network_dhcpv4_leases":           2,
+		"lxd_network_dhcpv4_pool_utilization": 0.25,
+	}, values)
+
+	// Test the lease count is cached.
+	content += fmt.Sprintf("%d 00:16:3e:aa:bb:ee 10.0.0.12 c3 *\n", expiry)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(leaseDir, "dnsmasq.leases"), []byte(content), 0644))
+
+	count, err := n.dhcpv4LeaseCount()
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	// Test the cached count is shared by other objects for the same network.
+	n2 := &bridge{}
+	n2.init(nil, 0, "lxdtest0", "bridge", "", config, api.NetworkStatusCreated)
+	count, err = n2.dhcpv4LeaseCount()
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	leaseCountsMu.Lock()
+	delete(leaseCounts, "lxdtest0")
+	leaseCountsMu.Unlock()
+
+	count, err = n.dhcpv4LeaseCount()
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	// Test networks without a host interface or DHCPv4 have no metrics.
+	m := &macvlan{}
+	m.init(nil, 0, "testnet", "macvlan", "", map[string]string{"parent": "eth0"}, api.NetworkStatusCreated)
+	metrics, err = m.Metrics()
+	require.NoError(t, err)
+	assert.Empty(t, metrics)
+}
+
+// Test DHCPv4Leases
+func TestDHCPv4Leases(t *testing.T) {
+	s, cleanup := state.NewTestState(t)
+	defer cleanup()
+
+	oldLXDDir := os.Getenv("LXD_DIR")
+	os.Setenv("LXD_DIR", s.OS.VarDir)
+	defer os.Setenv("LXD_DIR", oldLXDDir)
+
+	n := &common{}
+	n.init(s, 1, "testbr0", "bridge", "", map[string]string{}, api.NetworkStatusCreated)
+
+	// Test a network that has never been started has no leases.
+	leases, err := n.DHCPv4Leases()
+	assert.NoError(t, err)
+	assert.Equal(t, []api.NetworkLease{}, leases)
+
+	// Test leases are sorted by IP and expired leases are skipped.
+	leaseDir := filepath.Join(s.OS.VarDir, "networks", "testbr0")
+	require.NoError(t, os.MkdirAll(leaseDir, 0711))
+
+	expiry := time.Now().Add(time.Hour).Unix()
+	content := fmt.Sprintf("%d 00:16:3e:aa:bb:cc 10.0.0.20 c1 *\n%d 00:16:3e:aa:bb:dd 10.0.0.10 c2 *\n1 00:16:3e:aa:bb:ee 10.0.0.5 c3 *\n%d 1234 fd42::10 c1 00:01:00:01:26:aa:bb:cc:00:16:3e:aa:bb:cc\n", expiry, expiry, expiry)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(leaseDir, "dnsmasq.leases"), []byte(content), 0644))
+
+	leases, err = n.DHCPv4Leases()
+	assert.NoError(t, err)
+	assert.Len(t, leases, 2)
+	assert.Equal(t, "10.0.0.10", leases[0].Address)
+	assert.Equal(t, "10.0.0.20", leases[1].Address)
+	assert.Equal(t, "c1", leases[1].Hostname)
+
+	// Test DHCPLeases includes the IPv6 leases in file order and reports this node as their location.
+	leases, err = n.DHCPLeases()
+	assert.NoError(t, err)
+	require.Len(t, leases, 3)
+	assert.Equal(t, "10.0.0.20", leases[0].Address)
+	assert.Equal(t, "fd42::10", leases[2].Address)
+	assert.Equal(t, "00:16:3e:aa:bb:cc", leases[2].Hwaddr)
+	assert.Equal(t, "none", leases[2].Location)
+}
+
+// Test ValidateInstanceNIC
+func TestValidateInstanceNIC(t *testing.T) {
+	n := &common{name: "testbr0", config: map[string]string{
+		"ipv4.address":                    "10.0.0.1/24",
+		"ipv4.dhcp.ranges":                "10.0.0.100-10.0.0.200",
+		"ipv4.dhcp.reservation.10.0.0.30": "",
+		"ipv6.address":                    "fd42::1/64",
+	}}
+
+	tests := []struct {
+		name  string
+		nic   map[string]string
+		valid bool
+	}{
+		{"No addresses", map[string]string{}, true},
+		{"Valid static IPv4", map[string]string{"ipv4.address": "10.0.0.10", "hwaddr": "00:16:3e:00:00:01"}, true},
+		{"Invalid MAC", map[string]string{"hwaddr": "01:16:3e:00:00:01"}, false},
+		{"Malformed IPv4", map[string]string{"ipv4.address": "10.0.0"}, false},
+		{"Wrong family", map[string]string{"ipv4.address": "fd42::10"}, false},
+		{"Outside subnet", map[string]string{"ipv4.address": "10.0.1.10"}, false},
+		{"In dynamic range", map[string]string{"ipv4.address": "10.0.0.150"}, true},
+		{"Gateway", map[string]string{"ipv4.address": "10.0.0.1"}, false},
+		{"Broadcast", map[string]string{"ipv4.address": "10.0.0.255"}, false},
+		{"Reserved", map[string]string{"ipv4.address": "10.0.0.30"}, false},
+		{"IPv6 without stateful DHCPv6", map[string]string{"ipv6.address": "fd42::10"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := n.ValidateInstanceNIC(test.nic)
+			if test.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+// Test DHCPStrictMode
+func TestDHCPStrictMode(t *testing.T) {
+	n := &bridge{common{name: "lxdbr0", config: map[string]string{}}}
+	assert.False(t, n.DHCPStrictMode())
+
+	n.config["security.dhcp.strict"] = "true"
+	assert.True(t, n.DHCPStrictMode())
+
+	assert.NoError(t, n.ValidateKey("security.dhcp.strict", "false"))
+	assert.Error(t, n.ValidateKey("security.dhcp.strict", "maybe"))
+}
+
+// Test NextFreeDHCPv4IP
+func TestNextFreeDHCPv4IP(t *testing.T) {
+	s, cleanup := state.NewTestState(t)
+	defer cleanup()
+
+	oldLXDDir := os.Getenv("LXD_DIR")
+	os.Setenv("LXD_DIR", s.OS.VarDir)
+	defer os.Setenv("LXD_DIR", oldLXDDir)
+
+	n := &common{}
+	n.init(s, 1, "testbr0", "bridge", "", map[string]string{
+		"ipv4.address":                    "10.0.0.1/24",
+		"ipv4.dhcp.ranges":                "10.0.0.20-10.0.0.22,10.0.0.10-10.0.0.12",
+		"ipv4.dhcp.reservation.10.0.0.10": "",
+	}, api.NetworkStatusCreated)
+
+	// Test the lowest free IP is returned when there are no leases.
+	ip, err := n.NextFreeDHCPv4IP()
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.11", ip.String())
+
+	// Test leased IPs are skipped, moving on to the next range.
+	leaseDir := filepath.Join(s.OS.VarDir, "networks", "testbr0")
+	require.NoError(t, os.MkdirAll(leaseDir, 0711))
+
+	expiry := time.Now().Add(time.Hour).Unix()
+	content := fmt.Sprintf("%d 00:16:3e:aa:bb:cc 10.0.0.11 c1 *\n%d 00:16:3e:aa:bb:dd 10.0.0.12 c2 *\n", expiry, expiry)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(leaseDir, "dnsmasq.leases"), []byte(content), 0644))
+
+	ip, err = n.NextFreeDHCPv4IP()
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.20", ip.String())
+
+	// Test an exhausted pool.
+	n.config["ipv4.dhcp.ranges"] = "10.0.0.10-10.0.0.12"
+	_, err = n.NextFreeDHCPv4IP()
+	assert.Equal(t, ErrDHCPv4PoolExhausted, err)
+}
+
+// Test DHCPv4 reservations
+func TestDHCPv4Reservations(t *testing.T) {
+	n := &common{name: "testbr0", config: map[string]string{
+		"ipv4.address":     "10.0.0.1/24",
+		"ipv4.dhcp.ranges": "10.0.0.100-10.0.0.200",
+	}}
+
+	// Test reserving an IP within the range.
+	newNetwork, err := n.reserveDHCPv4IP(net.ParseIP("10.0.0.150"), "printer")
+	assert.NoError(t, err)
+	assert.Equal(t, "printer", newNetwork.Config["ipv4.dhcp.reservation.10.0.0.150"])
+	assert.Empty(t, n.DHCPv4Reservations()) // Current config isn't modified.
+
+	// Test reserving an IP outside of the range.
+	_, err = n.reserveDHCPv4IP(net.ParseIP("10.0.0.50"), "")
+	assert.Error(t, err)
+
+	// Test reserving an IPv6 address.
+	_, err = n.reserveDHCPv4IP(net.ParseIP("fd42::1"), "")
+	assert.Error(t, err)
+
+	// Test reservations are listed in order and can't be reserved twice.
+	n.config["ipv4.dhcp.reservation.10.0.0.150"] = "printer"
+	n.config["ipv4.dhcp.reservation.10.0.0.120"] = "switch"
+	reservations := n.DHCPv4Reservations()
+	assert.Len(t, reservations, 2)
+	assert.Equal(t, "10.0.0.120", reservations[0].IP.String())
+	assert.Equal(t, "switch", reservations[0].Comment)
+
+	_, err = n.reserveDHCPv4IP(net.ParseIP("10.0.0.150"), "")
+	assert.Error(t, err)
+
+	// Test releasing.
+	newNetwork, err = n.releaseDHCPv4IP(net.ParseIP("10.0.0.150"))
+	assert.NoError(t, err)
+	_, found := newNetwork.Config["ipv4.dhcp.reservation.10.0.0.150"]
+	assert.False(t, found)
+
+	_, err = n.releaseDHCPv4IP(net.ParseIP("10.0.0.151"))
+	assert.Error(t, err)
+}
+
+// Test that the config, description and status can be safely read while the network is being updated (run with
+// -race).
+func TestConfigConcurrentUpdate(t *testing.T) {
+	n := &common{description: "desc", config: map[string]string{"ipv4.address": "10.0.0.1/24"}, status: api.NetworkStatusCreated}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			// Identical config so that no database write or notification is attempted.
+			err := n.update(api.NetworkPut{Description: "desc", Config: map[string]string{"ipv4.address": "10.0.0.1/24"}}, "", false, nil)
+			assert.NoError(t, err)
+		}
+	}()
+
+	for i := 0; i < 1000; i++ {
+		config := n.Config()
+		for k := range config {
+			config[k] = "modified"
+		}
+
+		assert.True(t, n.HasDHCPv4())
+		assert.Equal(t, "desc", n.copyNetwork().Description)
+		assert.Equal(t, api.NetworkStatusCreated, n.Status())
+	}
+
+	<-done
+	assert.Equal(t, "10.0.0.1/24", n.Config()["ipv4.address"])
+}
+
+// Test disruptiveChangedKeys
+func TestDisruptiveChangedKeys(t *testing.T) {
+	n := &common{netType: "bridge"}
+	assert.Equal(t, []string{"ipv4.address", "bridge.mtu"}, n.disruptiveChangedKeys([]string{"ipv4.address", "dns.domain", "bridge.mtu"}))
+	assert.Empty(t, n.disruptiveChangedKeys([]string{"dns.domain"}))
+
+	n = &common{netType: "macvlan"}
+	assert.Equal(t, []string{"parent"}, n.disruptiveChangedKeys([]string{"parent", "mtu"}))
+}
+
+// Test DisruptiveChangeWarnings
+func TestDisruptiveChangeWarnings(t *testing.T) {
+	_, n, cleanup := newTestMacvlan(t, "", map[string]string{"parent": "eth0"})
+	defer cleanup()
+
+	n.status = api.NetworkStatusCreated
+
+	// Non-disruptive keys don't need the instances to be checked.
+	assert.Empty(t, n.DisruptiveChangeWarnings([]string{"mtu"}))
+
+	// Disruptive keys are only reported when running instances use the network.
+	assert.Empty(t, n.DisruptiveChangeWarnings([]string{"parent"}))
+}
+
+// Test ChangeRequiresRestart
+func TestChangeRequiresRestart(t *testing.T) {
+	n := &common{netType: "bridge"}
+	assert.False(t, n.ChangeRequiresRestart(nil))
+	assert.False(t, n.ChangeRequiresRestart([]string{"ipv4.dhcp.ranges", "dns.domain", "raw.dnsmasq", "security.frozen"}))
+	assert.True(t, n.ChangeRequiresRestart([]string{"ipv4.dhcp.ranges", "ipv4.address"}))
+	assert.True(t, n.ChangeRequiresRestart([]string{"raw.dnsmasq.extra"}))
+	assert.False(t, n.ChangeRequiresRestart([]string{"user.foo"}))
+
+	n = &common{netType: "macvlan"}
+	assert.False(t, n.ChangeRequiresRestart([]string{"maas.subnet.ipv4"}))
+	assert.True(t, n.ChangeRequiresRestart([]string{"parent"}))
+}
+
+// Test IsCompatibleChange
+func TestIsCompatibleChange(t *testing.T) {
+	n := &common{netType: "bridge", config: map[string]string{
+		"ipv4.address": "10.0.0.1/24",
+		"ipv4.routes":  "192.0.2.0/24",
+		"dns.domain":   "lxd",
+	}}
+
+	newConfig := func(changes map[string]string) api.NetworkPut {
+		config := map[string]string{}
+		for k, v := range n.config {
+			config[k] = v
+		}
+
+		for k, v := range changes {
+			if v == "" {
+				delete(config, k)
+			} else {
+				config[k] = v
+			}
+		}
+
+		return api.NetworkPut{Config: config}
+	}
+
+	compatible, keys := n.IsCompatibleChange(newConfig(nil))
+	assert.True(t, compatible)
+	assert.Empty(t, keys)
+
+	compatible, keys = n.IsCompatibleChange(newConfig(map[string]string{"dns.domain": "example", "ipv4.routes": "192.0.2.0/25"}))
+	assert.True(t, compatible)
+	assert.Empty(t, keys)
+
+	compatible, keys = n.IsCompatibleChange(newConfig(map[string]string{"ipv4.routes": "", "bridge.mtu": "1400"}))
+	assert.False(t, compatible)
+	assert.Equal(t, []string{"bridge.mtu", "ipv4.routes"}, keys)
+
+	compatible, keys = n.IsCompatibleChange(newConfig(map[string]string{"ipv4.address": "none"}))
+	assert.False(t, compatible)
+	assert.Equal(t, []string{"ipv4.address"}, keys)
+
+	n = &common{netType: "macvlan", config: map[string]string{"parent": "eth0"}}
+	compatible, keys = n.IsCompatibleChange(api.NetworkPut{Config: map[string]string{"parent": "eth1"}})
+	assert.False(t, compatible)
+	assert.Equal(t, []string{"parent"}, keys)
+}
+
+// Test DHCPv4Gateway and DHCPv4Subnet
+func TestDHCPv4GatewaySubnet(t *testing.T) {
+	n := &common{config: map[string]string{"ipv4.address": "10.0.0.1/24"}}
+
+	gateway, err := n.DHCPv4Gateway()
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", gateway.String())
+
+	subnet, err := n.DHCPv4Subnet()
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.0/24", subnet.String())
+
+	for _, value := range []string{"", "none"} {
+		n.config["ipv4.address"] = value
+		_, err = n.DHCPv4Gateway()
+		assert.Equal(t, ErrNoIPv4Address, err)
+		_, err = n.DHCPv4Subnet()
+		assert.Equal(t, ErrNoIPv4Address, err)
+	}
+
+	n.config["ipv4.address"] = "10.0.0.1"
+	_, err = n.DHCPv4Subnet()
+	assert.Error(t, err)
+	assert.NotEqual(t, ErrNoIPv4Address, err)
+}
@@ -5,8 +5,8 @@ import (
 
 	"github.com/pkg/errors"
 
+	"github.com/lxc/lxd/lxd/network/validate"
 	"github.com/lxc/lxd/lxd/revert"
-	"github.com/lxc/lxd/shared"
 	"github.com/lxc/lxd/shared/api"
 	log "github.com/lxc/lxd/shared/log15"
 )
@@ -16,50 +16,87 @@ type macvlan struct {
 	common
 }
 
-// Validate network config.
-func (n *macvlan) Validate(config map[string]string) error {
-	rules := map[string]func(value string) error{
-		"parent": func(value string) error {
-			if err := ValidNetworkName(value); err != nil {
-				return errors.Wrapf(err, "Invalid interface name %q", value)
-			}
-
-			return nil
+// rules returns the driver specific validation rules.
+func (n *macvlan) rules() map[string]configKeyRule {
+	return map[string]configKeyRule{
+		"parent": {
+			keyType:     "string",
+			description: "Parent interface to create macvlan NICs on",
+			validator: func(value string) error {
+				if err := ValidNetworkName(value); err != nil {
+					return errors.Wrapf(err, "Invalid interface name %q", value)
+				}
+
+				return nil
+			},
 		},
-		"maas.subnet.ipv4": shared.IsAny,
-		"maas.subnet.ipv6": shared.IsAny,
+		"maas.subnet.ipv4": {validator: validate.IsAny, keyType: "string", description: "MAAS IPv4 subnet to register instances in (when using `network` property on nic)"},
+		"maas.subnet.ipv6": {validator: validate.IsAny, keyType: "string", description: "MAAS IPv6 subnet to register instances in (when using `network` property on nic)"},
 	}
+}
 
+// Validate network config.
+func (n *macvlan) Validate(config map[string]string) error {
+	rules := n.rules()
 	err := n.validate(config, rules)
 	if err != nil {
 		return err
 	}
 
+	err = n.validateExclusiveKeys(config, rules, nil)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// ConfigSchema returns the schema of the macvlan config keys.
+func (n *macvlan) ConfigSchema() map[string]ConfigKeySchema {
+	return n.common.configSchema(n.rules())
+}
+
+// ValidateWithWarnings validates the network config. There are no warning checks for macvlan networks.
+func (n *macvlan) ValidateWithWarnings(config map[string]string) ([]Warning, error) {
+	return n.common.validateWithWarnings(config, n.Validate, nil)
+}
+
+// ValidateKey validates a single config key and value. Composite checks are performed when the full config is
+// validated.
+func (n *macvlan) ValidateKey(key string, value string) error {
+	return n.validateKey(key, value, n.rules())
+}
+
 // Delete deletes a network.
 func (n *macvlan) Delete(clusterNotification bool) error {
 	n.logger.Debug("Delete", log.Ctx{"clusterNotification": clusterNotification})
-	return n.common.delete(clusterNotification)
-}
 
-// Rename renames a network.
-func (n *macvlan) Rename(newName string) error {
-	n.logger.Debug("Rename", log.Ctx{"newName": newName})
+	err := n.validateNotFrozen(clusterNotification)
+	if err != nil {
+		return err
+	}
 
-	// Sanity checks.
-	inUse, err := n.IsUsed()
+	err = n.validateNotProtected(clusterNotification)
 	if err != nil {
 		return err
 	}
 
-	if inUse {
-		return fmt.Errorf("The network is currently in use")
+	return n.common.delete(clusterNotification, n.teardown)
+}
+
+// Rename renames a network. Accepts notification boolean indicating if this rename request is coming from a
+// cluster notification, in which case do not notify other nodes or update the database, just rename locally.
+func (n *macvlan) Rename(newName string, clusterNotification bool) error {
+	n.logger.Debug("Rename", log.Ctx{"newName": newName, "clusterNotification": clusterNotification})
+
+	// Sanity checks.
+	err := n.validateNotFrozen(clusterNotification)
+	if err != nil {
+		return err
 	}
 
 	// Rename common steps.
-	err = n.common.rename(newName)
+	err = n.common.rename(newName, clusterNotification, n.Stop)
 	if err != nil {
 		return err
 	}
@@ -69,7 +106,7 @@ func (n *macvlan) Rename(newName string) error {
 
 // Start starts is a no-op.
 func (n *macvlan) Start() error {
-	if n.status == api.NetworkStatusPending {
+	if n.IsPending() {
 		return fmt.Errorf("Cannot start pending network")
 	}
 
@@ -81,12 +118,25 @@ func (n *macvlan) Stop() error {
 	return nil
 }
 
+// Health returns the overall health of the network, checking that the parent interface exists and is up.
+func (n *macvlan) Health() (HealthStatus, []string) {
+	return n.common.health(n.healthInterface(n.currentConfig()["parent"]))
+}
+
 // Update updates the network. Accepts notification boolean indicating if this update request is coming from a
 // cluster notification, in which case do not update the database, just apply local changes needed.
 func (n *macvlan) Update(newNetwork api.NetworkPut, targetNode string, clusterNotification bool) error {
+	return n.update(newNetwork, targetNode, clusterNotification, nil)
+}
+
+// update applies the new network like Update. If expectedConfig isn't nil then the network is only updated if
+// its config in the database still matches expectedConfig, otherwise ErrConfigConflict is returned.
+func (n *macvlan) update(newNetwork api.NetworkPut, targetNode string, clusterNotification bool, expectedConfig map[string]string) error {
 	n.logger.Debug("Update", log.Ctx{"clusterNotification": clusterNotification, "newNetwork": newNetwork})
 
-	dbUpdateNeeeded, _, oldNetwork, err := n.common.configChanged(newNetwork)
+	newNetwork = n.preserveVolatileKeys(newNetwork)
+
+	dbUpdateNeeeded, changedKeys, _, oldNetwork, err := n.common.configChanged(newNetwork)
 	if err != nil {
 		return err
 	}
@@ -95,21 +145,69 @@ func (n *macvlan) Update(newNetwork api.NetworkPut, targetNode string, clusterNo
 		return nil // Nothing changed.
 	}
 
+	err = n.validateUpdateNotFrozen(newNetwork, clusterNotification)
+	if err != nil {
+		return err
+	}
+
 	revert := revert.New()
 	defer revert.Fail()
 
+	// Only restore the database if it still has the config stored by a conditional update.
+	var revertConfig map[string]string
+	if expectedConfig != nil {
+		revertConfig = newNetwork.Config
+	}
+
 	// Define a function which reverts everything.
 	revert.Add(func() {
 		// Reset changes to all nodes and database.
-		n.common.update(oldNetwork, targetNode, clusterNotification)
+		err := n.common.update(oldNetwork, targetNode, clusterNotification, revertConfig)
+		if err != nil {
+			n.logger.Error("Failed reverting network config", log.Ctx{"err": err})
+		}
 	})
 
 	// Apply changes to database.
-	err = n.common.update(newNetwork, targetNode, clusterNotification)
+	err = n.common.update(newNetwork, targetNode, clusterNotification, expectedConfig)
 	if err != nil {
 		return err
 	}
 
 	revert.Success()
+	n.common.updated(changedKeys, clusterNotification)
+
 	return nil
 }
+
+// Patch merges the supplied config keys into the network's config (a key with an empty value is removed) and
+// applies the result using Update.
+func (n *macvlan) Patch(applyNetwork api.NetworkPut, targetNode string, clusterNotification bool) error {
+	return n.common.patch(applyNetwork, func(newNetwork api.NetworkPut) error {
+		return n.Update(newNetwork, targetNode, clusterNotification)
+	})
+}
+
+// SetKeys merges the supplied config keys into the network's config, validates the result and applies it.
+func (n *macvlan) SetKeys(changes map[string]string, targetNode string) error {
+	return n.common.setKeys(changes, targetNode, func(newNetwork api.NetworkPut, expectedConfig map[string]string) error {
+		return n.update(newNetwork, targetNode, false, expectedConfig)
+	})
+}
+
+// CompareAndUpdate applies the new network config only if the network's current config matches the expected one.
+func (n *macvlan) CompareAndUpdate(expected api.NetworkPut, newNetwork api.NetworkPut, ignoreUserKeys bool) error {
+	return n.common.compareAndUpdate(expected, newNetwork, ignoreUserKeys, func(newNetwork api.NetworkPut, expectedConfig map[string]string) error {
+		return n.update(newNetwork, "", false, expectedConfig)
+	})
+}
+
+// RestoreSnapshot rolls the network back to the config stored in the named snapshot and applies it.
+func (n *macvlan) RestoreSnapshot(name string) error {
+	newNetwork, err := n.common.restoreSnapshot(name)
+	if err != nil {
+		return err
+	}
+
+	return n.Update(newNetwork, "", false)
+}
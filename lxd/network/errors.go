@@ -6,3 +6,18 @@ import (
 
 // ErrUnknownDriver is the "Unknown driver" error
 var ErrUnknownDriver = fmt.Errorf("Unknown driver")
+
+// ErrNotImplemented is the "Not implemented" error
+var ErrNotImplemented = fmt.Errorf("Not implemented")
+
+// ErrFrozen is the "Network is frozen" error
+var ErrFrozen = fmt.Errorf("Network is frozen")
+
+// ErrNoIPv4Address is the "Network has no IPv4 address" error
+var ErrNoIPv4Address = fmt.Errorf("Network has no IPv4 address")
+
+// ErrDHCPv4PoolExhausted is the "No free IPs in the DHCPv4 pool" error
+var ErrDHCPv4PoolExhausted = fmt.Errorf("No free IPs in the DHCPv4 pool")
+
+// ErrConfigConflict is the "Network config doesn't match the expected config" error
+var ErrConfigConflict = fmt.Errorf("Network config doesn't match the expected config")
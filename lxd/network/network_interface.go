@@ -1,7 +1,12 @@
 package network
 
 import (
+	"context"
+	"net"
+	"time"
+
 	"github.com/lxc/lxd/lxd/cluster"
+	firewallDrivers "github.com/lxc/lxd/lxd/firewall/drivers"
 	"github.com/lxc/lxd/lxd/state"
 	"github.com/lxc/lxd/shared/api"
 )
@@ -14,21 +19,102 @@ type Network interface {
 
 	// Config.
 	Validate(config map[string]string) error
+	ValidateWithWarnings(config map[string]string) ([]Warning, error)
+	ValidateKey(key string, value string) error
+	ConfigSchema() map[string]ConfigKeySchema
+	ValidateDelete() error
+	ValidateUpdate(newNetwork api.NetworkPut, targetNode string) error
 	Name() string
 	Type() string
 	Status() string
+	IsPending() bool
+	Capabilities() NetworkCapabilities
+	CanPeerWith(other Network) (bool, string)
+	Interface() string
+	State() (*api.NetworkState, error)
+	Health() (HealthStatus, []string)
+	WaitReady(ctx context.Context) error
+	Statistics() (*api.NetworkStatistics, error)
+	Metrics() ([]Metric, error)
 	Config() map[string]string
+	EffectiveConfig() map[string]string
+	ConfigDiff(newNetwork api.NetworkPut) []ConfigChange
+	Snapshots() ([]string, error)
+	ChangeRequiresRestart(changedKeys []string) bool
+	DisruptiveChangeWarnings(changedKeys []string) []Warning
+	IsCompatibleChange(newNetwork api.NetworkPut) (bool, []string)
 	IsUsed() (bool, error)
+	CheckConsistency() ([]Inconsistency, error)
+	UsedBy() ([]string, error)
+	IPv4Enabled() bool
+	IPv6Enabled() bool
 	HasDHCPv4() bool
 	HasDHCPv6() bool
+	DHCPStrictMode() bool
+	SecurityFiltering() (mac bool, ipv4 bool, ipv6 bool)
+	DHCPv4Gateway() (net.IP, error)
+	DHCPv4Subnet() (*net.IPNet, error)
 	DHCPv4Ranges() []DHCPRange
+	DHCPv4RangesValidated() ([]DHCPRange, error)
+	EffectiveDHCPv4Ranges() []DHCPRange
+	DHCPv4EffectiveRanges() ([]DHCPRange, error)
+	DHCPv4PoolSize() (int64, error)
+	DHCPv6PoolSize() (int64, error)
 	DHCPv6Ranges() []DHCPRange
+	DHCPv4ExpiryTime() (time.Duration, error)
+	DHCPv6ExpiryTime() (time.Duration, error)
+	DHCPv6PDRanges() ([]DHCPRange, error)
+	StaticRoutes() ([]StaticRoute, error)
+	IPv6RAConfig() RAConfig
+	DHCPv6PDPrefixLength() (int, error)
+	DHCPv4StaticLeases() ([]StaticLease, error)
+	DHCPv4Reservations() []DHCPReservation
+	FindDuplicateAddresses() ([]AddressConflict, error)
+	AllocatedAddresses() ([]AllocatedAddress, error)
+	ValidateInstanceNIC(nic map[string]string) error
+	DHCPv4Options() ([]DHCPOption, error)
+	VLAN() (uint16, error)
+	VLANTagged() ([]uint16, error)
+	DHCPLeases() ([]api.NetworkLease, error)
+	DHCPv4Leases() ([]api.NetworkLease, error)
+	FlushDHCPLeases() error
+	RenderDHCPConfig() (string, error)
+	NextFreeDHCPv4IP() (net.IP, error)
+	InstanceIPs(instanceName string, projectName string) ([]net.IP, error)
+	MTU() (uint32, error)
+	BridgeHWAddr(generate bool) (net.HardwareAddr, error)
+	Limits() (string, string, int, error)
+	HasDNS() bool
+	DNSDomain() string
+	DNSMode() string
+	DNSSearchDomains() []string
+	DNSUpstreams() ([]net.IP, error)
+	DNSRecords() ([]api.NetworkDNSRecord, error)
+	FirewallEnabled(family string) bool
+	FirewallRules(family string) ([]firewallDrivers.NetworkRule, error)
 
 	// Actions.
 	Start() error
 	Stop() error
-	Rename(name string) error
+	Rename(name string, clusterNotification bool) error
+	Clone(newName string, overrides map[string]string) (Network, error)
+	Export() (*api.NetworkBackup, error)
+	ProbeTunnelRemotes(timeout time.Duration) ([]TunnelProbeResult, error)
+	ReserveDHCPv4IP(ip net.IP, comment string) error
+	ReleaseDHCPv4IP(ip net.IP) error
+	SetMaintenance(on bool) error
+	Renumber(newCIDR string, shiftRanges bool) error
+	CreateSnapshot(name string) error
+	RestoreSnapshot(name string) error
+	DeleteSnapshot(name string) error
+	AddDNSRecord(record api.NetworkDNSRecord) error
+	DeleteDNSRecord(name string) error
 	Update(newNetwork api.NetworkPut, targetNode string, clusterNotification bool) error
+	Patch(applyNetwork api.NetworkPut, targetNode string, clusterNotification bool) error
+	Repair(dryRun bool) error
+	SetKeys(changes map[string]string, targetNode string) error
+	SetDescription(desc string, clusterNotification bool) error
+	CompareAndUpdate(expected api.NetworkPut, newNetwork api.NetworkPut, ignoreUserKeys bool) error
 	HandleHeartbeat(heartbeatData *cluster.APIHeartbeat) error
 	Delete(clusterNotification bool) error
 }
@@ -1,16 +1,110 @@
 package network
 
 import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/lxc/lxd/lxd/cluster"
+	"github.com/lxc/lxd/lxd/db"
+	"github.com/lxc/lxd/lxd/instance"
+	"github.com/lxc/lxd/lxd/project"
+	"github.com/lxc/lxd/lxd/revert"
 	"github.com/lxc/lxd/lxd/state"
+	"github.com/lxc/lxd/shared"
 	"github.com/lxc/lxd/shared/api"
+	"github.com/lxc/lxd/shared/version"
 )
 
+// UpdateInstanceNICFilters is linked from device.nicBridgedUpdateFilters to re-apply the anti-spoofing filters of a
+// running instance's bridged NIC after the filtering settings of its network have changed from oldNetConfig.
+var UpdateInstanceNICFilters func(s *state.State, inst instance.Instance, devName string, oldNetConfig map[string]string) error
+
 var drivers = map[string]func() Network{
 	"bridge":  func() Network { return &bridge{} },
 	"macvlan": func() Network { return &macvlan{} },
 	"sriov":   func() Network { return &sriov{} },
 }
 
+// preset is a named base config for a network type.
+type preset struct {
+	netType string
+	config  map[string]string
+}
+
+var presets = map[string]preset{
+	"isolated": {
+		netType: "bridge",
+		config: map[string]string{
+			"ipv4.address": "auto",
+			"ipv4.nat":     "false",
+			"ipv6.address": "auto",
+			"ipv6.nat":     "false",
+		},
+	},
+	"nat-bridge": {
+		netType: "bridge",
+		config: map[string]string{
+			"ipv4.address": "auto",
+			"ipv4.nat":     "true",
+			"ipv6.address": "auto",
+			"ipv6.nat":     "true",
+		},
+	},
+	"ipv6-only": {
+		netType: "bridge",
+		config: map[string]string{
+			"ipv4.address": "none",
+			"ipv6.address": "auto",
+			"ipv6.nat":     "true",
+		},
+	},
+}
+
+// PresetNames returns the names of the available network presets in alphabetical order.
+func PresetNames() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// ApplyPreset returns the config of the named preset with the supplied base config applied on top of it, so that
+// keys set in base override the preset's defaults. The result is validated for the preset's network type.
+func ApplyPreset(name string, base map[string]string) (map[string]string, error) {
+	p, ok := presets[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown network preset %q", name)
+	}
+
+	config := make(map[string]string, len(p.config)+len(base))
+	for k, v := range p.config {
+		config[k] = v
+	}
+
+	for k, v := range base {
+		config[k] = v
+	}
+
+	n := drivers[p.netType]()
+	n.init(nil, 0, "", p.netType, "", config, "Unknown")
+
+	err := n.Validate(config)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid config for network preset %q", name)
+	}
+
+	return config, nil
+}
+
 // LoadByName loads the network info from the database by name.
 func LoadByName(s *state.State, name string) (Network, error) {
 	id, netInfo, err := s.Cluster.GetNetworkInAnyState(name)
@@ -29,6 +123,289 @@ func LoadByName(s *state.State, name string) (Network, error) {
 	return n, nil
 }
 
+// LoadByID loads and initialises a network from the database by its ID. Returns db.ErrNoSuchObject if no network
+// has the given ID.
+func LoadByID(s *state.State, id int64) (Network, error) {
+	name, netInfo, err := s.Cluster.GetNetworkInAnyStateByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	driverFunc, ok := drivers[netInfo.Type]
+	if !ok {
+		return nil, ErrUnknownDriver
+	}
+
+	n := driverFunc()
+	n.init(s, id, name, netInfo.Type, netInfo.Description, netInfo.Config, netInfo.Status)
+
+	return n, nil
+}
+
+// LoadByType loads all of the networks that use the specified driver, ordered by name. Only the networks of the
+// requested type are instantiated.
+func LoadByType(s *state.State, netType string) ([]Network, error) {
+	driverFunc, ok := drivers[netType]
+	if !ok {
+		return nil, ErrUnknownDriver
+	}
+
+	names, err := s.Cluster.GetNetworks()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(names)
+
+	networks := []Network{}
+	for _, name := range names {
+		id, netInfo, err := s.Cluster.GetNetworkInAnyState(name)
+		if err != nil {
+			return nil, err
+		}
+
+		if netInfo.Type != netType {
+			continue
+		}
+
+		n := driverFunc()
+		n.init(s, id, name, netInfo.Type, netInfo.Description, netInfo.Config, netInfo.Status)
+		networks = append(networks, n)
+	}
+
+	return networks, nil
+}
+
+// labelConfigKey returns the config key that holds the given network label.
+func labelConfigKey(key string) string {
+	return fmt.Sprintf("user.%s", strings.TrimPrefix(key, "user."))
+}
+
+// LoadByLabel loads all of the networks whose label key (stored as the "user.<key>" config key) is set to value,
+// ordered by name.
+func LoadByLabel(s *state.State, key string, value string) ([]Network, error) {
+	configKey := labelConfigKey(key)
+
+	names, err := s.Cluster.GetNetworks()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(names)
+
+	networks := []Network{}
+	for _, name := range names {
+		id, netInfo, err := s.Cluster.GetNetworkInAnyState(name)
+		if err != nil {
+			return nil, err
+		}
+
+		if netInfo.Config[configKey] != value {
+			continue
+		}
+
+		driverFunc, ok := drivers[netInfo.Type]
+		if !ok {
+			return nil, ErrUnknownDriver
+		}
+
+		n := driverFunc()
+		n.init(s, id, name, netInfo.Type, netInfo.Description, netInfo.Config, netInfo.Status)
+		networks = append(networks, n)
+	}
+
+	return networks, nil
+}
+
+// LabelValues returns the sorted list of distinct values used for the label key across all networks. Networks
+// without the label are ignored.
+func LabelValues(s *state.State, key string) ([]string, error) {
+	configKey := labelConfigKey(key)
+
+	names, err := s.Cluster.GetNetworks()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]struct{}{}
+	values := []string{}
+	for _, name := range names {
+		_, netInfo, err := s.Cluster.GetNetworkInAnyState(name)
+		if err != nil {
+			return nil, err
+		}
+
+		value, ok := netInfo.Config[configKey]
+		if !ok {
+			continue
+		}
+
+		_, found := seen[value]
+		if found {
+			continue
+		}
+
+		seen[value] = struct{}{}
+		values = append(values, value)
+	}
+
+	sort.Strings(values)
+
+	return values, nil
+}
+
+// FindNetworkForIP returns the managed network whose IPv4 or IPv6 subnet contains the supplied IP.
+// Networks without a configured address are skipped. Returns db.ErrNoSuchObject if no network contains the IP.
+func FindNetworkForIP(s *state.State, ip net.IP) (Network, error) {
+	networks, err := s.Cluster.GetNetworks()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range networks {
+		n, err := LoadByName(s, name)
+		if err != nil {
+			return nil, err
+		}
+
+		// Check both address families so dual-stack networks are matched on either subnet.
+		for _, key := range []string{"ipv4.address", "ipv6.address"} {
+			_, subnet, err := net.ParseCIDR(n.Config()[key])
+			if err != nil {
+				continue // No concrete address configured for this family.
+			}
+
+			if subnet.Contains(ip) {
+				return n, nil
+			}
+		}
+	}
+
+	return nil, db.ErrNoSuchObject
+}
+
+// SwapNetworkNames swaps the names of the two named networks, so that instances and profiles referencing one
+// network use the other one (e.g. for a blue/green cutover). Both networks are stopped, their database records and
+// local directories are swapped and they are then started under their new names. On failure both networks keep
+// their original names. The networks must be of the same type, must not be pending or frozen and must not be used
+// by running instances. Swapping is not supported when clustered.
+func SwapNetworkNames(s *state.State, a string, b string) error {
+	if a == b {
+		return fmt.Errorf("Can't swap network %q with itself", a)
+	}
+
+	clustered, err := cluster.Enabled(s.Node)
+	if err != nil {
+		return err
+	}
+
+	// FIXME: swapping network names is currently not supported in clustering, as the other members would also
+	// need to stop their networks, swap their local directories and start them again under the new names, and
+	// there is no way to revert members that have already swapped if a later member fails.
+	if clustered {
+		return fmt.Errorf("Swapping network names is not supported in LXD clusters")
+	}
+
+	networks := make([]Network, 0, 2)
+	for _, name := range []string{a, b} {
+		n, err := LoadByName(s, name)
+		if err != nil {
+			return errors.Wrapf(err, "Failed loading network %q", name)
+		}
+
+		if n.IsPending() {
+			return fmt.Errorf("Network %q is pending", name)
+		}
+
+		if shared.IsTrue(n.Config()["security.frozen"]) {
+			return fmt.Errorf("Network %q is frozen", name)
+		}
+
+		networks = append(networks, n)
+	}
+
+	if networks[0].Type() != networks[1].Type() {
+		return fmt.Errorf("Can't swap network %q of type %q with network %q of type %q", a, networks[0].Type(), b, networks[1].Type())
+	}
+
+	// Running instances are attached to the host interfaces of the networks, which are named after them.
+	insts, err := instance.LoadFromAllProjects(s)
+	if err != nil {
+		return err
+	}
+
+	for _, inst := range insts {
+		if !inst.IsRunning() {
+			continue
+		}
+
+		for _, name := range []string{a, b} {
+			inUse, err := IsInUseByInstance(s, inst, name)
+			if err != nil {
+				return err
+			}
+
+			if inUse {
+				return fmt.Errorf("Network %q is in use by running instance %q", name, project.Instance(inst.Project(), inst.Name()))
+			}
+		}
+	}
+
+	revert := revert.New()
+	defer revert.Fail()
+
+	for _, n := range networks {
+		err = n.Stop()
+		if err != nil {
+			return errors.Wrapf(err, "Failed stopping network %q", n.Name())
+		}
+
+		n := n
+		revert.Add(func() { n.Start() })
+	}
+
+	pathA := shared.VarPath("networks", a)
+	pathB := shared.VarPath("networks", b)
+	err = swapPaths(pathA, pathB)
+	if err != nil {
+		return errors.Wrap(err, "Failed swapping network directories")
+	}
+
+	revert.Add(func() { swapPaths(pathA, pathB) })
+
+	err = s.Cluster.SwapNetworkNames(a, b)
+	if err != nil {
+		return errors.Wrap(err, "Failed swapping network names in the database")
+	}
+
+	// The cached usage of each network now belongs to the other.
+	invalidateUsageCache()
+
+	revert.Add(func() { s.Cluster.SwapNetworkNames(a, b) })
+
+	for _, name := range []string{a, b} {
+		n, err := LoadByName(s, name)
+		if err != nil {
+			return err
+		}
+
+		err = n.Start()
+		if err != nil {
+			return errors.Wrapf(err, "Failed starting network %q", name)
+		}
+
+		revert.Add(func() { n.Stop() })
+	}
+
+	for oldName, newName := range map[string]string{a: b, b: a} {
+		ctx := map[string]interface{}{"name": newName, "old_name": oldName, "project": project.Default}
+		s.Events.SendLifecycle(project.Default, "network-renamed", fmt.Sprintf("/%s/networks/%s", version.APIVersion, newName), ctx)
+	}
+
+	revert.Success()
+	return nil
+}
+
 // Validate validates the supplied network configuration for the specified network type.
 func Validate(name string, netType string, config map[string]string) error {
 	driverFunc, ok := drivers[netType]
@@ -46,17 +423,207 @@ func Validate(name string, netType string, config map[string]string) error {
 	return n.Validate(config)
 }
 
-// FillConfig populates the supplied api.NetworkPost with automatically populated values.
-func FillConfig(req *api.NetworksPost) error {
+// ConfigSchema returns the schema of the config keys supported by the specified network type.
+func ConfigSchema(netType string) (map[string]ConfigKeySchema, error) {
+	driverFunc, ok := drivers[netType]
+	if !ok {
+		return nil, ErrUnknownDriver
+	}
+
+	n := driverFunc()
+	n.init(nil, 0, "", netType, "", map[string]string{}, "Unknown")
+
+	return n.ConfigSchema(), nil
+}
+
+// ValidateNetworkSet validates a set of networks that are to be applied together. Each network is validated
+// individually and then checked against the rest of the set for duplicate names, overlapping subnets and VXLAN
+// tunnel ID collisions. If state is non-nil the subnets are also checked against existing managed networks with
+// a different name. Returns an error listing all problems found. No state is modified.
+func ValidateNetworkSet(s *state.State, networks []api.NetworksPost) error {
+	type networkSubnet struct {
+		name   string
+		subnet *net.IPNet
+	}
+
+	problems := []string{}
+	names := map[string]bool{}
+	subnets := []networkSubnet{}     // Subnets of the networks checked so far.
+	tunnelIDs := map[string]string{} // VXLAN tunnel ID and port of the networks checked so far, keyed to network name.
+
+	for _, network := range networks {
+		if names[network.Name] {
+			problems = append(problems, fmt.Sprintf("Network %q is defined more than once", network.Name))
+			continue
+		}
+
+		names[network.Name] = true
+
+		err := Validate(network.Name, network.Type, network.Config)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("Network %q: %v", network.Name, err))
+		}
+
+		for _, key := range []string{"ipv4.address", "ipv6.address"} {
+			_, subnet, err := net.ParseCIDR(network.Config[key])
+			if err != nil {
+				continue // No concrete address configured for this family.
+			}
+
+			for _, other := range subnets {
+				if subnetsOverlap(subnet, other.subnet) {
+					problems = append(problems, fmt.Sprintf("Network %q subnet %q overlaps with network %q subnet %q", network.Name, subnet.String(), other.name, other.subnet.String()))
+				}
+			}
+
+			if s != nil {
+				err = checkSubnetOverlap(s, network.Config[key], network.Name)
+				if err != nil {
+					problems = append(problems, fmt.Sprintf("Network %q: %v", network.Name, err))
+				}
+			}
+
+			subnets = append(subnets, networkSubnet{name: network.Name, subnet: subnet})
+		}
+
+		networkTunnelIDs := map[string]bool{}
+		for _, tunnel := range tunnelNames(network.Config) {
+			prefix := fmt.Sprintf("tunnel.%s.", tunnel)
+			if network.Config[prefix+"protocol"] != "vxlan" {
+				continue
+			}
+
+			// Match the defaults used when the tunnel is created.
+			id := network.Config[prefix+"id"]
+			if id == "" {
+				id = "1"
+			}
+
+			port := network.Config[prefix+"port"]
+			if port == "" {
+				port = "0"
+			}
+
+			tunnelID := fmt.Sprintf("%s/%s", id, port)
+			otherName, found := tunnelIDs[tunnelID]
+			if found {
+				problems = append(problems, fmt.Sprintf("Network %q tunnel %q ID %s on port %s collides with network %q", network.Name, tunnel, id, port, otherName))
+			}
+
+			networkTunnelIDs[tunnelID] = true
+		}
+
+		// Only record the tunnel IDs once the whole network has been checked so that tunnels within the same
+		// network are not compared against each other.
+		for tunnelID := range networkTunnelIDs {
+			_, found := tunnelIDs[tunnelID]
+			if !found {
+				tunnelIDs[tunnelID] = network.Name
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("Invalid network set: %s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// ProjectDefaultsPrefix is the prefix of the project config keys that set default config values for networks
+// created from within the project, e.g. "network.defaults.ipv4.dhcp".
+const ProjectDefaultsPrefix = "network.defaults."
+
+// projectDefaults returns the default network config values set in the config of the named project.
+func projectDefaults(s *state.State, projectName string) (map[string]string, error) {
+	var p *api.Project
+	err := s.Cluster.Transaction(func(tx *db.ClusterTx) error {
+		var err error
+		p, err = tx.GetProject(projectName)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed loading project %q", projectName)
+	}
+
+	defaults := map[string]string{}
+	for k, v := range p.Config {
+		if strings.HasPrefix(k, ProjectDefaultsPrefix) {
+			defaults[strings.TrimPrefix(k, ProjectDefaultsPrefix)] = v
+		}
+	}
+
+	return defaults, nil
+}
+
+// ValidateProjectDefault validates a default network config value set in a project's config (with the
+// ProjectDefaultsPrefix removed from the key). The key must be supported by at least one network type and the
+// value must be valid for every network type that supports it.
+func ValidateProjectDefault(key string, value string) error {
+	netTypes := make([]string, 0, len(drivers))
+	for netType := range drivers {
+		netTypes = append(netTypes, netType)
+	}
+
+	sort.Strings(netTypes)
+
+	known := false
+	for _, netType := range netTypes {
+		n := drivers[netType]()
+		n.init(nil, 0, "", netType, "", map[string]string{}, "Unknown")
+
+		_, found := n.ConfigSchema()[key]
+		if !found {
+			continue
+		}
+
+		known = true
+
+		err := n.ValidateKey(key, value)
+		if err != nil {
+			return errors.Wrapf(err, "Invalid default for %q networks", netType)
+		}
+	}
+
+	if !known {
+		return fmt.Errorf("Unknown network config key %q", key)
+	}
+
+	return nil
+}
+
+// FillConfig populates the supplied api.NetworkPost with automatically populated values. Keys that aren't set in
+// the request are first filled from the network defaults in the config of the named project (if supported by the
+// network type), and then from the driver's own defaults. The state is used to avoid allocating subnets that are in
+// use by existing networks.
+func FillConfig(s *state.State, projectName string, req *api.NetworksPost) error {
 	driverFunc, ok := drivers[req.Type]
 	if !ok {
 		return ErrUnknownDriver
 	}
 
+	if req.Config == nil {
+		req.Config = map[string]string{}
+	}
+
 	n := driverFunc()
-	n.init(nil, 0, req.Name, req.Type, req.Description, req.Config, "Unknown")
+	n.init(s, 0, req.Name, req.Type, req.Description, req.Config, "Unknown")
+
+	defaults, err := projectDefaults(s, projectName)
+	if err != nil {
+		return err
+	}
+
+	schema := n.ConfigSchema()
+	for k, v := range defaults {
+		_, known := schema[k]
+		_, set := req.Config[k]
+		if known && !set {
+			req.Config[k] = v
+		}
+	}
 
-	err := n.fillConfig(req)
+	err = n.fillConfig(req)
 	if err != nil {
 		return err
 	}
@@ -69,3 +636,144 @@ func FillConfig(req *api.NetworksPost) error {
 
 	return nil
 }
+
+// ImportNetworkBackup recreates a network from a backup created by Export. If newName is non-empty it is used
+// instead of the name in the backup. The network is validated (including checking its subnets don't overlap
+// existing networks), created in the database and started. Importing is not supported when clustered.
+func ImportNetworkBackup(s *state.State, backup *api.NetworkBackup, newName string) (Network, error) {
+	req := api.NetworksPost{
+		Name: backup.Name,
+		Type: backup.Type,
+		NetworkPut: api.NetworkPut{
+			Description: backup.Description,
+			Config:      make(map[string]string, len(backup.Config)),
+		},
+	}
+
+	if newName != "" {
+		req.Name = newName
+	}
+
+	for k, v := range backup.Config {
+		req.Config[k] = v
+	}
+
+	return create(s, req, map[string]interface{}{"backup": backup.Name})
+}
+
+// Import brings an existing unmanaged host interface under LXD management as a new network. A bridge network must
+// have the same name as the bridge interface being imported, whereas other network types use the interface as their
+// parent. The interface's current addresses (and MTU for bridges) are read into the network config and the network
+// is marked as imported so that its interface isn't destroyed when the network is stopped or deleted. Refuses to
+// import an interface that is already referenced by another LXD network. Importing is not supported when clustered.
+func Import(s *state.State, name string, netType string, iface string) (Network, error) {
+	if netType == "bridge" {
+		if iface != name {
+			return nil, fmt.Errorf("Network name %q must match the name of the imported bridge interface %q", name, iface)
+		}
+
+		if !shared.PathExists(filepath.Join(sysClassNet, iface, "bridge")) {
+			return nil, fmt.Errorf("Interface %q is not a bridge", iface)
+		}
+	}
+
+	netIf, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Interface %q not found", iface)
+	}
+
+	addrs, err := netIf.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the interface isn't already used by another network.
+	names, err := s.Cluster.GetNetworks()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, otherName := range names {
+		other, err := LoadByName(s, otherName)
+		if err != nil {
+			return nil, err
+		}
+
+		if other.Interface() == iface || shared.StringInSlice(iface, interfacesReferenced(other.Config())) {
+			return nil, fmt.Errorf("Interface %q is already used by network %q", iface, otherName)
+		}
+	}
+
+	req := api.NetworksPost{
+		Name: name,
+		Type: netType,
+		NetworkPut: api.NetworkPut{
+			Config: importedConfig(netType, iface, netIf.MTU, addrs),
+		},
+	}
+
+	return create(s, req, map[string]interface{}{"imported": iface})
+}
+
+// create validates the supplied network, checks its subnets don't overlap existing networks, creates it in the
+// database and starts it. On success a network-created lifecycle event is emitted with the supplied context.
+// Networks can't be created this way when clustered as they must first be defined on each node.
+func create(s *state.State, req api.NetworksPost, ctx map[string]interface{}) (Network, error) {
+	clustered, err := cluster.Enabled(s.Node)
+	if err != nil {
+		return nil, err
+	}
+
+	if clustered {
+		return nil, fmt.Errorf("Creating a network this way is not supported in LXD clusters")
+	}
+
+	dbNetType, err := dbNetworkType(req.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	_, _, err = s.Cluster.GetNetworkInAnyState(req.Name)
+	if err == nil {
+		return nil, fmt.Errorf("Network %q already exists", req.Name)
+	} else if err != db.ErrNoSuchObject {
+		return nil, err
+	}
+
+	err = Validate(req.Name, req.Type, req.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ValidateSubnets(s, req.Name, req.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	revert := revert.New()
+	defer revert.Fail()
+
+	_, err = s.Cluster.CreateNetwork(req.Name, req.Description, dbNetType, req.Config)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error inserting %q into database", req.Name)
+	}
+
+	revert.Add(func() { s.Cluster.DeleteNetwork(req.Name) })
+
+	n, err := LoadByName(s, req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = n.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx["name"] = req.Name
+	ctx["project"] = project.Default
+	s.Events.SendLifecycle(project.Default, "network-created", fmt.Sprintf("/%s/networks/%s", version.APIVersion, req.Name), ctx)
+
+	revert.Success()
+	return n, nil
+}
@@ -0,0 +1,393 @@
+package network
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lxc/lxd/lxd/db"
+	"github.com/lxc/lxd/lxd/endpoints"
+	"github.com/lxc/lxd/lxd/events"
+	"github.com/lxc/lxd/lxd/state"
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/api"
+)
+
+// Test LoadByType
+func TestLoadByType(t *testing.T) {
+	s, cleanup := state.NewTestState(t)
+	defer cleanup()
+
+	for _, name := range []string{"lxdbr1", "lxdbr0"} {
+		_, err := s.Cluster.CreateNetwork(name, "", db.NetworkTypeBridge, map[string]string{})
+		require.NoError(t, err)
+	}
+
+	_, err := s.Cluster.CreateNetwork("testnet", "", db.NetworkTypeMacvlan, map[string]string{"parent": "eth0"})
+	require.NoError(t, err)
+
+	networks, err := LoadByType(s, "bridge")
+	require.NoError(t, err)
+	require.Len(t, networks, 2)
+	assert.Equal(t, "lxdbr0", networks[0].Name())
+	assert.Equal(t, "lxdbr1", networks[1].Name())
+
+	networks, err = LoadByType(s, "sriov")
+	assert.NoError(t, err)
+	assert.Empty(t, networks)
+
+	_, err = LoadByType(s, "invalid")
+	assert.Equal(t, ErrUnknownDriver, err)
+}
+
+// Test LoadByID
+func TestLoadByID(t *testing.T) {
+	s, cleanup := state.NewTestState(t)
+	defer cleanup()
+
+	id, err := s.Cluster.CreateNetwork("testnet", "Test network", db.NetworkTypeMacvlan, map[string]string{"parent": "eth0"})
+	require.NoError(t, err)
+
+	n, err := LoadByID(s, id)
+	require.NoError(t, err)
+	assert.Equal(t, "testnet", n.Name())
+	assert.Equal(t, "macvlan", n.Type())
+	assert.Equal(t, "eth0", n.Config()["parent"])
+
+	_, err = LoadByID(s, id+1)
+	assert.Equal(t, db.ErrNoSuchObject, err)
+}
+
+func TestLoadByLabel(t *testing.T) {
+	s, cleanup := state.NewTestState(t)
+	defer cleanup()
+
+	_, err := s.Cluster.CreateNetwork("lxdbr1", "", db.NetworkTypeBridge, map[string]string{"user.group": "prod"})
+	require.NoError(t, err)
+
+	_, err = s.Cluster.CreateNetwork("lxdbr0", "", db.NetworkTypeBridge, map[string]string{"user.group": "prod"})
+	require.NoError(t, err)
+
+	_, err = s.Cluster.CreateNetwork("testnet", "", db.NetworkTypeMacvlan, map[string]string{"parent": "eth0", "user.group": "dev"})
+	require.NoError(t, err)
+
+	_, err = s.Cluster.CreateNetwork("lxdbr2", "", db.NetworkTypeBridge, map[string]string{})
+	require.NoError(t, err)
+
+	networks, err := LoadByLabel(s, "group", "prod")
+	require.NoError(t, err)
+	require.Len(t, networks, 2)
+	assert.Equal(t, "lxdbr0", networks[0].Name())
+	assert.Equal(t, "lxdbr1", networks[1].Name())
+
+	networks, err = LoadByLabel(s, "user.group", "dev")
+	require.NoError(t, err)
+	require.Len(t, networks, 1)
+	assert.Equal(t, "testnet", networks[0].Name())
+
+	networks, err = LoadByLabel(s, "group", "staging")
+	assert.NoError(t, err)
+	assert.Empty(t, networks)
+
+	values, err := LabelValues(s, "group")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dev", "prod"}, values)
+
+	values, err = LabelValues(s, "owner")
+	require.NoError(t, err)
+	assert.Empty(t, values)
+}
+
+// Test ConfigSchema
+func TestConfigSchema(t *testing.T) {
+	schema, err := ConfigSchema("bridge")
+	require.NoError(t, err)
+
+	assert.Equal(t, ConfigKeySchema{Type: "boolean", Default: "true", Description: "Whether to allocate addresses using DHCP"}, schema["ipv4.dhcp"])
+	assert.True(t, schema["bridge.external_interfaces"].NodeSpecific)
+	assert.Equal(t, "integer", schema["tunnel.NAME.id"].Type)
+	assert.NotContains(t, schema, "volatile.maintenance")
+
+	// Test all of the keys are described and their defaults are valid values.
+	for _, netType := range []string{"bridge", "macvlan", "sriov"} {
+		n := drivers[netType]()
+		n.init(nil, 0, "testnet", netType, "", map[string]string{}, "Unknown")
+
+		for key, keySchema := range n.ConfigSchema() {
+			assert.NotEmpty(t, keySchema.Type, "%s %s", netType, key)
+			assert.NotEmpty(t, keySchema.Description, "%s %s", netType, key)
+
+			if keySchema.Default != "" {
+				assert.NoError(t, n.ValidateKey(key, keySchema.Default), "%s %s", netType, key)
+			}
+		}
+	}
+
+	macvlanSchema, err := ConfigSchema("macvlan")
+	require.NoError(t, err)
+	assert.True(t, macvlanSchema["parent"].NodeSpecific)
+	assert.Equal(t, "false", macvlanSchema["security.frozen"].Default)
+	assert.NotContains(t, macvlanSchema, "ipv4.address")
+
+	_, err = ConfigSchema("invalid")
+	assert.Equal(t, ErrUnknownDriver, err)
+}
+
+// Test ValidateNetworkSet
+func TestValidateNetworkSet(t *testing.T) {
+	newNetwork := func(name string, config map[string]string) api.NetworksPost {
+		return api.NetworksPost{
+			Name:       name,
+			Type:       "bridge",
+			NetworkPut: api.NetworkPut{Config: config},
+		}
+	}
+
+	// Test valid set.
+	err := ValidateNetworkSet(nil, []api.NetworksPost{
+		newNetwork("lxdbr0", map[string]string{"ipv4.address": "10.0.0.1/24", "tunnel.a.protocol": "vxlan", "tunnel.a.id": "10"}),
+		newNetwork("lxdbr1", map[string]string{"ipv4.address": "10.0.1.1/24", "tunnel.a.protocol": "vxlan", "tunnel.a.id": "11"}),
+	})
+	assert.NoError(t, err)
+
+	// Test all problems are reported together.
+	err = ValidateNetworkSet(nil, []api.NetworksPost{
+		newNetwork("lxdbr0", map[string]string{"ipv4.address": "10.0.0.1/24", "tunnel.a.protocol": "vxlan"}),
+		newNetwork("lxdbr1", map[string]string{"ipv4.address": "10.0.0.2/16", "tunnel.b.protocol": "vxlan", "tunnel.b.id": "1"}),
+		newNetwork("lxdbr0", map[string]string{}),
+		newNetwork("lxdbr2", map[string]string{"ipv4.address": "invalid"}),
+	})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `Network "lxdbr0" is defined more than once`)
+	assert.Contains(t, err.Error(), `Network "lxdbr1" subnet "10.0.0.0/16" overlaps with network "lxdbr0" subnet "10.0.0.0/24"`)
+	assert.Contains(t, err.Error(), `Network "lxdbr1" tunnel "b" ID 1 on port 0 collides with network "lxdbr0"`)
+	assert.Contains(t, err.Error(), `Network "lxdbr2":`)
+}
+
+// Test exporting a network and recreating it from the backup.
+func TestExportImportNetworkBackup(t *testing.T) {
+	s, cleanup := state.NewTestState(t)
+	defer cleanup()
+
+	s.Endpoints = &endpoints.Endpoints{}
+	s.Events = events.NewServer(false, false)
+	s.OS.MockMode = true
+
+	config := map[string]string{
+		"ipv4.address":               "10.0.0.1/24",
+		"ipv6.address":               "none",
+		"bridge.external_interfaces": "eth1",
+		"volatile.imported":          "true",
+	}
+
+	_, err := s.Cluster.CreateNetwork("lxdbr0", "desc", db.NetworkTypeBridge, config)
+	require.NoError(t, err)
+
+	n, err := LoadByName(s, "lxdbr0")
+	require.NoError(t, err)
+
+	// Test node-specific and volatile keys are stripped.
+	backup, err := n.Export()
+	require.NoError(t, err)
+	assert.Equal(t, "lxdbr0", backup.Name)
+	assert.Equal(t, "bridge", backup.Type)
+	assert.Equal(t, "desc", backup.Description)
+	assert.Equal(t, map[string]string{"ipv4.address": "10.0.0.1/24", "ipv6.address": "none"}, backup.Config)
+
+	// Test import is refused while the subnet is used by the original network.
+	_, err = ImportNetworkBackup(s, backup, "lxdbr1")
+	assert.Error(t, err)
+
+	// Test import under a new name once the original network has gone.
+	require.NoError(t, s.Cluster.DeleteNetwork("lxdbr0"))
+
+	imported, err := ImportNetworkBackup(s, backup, "lxdbr1")
+	require.NoError(t, err)
+	assert.Equal(t, "lxdbr1", imported.Name())
+	assert.Equal(t, backup.Config, imported.Config())
+
+	_, netInfo, err := s.Cluster.GetNetworkInAnyState("lxdbr1")
+	require.NoError(t, err)
+	assert.Equal(t, "desc", netInfo.Description)
+}
+
+// Test Import of an existing host interface.
+func TestImport(t *testing.T) {
+	s, cleanup := state.NewTestState(t)
+	defer cleanup()
+
+	s.Endpoints = &endpoints.Endpoints{}
+	s.Events = events.NewServer(false, false)
+	s.OS.MockMode = true
+
+	// Test a bridge network must be named after a bridge interface.
+	_, err := Import(s, "lxdbr0", "bridge", "lo")
+	assert.Error(t, err)
+
+	_, err = Import(s, "lo", "bridge", "lo")
+	assert.EqualError(t, err, `Interface "lo" is not a bridge`)
+
+	_, err = Import(s, "testnet", "macvlan", "lxdtestmissing0")
+	assert.Error(t, err)
+
+	n, err := Import(s, "testnet", "macvlan", "lo")
+	require.NoError(t, err)
+	assert.Equal(t, "testnet", n.Name())
+	assert.Equal(t, map[string]string{"parent": "lo", "volatile.imported": "true"}, n.Config())
+
+	_, netInfo, err := s.Cluster.GetNetworkInAnyState("testnet")
+	require.NoError(t, err)
+	assert.Equal(t, "macvlan", netInfo.Type)
+	assert.Equal(t, api.NetworkStatusCreated, netInfo.Status)
+
+	// Test an interface already used by a network can't be imported again.
+	_, err = Import(s, "testnet2", "macvlan", "lo")
+	assert.EqualError(t, err, `Interface "lo" is already used by network "testnet"`)
+}
+
+// Test SwapNetworkNames
+func TestSwapNetworkNames(t *testing.T) {
+	s, cleanup := state.NewTestState(t)
+	defer cleanup()
+
+	s.Endpoints = &endpoints.Endpoints{}
+	s.Events = events.NewServer(false, false)
+
+	oldLXDDir := os.Getenv("LXD_DIR")
+	os.Setenv("LXD_DIR", s.OS.VarDir)
+	defer os.Setenv("LXD_DIR", oldLXDDir)
+
+	_, err := s.Cluster.CreateNetwork("blue", "", db.NetworkTypeMacvlan, map[string]string{"parent": "eth0"})
+	require.NoError(t, err)
+
+	_, err = s.Cluster.CreateNetwork("green", "", db.NetworkTypeMacvlan, map[string]string{"parent": "eth1"})
+	require.NoError(t, err)
+
+	_, err = s.Cluster.CreateNetwork("lxdbr0", "", db.NetworkTypeBridge, map[string]string{})
+	require.NoError(t, err)
+
+	bluePath := filepath.Join(s.OS.VarDir, "networks", "blue")
+	require.NoError(t, os.MkdirAll(bluePath, 0711))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(bluePath, "marker"), []byte("blue"), 0644))
+
+	assertParent := func(name string, parent string) {
+		_, netInfo, err := s.Cluster.GetNetworkInAnyState(name)
+		require.NoError(t, err)
+		assert.Equal(t, parent, netInfo.Config["parent"])
+	}
+
+	// Test a failure midway through the database swap leaves both names unchanged.
+	assert.Error(t, s.Cluster.SwapNetworkNames("blue", "missing"))
+	assertParent("blue", "eth0")
+
+	assert.Error(t, SwapNetworkNames(s, "blue", "missing"))
+	assert.Error(t, SwapNetworkNames(s, "blue", "blue"))
+	assert.Error(t, SwapNetworkNames(s, "blue", "lxdbr0"))
+	assertParent("blue", "eth0")
+	assertParent("green", "eth1")
+
+	require.NoError(t, SwapNetworkNames(s, "blue", "green"))
+	assertParent("blue", "eth1")
+	assertParent("green", "eth0")
+
+	// Test the local directories were swapped too.
+	content, err := ioutil.ReadFile(filepath.Join(s.OS.VarDir, "networks", "green", "marker"))
+	require.NoError(t, err)
+	assert.Equal(t, "blue", string(content))
+	assert.False(t, shared.PathExists(bluePath))
+}
+
+// Test FillConfig applies project network defaults
+func TestFillConfigProjectDefaults(t *testing.T) {
+	s, cleanup := state.NewTestState(t)
+	defer cleanup()
+
+	err := s.Cluster.Transaction(func(tx *db.ClusterTx) error {
+		_, err := tx.CreateProject(api.ProjectsPost{
+			Name: "tenant",
+			ProjectPut: api.ProjectPut{
+				Config: map[string]string{
+					"network.defaults.maas.subnet.ipv4": "foo",
+					"network.defaults.maas.subnet.ipv6": "bar",
+					"network.defaults.ipv4.dhcp":        "false",
+				},
+			},
+		})
+		return err
+	})
+	require.NoError(t, err)
+
+	// Test defaults the driver doesn't support are skipped and requested values take precedence.
+	req := api.NetworksPost{Name: "testnet", Type: "macvlan", NetworkPut: api.NetworkPut{Config: map[string]string{"parent": "eth0", "maas.subnet.ipv6": "baz"}}}
+	require.NoError(t, FillConfig(s, "tenant", &req))
+	assert.Equal(t, map[string]string{"parent": "eth0", "maas.subnet.ipv4": "foo", "maas.subnet.ipv6": "baz"}, req.Config)
+
+	// Test projects without network defaults leave the config unchanged.
+	req = api.NetworksPost{Name: "testnet", Type: "macvlan", NetworkPut: api.NetworkPut{Config: map[string]string{"parent": "eth0"}}}
+	require.NoError(t, FillConfig(s, "default", &req))
+	assert.Equal(t, map[string]string{"parent": "eth0"}, req.Config)
+
+	assert.Error(t, FillConfig(s, "missing", &req))
+}
+
+// Test ValidateProjectDefault
+func TestValidateProjectDefault(t *testing.T) {
+	assert.NoError(t, ValidateProjectDefault("ipv4.dhcp", "false"))
+	assert.NoError(t, ValidateProjectDefault("maas.subnet.ipv4", "foo"))
+	assert.Error(t, ValidateProjectDefault("ipv4.dhcp", "maybe"))
+	assert.Error(t, ValidateProjectDefault("foo.bar", "baz"))
+}
+
+// Test Validate handling of unknown keys
+func TestValidateUnknownKeys(t *testing.T) {
+	config := map[string]string{"parent": "eth0", "foo.bar": "baz"}
+
+	err := Validate("testnet", "macvlan", config)
+	assert.EqualError(t, err, `Invalid option for network "testnet" option "foo.bar"`)
+
+	// Test typos of known keys get a suggestion.
+	err = Validate("testnet", "macvlan", map[string]string{"parent": "eth0", "parnet": "eth0"})
+	assert.EqualError(t, err, `Invalid option for network "testnet" option "parnet" (did you mean "parent"?)`)
+
+	// Test unknown keys are accepted when allowed by the server config, but typos are still rejected.
+	s, cleanup := state.NewTestState(t)
+	defer cleanup()
+
+	err = s.Cluster.Transaction(func(tx *db.ClusterTx) error {
+		return tx.UpdateConfig(map[string]string{"network.allow_unknown_keys": "true"})
+	})
+	require.NoError(t, err)
+
+	n := &macvlan{}
+	n.init(s, 0, "testnet", "macvlan", "", config, api.NetworkStatusCreated)
+	assert.NoError(t, n.Validate(config))
+	assert.Error(t, n.Validate(map[string]string{"parent": "eth0", "parnet": "eth0"}))
+}
+
+// Test ApplyPreset
+func TestApplyPreset(t *testing.T) {
+	// Test each built-in preset produces a valid config.
+	for _, name := range PresetNames() {
+		config, err := ApplyPreset(name, nil)
+		assert.NoError(t, err, name)
+		assert.NoError(t, Validate("testbr0", presets[name].netType, config), name)
+	}
+
+	// Test overrides win over the preset's defaults.
+	config, err := ApplyPreset("isolated", map[string]string{"ipv4.address": "10.0.0.1/24", "dns.domain": "example"})
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.1/24", config["ipv4.address"])
+	assert.Equal(t, "false", config["ipv4.nat"])
+	assert.Equal(t, "example", config["dns.domain"])
+
+	// Test the merged config is validated.
+	_, err = ApplyPreset("isolated", map[string]string{"ipv4.nat": "maybe"})
+	assert.Error(t, err)
+
+	_, err = ApplyPreset("missing", nil)
+	assert.EqualError(t, err, `Unknown network preset "missing"`)
+}
@@ -0,0 +1,51 @@
+package network
+
+import (
+	"strings"
+
+	"github.com/lxc/lxd/lxd/db"
+	"github.com/lxc/lxd/shared"
+)
+
+// ConfigKeySchema describes a network config key so that tooling can build forms and validate configs.
+type ConfigKeySchema struct {
+	Type         string `json:"type" yaml:"type"`
+	Default      string `json:"default" yaml:"default"`
+	NodeSpecific bool   `json:"node_specific" yaml:"node_specific"`
+	Description  string `json:"description" yaml:"description"`
+}
+
+// configKeyRule is the validation rule of a config key along with the metadata describing the key in the config
+// schema. Defaults are the values that apply when the key isn't set, and are left empty when they depend on other
+// keys or on the host.
+type configKeyRule struct {
+	validator    func(value string) error
+	keyType      string
+	defaultValue string
+	description  string
+}
+
+// configSchema returns the schema of the common rules merged with the supplied driver specific rules. Volatile keys
+// are internal and so aren't included.
+func (n *common) configSchema(driverRules map[string]configKeyRule) map[string]ConfigKeySchema {
+	rules := n.validationRules()
+	for key, rule := range driverRules {
+		rules[key] = rule
+	}
+
+	schema := make(map[string]ConfigKeySchema, len(rules))
+	for key, rule := range rules {
+		if strings.HasPrefix(key, "volatile.") {
+			continue
+		}
+
+		schema[key] = ConfigKeySchema{
+			Type:         rule.keyType,
+			Default:      rule.defaultValue,
+			NodeSpecific: shared.StringInSlice(key, db.NodeSpecificNetworkConfig),
+			Description:  rule.description,
+		}
+	}
+
+	return schema
+}
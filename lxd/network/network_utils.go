@@ -2,6 +2,9 @@ package network
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
@@ -11,25 +14,33 @@ import (
 	"math/rand"
 	"net"
 	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/pkg/errors"
 
+	"github.com/lxc/lxd/lxd/db"
 	deviceConfig "github.com/lxc/lxd/lxd/device/config"
 	"github.com/lxc/lxd/lxd/device/nictype"
 	"github.com/lxc/lxd/lxd/dnsmasq"
+	firewallDrivers "github.com/lxc/lxd/lxd/firewall/drivers"
 	"github.com/lxc/lxd/lxd/instance"
 	"github.com/lxc/lxd/lxd/instance/instancetype"
 	"github.com/lxc/lxd/lxd/network/openvswitch"
+	"github.com/lxc/lxd/lxd/network/validate"
 	"github.com/lxc/lxd/lxd/project"
+	"github.com/lxc/lxd/lxd/revert"
 	"github.com/lxc/lxd/lxd/state"
 	"github.com/lxc/lxd/shared"
 	"github.com/lxc/lxd/shared/api"
 	"github.com/lxc/lxd/shared/logger"
+	"github.com/lxc/lxd/shared/units"
 )
 
 // ValidNetworkName validates network name.
@@ -118,809 +129,2626 @@ func isInUseByDevices(s *state.State, devices deviceConfig.Devices, networkName
 	return false, nil
 }
 
-// GetIP returns a net.IP representing the IP belonging to the subnet for the host number supplied.
-func GetIP(subnet *net.IPNet, host int64) net.IP {
-	// Convert IP to a big int.
-	bigIP := big.NewInt(0)
-	bigIP.SetBytes(subnet.IP.To16())
+// DHCPExpiryInfinite is the lease time returned for DHCP leases that don't expire.
+const DHCPExpiryInfinite = time.Duration(math.MaxInt64)
 
-	// Deal with negative offsets.
-	bigHost := big.NewInt(host)
-	bigCount := big.NewInt(host)
-	if host < 0 {
-		mask, size := subnet.Mask.Size()
+// dhcpExpiryMinimum is the shortest lease time accepted by dnsmasq.
+const dhcpExpiryMinimum = 2 * time.Minute
 
-		bigHosts := big.NewFloat(0)
-		bigHosts.SetFloat64((math.Pow(2, float64(size-mask))))
-		bigHostsInt, _ := bigHosts.Int(nil)
+// dhcpExpiryUnits maps the unit suffixes accepted by dnsmasq for lease times to their durations.
+var dhcpExpiryUnits = map[byte]time.Duration{
+	's': time.Second,
+	'm': time.Minute,
+	'h': time.Hour,
+	'd': 24 * time.Hour,
+	'w': 7 * 24 * time.Hour,
+}
 
-		bigCount.Set(bigHostsInt)
-		bigCount.Add(bigCount, bigHost)
+// parseDHCPExpiry parses a DHCP lease time in the format accepted by dnsmasq, which is "infinite" or a number
+// optionally followed by one of the s, m, h, d or w units (seconds if no unit is given), such as "1h".
+// An empty value returns the default of 1h. Returns an error if the lease time is shorter than 2 minutes.
+func parseDHCPExpiry(value string) (time.Duration, error) {
+	if value == "" {
+		return time.Hour, nil
 	}
 
-	// Get the new IP int.
-	bigIP.Add(bigIP, bigCount)
+	if value == "infinite" {
+		return DHCPExpiryInfinite, nil
+	}
 
-	// Generate an IPv6.
-	if subnet.IP.To4() == nil {
-		newIP := bigIP.Bytes()
-		return newIP
+	number := strings.ToLower(value)
+	unit := time.Second
+	suffixUnit, ok := dhcpExpiryUnits[number[len(number)-1]]
+	if ok {
+		number = number[:len(number)-1]
+		unit = suffixUnit
 	}
 
-	// Generate an IPv4.
-	newIP := make(net.IP, 4)
-	binary.BigEndian.PutUint32(newIP, uint32(bigIP.Int64()))
-	return newIP
+	count, err := strconv.ParseUint(number, 10, 32)
+	if err != nil {
+		return -1, fmt.Errorf("Invalid lease time %q, must be \"infinite\" or a number optionally followed by one of the s, m, h, d or w units", value)
+	}
+
+	// dnsmasq stores lease times as a 32-bit number of seconds.
+	seconds := count * uint64(unit/time.Second)
+	if seconds > math.MaxUint32 {
+		return -1, fmt.Errorf("Invalid lease time %q, must be at most %d seconds", value, uint64(math.MaxUint32))
+	}
+
+	expiry := time.Duration(seconds) * time.Second
+
+	if expiry < dhcpExpiryMinimum {
+		return -1, fmt.Errorf("Invalid lease time %q, must be at least %s", value, dhcpExpiryMinimum)
+	}
+
+	return expiry, nil
 }
 
-// IsNativeBridge returns whether the bridge name specified is a Linux native bridge.
-func IsNativeBridge(bridgeName string) bool {
-	return shared.PathExists(fmt.Sprintf("/sys/class/net/%s/bridge", bridgeName))
+// validDHCPExpiry validates a DHCP lease time.
+func validDHCPExpiry(value string) error {
+	_, err := parseDHCPExpiry(value)
+	return err
 }
 
-// AttachInterface attaches an interface to a bridge.
-func AttachInterface(bridgeName string, devName string) error {
-	if IsNativeBridge(bridgeName) {
-		_, err := shared.RunCommand("ip", "link", "set", "dev", devName, "master", bridgeName)
-		if err != nil {
-			return err
-		}
-	} else {
-		ovs := openvswitch.NewOVS()
-		err := ovs.BridgeAddPort(bridgeName, devName)
-		if err != nil {
-			return err
+// validDHCPRangesCount checks that a comma separated list of DHCP ranges has no more than MaxDHCPRanges entries.
+func validDHCPRangesCount(value string) error {
+	count := 0
+	for _, entry := range strings.Split(value, ",") {
+		if strings.TrimSpace(entry) != "" {
+			count++
 		}
 	}
 
+	if count > MaxDHCPRanges {
+		return fmt.Errorf("Too many DHCP ranges (%d), at most %d are allowed", count, MaxDHCPRanges)
+	}
+
 	return nil
 }
 
-// DetachInterface detaches an interface from a bridge.
-func DetachInterface(bridgeName string, devName string) error {
-	if IsNativeBridge(bridgeName) {
-		_, err := shared.RunCommand("ip", "link", "set", "dev", devName, "nomaster")
-		if err != nil {
-			return err
-		}
-	} else {
-		ovs := openvswitch.NewOVS()
-		err := ovs.BridgeDeletePort(bridgeName, devName)
-		if err != nil {
-			return err
-		}
+// dnsmasqLeaseTime returns the lease time in the format used by dnsmasq's --dhcp-range option.
+func dnsmasqLeaseTime(expiry time.Duration) string {
+	if expiry == DHCPExpiryInfinite {
+		return "infinite"
 	}
 
-	return nil
+	return fmt.Sprintf("%d", int64(expiry/time.Second))
 }
 
-// GetDevMTU retrieves the current MTU setting for a named network device.
-func GetDevMTU(devName string) (uint64, error) {
-	content, err := ioutil.ReadFile(fmt.Sprintf("/sys/class/net/%s/mtu", devName))
-	if err != nil {
-		return 0, err
+// validHWAddr validates an Ethernet MAC address, rejecting multicast and all-zero addresses.
+func validHWAddr(value string) error {
+	if value == "" {
+		return nil
 	}
 
-	// Parse value
-	mtu, err := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 32)
-	if err != nil {
-		return 0, err
+	hwAddr, err := net.ParseMAC(value)
+	if err != nil || len(hwAddr) != 6 {
+		return fmt.Errorf("Invalid MAC address %q", value)
 	}
 
-	return mtu, nil
+	if hwAddr[0]&0x01 != 0 {
+		return fmt.Errorf("Invalid MAC address %q, must not be a multicast address", value)
+	}
+
+	if bytes.Equal(hwAddr, make(net.HardwareAddr, 6)) {
+		return fmt.Errorf("Invalid MAC address %q, must not be all zeros", value)
+	}
+
+	return nil
 }
 
-// DefaultGatewaySubnetV4 returns subnet of default gateway interface.
-func DefaultGatewaySubnetV4() (*net.IPNet, string, error) {
-	file, err := os.Open("/proc/net/route")
-	if err != nil {
-		return nil, "", err
+// stableHWAddr generates a locally administered unicast MAC address from the hash of the supplied name, so the
+// same name always produces the same address.
+func stableHWAddr(name string) net.HardwareAddr {
+	hash := sha256.Sum256([]byte(name))
+
+	hwAddr := net.HardwareAddr(hash[:6])
+	hwAddr[0] = (hwAddr[0] | 0x02) &^ 0x01 // Set the locally administered bit and clear the multicast bit.
+
+	return hwAddr
+}
+
+// parseStaticRoutes parses a comma separated list of static routes in the "CIDR[ via GATEWAY]" format. Returns an
+// error if any destination isn't a subnet of the specified IP family in CIDR format or any gateway isn't an address
+// of that family. If subnet is non-nil then gateways must also be inside of it.
+func parseStaticRoutes(value string, family int, subnet *net.IPNet) ([]StaticRoute, error) {
+	routes := []StaticRoute{}
+
+	if value == "" {
+		return routes, nil
 	}
-	defer file.Close()
 
-	ifaceName := ""
+	for _, entry := range strings.Split(value, ",") {
+		fields := strings.Fields(entry)
+		if len(fields) != 1 && (len(fields) != 3 || fields[1] != "via") {
+			return nil, fmt.Errorf("Invalid route %q, must be in CIDR[ via GATEWAY] format", strings.TrimSpace(entry))
+		}
 
-	scanner := bufio.NewReader(file)
-	for {
-		line, _, err := scanner.ReadLine()
-		if err != nil {
-			break
+		_, destination, err := net.ParseCIDR(fields[0])
+		if err != nil || (destination.IP.To4() != nil) != (family == 4) {
+			return nil, fmt.Errorf("Invalid route destination %q, must be an IPv%d subnet in CIDR format", fields[0], family)
 		}
 
-		fields := strings.Fields(string(line))
+		route := StaticRoute{Destination: destination}
 
-		if fields[1] == "00000000" && fields[7] == "00000000" {
-			ifaceName = fields[0]
-			break
+		if len(fields) == 3 {
+			gateway := net.ParseIP(fields[2])
+			if gateway == nil || (gateway.To4() != nil) != (family == 4) {
+				return nil, fmt.Errorf("Invalid route gateway %q, must be an IPv%d address", fields[2], family)
+			}
+
+			if subnet != nil && !subnet.Contains(gateway) {
+				return nil, fmt.Errorf("Route gateway %q is not within the network subnet %q", fields[2], subnet.String())
+			}
+
+			route.Gateway = gateway
 		}
+
+		routes = append(routes, route)
 	}
 
-	if ifaceName == "" {
-		return nil, "", fmt.Errorf("No default gateway for IPv4")
+	return routes, nil
+}
+
+// validDNSName checks that each dot separated label of the supplied name is a valid hostname.
+func validDNSName(name string) error {
+	for _, label := range strings.Split(name, ".") {
+		err := shared.ValidHostname(label)
+		if err != nil {
+			return err
+		}
 	}
 
-	iface, err := net.InterfaceByName(ifaceName)
+	return nil
+}
+
+// dnsNameInDomain returns whether the supplied name is a subdomain of domain. The comparison is case-insensitive.
+func dnsNameInDomain(name string, domain string) bool {
+	return strings.HasSuffix(strings.ToLower(name), "."+strings.ToLower(domain))
+}
+
+// parseDNSRecord parses the value of a custom DNS record in the "TYPE VALUE" format. Supported types are A (the
+// value is an IPv4 address), AAAA (an IPv6 address) and CNAME (the target domain name).
+func parseDNSRecord(name string, value string) (api.NetworkDNSRecord, error) {
+	err := validDNSName(name)
 	if err != nil {
-		return nil, "", err
+		return api.NetworkDNSRecord{}, fmt.Errorf("Invalid DNS record name %q: %v", name, err)
+	}
+
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return api.NetworkDNSRecord{}, fmt.Errorf("Invalid DNS record %q, must be in TYPE VALUE format", value)
+	}
+
+	record := api.NetworkDNSRecord{Name: name, Type: strings.ToUpper(fields[0]), Value: fields[1]}
+
+	switch record.Type {
+	case "A":
+		err = shared.IsNetworkAddressV4(record.Value)
+	case "AAAA":
+		err = shared.IsNetworkAddressV6(record.Value)
+	case "CNAME":
+		err = validDNSName(strings.TrimSuffix(record.Value, "."))
+	default:
+		return api.NetworkDNSRecord{}, fmt.Errorf("Invalid DNS record type %q, must be one of A, AAAA or CNAME", fields[0])
 	}
 
-	addrs, err := iface.Addrs()
 	if err != nil {
-		return nil, "", err
+		return api.NetworkDNSRecord{}, fmt.Errorf("Invalid value %q for DNS record %q of type %s: %v", record.Value, name, record.Type, err)
 	}
 
-	var subnet *net.IPNet
+	return record, nil
+}
 
-	for _, addr := range addrs {
-		addrIP, addrNet, err := net.ParseCIDR(addr.String())
-		if err != nil {
-			return nil, "", err
-		}
+// parseFirewallRules parses a comma separated list of custom firewall rules. Each rule is in the
+// "CHAIN ACTION [PROTOCOL [PORT]]" format, where CHAIN is one of input, forward or output, ACTION is one of accept,
+// drop or reject and PROTOCOL is one of tcp, udp or icmp. A PORT can only be specified for tcp and udp rules.
+func parseFirewallRules(value string) ([]firewallDrivers.NetworkRule, error) {
+	rules := []firewallDrivers.NetworkRule{}
 
-		if addrIP.To4() == nil {
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
 			continue
 		}
 
-		if subnet != nil {
-			return nil, "", fmt.Errorf("More than one IPv4 subnet on default interface")
+		fields := strings.Fields(strings.ToLower(entry))
+		if len(fields) < 2 || len(fields) > 4 {
+			return nil, fmt.Errorf("Invalid firewall rule %q, must be in CHAIN ACTION [PROTOCOL [PORT]] format", entry)
 		}
 
-		subnet = addrNet
-	}
+		rule := firewallDrivers.NetworkRule{Chain: fields[0], Action: fields[1]}
 
-	if subnet == nil {
-		return nil, "", fmt.Errorf("No IPv4 subnet on default interface")
+		if !shared.StringInSlice(rule.Chain, firewallChains) {
+			return nil, fmt.Errorf("Invalid chain %q in firewall rule %q, must be one of %s", rule.Chain, entry, strings.Join(firewallChains, ", "))
+		}
+
+		if !shared.StringInSlice(rule.Action, firewallActions) {
+			return nil, fmt.Errorf("Invalid action %q in firewall rule %q, must be one of %s", rule.Action, entry, strings.Join(firewallActions, ", "))
+		}
+
+		if len(fields) > 2 {
+			rule.Protocol = fields[2]
+			if !shared.StringInSlice(rule.Protocol, firewallProtocols) {
+				return nil, fmt.Errorf("Invalid protocol %q in firewall rule %q, must be one of %s", rule.Protocol, entry, strings.Join(firewallProtocols, ", "))
+			}
+		}
+
+		if len(fields) > 3 {
+			if rule.Protocol == "icmp" {
+				return nil, fmt.Errorf("Invalid firewall rule %q, a port can only be used with tcp or udp", entry)
+			}
+
+			port, err := strconv.ParseUint(fields[3], 10, 16)
+			if err != nil || port == 0 {
+				return nil, fmt.Errorf("Invalid port %q in firewall rule %q", fields[3], entry)
+			}
+
+			rule.Port = uint16(port)
+		}
+
+		rules = append(rules, rule)
 	}
 
-	return subnet, ifaceName, nil
+	return rules, nil
 }
 
-// UpdateDNSMasqStatic rebuilds the DNSMasq static allocations.
-func UpdateDNSMasqStatic(s *state.State, networkName string) error {
-	// We don't want to race with ourselves here.
-	dnsmasq.ConfigMutex.Lock()
-	defer dnsmasq.ConfigMutex.Unlock()
+// parseVLANList parses a comma separated list of tagged VLAN IDs.
+func parseVLANList(value string) ([]uint16, error) {
+	vlanIDs := []uint16{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
 
-	// Get all the networks.
-	var networks []string
-	if networkName == "" {
-		var err error
-		networks, err = s.Cluster.GetNetworks()
+		err := validate.IsNetworkVLAN(entry)
 		if err != nil {
-			return err
+			return nil, err
 		}
-	} else {
-		networks = []string{networkName}
-	}
 
-	// Get all the instances.
-	insts, err := instance.LoadNodeAll(s, instancetype.Any)
-	if err != nil {
-		return err
+		vlanID, _ := strconv.ParseUint(entry, 10, 16)
+		if vlanID == 0 {
+			return nil, fmt.Errorf("VLAN ID 0 can't be tagged")
+		}
+
+		vlanIDs = append(vlanIDs, uint16(vlanID))
 	}
 
-	// Build a list of dhcp host entries.
-	entries := map[string][][]string{}
-	for _, inst := range insts {
-		// Go through all its devices (including profiles).
-		for k, d := range inst.ExpandedDevices() {
-			// Skip uninteresting entries.
-			if d["type"] != "nic" {
-				continue
-			}
+	return vlanIDs, nil
+}
 
-			nicType, err := nictype.NICType(s, d)
-			if err != nil || nicType != "bridged" {
-				continue
+// removedVLANs returns the VLAN IDs in the old list that aren't in the new list.
+func removedVLANs(oldVLANs []uint16, newVLANs []uint16) []uint16 {
+	removed := []uint16{}
+	for _, oldVLAN := range oldVLANs {
+		found := false
+		for _, newVLAN := range newVLANs {
+			if newVLAN == oldVLAN {
+				found = true
+				break
 			}
+		}
 
-			// Temporarily populate parent from network setting if used.
-			if d["network"] != "" {
-				d["parent"] = d["network"]
-			}
+		if !found {
+			removed = append(removed, oldVLAN)
+		}
+	}
 
-			// Skip devices not connected to managed networks.
-			if !shared.StringInSlice(d["parent"], networks) {
-				continue
-			}
+	return removed
+}
 
-			// Fill in the hwaddr from volatile.
-			d, err = inst.FillNetworkDevice(k, d)
-			if err != nil {
-				continue
-			}
+// dhcpLeaseMAC returns the MAC address of the supplied dnsmasq lease file line fields. IPv6 leases have an IAID
+// instead of the MAC, so the MAC is taken from the end of the client DUID instead.
+func dhcpLeaseMAC(fields []string) string {
+	macStr := strings.Join(GetMACSlice(fields[1]), ":")
+	if len(macStr) < 17 && len(fields[4]) >= 17 {
+		macStr = fields[4][len(fields[4])-17:]
+	}
 
-			// Add the new host entries.
-			_, ok := entries[d["parent"]]
-			if !ok {
-				entries[d["parent"]] = [][]string{}
-			}
+	return macStr
+}
 
-			if (shared.IsTrue(d["security.ipv4_filtering"]) && d["ipv4.address"] == "") || (shared.IsTrue(d["security.ipv6_filtering"]) && d["ipv6.address"] == "") {
-				curIPv4, curIPv6, err := dnsmasq.DHCPStaticIPs(d["parent"], inst.Project(), inst.Name())
-				if err != nil && !os.IsNotExist(err) {
-					return err
-				}
+// filterDHCPLeases returns the lines of the dnsmasq lease file content for the leases of the supplied MAC addresses
+// along with any lines that aren't leases (such as the IPv6 server DUID line).
+func filterDHCPLeases(content string, macs []string) string {
+	lines := []string{}
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
 
-				if d["ipv4.address"] == "" && curIPv4.IP != nil {
-					d["ipv4.address"] = curIPv4.IP.String()
-				}
+		if len(fields) < 5 || shared.StringInSlice(strings.ToLower(dhcpLeaseMAC(fields)), macs) {
+			lines = append(lines, line)
+		}
+	}
 
-				if d["ipv6.address"] == "" && curIPv6.IP != nil {
-					d["ipv6.address"] = curIPv6.IP.String()
-				}
-			}
+	if len(lines) == 0 {
+		return ""
+	}
 
-			entries[d["parent"]] = append(entries[d["parent"]], []string{d["hwaddr"], inst.Project(), inst.Name(), d["ipv4.address"], d["ipv6.address"]})
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// renderDnsmasqConfig converts the supplied dnsmasq command line arguments into dnsmasq config file format, with one
+// option per line. Options that take their value as a separate argument (such as "--dhcp-range VALUE") are joined.
+func renderDnsmasqConfig(args []string) string {
+	var sb strings.Builder
+
+	for i := 0; i < len(args); i++ {
+		option := strings.TrimPrefix(args[i], "--")
+		if !strings.Contains(option, "=") && i+1 < len(args) && !strings.HasPrefix(args[i+1], "--") {
+			option = fmt.Sprintf("%s=%s", option, args[i+1])
+			i++
 		}
+
+		sb.WriteString(option + "\n")
 	}
 
-	// Update the host files.
-	for _, network := range networks {
-		entries, _ := entries[network]
+	return sb.String()
+}
 
-		// Skip networks we don't manage (or don't have DHCP enabled).
-		if !shared.PathExists(shared.VarPath("networks", network, "dnsmasq.pid")) {
+// parseDHCPOptions parses a comma separated list of custom DHCP options. Each option is in the
+// "NUMBER:VALUE[ VALUE...]" format, where NUMBER is between 1 and 254 (0 and 255 are the reserved pad and end
+// options) and multiple values are separated by spaces. The values of well known options are checked according to
+// their format and options that LXD generates from other config keys can't be set.
+func parseDHCPOptions(value string) ([]DHCPOption, error) {
+	options := []DHCPOption{}
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
 			continue
 		}
 
-		n, err := LoadByName(s, network)
-		if err != nil {
-			return err
+		fields := strings.SplitN(entry, ":", 2)
+		if len(fields) != 2 || len(strings.Fields(fields[1])) == 0 {
+			return nil, fmt.Errorf("Invalid DHCP option %q, must be in NUMBER:VALUE format", entry)
 		}
-		config := n.Config()
 
-		// Wipe everything clean.
-		files, err := ioutil.ReadDir(shared.VarPath("networks", network, "dnsmasq.hosts"))
+		number, err := strconv.ParseUint(strings.TrimSpace(fields[0]), 10, 8)
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("Invalid number in DHCP option %q, must be between 0 and 255", entry)
 		}
 
-		for _, entry := range files {
+		if number == 0 || number == 255 {
+			return nil, fmt.Errorf("Invalid number in DHCP option %q, options 0 and 255 are reserved", entry)
+		}
+
+		option := DHCPOption{Number: uint8(number), Values: strings.Fields(fields[1])}
+
+		key, found := dhcpOptionsManaged[option.Number]
+		if found {
+			return nil, fmt.Errorf("DHCP option %d is managed by LXD, use %q instead", option.Number, key)
+		}
+
+		for _, existing := range options {
+			if existing.Number == option.Number {
+				return nil, fmt.Errorf("DHCP option %d is specified more than once", option.Number)
+			}
+		}
+
+		switch dhcpOptionFormats[option.Number] {
+		case "ipv4":
+			for _, v := range option.Values {
+				ip := net.ParseIP(v)
+				if ip == nil || ip.To4() == nil {
+					return nil, fmt.Errorf("Invalid value %q for DHCP option %d, must be a list of IPv4 addresses", v, option.Number)
+				}
+			}
+		case "string":
+			if len(option.Values) != 1 {
+				return nil, fmt.Errorf("Invalid value for DHCP option %d, must be a single value", option.Number)
+			}
+		case "domain":
+			for _, v := range option.Values {
+				err := validate.IsDNSDomain(v)
+				if err != nil {
+					return nil, errors.Wrapf(err, "Invalid value %q for DHCP option %d", v, option.Number)
+				}
+			}
+		}
+
+		options = append(options, option)
+	}
+
+	return options, nil
+}
+
+// editDistance returns the Levenshtein distance between the two strings, i.e. the minimum number of single
+// character insertions, deletions and substitutions needed to turn one into the other.
+func editDistance(a string, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+
+		prev, cur = cur, prev
+	}
+
+	return prev[len(b)]
+}
+
+// suggestKey returns the known key closest to the supplied key if it is within a small edit distance (at most 2,
+// or 1 for short keys), preferring the alphabetically first key on ties. Returns empty string if none are close.
+func suggestKey(key string, knownKeys []string) string {
+	maxDistance := 2
+	if len(key) <= 4 {
+		maxDistance = 1
+	}
+
+	sort.Strings(knownKeys)
+
+	suggestion := ""
+	for _, knownKey := range knownKeys {
+		distance := editDistance(key, knownKey)
+		if distance > 0 && distance <= maxDistance {
+			suggestion = knownKey
+			maxDistance = distance - 1
+		}
+	}
+
+	return suggestion
+}
+
+// pingHost sends a single ICMP echo request to the address and waits up to the timeout for a reply, returning an
+// error if none is received. Allows tests to avoid running ping.
+var pingHost = func(ip net.IP, timeout time.Duration) error {
+	cmd := "ping"
+	if ip.To4() == nil {
+		cmd = "ping6"
+	}
+
+	// Ping only accepts whole seconds.
+	wait := int64(math.Ceil(timeout.Seconds()))
+
+	_, err := shared.RunCommand(cmd, "-n", "-q", ip.String(), "-c", "1", "-W", fmt.Sprintf("%d", wait))
+	return err
+}
+
+// vxlanDefaultPort is the UDP port used by the kernel for VXLAN tunnels that don't specify one.
+const vxlanDefaultPort = "8472"
+
+// probeTunnelRemote checks whether the tunnel's remote address answers an ICMP echo request. If it doesn't and the
+// tunnel is VXLAN then a TCP connection to the tunnel port is attempted instead. As VXLAN uses UDP, a refused
+// connection is expected from a reachable host and so is considered a success.
+func probeTunnelRemote(tunnel tunnel, timeout time.Duration) TunnelProbeResult {
+	result := TunnelProbeResult{Name: tunnel.Name, Remote: tunnel.Remote, Method: "icmp"}
+
+	ip := net.ParseIP(tunnel.Remote)
+	if ip == nil {
+		result.Err = fmt.Errorf("Invalid remote address %q", tunnel.Remote)
+		return result
+	}
+
+	err := pingHost(ip, timeout)
+	if err == nil {
+		result.Reachable = true
+		return result
+	}
+
+	result.Err = err
+
+	if tunnel.Protocol != "vxlan" {
+		return result
+	}
+
+	port := tunnel.Port
+	if port == "" || port == "0" {
+		port = vxlanDefaultPort
+	}
+
+	result.Method = "tcp"
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip.String(), port), timeout)
+	if err == nil {
+		conn.Close()
+		result.Reachable = true
+		result.Err = nil
+		return result
+	}
+
+	opErr, ok := err.(*net.OpError)
+	if ok {
+		sysErr, ok := opErr.Err.(*os.SyscallError)
+		if ok && sysErr.Err == syscall.ECONNREFUSED {
+			result.Reachable = true
+			result.Err = nil
+			return result
+		}
+	}
+
+	result.Err = err
+	return result
+}
+
+// tunnel represents the config of a single tunnel from the grouped "tunnel.NAME.KEY" config keys.
+type tunnel struct {
+	Name      string
+	Protocol  string
+	Local     string
+	Remote    string
+	Group     string
+	Port      string
+	ID        string
+	Interface string
+	TTL       string
+}
+
+// parseTunnels parses the grouped "tunnel.NAME.KEY" config keys into a list of tunnels ordered by name.
+func parseTunnels(config map[string]string) []tunnel {
+	tunnels := []tunnel{}
+
+	for _, name := range tunnelNames(config) {
+		getConfig := func(key string) string {
+			return config[fmt.Sprintf("tunnel.%s.%s", name, key)]
+		}
+
+		tunnels = append(tunnels, tunnel{
+			Name:      name,
+			Protocol:  getConfig("protocol"),
+			Local:     getConfig("local"),
+			Remote:    getConfig("remote"),
+			Group:     getConfig("group"),
+			Port:      getConfig("port"),
+			ID:        getConfig("id"),
+			Interface: getConfig("interface"),
+			TTL:       getConfig("ttl"),
+		})
+	}
+
+	return tunnels
+}
+
+// validateTunnels checks that each tunnel is complete and consistent. GRE tunnels need both a local and remote
+// address, VXLAN tunnels need an ID (defaulting to 1) that is unique amongst the supplied tunnels and either both a
+// local and remote address or neither (for multicast). Returns an error listing the problems found with each tunnel.
+func validateTunnels(tunnels []tunnel) error {
+	problems := []string{}
+	vxlanIDs := map[string]string{}
+
+	for _, tunnel := range tunnels {
+		switch tunnel.Protocol {
+		case "":
+			problems = append(problems, fmt.Sprintf("Tunnel %q is missing a protocol", tunnel.Name))
+		case "gre":
+			if tunnel.Local == "" || tunnel.Remote == "" {
+				problems = append(problems, fmt.Sprintf("Tunnel %q requires both a local and remote address", tunnel.Name))
+			}
+		case "vxlan":
+			if (tunnel.Local == "") != (tunnel.Remote == "") {
+				problems = append(problems, fmt.Sprintf("Tunnel %q requires both a local and remote address or neither", tunnel.Name))
+			}
+
+			// Match the default used when the tunnel is created.
+			id := tunnel.ID
+			if id == "" {
+				id = "1"
+			}
+
+			otherName, found := vxlanIDs[id]
+			if found {
+				problems = append(problems, fmt.Sprintf("Tunnel %q ID %s is already used by tunnel %q", tunnel.Name, id, otherName))
+				continue
+			}
+
+			vxlanIDs[id] = tunnel.Name
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("Invalid tunnel config: %s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// standardUnderlayMTU is the MTU of a standard Ethernet underlay, used to warn about tunnels whose underlay MTU
+// isn't known.
+const standardUnderlayMTU = 1500
+
+// tunnelOverhead returns the number of bytes the tunnel's encapsulation adds to each packet. Both protocols carry
+// the inner Ethernet frame (14 bytes) inside an outer IP header (20 bytes for IPv4, 40 for IPv6), along with an 8
+// byte UDP and 8 byte VXLAN header for "vxlan" or a 4 byte GRE header for "gre".
+func tunnelOverhead(t tunnel) uint64 {
+	var ipHeader uint64 = 20
+	for _, address := range []string{t.Local, t.Remote, t.Group} {
+		ip := net.ParseIP(address)
+		if ip != nil && ip.To4() == nil {
+			ipHeader = 40
+			break
+		}
+	}
+
+	if t.Protocol == "gre" {
+		return ipHeader + 4 + 14
+	}
+
+	return ipHeader + 8 + 8 + 14
+}
+
+// tunnelUnderlayMTU returns the MTU of the tunnel's host interface. Returns false if the tunnel doesn't specify a
+// host interface or its MTU can't be read.
+func tunnelUnderlayMTU(t tunnel) (uint64, bool) {
+	if t.Interface == "" {
+		return 0, false
+	}
+
+	mtu, err := GetDevMTU(t.Interface)
+	if err != nil {
+		return 0, false
+	}
+
+	return mtu, true
+}
+
+// maxTunnelMTU returns the largest MTU that the supplied tunnels can carry without fragmentation along with the
+// tunnel that limits it. The underlay MTU of each tunnel is obtained using the supplied function and tunnels whose
+// underlay MTU isn't known are skipped. Returns false if there are no tunnels with a known underlay MTU.
+func maxTunnelMTU(tunnels []tunnel, underlayMTU func(t tunnel) (uint64, bool)) (uint64, tunnel, bool) {
+	var maxMTU uint64
+	var limit tunnel
+	found := false
+
+	for _, t := range tunnels {
+		underlay, known := underlayMTU(t)
+		if !known {
+			continue
+		}
+
+		overhead := tunnelOverhead(t)
+
+		var mtu uint64
+		if underlay > overhead {
+			mtu = underlay - overhead
+		}
+
+		if !found || mtu < maxMTU {
+			maxMTU = mtu
+			limit = t
+			found = true
+		}
+	}
+
+	return maxMTU, limit, found
+}
+
+// commonPrefixLength returns the number of leading bits that are the same in both IPs in their 16 byte form.
+func commonPrefixLength(ip1 net.IP, ip2 net.IP) int {
+	a := ip1.To16()
+	b := ip2.To16()
+
+	for i := range a {
+		diff := a[i] ^ b[i]
+		if diff == 0 {
+			continue
+		}
+
+		length := i * 8
+		for diff&0x80 == 0 {
+			length++
+			diff <<= 1
+		}
+
+		return length
+	}
+
+	return len(a) * 8
+}
+
+// tunnelNames returns the sorted names of the tunnels defined in the supplied config.
+func tunnelNames(config map[string]string) []string {
+	tunnels := []string{}
+
+	for k := range config {
+		if !strings.HasPrefix(k, "tunnel.") {
+			continue
+		}
+
+		fields := strings.Split(k, ".")
+		if !shared.StringInSlice(fields[1], tunnels) {
+			tunnels = append(tunnels, fields[1])
+		}
+	}
+
+	sort.Strings(tunnels)
+
+	return tunnels
+}
+
+// isInUseByProxyDevices indicates if any of the NAT proxy devices has a listen or connect address inside one of
+// the supplied subnets.
+func isInUseByProxyDevices(devices deviceConfig.Devices, subnets []*net.IPNet) bool {
+	for _, d := range devices {
+		if d["type"] != "proxy" || !shared.IsTrue(d["nat"]) {
+			continue
+		}
+
+		for _, addr := range []string{d["listen"], d["connect"]} {
+			ip := proxyAddressIP(addr)
+			if ip == nil {
+				continue
+			}
+
+			for _, subnet := range subnets {
+				if subnet.Contains(ip) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// proxyAddressIP returns the IP from a proxy device address in the <type>:<addr>:<port> format, or nil if the
+// address isn't a TCP or UDP address.
+func proxyAddressIP(addr string) net.IP {
+	fields := strings.SplitN(addr, ":", 2)
+	if len(fields) != 2 || !shared.StringInSlice(fields[0], []string{"tcp", "udp"}) {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(fields[1])
+	if err != nil {
+		return nil
+	}
+
+	return net.ParseIP(host)
+}
+
+// parseDHCPv4Ranges parses a comma separated list of DHCPv4 ranges in the FIRST-LAST format. Returns an error if
+// any range is malformed, isn't IPv4, has its start after its end or (if subnet is non-nil) isn't inside subnet.
+func parseDHCPv4Ranges(value string, subnet *net.IPNet) ([]DHCPRange, error) {
+	return parseDHCPRanges(value, subnet, 4)
+}
+
+// parseDHCPv6Ranges parses a comma separated list of DHCPv6 ranges in the FIRST-LAST format. Returns an error if
+// any range is malformed, isn't IPv6, has its start after its end or (if subnet is non-nil) isn't inside subnet.
+func parseDHCPv6Ranges(value string, subnet *net.IPNet) ([]DHCPRange, error) {
+	return parseDHCPRanges(value, subnet, 6)
+}
+
+// parseDHCPRanges parses a comma separated list of DHCP ranges of the specified IP family.
+func parseDHCPRanges(value string, subnet *net.IPNet, family int) ([]DHCPRange, error) {
+	dhcpRanges := make([]DHCPRange, 0)
+	if value == "" {
+		return dhcpRanges, nil
+	}
+
+	// parseIP parses an IP and checks it belongs to the requested family.
+	parseIP := func(value string) net.IP {
+		ip := net.ParseIP(strings.TrimSpace(value))
+		if ip == nil {
+			return nil
+		}
+
+		if family == 4 {
+			return ip.To4()
+		}
+
+		if ip.To4() != nil {
+			return nil
+		}
+
+		return ip.To16()
+	}
+
+	for _, r := range strings.Split(value, ",") {
+		r = strings.TrimSpace(r)
+		parts := strings.SplitN(r, "-", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("IP range %q must be in the format FIRST-LAST", r)
+		}
+
+		startIP := parseIP(parts[0])
+		if startIP == nil {
+			return nil, fmt.Errorf("Start IP %q of range %q is not a valid IPv%d address", parts[0], r, family)
+		}
+
+		endIP := parseIP(parts[1])
+		if endIP == nil {
+			return nil, fmt.Errorf("End IP %q of range %q is not a valid IPv%d address", parts[1], r, family)
+		}
+
+		if bytes.Compare(startIP, endIP) > 0 {
+			return nil, fmt.Errorf("Start IP of range %q is after its end IP", r)
+		}
+
+		// Link-local addresses can't be allocated using DHCP.
+		if family == 6 && (startIP.IsLinkLocalUnicast() || endIP.IsLinkLocalUnicast()) {
+			return nil, fmt.Errorf("IP range %q must not contain link-local addresses", r)
+		}
+
+		if subnet != nil && (!subnet.Contains(startIP) || !subnet.Contains(endIP)) {
+			return nil, fmt.Errorf("IP range %q is not within the network subnet %q", r, subnet.String())
+		}
+
+		dhcpRanges = append(dhcpRanges, DHCPRange{
+			Start: startIP,
+			End:   endIP,
+		})
+	}
+
+	return dhcpRanges, nil
+}
+
+// dhcpRangesSize returns the number of addresses in the supplied ranges, excluding the gateway address if it falls
+// inside any of them.
+func dhcpRangesSize(dhcpRanges []DHCPRange, gateway net.IP) *big.Int {
+	size := big.NewInt(0)
+	for _, r := range dhcpRanges {
+		startIP := big.NewInt(0).SetBytes(r.Start.To16())
+		endIP := big.NewInt(0).SetBytes(r.End.To16())
+
+		size.Add(size, endIP.Sub(endIP, startIP))
+		size.Add(size, big.NewInt(1))
+
+		if gateway != nil && bytes.Compare(gateway.To16(), r.Start.To16()) >= 0 && bytes.Compare(gateway.To16(), r.End.To16()) <= 0 {
+			size.Sub(size, big.NewInt(1))
+		}
+	}
+
+	return size
+}
+
+// GetIP returns a net.IP representing the IP belonging to the subnet for the host number supplied.
+func GetIP(subnet *net.IPNet, host int64) net.IP {
+	// Convert IP to a big int.
+	bigIP := big.NewInt(0)
+	bigIP.SetBytes(subnet.IP.To16())
+
+	// Deal with negative offsets.
+	bigHost := big.NewInt(host)
+	bigCount := big.NewInt(host)
+	if host < 0 {
+		mask, size := subnet.Mask.Size()
+
+		bigHosts := big.NewFloat(0)
+		bigHosts.SetFloat64((math.Pow(2, float64(size-mask))))
+		bigHostsInt, _ := bigHosts.Int(nil)
+
+		bigCount.Set(bigHostsInt)
+		bigCount.Add(bigCount, bigHost)
+	}
+
+	// Get the new IP int.
+	bigIP.Add(bigIP, bigCount)
+
+	// Generate an IPv6.
+	if subnet.IP.To4() == nil {
+		newIP := bigIP.Bytes()
+		return newIP
+	}
+
+	// Generate an IPv4.
+	newIP := make(net.IP, 4)
+	binary.BigEndian.PutUint32(newIP, uint32(bigIP.Int64()))
+	return newIP
+}
+
+// IsNativeBridge returns whether the bridge name specified is a Linux native bridge.
+func IsNativeBridge(bridgeName string) bool {
+	return shared.PathExists(fmt.Sprintf("/sys/class/net/%s/bridge", bridgeName))
+}
+
+// AttachInterface attaches an interface to a bridge.
+func AttachInterface(bridgeName string, devName string) error {
+	if IsNativeBridge(bridgeName) {
+		_, err := shared.RunCommand("ip", "link", "set", "dev", devName, "master", bridgeName)
+		if err != nil {
+			return err
+		}
+	} else {
+		ovs := openvswitch.NewOVS()
+		err := ovs.BridgeAddPort(bridgeName, devName)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DetachInterface detaches an interface from a bridge.
+func DetachInterface(bridgeName string, devName string) error {
+	if IsNativeBridge(bridgeName) {
+		_, err := shared.RunCommand("ip", "link", "set", "dev", devName, "nomaster")
+		if err != nil {
+			return err
+		}
+	} else {
+		ovs := openvswitch.NewOVS()
+		err := ovs.BridgeDeletePort(bridgeName, devName)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetDevMTU retrieves the current MTU setting for a named network device.
+func GetDevMTU(devName string) (uint64, error) {
+	content, err := ioutil.ReadFile(fmt.Sprintf("/sys/class/net/%s/mtu", devName))
+	if err != nil {
+		return 0, err
+	}
+
+	// Parse value
+	mtu, err := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 32)
+	if err != nil {
+		return 0, err
+	}
+
+	return mtu, nil
+}
+
+// DefaultGatewaySubnetV4 returns subnet of default gateway interface.
+func DefaultGatewaySubnetV4() (*net.IPNet, string, error) {
+	file, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+
+	ifaceName := ""
+
+	scanner := bufio.NewReader(file)
+	for {
+		line, _, err := scanner.ReadLine()
+		if err != nil {
+			break
+		}
+
+		fields := strings.Fields(string(line))
+
+		if fields[1] == "00000000" && fields[7] == "00000000" {
+			ifaceName = fields[0]
+			break
+		}
+	}
+
+	if ifaceName == "" {
+		return nil, "", fmt.Errorf("No default gateway for IPv4")
+	}
+
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return nil, "", err
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var subnet *net.IPNet
+
+	for _, addr := range addrs {
+		addrIP, addrNet, err := net.ParseCIDR(addr.String())
+		if err != nil {
+			return nil, "", err
+		}
+
+		if addrIP.To4() == nil {
+			continue
+		}
+
+		if subnet != nil {
+			return nil, "", fmt.Errorf("More than one IPv4 subnet on default interface")
+		}
+
+		subnet = addrNet
+	}
+
+	if subnet == nil {
+		return nil, "", fmt.Errorf("No IPv4 subnet on default interface")
+	}
+
+	return subnet, ifaceName, nil
+}
+
+// UpdateDNSMasqStatic rebuilds the DNSMasq static allocations.
+func UpdateDNSMasqStatic(s *state.State, networkName string) error {
+	// We don't want to race with ourselves here.
+	dnsmasq.ConfigMutex.Lock()
+	defer dnsmasq.ConfigMutex.Unlock()
+
+	// Get all the networks.
+	var networks []string
+	if networkName == "" {
+		var err error
+		networks, err = s.Cluster.GetNetworks()
+		if err != nil {
+			return err
+		}
+	} else {
+		networks = []string{networkName}
+	}
+
+	// Get all the instances.
+	insts, err := instance.LoadNodeAll(s, instancetype.Any)
+	if err != nil {
+		return err
+	}
+
+	// Build a list of dhcp host entries.
+	entries := map[string][][]string{}
+	for _, inst := range insts {
+		// Go through all its devices (including profiles).
+		for k, d := range inst.ExpandedDevices() {
+			// Skip uninteresting entries.
+			if d["type"] != "nic" {
+				continue
+			}
+
+			nicType, err := nictype.NICType(s, d)
+			if err != nil || nicType != "bridged" {
+				continue
+			}
+
+			// Temporarily populate parent from network setting if used.
+			if d["network"] != "" {
+				d["parent"] = d["network"]
+			}
+
+			// Skip devices not connected to managed networks.
+			if !shared.StringInSlice(d["parent"], networks) {
+				continue
+			}
+
+			// Fill in the hwaddr from volatile.
+			d, err = inst.FillNetworkDevice(k, d)
+			if err != nil {
+				continue
+			}
+
+			// Add the new host entries.
+			_, ok := entries[d["parent"]]
+			if !ok {
+				entries[d["parent"]] = [][]string{}
+			}
+
+			if (shared.IsTrue(d["security.ipv4_filtering"]) && d["ipv4.address"] == "") || (shared.IsTrue(d["security.ipv6_filtering"]) && d["ipv6.address"] == "") {
+				curIPv4, curIPv6, err := dnsmasq.DHCPStaticIPs(d["parent"], inst.Project(), inst.Name())
+				if err != nil && !os.IsNotExist(err) {
+					return err
+				}
+
+				if d["ipv4.address"] == "" && curIPv4.IP != nil {
+					d["ipv4.address"] = curIPv4.IP.String()
+				}
+
+				if d["ipv6.address"] == "" && curIPv6.IP != nil {
+					d["ipv6.address"] = curIPv6.IP.String()
+				}
+			}
+
+			entries[d["parent"]] = append(entries[d["parent"]], []string{d["hwaddr"], inst.Project(), inst.Name(), d["ipv4.address"], d["ipv6.address"]})
+		}
+	}
+
+	// Update the host files.
+	for _, network := range networks {
+		entries, _ := entries[network]
+
+		// Skip networks we don't manage (or don't have DHCP enabled).
+		if !shared.PathExists(shared.VarPath("networks", network, "dnsmasq.pid")) {
+			continue
+		}
+
+		n, err := LoadByName(s, network)
+		if err != nil {
+			return err
+		}
+		config := n.Config()
+
+		// Wipe everything clean.
+		files, err := ioutil.ReadDir(shared.VarPath("networks", network, "dnsmasq.hosts"))
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range files {
 			err = os.Remove(shared.VarPath("networks", network, "dnsmasq.hosts", entry.Name()))
 			if err != nil {
-				return err
+				return err
+			}
+		}
+
+		// Apply the changes.
+		for entryIdx, entry := range entries {
+			hwaddr := entry[0]
+			projectName := entry[1]
+			cName := entry[2]
+			ipv4Address := entry[3]
+			ipv6Address := entry[4]
+			line := hwaddr
+
+			// Look for duplicates.
+			duplicate := false
+			for iIdx, i := range entries {
+				if project.Instance(entry[1], entry[2]) == project.Instance(i[1], i[2]) {
+					// Skip ourselves.
+					continue
+				}
+
+				if entry[0] == i[0] {
+					// Find broken configurations
+					logger.Errorf("Duplicate MAC detected: %s and %s", project.Instance(entry[1], entry[2]), project.Instance(i[1], i[2]))
+				}
+
+				if i[3] == "" && i[4] == "" {
+					// Skip unconfigured.
+					continue
+				}
+
+				if entry[3] == i[3] && entry[4] == i[4] {
+					// Find identical containers (copies with static configuration).
+					if entryIdx > iIdx {
+						duplicate = true
+					} else {
+						line = fmt.Sprintf("%s,%s", line, i[0])
+						logger.Debugf("Found containers with duplicate IPv4/IPv6: %s and %s", project.Instance(entry[1], entry[2]), project.Instance(i[1], i[2]))
+					}
+				}
+			}
+
+			if duplicate {
+				continue
+			}
+
+			// Generate the dhcp-host line.
+			err := dnsmasq.UpdateStaticEntry(network, projectName, cName, config, hwaddr, ipv4Address, ipv6Address)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Signal dnsmasq.
+		err = dnsmasq.Kill(network, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ForkdnsServersList reads the server list file and returns the list as a slice.
+func ForkdnsServersList(networkName string) ([]string, error) {
+	servers := []string{}
+	file, err := os.Open(shared.VarPath("networks", networkName, ForkdnsServersListPath, "/", ForkdnsServersListFile))
+	if err != nil {
+		return servers, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 {
+			servers = append(servers, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return servers, err
+	}
+
+	return servers, nil
+}
+
+func fillAuto(config map[string]string) error {
+	if config["fan.underlay_subnet"] == "auto" {
+		subnet, _, err := DefaultGatewaySubnetV4()
+		if err != nil {
+			return err
+		}
+
+		config["fan.underlay_subnet"] = subnet.String()
+	}
+
+	return nil
+}
+
+// randomIntn returns a random number in [0,n). It is a variable so that tests can control the subnets generated.
+var randomIntn = rand.Intn
+
+// randomSubnetV4 returns a random /24 subnet within 10.0.0.0/8 that doesn't overlap any of the used subnets.
+// Subnets that are already routed or that respond to pings are also skipped.
+func randomSubnetV4(used []*net.IPNet) (*net.IPNet, error) {
+	for i := 0; i < 100; i++ {
+		subnet := &net.IPNet{
+			IP:   net.IPv4(10, byte(randomIntn(256)), byte(randomIntn(256)), 0).To4(),
+			Mask: net.CIDRMask(24, 32),
+		}
+
+		overlap := false
+		for _, usedSubnet := range used {
+			if subnetsOverlap(subnet, usedSubnet) {
+				overlap = true
+				break
+			}
+		}
+
+		if overlap {
+			continue
+		}
+
+		if inRoutingTable(subnet) {
+			continue
+		}
+
+		if pingSubnet(subnet) {
+			continue
+		}
+
+		return subnet, nil
+	}
+
+	return nil, fmt.Errorf("Failed to automatically find an unused IPv4 subnet, manual configuration required")
+}
+
+func inRoutingTable(subnet *net.IPNet) bool {
+	filename := "route"
+	if subnet.IP.To4() == nil {
+		filename = "ipv6_route"
+	}
+
+	file, err := os.Open(fmt.Sprintf("/proc/net/%s", filename))
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewReader(file)
+	for {
+		line, _, err := scanner.ReadLine()
+		if err != nil {
+			break
+		}
+
+		fields := strings.Fields(string(line))
+
+		// Get the IP
+		var ip net.IP
+		if filename == "ipv6_route" {
+			ip, err = hex.DecodeString(fields[0])
+			if err != nil {
+				continue
+			}
+		} else {
+			bytes, err := hex.DecodeString(fields[1])
+			if err != nil {
+				continue
+			}
+
+			ip = net.IPv4(bytes[3], bytes[2], bytes[1], bytes[0])
+		}
+
+		// Get the mask
+		var mask net.IPMask
+		if filename == "ipv6_route" {
+			size, err := strconv.ParseInt(fmt.Sprintf("0x%s", fields[1]), 0, 64)
+			if err != nil {
+				continue
+			}
+
+			mask = net.CIDRMask(int(size), 128)
+		} else {
+			bytes, err := hex.DecodeString(fields[7])
+			if err != nil {
+				continue
+			}
+
+			mask = net.IPv4Mask(bytes[3], bytes[2], bytes[1], bytes[0])
+		}
+
+		// Generate a new network
+		lineNet := net.IPNet{IP: ip, Mask: mask}
+
+		// Ignore default gateway
+		if lineNet.IP.Equal(net.ParseIP("::")) {
+			continue
+		}
+
+		if lineNet.IP.Equal(net.ParseIP("0.0.0.0")) {
+			continue
+		}
+
+		// Check if we have a route to our new subnet
+		if lineNet.Contains(subnet.IP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func pingSubnet(subnet *net.IPNet) bool {
+	var fail bool
+	var failLock sync.Mutex
+	var wgChecks sync.WaitGroup
+
+	ping := func(ip net.IP) {
+		defer wgChecks.Done()
+
+		err := pingHost(ip, time.Second)
+		if err != nil {
+			// Remote didn't answer
+			return
+		}
+
+		// Remote answered
+		failLock.Lock()
+		fail = true
+		failLock.Unlock()
+	}
+
+	poke := func(ip net.IP) {
+		defer wgChecks.Done()
+
+		addr := fmt.Sprintf("%s:22", ip.String())
+		if ip.To4() == nil {
+			addr = fmt.Sprintf("[%s]:22", ip.String())
+		}
+
+		_, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			// Remote answered
+			failLock.Lock()
+			fail = true
+			failLock.Unlock()
+			return
+		}
+	}
+
+	// Ping first IP
+	wgChecks.Add(1)
+	go ping(GetIP(subnet, 1))
+
+	// Poke port on first IP
+	wgChecks.Add(1)
+	go poke(GetIP(subnet, 1))
+
+	// Ping check
+	if subnet.IP.To4() != nil {
+		// Ping last IP
+		wgChecks.Add(1)
+		go ping(GetIP(subnet, -2))
+
+		// Poke port on last IP
+		wgChecks.Add(1)
+		go poke(GetIP(subnet, -2))
+	}
+
+	wgChecks.Wait()
+
+	return fail
+}
+
+// GetHostDevice returns the interface name to use for a combination of parent device name and VLAN ID.
+// If no vlan ID supplied, parent name is returned unmodified. If non-empty VLAN ID is supplied then it will look
+// for an existing VLAN device and return that, otherwise it will return the default "parent.vlan" format as name.
+func GetHostDevice(parent string, vlan string) string {
+	// If no VLAN, just use the raw device
+	if vlan == "" {
+		return parent
+	}
+
+	// If no VLANs are configured, use the default pattern
+	defaultVlan := fmt.Sprintf("%s.%s", parent, vlan)
+	if !shared.PathExists("/proc/net/vlan/config") {
+		return defaultVlan
+	}
+
+	// Look for an existing VLAN
+	f, err := os.Open("/proc/net/vlan/config")
+	if err != nil {
+		return defaultVlan
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Only grab the lines we're interested in
+		s := strings.Split(scanner.Text(), "|")
+		if len(s) != 3 {
+			continue
+		}
+
+		vlanIface := strings.TrimSpace(s[0])
+		vlanID := strings.TrimSpace(s[1])
+		vlanParent := strings.TrimSpace(s[2])
+
+		if vlanParent == parent && vlanID == vlan {
+			return vlanIface
+		}
+	}
+
+	// Return the default pattern
+	return defaultVlan
+}
+
+// GetLeaseAddresses returns the lease addresses for a network and hwaddr.
+func GetLeaseAddresses(s *state.State, networkName string, hwaddr string) ([]api.InstanceStateNetworkAddress, error) {
+	addresses := []api.InstanceStateNetworkAddress{}
+
+	// Look for neighborhood entries for IPv6.
+	out, err := shared.RunCommand("ip", "-6", "neigh", "show", "dev", networkName)
+	if err == nil {
+		for _, line := range strings.Split(out, "\n") {
+			// Split fields and early validation.
+			fields := strings.Fields(line)
+			if len(fields) != 4 {
+				continue
+			}
+
+			if fields[2] != hwaddr {
+				continue
+			}
+
+			// Prepare the entry.
+			addr := api.InstanceStateNetworkAddress{}
+			addr.Address = fields[0]
+			addr.Family = "inet6"
+
+			if strings.HasPrefix(fields[0], "fe80::") {
+				addr.Scope = "link"
+			} else {
+				addr.Scope = "global"
 			}
+
+			addresses = append(addresses, addr)
 		}
+	}
 
-		// Apply the changes.
-		for entryIdx, entry := range entries {
-			hwaddr := entry[0]
-			projectName := entry[1]
-			cName := entry[2]
-			ipv4Address := entry[3]
-			ipv6Address := entry[4]
-			line := hwaddr
+	// Look for DHCP leases.
+	leaseFile := shared.VarPath("networks", networkName, "dnsmasq.leases")
+	if !shared.PathExists(leaseFile) {
+		return addresses, nil
+	}
 
-			// Look for duplicates.
-			duplicate := false
-			for iIdx, i := range entries {
-				if project.Instance(entry[1], entry[2]) == project.Instance(i[1], i[2]) {
-					// Skip ourselves.
-					continue
-				}
+	dbInfo, err := LoadByName(s, networkName)
+	if err != nil {
+		return nil, err
+	}
 
-				if entry[0] == i[0] {
-					// Find broken configurations
-					logger.Errorf("Duplicate MAC detected: %s and %s", project.Instance(entry[1], entry[2]), project.Instance(i[1], i[2]))
+	content, err := ioutil.ReadFile(leaseFile)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, lease := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(lease)
+		if len(fields) < 5 {
+			continue
+		}
+
+		// Parse the MAC
+		mac := GetMACSlice(fields[1])
+		macStr := strings.Join(mac, ":")
+
+		if len(macStr) < 17 && fields[4] != "" {
+			macStr = fields[4][len(fields[4])-17:]
+		}
+
+		if macStr != hwaddr {
+			continue
+		}
+
+		// Parse the IP
+		addr := api.InstanceStateNetworkAddress{
+			Address: fields[2],
+			Scope:   "global",
+		}
+
+		ip := net.ParseIP(addr.Address)
+		if ip == nil {
+			continue
+		}
+
+		if ip.To4() != nil {
+			addr.Family = "inet"
+
+			_, subnet, _ := net.ParseCIDR(dbInfo.Config()["ipv4.address"])
+			if subnet != nil {
+				mask, _ := subnet.Mask.Size()
+				addr.Netmask = fmt.Sprintf("%d", mask)
+			}
+		} else {
+			addr.Family = "inet6"
+
+			_, subnet, _ := net.ParseCIDR(dbInfo.Config()["ipv6.address"])
+			if subnet != nil {
+				mask, _ := subnet.Mask.Size()
+				addr.Netmask = fmt.Sprintf("%d", mask)
+			}
+		}
+
+		addresses = append(addresses, addr)
+	}
+
+	return addresses, nil
+}
+
+// GetMACSlice parses MAC address.
+func GetMACSlice(hwaddr string) []string {
+	var buf []string
+
+	if !strings.Contains(hwaddr, ":") {
+		if s, err := strconv.ParseUint(hwaddr, 10, 64); err == nil {
+			hwaddr = fmt.Sprintln(fmt.Sprintf("%x", s))
+			var tuple string
+			for i, r := range hwaddr {
+				tuple = tuple + string(r)
+				if i > 0 && (i+1)%2 == 0 {
+					buf = append(buf, tuple)
+					tuple = ""
 				}
+			}
+		}
+	} else {
+		buf = strings.Split(strings.ToLower(hwaddr), ":")
+	}
+
+	return buf
+}
+
+// sortIPs returns the supplied IPs with duplicates removed, sorted with IPv4 addresses before IPv6 addresses.
+func sortIPs(ips []net.IP) []net.IP {
+	sorted := []net.IP{}
+	for _, ip := range ips {
+		found := false
+		for _, existing := range sorted {
+			if existing.Equal(ip) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			sorted = append(sorted, ip)
+		}
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		iv4 := sorted[i].To4() != nil
+		jv4 := sorted[j].To4() != nil
+		if iv4 != jv4 {
+			return iv4
+		}
+
+		return bytes.Compare(sorted[i].To16(), sorted[j].To16()) < 0
+	})
+
+	return sorted
+}
+
+// filterDHCPv4Leases returns the IPv4 leases from the supplied leases.
+func filterDHCPv4Leases(allLeases []api.NetworkLease) []api.NetworkLease {
+	leases := []api.NetworkLease{}
+	for _, lease := range allLeases {
+		if net.ParseIP(lease.Address).To4() != nil {
+			leases = append(leases, lease)
+		}
+	}
+
+	return leases
+}
+
+// parseDHCPLeases parses the content of a dnsmasq lease file and returns the IPv4 and IPv6 leases that haven't
+// expired at the supplied time. Each lease line is in the "EXPIRY MAC IP HOSTNAME CLIENTID" format (IPv6 leases
+// have an IAID instead of the MAC, which is then taken from the end of the client DUID), where an expiry of 0 means
+// the lease never expires and a hostname of "*" means the client didn't supply one.
+func parseDHCPLeases(content string, now time.Time) []api.NetworkLease {
+	leases := []api.NetworkLease{}
+
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+
+		ip := net.ParseIP(fields[2])
+		if ip == nil {
+			continue
+		}
+
+		expiry, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		lease := api.NetworkLease{
+			Address: ip.String(),
+			Hwaddr:  dhcpLeaseMAC(fields),
+			Type:    "dynamic",
+		}
+
+		if expiry > 0 {
+			lease.Expiry = time.Unix(expiry, 0)
+			if !lease.Expiry.After(now) {
+				continue
+			}
+		}
+
+		if fields[3] != "*" {
+			lease.Hostname = fields[3]
+		}
+
+		leases = append(leases, lease)
+	}
+
+	return leases
+}
+
+// usesIPv4Firewall returns whether network config will need to use the IPv4 firewall.
+func usesIPv4Firewall(netConfig map[string]string) bool {
+	if netConfig == nil {
+		return false
+	}
+
+	if netConfig["ipv4.firewall"] == "" || shared.IsTrue(netConfig["ipv4.firewall"]) {
+		return true
+	}
+
+	if shared.IsTrue(netConfig["ipv4.nat"]) {
+		return true
+	}
+
+	return false
+}
+
+// usesIPv6Firewall returns whether network config will need to use the IPv6 firewall.
+func usesIPv6Firewall(netConfig map[string]string) bool {
+	if netConfig == nil {
+		return false
+	}
+
+	if netConfig["ipv6.firewall"] == "" || shared.IsTrue(netConfig["ipv6.firewall"]) {
+		return true
+	}
+
+	if shared.IsTrue(netConfig["ipv6.nat"]) {
+		return true
+	}
+
+	return false
+}
+
+// BridgeVLANFilteringStatus returns whether VLAN filtering is enabled on a bridge interface.
+func BridgeVLANFilteringStatus(interfaceName string) (string, error) {
+	content, err := ioutil.ReadFile(fmt.Sprintf("/sys/class/net/%s/bridge/vlan_filtering", interfaceName))
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed getting bridge VLAN status for %q", interfaceName)
+	}
+
+	return strings.TrimSpace(fmt.Sprintf("%s", content)), nil
+}
+
+// parseIPv4List parses a comma separated list of IPv4 addresses and FIRST-LAST ranges into a list of ranges.
+// Single addresses are returned as a range that starts and ends with that address.
+func parseIPv4List(value string) ([]DHCPRange, error) {
+	ranges := []DHCPRange{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if strings.Contains(entry, "-") {
+			entryRanges, err := parseDHCPv4Ranges(entry, nil)
+			if err != nil {
+				return nil, err
+			}
+
+			ranges = append(ranges, entryRanges...)
+			continue
+		}
+
+		ip := net.ParseIP(entry).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("%q is not a valid IPv4 address", entry)
+		}
+
+		ranges = append(ranges, DHCPRange{Start: ip, End: ip})
+	}
+
+	return ranges, nil
+}
+
+// ipAdd returns the IP that is offset from the supplied IP by delta. IPv4 addresses are returned in 4 byte form.
+func ipAdd(ip net.IP, delta int64) net.IP {
+	ipInt := big.NewInt(0).SetBytes(ip.To16())
+	ipInt.Add(ipInt, big.NewInt(delta))
+
+	ipBytes := ipInt.Bytes()
+	newIP := make(net.IP, net.IPv6len)
+	copy(newIP[net.IPv6len-len(ipBytes):], ipBytes)
+
+	if ip.To4() != nil {
+		return newIP.To4()
+	}
+
+	return newIP
+}
+
+// subnetHostOffset returns the offset of the IP from the start of the subnet and the total number of addresses in
+// the subnet.
+func subnetHostOffset(ip net.IP, subnet *net.IPNet) (*big.Int, *big.Int) {
+	offset := big.NewInt(0).SetBytes(ip.To16())
+	offset.Sub(offset, big.NewInt(0).SetBytes(subnet.IP.To16()))
+
+	ones, bits := subnet.Mask.Size()
+	size := big.NewInt(0).Lsh(big.NewInt(1), uint(bits-ones))
+
+	return offset, size
+}
+
+// subnetIP returns the IP at the supplied offset from the start of the subnet. IPv4 addresses are returned in 4
+// byte form.
+func subnetIP(subnet *net.IPNet, offset *big.Int) net.IP {
+	ipInt := big.NewInt(0).SetBytes(subnet.IP.To16())
+	ipInt.Add(ipInt, offset)
+
+	ipBytes := ipInt.Bytes()
+	newIP := make(net.IP, net.IPv6len)
+	copy(newIP[net.IPv6len-len(ipBytes):], ipBytes)
+
+	if subnet.IP.To4() != nil {
+		return newIP.To4()
+	}
+
+	return newIP
+}
+
+// renumberIP returns the IP in newSubnet with the same host part as the supplied IP has in oldSubnet. Returns an
+// error if the IP isn't in oldSubnet or its host part doesn't fit in newSubnet.
+func renumberIP(ip net.IP, oldSubnet *net.IPNet, newSubnet *net.IPNet) (net.IP, error) {
+	if !oldSubnet.Contains(ip) {
+		return nil, fmt.Errorf("IP %q is not within subnet %q", ip.String(), oldSubnet.String())
+	}
+
+	offset, _ := subnetHostOffset(ip, oldSubnet)
+	_, newSize := subnetHostOffset(newSubnet.IP, newSubnet)
+	if offset.Cmp(newSize) >= 0 {
+		return nil, fmt.Errorf("IP %q can't be mapped into subnet %q", ip.String(), newSubnet.String())
+	}
+
+	return subnetIP(newSubnet, offset), nil
+}
+
+// scaleDHCPRanges returns the DHCP ranges moved from oldSubnet into newSubnet with their start and end positions
+// scaled in proportion to the size of the subnets. For IPv4 the network and broadcast addresses of the new subnet
+// are excluded.
+func scaleDHCPRanges(dhcpRanges []DHCPRange, oldSubnet *net.IPNet, newSubnet *net.IPNet) []DHCPRange {
+	_, oldSize := subnetHostOffset(oldSubnet.IP, oldSubnet)
+	_, newSize := subnetHostOffset(newSubnet.IP, newSubnet)
+
+	first := big.NewInt(0)
+	last := big.NewInt(0).Sub(newSize, big.NewInt(1))
+	if newSubnet.IP.To4() != nil && newSize.Cmp(big.NewInt(4)) >= 0 {
+		first.SetInt64(1)
+		last.Sub(last, big.NewInt(1))
+	}
+
+	// The end of a range is scaled from the end of its last address so that the range keeps covering it.
+	scale := func(ip net.IP, end bool) net.IP {
+		offset, _ := subnetHostOffset(ip, oldSubnet)
+		if end {
+			offset.Add(offset, big.NewInt(1))
+		}
+
+		offset.Mul(offset, newSize)
+		offset.Div(offset, oldSize)
+
+		if end {
+			offset.Sub(offset, big.NewInt(1))
+		}
+
+		if offset.Cmp(first) < 0 {
+			offset.Set(first)
+		} else if offset.Cmp(last) > 0 {
+			offset.Set(last)
+		}
+
+		return subnetIP(newSubnet, offset)
+	}
 
-				if i[3] == "" && i[4] == "" {
-					// Skip unconfigured.
-					continue
-				}
+	scaled := make([]DHCPRange, 0, len(dhcpRanges))
+	for _, dhcpRange := range dhcpRanges {
+		scaled = append(scaled, DHCPRange{Start: scale(dhcpRange.Start, false), End: scale(dhcpRange.End, true)})
+	}
 
-				if entry[3] == i[3] && entry[4] == i[4] {
-					// Find identical containers (copies with static configuration).
-					if entryIdx > iIdx {
-						duplicate = true
-					} else {
-						line = fmt.Sprintf("%s,%s", line, i[0])
-						logger.Debugf("Found containers with duplicate IPv4/IPv6: %s and %s", project.Instance(entry[1], entry[2]), project.Instance(i[1], i[2]))
-					}
-				}
-			}
+	return scaled
+}
 
-			if duplicate {
+// subtractDHCPRanges returns the supplied ranges with the addresses in the exclude ranges removed. A range that
+// has a section removed from its middle is split into two ranges, and ranges that are entirely excluded are dropped.
+func subtractDHCPRanges(ranges []DHCPRange, excludeRanges []DHCPRange) []DHCPRange {
+	result := ranges
+	for _, exclude := range excludeRanges {
+		excludeStart := exclude.Start.To16()
+		excludeEnd := exclude.End.To16()
+
+		remaining := []DHCPRange{}
+		for _, r := range result {
+			start := r.Start.To16()
+			end := r.End.To16()
+
+			// No overlap, keep the range as is.
+			if bytes.Compare(excludeEnd, start) < 0 || bytes.Compare(excludeStart, end) > 0 {
+				remaining = append(remaining, r)
 				continue
 			}
 
-			// Generate the dhcp-host line.
-			err := dnsmasq.UpdateStaticEntry(network, projectName, cName, config, hwaddr, ipv4Address, ipv6Address)
-			if err != nil {
-				return err
+			// Keep the part of the range before the excluded addresses.
+			if bytes.Compare(excludeStart, start) > 0 {
+				remaining = append(remaining, DHCPRange{Start: r.Start, End: ipAdd(exclude.Start, -1)})
 			}
-		}
 
-		// Signal dnsmasq.
-		err = dnsmasq.Kill(network, true)
-		if err != nil {
-			return err
+			// Keep the part of the range after the excluded addresses.
+			if bytes.Compare(excludeEnd, end) < 0 {
+				remaining = append(remaining, DHCPRange{Start: ipAdd(exclude.End, 1), End: r.End})
+			}
 		}
+
+		result = remaining
 	}
 
-	return nil
+	return result
 }
 
-// ForkdnsServersList reads the server list file and returns the list as a slice.
-func ForkdnsServersList(networkName string) ([]string, error) {
-	servers := []string{}
-	file, err := os.Open(shared.VarPath("networks", networkName, ForkdnsServersListPath, "/", ForkdnsServersListFile))
-	if err != nil {
-		return servers, err
+// dbNetworkType converts a network type name to its database type code.
+func dbNetworkType(netType string) (db.NetworkType, error) {
+	switch netType {
+	case "bridge":
+		return db.NetworkTypeBridge, nil
+	case "macvlan":
+		return db.NetworkTypeMacvlan, nil
+	case "sriov":
+		return db.NetworkTypeSriov, nil
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		fields := strings.Fields(scanner.Text())
-		if len(fields) > 0 {
-			servers = append(servers, fields[0])
-		}
+	return -1, ErrUnknownDriver
+}
+
+// validBitRate validates a bit rate value with a unit suffix (such as "10Mbit"). Empty value is allowed.
+func validBitRate(value string) error {
+	if value == "" {
+		return nil
 	}
-	if err := scanner.Err(); err != nil {
-		return servers, err
+
+	_, err := units.ParseBitSizeString(value)
+	if err != nil {
+		return err
 	}
 
-	return servers, nil
+	return nil
 }
 
-func fillAuto(config map[string]string) error {
-	if config["ipv4.address"] == "auto" {
-		subnet, err := randomSubnetV4()
+// subnetsOverlap indicates whether the two supplied subnets overlap (including one containing the other).
+func subnetsOverlap(subnet1 *net.IPNet, subnet2 *net.IPNet) bool {
+	return subnet1.Contains(subnet2.IP) || subnet2.Contains(subnet1.IP)
+}
+
+// ValidateSubnets checks that the IPv4 and IPv6 subnets in the config of the named network don't overlap with those
+// of other networks. As this loads every network, it is only run when a network is created or updated rather than
+// as part of validating its config.
+func ValidateSubnets(s *state.State, networkName string, config map[string]string) error {
+	for _, key := range []string{"ipv4.address", "ipv6.address"} {
+		err := checkSubnetOverlap(s, config[key], networkName)
 		if err != nil {
-			return err
+			return errors.Wrapf(err, "Invalid value for network %q option %q", networkName, key)
 		}
+	}
+
+	return nil
+}
 
-		config["ipv4.address"] = subnet
+// checkSubnetOverlap checks that the subnet of the supplied CIDR address doesn't overlap with the subnet of the same
+// address family of any other managed network. The network named by excludeNetwork is skipped so that a network
+// being updated isn't compared against itself. Non-CIDR values (such as "none" or "auto") are ignored.
+func checkSubnetOverlap(s *state.State, newCIDR string, excludeNetwork string) error {
+	_, newSubnet, err := net.ParseCIDR(newCIDR)
+	if err != nil {
+		return nil
 	}
 
-	if config["ipv6.address"] == "auto" {
-		subnet, err := randomSubnetV6()
-		if err != nil {
-			return err
-		}
+	key := "ipv6.address"
+	if newSubnet.IP.To4() != nil {
+		key = "ipv4.address"
+	}
 
-		config["ipv6.address"] = subnet
+	networks, err := s.Cluster.GetNetworks()
+	if err != nil {
+		return err
 	}
 
-	if config["fan.underlay_subnet"] == "auto" {
-		subnet, _, err := DefaultGatewaySubnetV4()
+	for _, name := range networks {
+		if name == excludeNetwork {
+			continue
+		}
+
+		_, netInfo, err := s.Cluster.GetNetworkInAnyState(name)
 		if err != nil {
 			return err
 		}
 
-		config["fan.underlay_subnet"] = subnet.String()
+		_, subnet, err := net.ParseCIDR(netInfo.Config[key])
+		if err != nil {
+			continue // No concrete address configured for this family.
+		}
+
+		if subnetsOverlap(newSubnet, subnet) {
+			return fmt.Errorf("Subnet %q overlaps with subnet %q of network %q", newSubnet.String(), subnet.String(), name)
+		}
 	}
 
 	return nil
 }
 
-func randomSubnetV4() (string, error) {
-	for i := 0; i < 100; i++ {
-		cidr := fmt.Sprintf("10.%d.%d.1/24", rand.Intn(255), rand.Intn(255))
-		_, subnet, err := net.ParseCIDR(cidr)
-		if err != nil {
-			continue
+// validateStaticLeases checks that each static lease IP is within the subnet (if supplied), isn't within one of the
+// dynamic DHCP ranges and isn't used by another lease. All conflicts found are listed in the returned error.
+func validateStaticLeases(leases []StaticLease, subnet *net.IPNet, dhcpRanges []DHCPRange) error {
+	conflicts := []string{}
+	seen := make(map[string]string, len(leases))
+
+	for _, lease := range leases {
+		ip := lease.IP.String()
+
+		if subnet != nil && !subnet.Contains(lease.IP) {
+			conflicts = append(conflicts, fmt.Sprintf("%s (%s) is not within the network subnet %q", ip, lease.Hostname, subnet.String()))
 		}
 
-		if inRoutingTable(subnet) {
-			continue
+		for _, dhcpRange := range dhcpRanges {
+			if dhcpRange.Contains(lease.IP) {
+				conflicts = append(conflicts, fmt.Sprintf("%s (%s) is within the dynamic range %s-%s", ip, lease.Hostname, dhcpRange.Start.String(), dhcpRange.End.String()))
+			}
 		}
 
-		if pingSubnet(subnet) {
-			continue
+		otherHostname, found := seen[ip]
+		if found {
+			conflicts = append(conflicts, fmt.Sprintf("%s (%s) is already used by %s", ip, lease.Hostname, otherHostname))
+		} else {
+			seen[ip] = lease.Hostname
 		}
+	}
 
-		return cidr, nil
+	if len(conflicts) > 0 {
+		return fmt.Errorf("Static DHCP lease conflicts: %s", strings.Join(conflicts, ", "))
 	}
 
-	return "", fmt.Errorf("Failed to automatically find an unused IPv4 subnet, manual configuration required")
+	return nil
 }
 
-func randomSubnetV6() (string, error) {
-	for i := 0; i < 100; i++ {
-		cidr := fmt.Sprintf("fd42:%x:%x:%x::1/64", rand.Intn(65535), rand.Intn(65535), rand.Intn(65535))
-		_, subnet, err := net.ParseCIDR(cidr)
-		if err != nil {
-			continue
+// validateConfigTarget checks that the keys changed between the current and new config are appropriate for the
+// target node. When a target node is specified only node-specific keys may be changed, and when no target node is
+// specified (a cluster-wide change) node-specific keys may not be changed, as they would not be stored per-node.
+func validateConfigTarget(currentConfig map[string]string, newConfig map[string]string, targetNode string) error {
+	changedKeys := []string{}
+	for k, v := range newConfig {
+		curValue, found := currentConfig[k]
+		if !found || curValue != v {
+			changedKeys = append(changedKeys, k)
 		}
+	}
 
-		if inRoutingTable(subnet) {
-			continue
+	for k := range currentConfig {
+		_, found := newConfig[k]
+		if !found {
+			changedKeys = append(changedKeys, k)
 		}
+	}
 
-		if pingSubnet(subnet) {
-			continue
+	sort.Strings(changedKeys)
+
+	for _, k := range changedKeys {
+		nodeSpecific := shared.StringInSlice(k, db.NodeSpecificNetworkConfig)
+
+		if targetNode == "" && nodeSpecific {
+			return fmt.Errorf("Config key %q is node-specific and requires a target node", k)
 		}
 
-		return cidr, nil
+		if targetNode != "" && !nodeSpecific {
+			return fmt.Errorf("Config key %q may not be used as node-specific key on target node %q", k, targetNode)
+		}
 	}
 
-	return "", fmt.Errorf("Failed to automatically find an unused IPv6 subnet, manual configuration required")
+	return nil
 }
 
-func inRoutingTable(subnet *net.IPNet) bool {
-	filename := "route"
-	if subnet.IP.To4() == nil {
-		filename = "ipv6_route"
+// BridgeVLANFilterSetStatus sets the status of VLAN filtering on a bridge interface.
+func BridgeVLANFilterSetStatus(interfaceName string, status string) error {
+	err := ioutil.WriteFile(fmt.Sprintf("/sys/class/net/%s/bridge/vlan_filtering", interfaceName), []byte(status), 0)
+	if err != nil {
+		return errors.Wrapf(err, "Failed enabling VLAN filtering on bridge %q", interfaceName)
 	}
 
-	file, err := os.Open(fmt.Sprintf("/proc/net/%s", filename))
+	return nil
+}
+
+// BridgeVLANDefaultPVID returns the VLAN default port VLAN ID (PVID).
+func BridgeVLANDefaultPVID(interfaceName string) (string, error) {
+	content, err := ioutil.ReadFile(fmt.Sprintf("/sys/class/net/%s/bridge/default_pvid", interfaceName))
 	if err != nil {
-		return false
+		return "", errors.Wrapf(err, "Failed getting bridge VLAN default PVID for %q", interfaceName)
 	}
-	defer file.Close()
 
-	scanner := bufio.NewReader(file)
-	for {
-		line, _, err := scanner.ReadLine()
-		if err != nil {
-			break
-		}
+	return strings.TrimSpace(fmt.Sprintf("%s", content)), nil
+}
 
-		fields := strings.Fields(string(line))
+// BridgeVLANSetDefaultPVID sets the VLAN default port VLAN ID (PVID).
+func BridgeVLANSetDefaultPVID(interfaceName string, vlanID string) error {
+	err := ioutil.WriteFile(fmt.Sprintf("/sys/class/net/%s/bridge/default_pvid", interfaceName), []byte(vlanID), 0)
+	if err != nil {
+		return errors.Wrapf(err, "Failed setting bridge VLAN default PVID for %q", interfaceName)
+	}
 
-		// Get the IP
-		var ip net.IP
-		if filename == "ipv6_route" {
-			ip, err = hex.DecodeString(fields[0])
-			if err != nil {
+	return nil
+}
+
+func readUint(path string) (uint64, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	value, err := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return value, nil
+}
+
+// GetState returns the runtime state of the supplied host network interface.
+func GetState(netIf net.Interface) api.NetworkState {
+	netState := "down"
+	netType := "unknown"
+
+	if netIf.Flags&net.FlagBroadcast > 0 {
+		netType = "broadcast"
+	}
+
+	if netIf.Flags&net.FlagPointToPoint > 0 {
+		netType = "point-to-point"
+	}
+
+	if netIf.Flags&net.FlagLoopback > 0 {
+		netType = "loopback"
+	}
+
+	if netIf.Flags&net.FlagUp > 0 {
+		netState = "up"
+	}
+
+	network := api.NetworkState{
+		Addresses: []api.NetworkStateAddress{},
+		Counters:  api.NetworkStateCounters{},
+		Hwaddr:    netIf.HardwareAddr.String(),
+		Mtu:       netIf.MTU,
+		State:     netState,
+		Type:      netType,
+	}
+
+	// Populate address information.
+	addrs, err := netIf.Addrs()
+	if err == nil {
+		for _, addr := range addrs {
+			fields := strings.SplitN(addr.String(), "/", 2)
+			if len(fields) != 2 {
 				continue
 			}
-		} else {
-			bytes, err := hex.DecodeString(fields[1])
-			if err != nil {
-				continue
+
+			family := "inet"
+			if strings.Contains(fields[0], ":") {
+				family = "inet6"
 			}
 
-			ip = net.IPv4(bytes[3], bytes[2], bytes[1], bytes[0])
-		}
+			scope := "global"
+			if strings.HasPrefix(fields[0], "127") {
+				scope = "local"
+			}
 
-		// Get the mask
-		var mask net.IPMask
-		if filename == "ipv6_route" {
-			size, err := strconv.ParseInt(fmt.Sprintf("0x%s", fields[1]), 0, 64)
-			if err != nil {
-				continue
+			if fields[0] == "::1" {
+				scope = "local"
 			}
 
-			mask = net.CIDRMask(int(size), 128)
-		} else {
-			bytes, err := hex.DecodeString(fields[7])
-			if err != nil {
-				continue
+			if strings.HasPrefix(fields[0], "169.254") {
+				scope = "link"
 			}
 
-			mask = net.IPv4Mask(bytes[3], bytes[2], bytes[1], bytes[0])
-		}
+			if strings.HasPrefix(fields[0], "fe80:") {
+				scope = "link"
+			}
 
-		// Generate a new network
-		lineNet := net.IPNet{IP: ip, Mask: mask}
+			address := api.NetworkStateAddress{}
+			address.Family = family
+			address.Address = fields[0]
+			address.Netmask = fields[1]
+			address.Scope = scope
 
-		// Ignore default gateway
-		if lineNet.IP.Equal(net.ParseIP("::")) {
-			continue
+			network.Addresses = append(network.Addresses, address)
 		}
+	}
 
-		if lineNet.IP.Equal(net.ParseIP("0.0.0.0")) {
-			continue
+	// Populate bond details.
+	bondPath := fmt.Sprintf("/sys/class/net/%s/bonding", netIf.Name)
+	if shared.PathExists(bondPath) {
+		bonding := api.NetworkStateBond{}
+
+		// Bond mode.
+		strValue, err := ioutil.ReadFile(filepath.Join(bondPath, "mode"))
+		if err == nil {
+			bonding.Mode = strings.Split(strings.TrimSpace(string(strValue)), " ")[0]
 		}
 
-		// Check if we have a route to our new subnet
-		if lineNet.Contains(subnet.IP) {
-			return true
+		// Bond transmit policy.
+		strValue, err = ioutil.ReadFile(filepath.Join(bondPath, "xmit_hash_policy"))
+		if err == nil {
+			bonding.TransmitPolicy = strings.Split(strings.TrimSpace(string(strValue)), " ")[0]
 		}
-	}
 
-	return false
-}
+		// Up delay.
+		uintValue, err := readUint(filepath.Join(bondPath, "updelay"))
+		if err == nil {
+			bonding.UpDelay = uintValue
+		}
 
-func pingSubnet(subnet *net.IPNet) bool {
-	var fail bool
-	var failLock sync.Mutex
-	var wgChecks sync.WaitGroup
+		// Down delay.
+		uintValue, err = readUint(filepath.Join(bondPath, "downdelay"))
+		if err == nil {
+			bonding.DownDelay = uintValue
+		}
 
-	ping := func(ip net.IP) {
-		defer wgChecks.Done()
+		// MII frequency.
+		uintValue, err = readUint(filepath.Join(bondPath, "miimon"))
+		if err == nil {
+			bonding.MIIFrequency = uintValue
+		}
 
-		cmd := "ping"
-		if ip.To4() == nil {
-			cmd = "ping6"
+		// MII state.
+		strValue, err = ioutil.ReadFile(filepath.Join(bondPath, "mii_status"))
+		if err == nil {
+			bonding.MIIState = strings.TrimSpace(string(strValue))
 		}
 
-		_, err := shared.RunCommand(cmd, "-n", "-q", ip.String(), "-c", "1", "-W", "1")
-		if err != nil {
-			// Remote didn't answer
-			return
+		// Lower devices.
+		strValue, err = ioutil.ReadFile(filepath.Join(bondPath, "slaves"))
+		if err == nil {
+			bonding.LowerDevices = strings.Split(strings.TrimSpace(string(strValue)), " ")
 		}
 
-		// Remote answered
-		failLock.Lock()
-		fail = true
-		failLock.Unlock()
+		network.Bond = &bonding
 	}
 
-	poke := func(ip net.IP) {
-		defer wgChecks.Done()
+	// Populate bridge details.
+	bridgePath := fmt.Sprintf("/sys/class/net/%s/bridge", netIf.Name)
+	if shared.PathExists(bridgePath) {
+		bridge := api.NetworkStateBridge{}
 
-		addr := fmt.Sprintf("%s:22", ip.String())
-		if ip.To4() == nil {
-			addr = fmt.Sprintf("[%s]:22", ip.String())
+		// Bridge ID.
+		strValue, err := ioutil.ReadFile(filepath.Join(bridgePath, "bridge_id"))
+		if err == nil {
+			bridge.ID = strings.TrimSpace(string(strValue))
 		}
 
-		_, err := net.DialTimeout("tcp", addr, time.Second)
+		// Bridge STP.
+		uintValue, err := readUint(filepath.Join(bridgePath, "stp_state"))
 		if err == nil {
-			// Remote answered
-			failLock.Lock()
-			fail = true
-			failLock.Unlock()
-			return
+			bridge.STP = uintValue == 1
+		}
+
+		// Bridge forward delay.
+		uintValue, err = readUint(filepath.Join(bridgePath, "forward_delay"))
+		if err == nil {
+			bridge.ForwardDelay = uintValue
+		}
+
+		// Bridge default VLAN.
+		uintValue, err = readUint(filepath.Join(bridgePath, "default_pvid"))
+		if err == nil {
+			bridge.VLANDefault = uintValue
+		}
+
+		// Bridge VLAN filtering.
+		uintValue, err = readUint(filepath.Join(bridgePath, "vlan_filtering"))
+		if err == nil {
+			bridge.VLANFiltering = uintValue == 1
+		}
+
+		// Upper devices.
+		bridgeIfPath := fmt.Sprintf("/sys/class/net/%s/brif", netIf.Name)
+		if shared.PathExists(bridgeIfPath) {
+			entries, err := ioutil.ReadDir(bridgeIfPath)
+			if err == nil {
+				bridge.UpperDevices = []string{}
+				for _, entry := range entries {
+					bridge.UpperDevices = append(bridge.UpperDevices, entry.Name())
+				}
+			}
 		}
-	}
 
-	// Ping first IP
-	wgChecks.Add(1)
-	go ping(GetIP(subnet, 1))
+		network.Bridge = &bridge
+	}
 
-	// Poke port on first IP
-	wgChecks.Add(1)
-	go poke(GetIP(subnet, 1))
+	// Get counters.
+	network.Counters = shared.NetworkGetCounters(netIf.Name)
+	return network
+}
 
-	// Ping check
-	if subnet.IP.To4() != nil {
-		// Ping last IP
-		wgChecks.Add(1)
-		go ping(GetIP(subnet, -2))
+// exclusiveKeys is a pair of config keys that can't be set together. Each key is either a plain key name, which
+// matches when the key is set to any value, or "KEY=VALUE", which matches when the key's value (or its default if
+// it isn't set) is VALUE. Boolean values only need to agree on whether they are true.
+type exclusiveKeys [2]string
+
+// exclusiveKeyMatches returns whether the config matches the key (in the form used by exclusiveKeys). The rules
+// provide the type and default value of each key.
+func exclusiveKeyMatches(config map[string]string, rules map[string]configKeyRule, key string) bool {
+	fields := strings.SplitN(key, "=", 2)
+	if len(fields) != 2 {
+		return config[key] != ""
+	}
 
-		// Poke port on last IP
-		wgChecks.Add(1)
-		go poke(GetIP(subnet, -2))
+	rule := rules[fields[0]]
+	value := config[fields[0]]
+	if value == "" {
+		value = rule.defaultValue
 	}
 
-	wgChecks.Wait()
+	if rule.keyType == "boolean" {
+		return shared.IsTrue(value) == shared.IsTrue(fields[1])
+	}
 
-	return fail
+	return value == fields[1]
 }
 
-// GetHostDevice returns the interface name to use for a combination of parent device name and VLAN ID.
-// If no vlan ID supplied, parent name is returned unmodified. If non-empty VLAN ID is supplied then it will look
-// for an existing VLAN device and return that, otherwise it will return the default "parent.vlan" format as name.
-func GetHostDevice(parent string, vlan string) string {
-	// If no VLAN, just use the raw device
-	if vlan == "" {
-		return parent
+// findExclusiveKeysConflict returns the first pair of mutually exclusive keys that are both matched by the config.
+func findExclusiveKeysConflict(config map[string]string, rules map[string]configKeyRule, pairs []exclusiveKeys) (exclusiveKeys, bool) {
+	for _, pair := range pairs {
+		if exclusiveKeyMatches(config, rules, pair[0]) && exclusiveKeyMatches(config, rules, pair[1]) {
+			return pair, true
+		}
 	}
 
-	// If no VLANs are configured, use the default pattern
-	defaultVlan := fmt.Sprintf("%s.%s", parent, vlan)
-	if !shared.PathExists("/proc/net/vlan/config") {
-		return defaultVlan
-	}
+	return exclusiveKeys{}, false
+}
 
-	// Look for an existing VLAN
-	f, err := os.Open("/proc/net/vlan/config")
-	if err != nil {
-		return defaultVlan
-	}
-	defer f.Close()
+// waitBackoff calls check until it reports success, returns an error or the context is done. The delay between
+// attempts starts at interval and doubles after each attempt up to maxInterval.
+func waitBackoff(ctx context.Context, interval time.Duration, maxInterval time.Duration, check func() (bool, error)) error {
+	for {
+		done, err := check()
+		if err != nil {
+			return err
+		}
 
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		// Only grab the lines we're interested in
-		s := strings.Split(scanner.Text(), "|")
-		if len(s) != 3 {
-			continue
+		if done {
+			return nil
 		}
 
-		vlanIface := strings.TrimSpace(s[0])
-		vlanID := strings.TrimSpace(s[1])
-		vlanParent := strings.TrimSpace(s[2])
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
 
-		if vlanParent == parent && vlanID == vlan {
-			return vlanIface
+		interval *= 2
+		if interval > maxInterval {
+			interval = maxInterval
 		}
 	}
-
-	// Return the default pattern
-	return defaultVlan
 }
 
-// GetLeaseAddresses returns the lease addresses for a network and hwaddr.
-func GetLeaseAddresses(s *state.State, networkName string, hwaddr string) ([]api.InstanceStateNetworkAddress, error) {
-	addresses := []api.InstanceStateNetworkAddress{}
+// findAddressConflicts groups the supplied claims by IP and returns the IPs claimed by more than one client, ordered
+// with IPv4 addresses first. Claims with the same MAC (such as a static lease and the dynamic lease handed out for
+// it) are from the same client, while each reservation is a client of its own.
+func findAddressConflicts(claims []AddressClaim) []AddressConflict {
+	ipClaims := map[string][]AddressClaim{}
+	ips := []net.IP{}
+	for _, claim := range claims {
+		if claim.IP == nil {
+			continue
+		}
 
-	// Look for neighborhood entries for IPv6.
-	out, err := shared.RunCommand("ip", "-6", "neigh", "show", "dev", networkName)
-	if err == nil {
-		for _, line := range strings.Split(out, "\n") {
-			// Split fields and early validation.
-			fields := strings.Fields(line)
-			if len(fields) != 4 {
-				continue
-			}
+		key := claim.IP.String()
+		_, found := ipClaims[key]
+		if !found {
+			ips = append(ips, claim.IP)
+		}
 
-			if fields[2] != hwaddr {
-				continue
-			}
+		ipClaims[key] = append(ipClaims[key], claim)
+	}
 
-			// Prepare the entry.
-			addr := api.InstanceStateNetworkAddress{}
-			addr.Address = fields[0]
-			addr.Family = "inet6"
+	conflicts := []AddressConflict{}
+	for _, ip := range sortIPs(ips) {
+		ipClaim := ipClaims[ip.String()]
 
-			if strings.HasPrefix(fields[0], "fe80::") {
-				addr.Scope = "link"
-			} else {
-				addr.Scope = "global"
+		clients := map[string]struct{}{}
+		for i, claim := range ipClaim {
+			client := claim.MAC
+			if client == "" {
+				client = fmt.Sprintf("%s/%d", claim.Source, i)
 			}
 
-			addresses = append(addresses, addr)
+			clients[client] = struct{}{}
 		}
-	}
 
-	// Look for DHCP leases.
-	leaseFile := shared.VarPath("networks", networkName, "dnsmasq.leases")
-	if !shared.PathExists(leaseFile) {
-		return addresses, nil
-	}
+		if len(clients) < 2 {
+			continue
+		}
 
-	dbInfo, err := LoadByName(s, networkName)
-	if err != nil {
-		return nil, err
-	}
+		sort.SliceStable(ipClaim, func(i, j int) bool {
+			if ipClaim[i].Source != ipClaim[j].Source {
+				return ipClaim[i].Source < ipClaim[j].Source
+			}
 
-	content, err := ioutil.ReadFile(leaseFile)
-	if err != nil {
-		return nil, err
+			return ipClaim[i].MAC < ipClaim[j].MAC
+		})
+
+		conflicts = append(conflicts, AddressConflict{IP: ip, Claims: ipClaim})
 	}
 
-	for _, lease := range strings.Split(string(content), "\n") {
-		fields := strings.Fields(lease)
-		if len(fields) < 5 {
-			continue
+	return conflicts
+}
+
+// privateSubnets lists the private IPv4 (RFC 1918) and unique local IPv6 (RFC 4193) address ranges.
+var privateSubnets = []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"}
+
+// isPrivateSubnet indicates whether the supplied subnet lies entirely within one of the private address ranges.
+func isPrivateSubnet(subnet *net.IPNet) bool {
+	subnetOnes, _ := subnet.Mask.Size()
+
+	for _, cidr := range privateSubnets {
+		_, private, _ := net.ParseCIDR(cidr)
+		privateOnes, privateBits := private.Mask.Size()
+
+		if (subnet.IP.To4() != nil) == (privateBits == 32) && subnetOnes >= privateOnes && private.Contains(subnet.IP) {
+			return true
 		}
+	}
 
-		// Parse the MAC
-		mac := GetMACSlice(fields[1])
-		macStr := strings.Join(mac, ":")
+	return false
+}
 
-		if len(macStr) < 17 && fields[4] != "" {
-			macStr = fields[4][len(fields[4])-17:]
+// resolvConfHasNameservers indicates whether the supplied resolv.conf content lists any nameservers.
+func resolvConfHasNameservers(content string) bool {
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return true
 		}
+	}
 
-		if macStr != hwaddr {
-			continue
+	return false
+}
+
+// allocatedAddressSources maps the source of an address claim to the source of the allocated address.
+var allocatedAddressSources = map[AddressClaimSource]AllocatedAddressSource{
+	AddressClaimLease:       AllocatedAddressDynamic,
+	AddressClaimStatic:      AllocatedAddressStatic,
+	AddressClaimReservation: AllocatedAddressReserved,
+}
+
+// allocatedAddresses combines the gateway addresses from the supplied network config, the network and broadcast
+// addresses of its subnets and the supplied address claims into a list of allocated addresses ordered by family
+// and then by IP. Addresses with the same IP are ordered by source.
+func allocatedAddresses(config map[string]string, claims []AddressClaim) []AllocatedAddress {
+	addresses := []AllocatedAddress{}
+
+	addAddress := func(ip net.IP, source AllocatedAddressSource, mac string, instance string) {
+		if ip == nil {
+			return
 		}
 
-		// Parse the IP
-		addr := api.InstanceStateNetworkAddress{
-			Address: fields[2],
-			Scope:   "global",
+		family := 6
+		if ip.To4() != nil {
+			family = 4
+			ip = ip.To4()
 		}
 
-		ip := net.ParseIP(addr.Address)
-		if ip == nil {
+		addresses = append(addresses, AllocatedAddress{IP: ip, Family: family, Source: source, MAC: mac, Instance: instance})
+	}
+
+	for _, key := range []string{"ipv4.address", "ipv6.address"} {
+		gateway, subnet, err := net.ParseCIDR(config[key])
+		if err != nil {
 			continue
 		}
 
-		if ip.To4() != nil {
-			addr.Family = "inet"
+		addAddress(gateway, AllocatedAddressGateway, "", "")
+		addAddress(subnet.IP, AllocatedAddressReserved, "", "")
 
-			_, subnet, _ := net.ParseCIDR(dbInfo.Config()["ipv4.address"])
-			if subnet != nil {
-				mask, _ := subnet.Mask.Size()
-				addr.Netmask = fmt.Sprintf("%d", mask)
+		// Point-to-point IPv4 subnets have no broadcast address.
+		ones, bits := subnet.Mask.Size()
+		if bits == 32 && ones < 31 {
+			broadcast := make(net.IP, len(subnet.IP))
+			for i := range subnet.IP {
+				broadcast[i] = subnet.IP[i] | ^subnet.Mask[i]
 			}
-		} else {
-			addr.Family = "inet6"
 
-			_, subnet, _ := net.ParseCIDR(dbInfo.Config()["ipv6.address"])
-			if subnet != nil {
-				mask, _ := subnet.Mask.Size()
-				addr.Netmask = fmt.Sprintf("%d", mask)
-			}
+			addAddress(broadcast, AllocatedAddressReserved, "", "")
 		}
+	}
 
-		addresses = append(addresses, addr)
+	for _, claim := range claims {
+		addAddress(claim.IP, allocatedAddressSources[claim.Source], claim.MAC, claim.Instance)
 	}
 
-	return addresses, nil
+	sort.SliceStable(addresses, func(i, j int) bool {
+		if addresses[i].Family != addresses[j].Family {
+			return addresses[i].Family < addresses[j].Family
+		}
+
+		cmp := bytes.Compare(addresses[i].IP.To16(), addresses[j].IP.To16())
+		if cmp != 0 {
+			return cmp < 0
+		}
+
+		return addresses[i].Source < addresses[j].Source
+	})
+
+	return addresses
 }
 
-// GetMACSlice parses MAC address.
-func GetMACSlice(hwaddr string) []string {
-	var buf []string
+// swapPaths swaps the two paths, using a temporary name alongside the first path. A path that doesn't exist is
+// treated as empty, so if only one of the paths exists it is simply moved to the other. On failure any completed
+// moves are reverted.
+func swapPaths(path1 string, path2 string) error {
+	tmpPath := path1 + ".swap"
+	exists1 := shared.PathExists(path1)
+	exists2 := shared.PathExists(path2)
 
-	if !strings.Contains(hwaddr, ":") {
-		if s, err := strconv.ParseUint(hwaddr, 10, 64); err == nil {
-			hwaddr = fmt.Sprintln(fmt.Sprintf("%x", s))
-			var tuple string
-			for i, r := range hwaddr {
-				tuple = tuple + string(r)
-				if i > 0 && (i+1)%2 == 0 {
-					buf = append(buf, tuple)
-					tuple = ""
-				}
-			}
+	revert := revert.New()
+	defer revert.Fail()
+
+	if exists1 {
+		err := os.Rename(path1, tmpPath)
+		if err != nil {
+			return err
 		}
-	} else {
-		buf = strings.Split(strings.ToLower(hwaddr), ":")
+
+		revert.Add(func() { os.Rename(tmpPath, path1) })
 	}
 
-	return buf
-}
+	if exists2 {
+		err := os.Rename(path2, path1)
+		if err != nil {
+			return err
+		}
 
-// usesIPv4Firewall returns whether network config will need to use the IPv4 firewall.
-func usesIPv4Firewall(netConfig map[string]string) bool {
-	if netConfig == nil {
-		return false
+		revert.Add(func() { os.Rename(path1, path2) })
 	}
 
-	if netConfig["ipv4.firewall"] == "" || shared.IsTrue(netConfig["ipv4.firewall"]) {
-		return true
+	if exists1 {
+		err := os.Rename(tmpPath, path2)
+		if err != nil {
+			return err
+		}
 	}
 
-	if shared.IsTrue(netConfig["ipv4.nat"]) {
-		return true
+	revert.Success()
+	return nil
+}
+
+// interfacesReferenced returns the host interfaces referenced by a network config as its parent or as external
+// interfaces connected to the bridge.
+func interfacesReferenced(config map[string]string) []string {
+	ifaces := []string{}
+	if config["parent"] != "" {
+		ifaces = append(ifaces, config["parent"])
 	}
 
-	return false
+	for _, entry := range strings.Split(config["bridge.external_interfaces"], ",") {
+		entry = strings.TrimSpace(entry)
+		if entry != "" {
+			ifaces = append(ifaces, entry)
+		}
+	}
+
+	return ifaces
 }
 
-// usesIPv6Firewall returns whether network config will need to use the IPv6 firewall.
-func usesIPv6Firewall(netConfig map[string]string) bool {
-	if netConfig == nil {
-		return false
+// importedConfig returns the config of a network of the supplied type that adopts an existing host interface. The
+// first global unicast address of each family (and the MTU for bridges) is taken from the interface. Other network
+// types use the interface as their parent.
+func importedConfig(netType string, iface string, mtu int, addrs []net.Addr) map[string]string {
+	config := map[string]string{
+		"volatile.imported": "true",
 	}
 
-	if netConfig["ipv6.firewall"] == "" || shared.IsTrue(netConfig["ipv6.firewall"]) {
-		return true
+	if netType != "bridge" {
+		config["parent"] = iface
+		return config
 	}
 
-	if shared.IsTrue(netConfig["ipv6.nat"]) {
-		return true
+	config["bridge.mtu"] = fmt.Sprintf("%d", mtu)
+	config["ipv4.address"] = "none"
+	config["ipv6.address"] = "none"
+
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || !ipNet.IP.IsGlobalUnicast() {
+			continue
+		}
+
+		key := "ipv6.address"
+		if ipNet.IP.To4() != nil {
+			key = "ipv4.address"
+		}
+
+		// Only the first address of each family is used.
+		if config[key] == "none" {
+			config[key] = ipNet.String()
+		}
 	}
 
-	return false
+	return config
 }
 
-// BridgeVLANFilteringStatus returns whether VLAN filtering is enabled on a bridge interface.
-func BridgeVLANFilteringStatus(interfaceName string) (string, error) {
-	content, err := ioutil.ReadFile(fmt.Sprintf("/sys/class/net/%s/bridge/vlan_filtering", interfaceName))
-	if err != nil {
-		return "", errors.Wrapf(err, "Failed getting bridge VLAN status for %q", interfaceName)
+// configKeyMatches returns whether the config key is one of the supplied keys. Entries ending in "." match all keys
+// with that prefix.
+func configKeyMatches(key string, keys []string) bool {
+	for _, k := range keys {
+		if key == k || (strings.HasSuffix(k, ".") && strings.HasPrefix(key, k)) {
+			return true
+		}
 	}
 
-	return strings.TrimSpace(fmt.Sprintf("%s", content)), nil
+	return false
 }
 
-// BridgeVLANFilterSetStatus sets the status of VLAN filtering on a bridge interface.
-func BridgeVLANFilterSetStatus(interfaceName string, status string) error {
-	err := ioutil.WriteFile(fmt.Sprintf("/sys/class/net/%s/bridge/vlan_filtering", interfaceName), []byte(status), 0)
-	if err != nil {
-		return errors.Wrapf(err, "Failed enabling VLAN filtering on bridge %q", interfaceName)
+// mergeConfig returns a copy of the config with the supplied changes merged in. A key with an empty value is
+// removed, except for volatile keys which are kept with an empty value so that preserveVolatileKeys removes them
+// rather than preserving their current value.
+func mergeConfig(config map[string]string, changes map[string]string) map[string]string {
+	newConfig := make(map[string]string, len(config))
+	for k, v := range config {
+		newConfig[k] = v
 	}
 
-	return nil
-}
+	for k, v := range changes {
+		if v == "" && !strings.HasPrefix(k, "volatile.") {
+			delete(newConfig, k)
+			continue
+		}
 
-// BridgeVLANDefaultPVID returns the VLAN default port VLAN ID (PVID).
-func BridgeVLANDefaultPVID(interfaceName string) (string, error) {
-	content, err := ioutil.ReadFile(fmt.Sprintf("/sys/class/net/%s/bridge/default_pvid", interfaceName))
-	if err != nil {
-		return "", errors.Wrapf(err, "Failed getting bridge VLAN default PVID for %q", interfaceName)
+		newConfig[k] = v
 	}
 
-	return strings.TrimSpace(fmt.Sprintf("%s", content)), nil
+	return newConfig
 }
 
-// BridgeVLANSetDefaultPVID sets the VLAN default port VLAN ID (PVID).
-func BridgeVLANSetDefaultPVID(interfaceName string, vlanID string) error {
-	err := ioutil.WriteFile(fmt.Sprintf("/sys/class/net/%s/bridge/default_pvid", interfaceName), []byte(vlanID), 0)
-	if err != nil {
-		return errors.Wrapf(err, "Failed setting bridge VLAN default PVID for %q", interfaceName)
+// configChanges returns the changes that turn the old config into the new config, suitable for storing in the
+// database. Removed keys have an empty value.
+func configChanges(oldConfig map[string]string, newConfig map[string]string) map[string]string {
+	changes := map[string]string{}
+	for _, change := range diffConfig(oldConfig, newConfig) {
+		changes[change.Key] = change.NewValue
 	}
 
-	return nil
+	return changes
 }
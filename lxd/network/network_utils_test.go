@@ -0,0 +1,887 @@
+package network
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	deviceConfig "github.com/lxc/lxd/lxd/device/config"
+	firewallDrivers "github.com/lxc/lxd/lxd/firewall/drivers"
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/api"
+)
+
+// Test parseDHCPv4Ranges
+func TestParseDHCPv4Ranges(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.0.0.1/24")
+
+	// Test valid ranges, including a single address range.
+	ranges, err := parseDHCPv4Ranges("10.0.0.10-10.0.0.20, 10.0.0.30-10.0.0.30", subnet)
+	assert.NoError(t, err)
+	assert.Len(t, ranges, 2)
+	assert.Equal(t, "10.0.0.30", ranges[1].Start.String())
+	assert.Equal(t, "10.0.0.30", ranges[1].End.String())
+
+	// Test start after end.
+	_, err = parseDHCPv4Ranges("10.0.0.20-10.0.0.10", subnet)
+	assert.Error(t, err)
+
+	// Test malformed separator.
+	_, err = parseDHCPv4Ranges("10.0.0.10=10.0.0.20", subnet)
+	assert.Error(t, err)
+
+	// Test IPv6 addresses.
+	_, err = parseDHCPv4Ranges("fd42::10-fd42::20", nil)
+	assert.Error(t, err)
+
+	// Test range outside of subnet.
+	_, err = parseDHCPv4Ranges("10.0.1.10-10.0.1.20", subnet)
+	assert.Error(t, err)
+
+	// Test range outside of subnet is allowed when no subnet is supplied.
+	_, err = parseDHCPv4Ranges("10.0.1.10-10.0.1.20", nil)
+	assert.NoError(t, err)
+}
+
+// Test validateConfigTarget
+func TestValidateConfigTarget(t *testing.T) {
+	current := map[string]string{
+		"ipv4.address":               "10.0.0.1/24",
+		"bridge.external_interfaces": "eth1",
+	}
+
+	// Test cluster-wide change of a non-node-specific key.
+	err := validateConfigTarget(current, map[string]string{"ipv4.address": "10.0.1.1/24", "bridge.external_interfaces": "eth1"}, "")
+	assert.NoError(t, err)
+
+	// Test cluster-wide change of a node-specific key.
+	err = validateConfigTarget(current, map[string]string{"ipv4.address": "10.0.0.1/24", "bridge.external_interfaces": "eth2"}, "")
+	assert.EqualError(t, err, `Config key "bridge.external_interfaces" is node-specific and requires a target node`)
+
+	// Test targeted change of a node-specific key, with unchanged cluster-wide keys.
+	err = validateConfigTarget(current, map[string]string{"ipv4.address": "10.0.0.1/24", "bridge.external_interfaces": "eth2"}, "node1")
+	assert.NoError(t, err)
+
+	// Test targeted change of a cluster-wide key.
+	err = validateConfigTarget(current, map[string]string{"ipv4.address": "10.0.1.1/24", "bridge.external_interfaces": "eth1"}, "node1")
+	assert.EqualError(t, err, `Config key "ipv4.address" may not be used as node-specific key on target node "node1"`)
+
+	// Test targeted removal of a cluster-wide key.
+	err = validateConfigTarget(current, map[string]string{"bridge.external_interfaces": "eth1"}, "node1")
+	assert.Error(t, err)
+}
+
+// Test validateStaticLeases
+func TestValidateStaticLeases(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.0.0.1/24")
+	dhcpRanges := []DHCPRange{{Start: net.ParseIP("10.0.0.100"), End: net.ParseIP("10.0.0.200")}}
+
+	// Test valid leases.
+	leases := []StaticLease{
+		{MAC: "00:16:3e:00:00:01", IP: net.ParseIP("10.0.0.10"), Hostname: "c1"},
+		{MAC: "00:16:3e:00:00:02", IP: net.ParseIP("10.0.0.11"), Hostname: "c2"},
+	}
+	assert.NoError(t, validateStaticLeases(leases, subnet, dhcpRanges))
+
+	// Test lease outside of subnet, inside a dynamic range and duplicate IP are all reported.
+	leases = []StaticLease{
+		{MAC: "00:16:3e:00:00:01", IP: net.ParseIP("10.0.1.10"), Hostname: "c1"},
+		{MAC: "00:16:3e:00:00:02", IP: net.ParseIP("10.0.0.150"), Hostname: "c2"},
+		{MAC: "00:16:3e:00:00:03", IP: net.ParseIP("10.0.0.10"), Hostname: "c3"},
+		{MAC: "00:16:3e:00:00:04", IP: net.ParseIP("10.0.0.10"), Hostname: "c4"},
+	}
+	err := validateStaticLeases(leases, subnet, dhcpRanges)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `10.0.1.10 (c1) is not within the network subnet "10.0.0.0/24"`)
+	assert.Contains(t, err.Error(), "10.0.0.150 (c2) is within the dynamic range 10.0.0.100-10.0.0.200")
+	assert.Contains(t, err.Error(), "10.0.0.10 (c4) is already used by c3")
+}
+
+// Test subnetsOverlap
+func TestSubnetsOverlap(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.0.0.1/24")
+	tests := []struct {
+		cidr    string
+		overlap bool
+	}{
+		{"10.0.0.1/24", true},     // Exact match.
+		{"10.0.0.128/25", true},   // Subset.
+		{"10.0.0.1/16", true},     // Superset.
+		{"10.0.1.1/24", false},    // Adjacent.
+		{"192.168.0.1/24", false}, // Unrelated.
+		{"fd42::1/64", false},     // Different family.
+	}
+
+	for _, test := range tests {
+		_, other, _ := net.ParseCIDR(test.cidr)
+		assert.Equal(t, test.overlap, subnetsOverlap(subnet, other), test.cidr)
+		assert.Equal(t, test.overlap, subnetsOverlap(other, subnet), test.cidr)
+	}
+}
+
+// Test parseDHCPv6Ranges
+func TestParseDHCPv6Ranges(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("fd42::1/64")
+
+	// Test valid range.
+	ranges, err := parseDHCPv6Ranges("fd42::10-fd42::20", subnet)
+	assert.NoError(t, err)
+	assert.Len(t, ranges, 1)
+
+	// Test IPv4 addresses.
+	_, err = parseDHCPv6Ranges("10.0.0.10-10.0.0.20", nil)
+	assert.Error(t, err)
+
+	// Test mixed families.
+	_, err = parseDHCPv6Ranges("fd42::10-10.0.0.20", nil)
+	assert.Error(t, err)
+
+	// Test link-local addresses.
+	_, err = parseDHCPv6Ranges("fe80::10-fe80::20", nil)
+	assert.EqualError(t, err, `IP range "fe80::10-fe80::20" must not contain link-local addresses`)
+}
+
+// Test subtractDHCPRanges
+func TestSubtractDHCPRanges(t *testing.T) {
+	r := func(start string, end string) DHCPRange {
+		return DHCPRange{Start: net.ParseIP(start).To4(), End: net.ParseIP(end).To4()}
+	}
+
+	pool := []DHCPRange{r("10.0.0.10", "10.0.0.20")}
+
+	tests := []struct {
+		name     string
+		ranges   []DHCPRange
+		exclude  []DHCPRange
+		expected []DHCPRange
+	}{
+		{"No exclusions", pool, []DHCPRange{}, pool},
+		{"Exclusion outside of range", pool, []DHCPRange{r("10.0.0.30", "10.0.0.40")}, pool},
+		{"Exclusion adjacent to range", pool, []DHCPRange{r("10.0.0.21", "10.0.0.21")}, pool},
+		{"Single address in middle", pool, []DHCPRange{r("10.0.0.15", "10.0.0.15")}, []DHCPRange{r("10.0.0.10", "10.0.0.14"), r("10.0.0.16", "10.0.0.20")}},
+		{"Sub-range in middle", pool, []DHCPRange{r("10.0.0.12", "10.0.0.18")}, []DHCPRange{r("10.0.0.10", "10.0.0.11"), r("10.0.0.19", "10.0.0.20")}},
+		{"Start address", pool, []DHCPRange{r("10.0.0.10", "10.0.0.10")}, []DHCPRange{r("10.0.0.11", "10.0.0.20")}},
+		{"End address", pool, []DHCPRange{r("10.0.0.20", "10.0.0.20")}, []DHCPRange{r("10.0.0.10", "10.0.0.19")}},
+		{"Overlapping start", pool, []DHCPRange{r("10.0.0.5", "10.0.0.12")}, []DHCPRange{r("10.0.0.13", "10.0.0.20")}},
+		{"Overlapping end", pool, []DHCPRange{r("10.0.0.18", "10.0.0.25")}, []DHCPRange{r("10.0.0.10", "10.0.0.17")}},
+		{"Whole range", pool, []DHCPRange{r("10.0.0.10", "10.0.0.20")}, []DHCPRange{}},
+		{"Superset of range", pool, []DHCPRange{r("10.0.0.1", "10.0.0.254")}, []DHCPRange{}},
+		{"Multiple exclusions", pool, []DHCPRange{r("10.0.0.12", "10.0.0.12"), r("10.0.0.17", "10.0.0.18")}, []DHCPRange{r("10.0.0.10", "10.0.0.11"), r("10.0.0.13", "10.0.0.16"), r("10.0.0.19", "10.0.0.20")}},
+		{"Exclusion spanning ranges", []DHCPRange{r("10.0.0.10", "10.0.0.20"), r("10.0.0.30", "10.0.0.40")}, []DHCPRange{r("10.0.0.15", "10.0.0.35")}, []DHCPRange{r("10.0.0.10", "10.0.0.14"), r("10.0.0.36", "10.0.0.40")}},
+		{"Octet boundary", []DHCPRange{r("10.0.0.250", "10.0.1.5")}, []DHCPRange{r("10.0.1.0", "10.0.1.0")}, []DHCPRange{r("10.0.0.250", "10.0.0.255"), r("10.0.1.1", "10.0.1.5")}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := subtractDHCPRanges(test.ranges, test.exclude)
+			assert.Len(t, result, len(test.expected))
+			for i := range test.expected {
+				assert.Equal(t, test.expected[i].Start.String(), result[i].Start.String())
+				assert.Equal(t, test.expected[i].End.String(), result[i].End.String())
+			}
+		})
+	}
+}
+
+// Test parseIPv4List
+func TestParseIPv4List(t *testing.T) {
+	ranges, err := parseIPv4List("10.0.0.5, 10.0.0.10-10.0.0.20")
+	assert.NoError(t, err)
+	assert.Len(t, ranges, 2)
+	assert.Equal(t, "10.0.0.5", ranges[0].Start.String())
+	assert.Equal(t, "10.0.0.5", ranges[0].End.String())
+	assert.Equal(t, "10.0.0.20", ranges[1].End.String())
+
+	_, err = parseIPv4List("10.0.0.500")
+	assert.Error(t, err)
+
+	_, err = parseIPv4List("fd42::1")
+	assert.Error(t, err)
+}
+
+// Test isInUseByProxyDevices
+func TestIsInUseByProxyDevices(t *testing.T) {
+	_, subnetV4, _ := net.ParseCIDR("10.0.0.1/24")
+	_, subnetV6, _ := net.ParseCIDR("fd42::1/64")
+	subnets := []*net.IPNet{subnetV4, subnetV6}
+
+	// Test NAT proxy with connect address inside the subnet.
+	devices := deviceConfig.Devices{
+		"eth0":  {"type": "nic", "nictype": "bridged", "parent": "lxdbr1"},
+		"proxy": {"type": "proxy", "nat": "true", "listen": "tcp:192.168.1.1:80", "connect": "tcp:10.0.0.5:80"},
+	}
+	assert.True(t, isInUseByProxyDevices(devices, subnets))
+
+	// Test NAT proxy with IPv6 listen address inside the subnet.
+	devices = deviceConfig.Devices{
+		"proxy": {"type": "proxy", "nat": "true", "listen": "udp:[fd42::5]:53", "connect": "udp:[fd43::5]:53"},
+	}
+	assert.True(t, isInUseByProxyDevices(devices, subnets))
+
+	// Test NAT proxy with addresses outside the subnets.
+	devices = deviceConfig.Devices{
+		"proxy": {"type": "proxy", "nat": "true", "listen": "tcp:192.168.1.1:80", "connect": "tcp:10.0.1.5:80"},
+	}
+	assert.False(t, isInUseByProxyDevices(devices, subnets))
+
+	// Test non-NAT proxy is ignored.
+	devices = deviceConfig.Devices{
+		"proxy": {"type": "proxy", "listen": "tcp:10.0.0.1:80", "connect": "tcp:127.0.0.1:80"},
+	}
+	assert.False(t, isInUseByProxyDevices(devices, subnets))
+
+	// Test unix socket addresses are ignored.
+	devices = deviceConfig.Devices{
+		"proxy": {"type": "proxy", "nat": "true", "listen": "unix:/tmp/foo.sock", "connect": "unix:/tmp/bar.sock"},
+	}
+	assert.False(t, isInUseByProxyDevices(devices, subnets))
+}
+
+// Test parseTunnels
+func TestParseTunnels(t *testing.T) {
+	tunnels := parseTunnels(map[string]string{
+		"ipv4.address":         "10.0.0.1/24",
+		"tunnel.b.protocol":    "vxlan",
+		"tunnel.b.id":          "10",
+		"tunnel.a.protocol":    "gre",
+		"tunnel.a.local":       "192.168.1.1",
+		"tunnel.a.remote":      "192.168.1.2",
+		"tunnel.b.interface":   "eth0",
+		"tunnel.b.ttl":         "5",
+		"tunnel.b.port":        "4789",
+		"tunnel.b.group":       "239.0.0.2",
+		"tunnel.a.description": "ignored",
+	})
+
+	assert.Equal(t, []tunnel{
+		{Name: "a", Protocol: "gre", Local: "192.168.1.1", Remote: "192.168.1.2"},
+		{Name: "b", Protocol: "vxlan", Group: "239.0.0.2", Port: "4789", ID: "10", Interface: "eth0", TTL: "5"},
+	}, tunnels)
+
+	assert.Len(t, parseTunnels(map[string]string{}), 0)
+}
+
+// Test validateTunnels
+func TestValidateTunnels(t *testing.T) {
+	// Test valid tunnels.
+	err := validateTunnels([]tunnel{
+		{Name: "a", Protocol: "gre", Local: "192.168.1.1", Remote: "192.168.1.2"},
+		{Name: "b", Protocol: "vxlan", ID: "10"},
+		{Name: "c", Protocol: "vxlan", ID: "11", Local: "192.168.1.1", Remote: "192.168.1.3"},
+		{Name: "d", Protocol: "vxlan"},
+	})
+	assert.NoError(t, err)
+
+	// Test each problem is reported against its tunnel.
+	err = validateTunnels([]tunnel{
+		{Name: "a"},
+		{Name: "b", Protocol: "gre", Local: "192.168.1.1"},
+		{Name: "c", Protocol: "vxlan"},
+		{Name: "d", Protocol: "vxlan", ID: "10", Remote: "192.168.1.2"},
+		{Name: "e", Protocol: "vxlan", ID: "10"},
+		{Name: "f", Protocol: "vxlan", ID: "1"},
+	})
+	assert.EqualError(t, err, `Invalid tunnel config: Tunnel "a" is missing a protocol; Tunnel "b" requires both a local and remote address; Tunnel "d" requires both a local and remote address or neither; Tunnel "e" ID 10 is already used by tunnel "d"; Tunnel "f" ID 1 is already used by tunnel "c"`)
+}
+
+// Test parseStaticRoutes
+func TestParseStaticRoutes(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.0.0.1/24")
+
+	// Test valid routes with and without a gateway.
+	routes, err := parseStaticRoutes("192.168.0.0/16 via 10.0.0.5, 172.16.0.0/12", 4, subnet)
+	assert.NoError(t, err)
+	assert.Len(t, routes, 2)
+	assert.Equal(t, "192.168.0.0/16", routes[0].Destination.String())
+	assert.Equal(t, "10.0.0.5", routes[0].Gateway.String())
+	assert.Equal(t, "172.16.0.0/12", routes[1].Destination.String())
+	assert.Nil(t, routes[1].Gateway)
+
+	// Test IPv6 routes.
+	routes, err = parseStaticRoutes("fd43::/64 via fd42::5", 6, nil)
+	assert.NoError(t, err)
+	assert.Len(t, routes, 1)
+
+	// Test empty value.
+	routes, err = parseStaticRoutes("", 4, subnet)
+	assert.NoError(t, err)
+	assert.Len(t, routes, 0)
+
+	// Test invalid routes.
+	for _, value := range []string{
+		"192.168.0.0",                     // Not CIDR.
+		"fd43::/64",                       // Wrong family.
+		"192.168.0.0/16 via",              // Missing gateway.
+		"192.168.0.0/16 through 10.0.0.5", // Invalid separator.
+		"192.168.0.0/16 via fd42::5",      // Wrong gateway family.
+		"192.168.0.0/16 via 10.0.1.5",     // Gateway outside subnet.
+		"192.168.0.0/16,",                 // Empty entry.
+	} {
+		_, err = parseStaticRoutes(value, 4, subnet)
+		assert.Error(t, err, value)
+	}
+}
+
+// Test validHWAddr
+func TestValidHWAddr(t *testing.T) {
+	assert.NoError(t, validHWAddr(""))
+	assert.NoError(t, validHWAddr("00:16:3e:12:34:56"))
+	assert.NoError(t, validHWAddr("0a:00:00:00:00:01"))
+
+	for _, value := range []string{
+		"foo",
+		"00:16:3e:12:34",          // Too short.
+		"00:00:00:00:fe:80:00:00", // Not Ethernet.
+		"01:00:5e:00:00:01",       // Multicast.
+		"ff:ff:ff:ff:ff:ff",       // Broadcast.
+		"00:00:00:00:00:00",       // All zeros.
+	} {
+		assert.Error(t, validHWAddr(value), value)
+	}
+}
+
+// Test stableHWAddr
+func TestStableHWAddr(t *testing.T) {
+	hwAddr := stableHWAddr("lxdbr0")
+
+	// Test the address is stable, valid and locally administered.
+	assert.Equal(t, hwAddr, stableHWAddr("lxdbr0"))
+	assert.NoError(t, validHWAddr(hwAddr.String()))
+	assert.NotZero(t, hwAddr[0]&0x02)
+
+	// Test different names produce different addresses.
+	assert.NotEqual(t, hwAddr, stableHWAddr("lxdbr1"))
+}
+
+// Test parseDHCPExpiry
+func TestParseDHCPExpiry(t *testing.T) {
+	tests := []struct {
+		value  string
+		expiry time.Duration
+	}{
+		{"", time.Hour},
+		{"1h", time.Hour},
+		{"90m", 90 * time.Minute},
+		{"120", 2 * time.Minute},
+		{"120s", 2 * time.Minute},
+		{"3600", time.Hour},
+		{"2d", 48 * time.Hour},
+		{"1w", 7 * 24 * time.Hour},
+		{"12H", 12 * time.Hour},
+		{"infinite", DHCPExpiryInfinite},
+	}
+
+	for _, test := range tests {
+		expiry, err := parseDHCPExpiry(test.value)
+		assert.NoError(t, err, test.value)
+		assert.Equal(t, test.expiry, expiry, test.value)
+	}
+
+	// Test values dnsmasq doesn't accept are rejected, including Go durations with several or fractional units.
+	for _, value := range []string{"foo", "h", "1x", "-1h", "-120", "0", "119", "1m", "1h30m", "1.5h", "90000ms", " 1h", "4294967295w"} {
+		_, err := parseDHCPExpiry(value)
+		assert.Error(t, err, value)
+	}
+
+	// Test dnsmasq lease time format.
+	assert.Equal(t, "3600", dnsmasqLeaseTime(time.Hour))
+	assert.Equal(t, "infinite", dnsmasqLeaseTime(DHCPExpiryInfinite))
+}
+
+// Test parseDNSRecord
+func TestParseDNSRecord(t *testing.T) {
+	record, err := parseDNSRecord("db.lxd", "AAAA fd42::10")
+	assert.NoError(t, err)
+	assert.Equal(t, api.NetworkDNSRecord{Name: "db.lxd", Type: "AAAA", Value: "fd42::10"}, record)
+
+	record, err = parseDNSRecord("alias.lxd", "cname db.lxd.")
+	assert.NoError(t, err)
+	assert.Equal(t, "CNAME", record.Type)
+
+	// Test invalid records.
+	for _, value := range []string{"", "A", "A 192.0.2.10 extra", "A fd42::10", "AAAA 192.0.2.10", "MX mail.lxd", "CNAME -bad.lxd"} {
+		_, err = parseDNSRecord("db.lxd", value)
+		assert.Error(t, err, value)
+	}
+
+	_, err = parseDNSRecord("bad_name.lxd", "A 192.0.2.10")
+	assert.Error(t, err)
+}
+
+// Test validDHCPRangesCount
+func TestValidDHCPRangesCount(t *testing.T) {
+	ranges := make([]string, MaxDHCPRanges+1)
+	for i := range ranges {
+		ranges[i] = fmt.Sprintf("10.0.%d.10-10.0.%d.20", i, i)
+	}
+
+	assert.NoError(t, validDHCPRangesCount(""))
+	assert.NoError(t, validDHCPRangesCount(strings.Join(ranges[:MaxDHCPRanges], ",")+","))
+	assert.EqualError(t, validDHCPRangesCount(strings.Join(ranges, ",")), fmt.Sprintf("Too many DHCP ranges (%d), at most %d are allowed", MaxDHCPRanges+1, MaxDHCPRanges))
+}
+
+// Test parseFirewallRules
+func TestParseFirewallRules(t *testing.T) {
+	rules, err := parseFirewallRules("input accept tcp 22, FORWARD drop,, output reject icmp")
+	assert.NoError(t, err)
+	assert.Equal(t, []firewallDrivers.NetworkRule{
+		{Chain: "input", Action: "accept", Protocol: "tcp", Port: 22},
+		{Chain: "forward", Action: "drop"},
+		{Chain: "output", Action: "reject", Protocol: "icmp"},
+	}, rules)
+
+	rules, err = parseFirewallRules("")
+	assert.NoError(t, err)
+	assert.Empty(t, rules)
+
+	// Test invalid rules.
+	for _, value := range []string{"input", "prerouting accept", "input allow", "input accept sctp", "input accept icmp 22", "input accept tcp 0", "input accept tcp 65536", "input accept tcp 22 extra"} {
+		_, err = parseFirewallRules(value)
+		assert.Error(t, err, value)
+	}
+}
+
+// Test FirewallEnabled
+func TestFirewallEnabled(t *testing.T) {
+	n := &common{config: map[string]string{"ipv6.firewall": "false"}}
+	assert.True(t, n.FirewallEnabled("ipv4"))
+	assert.False(t, n.FirewallEnabled("ipv6"))
+}
+
+// Test parseDHCPOptions
+func TestParseDHCPOptions(t *testing.T) {
+	options, err := parseDHCPOptions("42:10.0.0.1 10.0.0.2, 67:pxelinux.0,119:lxd example.com,224:custom")
+	assert.NoError(t, err)
+	assert.Equal(t, []DHCPOption{
+		{Number: 42, Values: []string{"10.0.0.1", "10.0.0.2"}},
+		{Number: 67, Values: []string{"pxelinux.0"}},
+		{Number: 119, Values: []string{"lxd", "example.com"}},
+		{Number: 224, Values: []string{"custom"}},
+	}, options)
+
+	options, err = parseDHCPOptions("")
+	assert.NoError(t, err)
+	assert.Len(t, options, 0)
+
+	// Test invalid options.
+	for _, value := range []string{"42", "42:", "256:foo", "0:foo", "255:foo", "42:ntp.example.com", "42:fd42::1", "67:a b", "119:-invalid", "26:1400", "42:10.0.0.1,42:10.0.0.2"} {
+		_, err = parseDHCPOptions(value)
+		assert.Error(t, err, value)
+	}
+}
+
+// Test removedVLANs
+func TestRemovedVLANs(t *testing.T) {
+	assert.Equal(t, []uint16{10, 30}, removedVLANs([]uint16{10, 20, 30}, []uint16{20, 40}))
+	assert.Equal(t, []uint16{}, removedVLANs([]uint16{}, []uint16{20}))
+	assert.Equal(t, []uint16{20}, removedVLANs([]uint16{20}, []uint16{}))
+}
+
+// Test filterDHCPv4Leases
+func TestFilterDHCPv4Leases(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	content := `1600000100 00:16:3e:aa:bb:cc 10.0.0.10 c1 01:00:16:3e:aa:bb:cc
+1599999900 00:16:3e:aa:bb:dd 10.0.0.11 c2 *
+0 00:16:3e:aa:bb:ee 10.0.0.12 * *
+1600000100 1234 fd42::10 c3 00:01:00:01:26:aa:bb:cc:00:16:3e:aa:bb:cc
+duid 00:01:00:01:26:aa:bb:cc:00:16:3e:aa:bb:cc
+`
+
+	leases := filterDHCPv4Leases(parseDHCPLeases(content, now))
+	assert.Len(t, leases, 2)
+
+	// Test active lease.
+	assert.Equal(t, "10.0.0.10", leases[0].Address)
+	assert.Equal(t, "00:16:3e:aa:bb:cc", leases[0].Hwaddr)
+	assert.Equal(t, "c1", leases[0].Hostname)
+	assert.Equal(t, "dynamic", leases[0].Type)
+	assert.Equal(t, time.Unix(1600000100, 0), leases[0].Expiry)
+
+	// Test lease that never expires and has no hostname.
+	assert.Equal(t, "10.0.0.12", leases[1].Address)
+	assert.Equal(t, "", leases[1].Hostname)
+	assert.True(t, leases[1].Expiry.IsZero())
+}
+
+// Test parseDHCPLeases
+func TestParseDHCPLeases(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	content := `1600000100 00:16:3e:aa:bb:cc 10.0.0.10 c1 01:00:16:3e:aa:bb:cc
+1600000100 1234 fd42::10 c1 00:01:00:01:26:aa:bb:cc:00:16:3e:aa:bb:cc
+1599999900 5678 fd42::11 c2 00:01:00:01:26:aa:bb:cc:00:16:3e:aa:bb:dd
+duid 00:01:00:01:26:aa:bb:cc:00:16:3e:aa:bb:cc
+`
+
+	leases := parseDHCPLeases(content, now)
+	assert.Len(t, leases, 2)
+	assert.Equal(t, "10.0.0.10", leases[0].Address)
+
+	// Test IPv6 lease takes its MAC from the client DUID.
+	assert.Equal(t, "fd42::10", leases[1].Address)
+	assert.Equal(t, "00:16:3e:aa:bb:cc", leases[1].Hwaddr)
+}
+
+// Test filterDHCPLeases
+func TestFilterDHCPLeases(t *testing.T) {
+	content := `1600000100 00:16:3e:aa:bb:cc 10.0.0.10 c1 01:00:16:3e:aa:bb:cc
+1600000100 00:16:3e:aa:bb:dd 10.0.0.11 c2 *
+1600000100 1234 fd42::10 c1 00:01:00:01:26:aa:bb:cc:00:16:3e:aa:bb:cc
+1600000100 5678 fd42::11 c2 00:01:00:01:26:aa:bb:cc:00:16:3e:aa:bb:dd
+duid 00:01:00:01:26:aa:bb:cc:00:16:3e:aa:bb:cc
+`
+
+	// Test only the leases of the supplied MACs and the DUID line are kept.
+	assert.Equal(t, `1600000100 00:16:3e:aa:bb:cc 10.0.0.10 c1 01:00:16:3e:aa:bb:cc
+1600000100 1234 fd42::10 c1 00:01:00:01:26:aa:bb:cc:00:16:3e:aa:bb:cc
+duid 00:01:00:01:26:aa:bb:cc:00:16:3e:aa:bb:cc
+`, filterDHCPLeases(content, []string{"00:16:3e:aa:bb:cc"}))
+
+	assert.Equal(t, "", filterDHCPLeases("1600000100 00:16:3e:aa:bb:dd 10.0.0.11 c2 *\n", []string{}))
+}
+
+// Test sortIPs
+func TestSortIPs(t *testing.T) {
+	ips := sortIPs([]net.IP{
+		net.ParseIP("fd42::10"),
+		net.ParseIP("10.0.0.20"),
+		net.ParseIP("10.0.0.10"),
+		net.ParseIP("10.0.0.20"),
+	})
+
+	assert.Equal(t, []string{"10.0.0.10", "10.0.0.20", "fd42::10"}, []string{ips[0].String(), ips[1].String(), ips[2].String()})
+	assert.Len(t, ips, 3)
+}
+
+// Test editDistance
+func TestEditDistance(t *testing.T) {
+	assert.Equal(t, 0, editDistance("ipv4.dhcp", "ipv4.dhcp"))
+	assert.Equal(t, 1, editDistance("ipv4.dhc", "ipv4.dhcp"))
+	assert.Equal(t, 2, editDistance("ipv4.dchp", "ipv4.dhcp"))
+	assert.Equal(t, 3, editDistance("", "abc"))
+	assert.Equal(t, 3, editDistance("kitten", "sitting"))
+}
+
+// Test suggestKey
+func TestSuggestKey(t *testing.T) {
+	knownKeys := []string{"ipv4.dhcp", "ipv6.dhcp", "ipv4.nat", "bridge.mtu", "dns.mode"}
+
+	assert.Equal(t, "ipv4.dhcp", suggestKey("ipv4.dchp", knownKeys))
+	assert.Equal(t, "bridge.mtu", suggestKey("bridge.mt", knownKeys))
+
+	// Test ties prefer the alphabetically first key.
+	assert.Equal(t, "ipv4.dhcp", suggestKey("ipv5.dhcp", knownKeys))
+
+	// Test unrelated keys get no suggestion.
+	assert.Equal(t, "", suggestKey("foo.bar", knownKeys))
+	assert.Equal(t, "", suggestKey("ipv4.dhcp.ranges.extra", knownKeys))
+}
+
+// Test findExclusiveKeysConflict
+func TestFindExclusiveKeysConflict(t *testing.T) {
+	n := &bridge{}
+	rules, err := n.rules(map[string]string{})
+	require.NoError(t, err)
+
+	pairs := n.exclusiveKeys(rules)
+
+	// Test keys that don't conflict.
+	_, found := findExclusiveKeysConflict(map[string]string{"bridge.vlan": "10"}, rules, pairs)
+	assert.False(t, found)
+
+	_, found = findExclusiveKeysConflict(map[string]string{"bridge.vlan": "10", "bridge.driver": "native"}, rules, pairs)
+	assert.False(t, found)
+
+	_, found = findExclusiveKeysConflict(map[string]string{"bridge.driver": "openvswitch"}, rules, pairs)
+	assert.False(t, found)
+
+	_, found = findExclusiveKeysConflict(map[string]string{"ipv4.nat.address": "192.0.2.1", "ipv4.nat": "true"}, rules, pairs)
+	assert.False(t, found)
+
+	_, found = findExclusiveKeysConflict(map[string]string{"bridge.mode": "fan", "fan.type": "ipip", "ipv4.nat": "true"}, rules, pairs)
+	assert.False(t, found)
+
+	// Test the conflicting pair is reported.
+	pair, found := findExclusiveKeysConflict(map[string]string{"bridge.vlan.tagged": "20", "bridge.driver": "openvswitch"}, rules, pairs)
+	assert.True(t, found)
+	assert.Equal(t, exclusiveKeys{"bridge.vlan.tagged", "bridge.driver=openvswitch"}, pair)
+
+	// Test unset keys use their defaults.
+	pair, found = findExclusiveKeysConflict(map[string]string{"ipv4.nat.address": "192.0.2.1"}, rules, pairs)
+	assert.True(t, found)
+	assert.Equal(t, exclusiveKeys{"ipv4.nat.address", "ipv4.nat=false"}, pair)
+
+	pair, found = findExclusiveKeysConflict(map[string]string{"fan.type": "ipip"}, rules, pairs)
+	assert.True(t, found)
+	assert.Equal(t, exclusiveKeys{"fan.type", "bridge.mode=standard"}, pair)
+
+	_, found = findExclusiveKeysConflict(map[string]string{"ipv4.firewall.rules": "input accept"}, rules, pairs)
+	assert.False(t, found)
+
+	// Test booleans only need to agree on whether they are true.
+	_, found = findExclusiveKeysConflict(map[string]string{"ipv4.firewall.rules": "input accept", "ipv4.firewall": "0"}, rules, pairs)
+	assert.True(t, found)
+
+	// Test the keys that depend on the bridge mode.
+	pair, found = findExclusiveKeysConflict(map[string]string{"bridge.mode": "fan", "ipv6.address": "fd42::1/64"}, rules, pairs)
+	assert.True(t, found)
+	assert.Equal(t, exclusiveKeys{"ipv6.address", "bridge.mode=fan"}, pair)
+
+	_, found = findExclusiveKeysConflict(map[string]string{"dns.nameservers": "192.0.2.53", "dns.mode": "none"}, rules, pairs)
+	assert.True(t, found)
+
+	// Test plain keys match any value.
+	_, found = findExclusiveKeysConflict(map[string]string{"foo": "a", "bar": "b"}, rules, []exclusiveKeys{{"foo", "bar"}})
+	assert.True(t, found)
+}
+
+// Test renumberIP
+func TestRenumberIP(t *testing.T) {
+	_, oldSubnet, _ := net.ParseCIDR("10.0.0.0/24")
+	_, newSubnet, _ := net.ParseCIDR("192.168.5.0/24")
+	_, smallSubnet, _ := net.ParseCIDR("192.168.6.0/28")
+
+	ip, err := renumberIP(net.ParseIP("10.0.0.42"), oldSubnet, newSubnet)
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.5.42", ip.String())
+
+	_, err = renumberIP(net.ParseIP("10.0.0.42"), oldSubnet, smallSubnet)
+	assert.Error(t, err)
+
+	_, err = renumberIP(net.ParseIP("10.0.1.42"), oldSubnet, newSubnet)
+	assert.Error(t, err)
+
+	_, oldSubnet, _ = net.ParseCIDR("fd42::/64")
+	_, newSubnet, _ = net.ParseCIDR("fd43:1::/64")
+	ip, err = renumberIP(net.ParseIP("fd42::1:5"), oldSubnet, newSubnet)
+	assert.NoError(t, err)
+	assert.Equal(t, "fd43:1::1:5", ip.String())
+}
+
+// Test scaleDHCPRanges
+func TestScaleDHCPRanges(t *testing.T) {
+	_, oldSubnet, _ := net.ParseCIDR("10.0.0.0/24")
+	_, newSubnet, _ := net.ParseCIDR("10.1.0.0/16")
+
+	dhcpRanges := []DHCPRange{
+		{Start: net.ParseIP("10.0.0.0").To4(), End: net.ParseIP("10.0.0.127").To4()},
+		{Start: net.ParseIP("10.0.0.200").To4(), End: net.ParseIP("10.0.0.255").To4()},
+	}
+
+	scaled := scaleDHCPRanges(dhcpRanges, oldSubnet, newSubnet)
+	require.Len(t, scaled, 2)
+
+	// Test positions are scaled and the network and broadcast addresses are excluded.
+	assert.Equal(t, "10.1.0.1", scaled[0].Start.String())
+	assert.Equal(t, "10.1.127.255", scaled[0].End.String())
+	assert.Equal(t, "10.1.200.0", scaled[1].Start.String())
+	assert.Equal(t, "10.1.255.254", scaled[1].End.String())
+}
+
+// Test findAddressConflicts
+func TestFindAddressConflicts(t *testing.T) {
+	static := AddressClaim{IP: net.ParseIP("10.0.0.10"), Source: AddressClaimStatic, MAC: "00:16:3e:00:00:01", Instance: "c1"}
+	ownLease := AddressClaim{IP: net.ParseIP("10.0.0.10"), Source: AddressClaimLease, MAC: "00:16:3e:00:00:01", Instance: "c1"}
+	otherLease := AddressClaim{IP: net.ParseIP("10.0.0.20"), Source: AddressClaimLease, MAC: "00:16:3e:00:00:02", Instance: "c2"}
+	unknownLease := AddressClaim{IP: net.ParseIP("10.0.0.20"), Source: AddressClaimLease, MAC: "00:16:3e:00:00:03"}
+	reservation := AddressClaim{IP: net.ParseIP("10.0.0.30"), Source: AddressClaimReservation}
+	reservedStatic := AddressClaim{IP: net.ParseIP("10.0.0.30"), Source: AddressClaimStatic, MAC: "00:16:3e:00:00:04", Instance: "c4"}
+	v6Static := AddressClaim{IP: net.ParseIP("fd42::10"), Source: AddressClaimStatic, MAC: "00:16:3e:00:00:01", Instance: "c1"}
+	v6Lease := AddressClaim{IP: net.ParseIP("fd42::10"), Source: AddressClaimLease, MAC: "00:16:3e:00:00:05", Instance: "c5"}
+
+	// Test a static lease and the dynamic lease of the same client don't conflict.
+	assert.Empty(t, findAddressConflicts([]AddressClaim{static, ownLease}))
+
+	// Test conflicts are found for both families and ordered by IP.
+	conflicts := findAddressConflicts([]AddressClaim{v6Lease, static, ownLease, otherLease, unknownLease, reservedStatic, reservation, v6Static})
+	require.Len(t, conflicts, 3)
+
+	assert.Equal(t, "10.0.0.20", conflicts[0].IP.String())
+	assert.Equal(t, []AddressClaim{otherLease, unknownLease}, conflicts[0].Claims)
+
+	assert.Equal(t, "10.0.0.30", conflicts[1].IP.String())
+	assert.Equal(t, []AddressClaim{reservation, reservedStatic}, conflicts[1].Claims)
+
+	assert.Equal(t, "fd42::10", conflicts[2].IP.String())
+	assert.Equal(t, []AddressClaim{v6Lease, v6Static}, conflicts[2].Claims)
+}
+
+func TestIsPrivateSubnet(t *testing.T) {
+	tests := []struct {
+		cidr    string
+		private bool
+	}{
+		{"10.0.0.0/24", true},
+		{"172.16.5.0/24", true},
+		{"172.32.0.0/24", false},
+		{"192.168.0.0/16", true},
+		{"192.0.0.0/8", false},
+		{"203.0.113.0/24", false},
+		{"fd42::/64", true},
+		{"2001:db8::/64", false},
+	}
+
+	for _, test := range tests {
+		_, subnet, err := net.ParseCIDR(test.cidr)
+		require.NoError(t, err)
+		assert.Equal(t, test.private, isPrivateSubnet(subnet), test.cidr)
+	}
+}
+
+func TestResolvConfHasNameservers(t *testing.T) {
+	assert.True(t, resolvConfHasNameservers("# comment\nnameserver 127.0.0.53\noptions edns0\n"))
+	assert.False(t, resolvConfHasNameservers("# comment\nsearch lxd\n"))
+	assert.False(t, resolvConfHasNameservers("nameserver\n"))
+	assert.False(t, resolvConfHasNameservers(""))
+}
+
+func TestAllocatedAddresses(t *testing.T) {
+	config := map[string]string{"ipv4.address": "10.0.0.1/24", "ipv6.address": "fd42::1/64"}
+	claims := []AddressClaim{
+		{IP: net.ParseIP("fd42::10"), Source: AddressClaimStatic, MAC: "00:16:3e:00:00:02", Instance: "c2"},
+		{IP: net.ParseIP("10.0.0.50"), Source: AddressClaimLease, MAC: "00:16:3e:00:00:01", Instance: "c1"},
+		{IP: net.ParseIP("10.0.0.20"), Source: AddressClaimReservation},
+		{IP: net.ParseIP("10.0.0.50"), Source: AddressClaimStatic, MAC: "00:16:3e:00:00:03"},
+	}
+
+	expected := []AllocatedAddress{
+		{IP: net.ParseIP("10.0.0.0").To4(), Family: 4, Source: AllocatedAddressReserved},
+		{IP: net.ParseIP("10.0.0.1").To4(), Family: 4, Source: AllocatedAddressGateway},
+		{IP: net.ParseIP("10.0.0.20").To4(), Family: 4, Source: AllocatedAddressReserved},
+		{IP: net.ParseIP("10.0.0.50").To4(), Family: 4, Source: AllocatedAddressDynamic, MAC: "00:16:3e:00:00:01", Instance: "c1"},
+		{IP: net.ParseIP("10.0.0.50").To4(), Family: 4, Source: AllocatedAddressStatic, MAC: "00:16:3e:00:00:03"},
+		{IP: net.ParseIP("10.0.0.255").To4(), Family: 4, Source: AllocatedAddressReserved},
+		{IP: net.ParseIP("fd42::"), Family: 6, Source: AllocatedAddressReserved},
+		{IP: net.ParseIP("fd42::1"), Family: 6, Source: AllocatedAddressGateway},
+		{IP: net.ParseIP("fd42::10"), Family: 6, Source: AllocatedAddressStatic, MAC: "00:16:3e:00:00:02", Instance: "c2"},
+	}
+
+	assert.Equal(t, expected, allocatedAddresses(config, claims))
+
+	// Test point-to-point subnets have no broadcast address and unset addresses are skipped.
+	addresses := allocatedAddresses(map[string]string{"ipv4.address": "10.0.0.0/31", "ipv6.address": "none"}, nil)
+	assert.Equal(t, []AllocatedAddress{
+		{IP: net.ParseIP("10.0.0.0").To4(), Family: 4, Source: AllocatedAddressGateway},
+		{IP: net.ParseIP("10.0.0.0").To4(), Family: 4, Source: AllocatedAddressReserved},
+	}, addresses)
+}
+
+func TestMaxTunnelMTU(t *testing.T) {
+	underlay := func(t tunnel) (uint64, bool) {
+		switch t.Interface {
+		case "jumbo0":
+			return 9000, true
+		case "eth0":
+			return 1500, true
+		}
+
+		return 0, false
+	}
+
+	tunnels := []tunnel{
+		{Name: "vx4", Protocol: "vxlan", Local: "10.0.0.1", Remote: "10.0.0.2", ID: "1", Interface: "eth0"},
+		{Name: "vx6", Protocol: "vxlan", Group: "ff05::1", ID: "2", Interface: "jumbo0"},
+		{Name: "gre6", Protocol: "gre", Local: "fd42::1", Remote: "fd42::2", Interface: "eth0"},
+		{Name: "unknown", Protocol: "gre", Local: "fd42::1", Remote: "fd42::3"},
+	}
+
+	assert.Equal(t, uint64(50), tunnelOverhead(tunnels[0]))
+	assert.Equal(t, uint64(70), tunnelOverhead(tunnels[1]))
+	assert.Equal(t, uint64(58), tunnelOverhead(tunnels[2]))
+	assert.Equal(t, uint64(38), tunnelOverhead(tunnel{Protocol: "gre", Local: "10.0.0.1", Remote: "10.0.0.2"}))
+
+	mtu, limit, found := maxTunnelMTU(tunnels, underlay)
+	assert.True(t, found)
+	assert.Equal(t, uint64(1442), mtu)
+	assert.Equal(t, "gre6", limit.Name)
+
+	mtu, limit, found = maxTunnelMTU(tunnels[1:2], underlay)
+	assert.True(t, found)
+	assert.Equal(t, uint64(8930), mtu)
+	assert.Equal(t, "vx6", limit.Name)
+
+	// Test tunnels with an unknown underlay MTU are skipped.
+	_, _, found = maxTunnelMTU(tunnels[3:], underlay)
+	assert.False(t, found)
+
+	_, _, found = maxTunnelMTU(nil, underlay)
+	assert.False(t, found)
+}
+
+func TestSwapPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lxd-network-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path1 := filepath.Join(dir, "one")
+	path2 := filepath.Join(dir, "two")
+	require.NoError(t, ioutil.WriteFile(path1, []byte("one"), 0644))
+	require.NoError(t, ioutil.WriteFile(path2, []byte("two"), 0644))
+
+	require.NoError(t, swapPaths(path1, path2))
+
+	content, err := ioutil.ReadFile(path1)
+	require.NoError(t, err)
+	assert.Equal(t, "two", string(content))
+
+	content, err = ioutil.ReadFile(path2)
+	require.NoError(t, err)
+	assert.Equal(t, "one", string(content))
+
+	// Test a missing path is treated as empty.
+	require.NoError(t, os.Remove(path2))
+	require.NoError(t, swapPaths(path1, path2))
+	assert.False(t, shared.PathExists(path1))
+	assert.True(t, shared.PathExists(path2))
+
+	require.NoError(t, swapPaths(path1, filepath.Join(dir, "three")))
+	assert.False(t, shared.PathExists(path1))
+}
+
+// Test importedConfig
+func TestImportedConfig(t *testing.T) {
+	addrs := []net.Addr{
+		&net.IPNet{IP: net.ParseIP("fe80::1"), Mask: net.CIDRMask(64, 128)},
+		&net.IPNet{IP: net.ParseIP("10.0.0.1"), Mask: net.CIDRMask(24, 32)},
+		&net.IPNet{IP: net.ParseIP("10.0.1.1"), Mask: net.CIDRMask(24, 32)},
+		&net.IPNet{IP: net.ParseIP("2001:db8::1"), Mask: net.CIDRMask(64, 128)},
+	}
+
+	assert.Equal(t, map[string]string{
+		"bridge.mtu":        "9000",
+		"ipv4.address":      "10.0.0.1/24",
+		"ipv6.address":      "2001:db8::1/64",
+		"volatile.imported": "true",
+	}, importedConfig("bridge", "br0", 9000, addrs))
+
+	assert.Equal(t, map[string]string{
+		"bridge.mtu":        "1500",
+		"ipv4.address":      "none",
+		"ipv6.address":      "none",
+		"volatile.imported": "true",
+	}, importedConfig("bridge", "br0", 1500, addrs[:1]))
+
+	assert.Equal(t, map[string]string{
+		"parent":            "eth0",
+		"volatile.imported": "true",
+	}, importedConfig("macvlan", "eth0", 1500, addrs))
+}
+
+// Test mergeConfig and configChanges
+func TestMergeConfig(t *testing.T) {
+	config := map[string]string{"ipv4.address": "10.0.0.1/24", "user.foo": "bar", "volatile.foo": "bar"}
+	newConfig := mergeConfig(config, map[string]string{"ipv4.nat": "true", "user.foo": "", "volatile.foo": ""})
+
+	assert.Equal(t, map[string]string{"ipv4.address": "10.0.0.1/24", "ipv4.nat": "true", "volatile.foo": ""}, newConfig)
+	assert.Equal(t, map[string]string{"ipv4.address": "10.0.0.1/24", "user.foo": "bar", "volatile.foo": "bar"}, config)
+
+	assert.Equal(t, map[string]string{"ipv4.nat": "true", "user.foo": "", "volatile.foo": ""}, configChanges(config, newConfig))
+	assert.Equal(t, map[string]string{"ipv4.nat": "", "user.foo": "bar", "volatile.foo": "bar"}, configChanges(newConfig, config))
+}
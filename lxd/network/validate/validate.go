@@ -0,0 +1,307 @@
+package validate
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/lxc/lxd/shared"
+)
+
+// Optional wraps the supplied validators so that an empty value is considered valid. Non-empty values must pass
+// all of the validators.
+func Optional(validators ...func(value string) error) func(value string) error {
+	return func(value string) error {
+		if value == "" {
+			return nil
+		}
+
+		for _, validator := range validators {
+			err := validator(value)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// Required wraps the supplied validators so that an empty value is rejected. The value must pass all of the
+// validators.
+func Required(validators ...func(value string) error) func(value string) error {
+	return func(value string) error {
+		if value == "" {
+			return fmt.Errorf("Required value")
+		}
+
+		for _, validator := range validators {
+			err := validator(value)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// Or returns a validator that passes if any of the supplied validators pass. If none pass then the error from the
+// last validator is returned.
+func Or(validators ...func(value string) error) func(value string) error {
+	return func(value string) error {
+		var err error
+		for _, validator := range validators {
+			err = validator(value)
+			if err == nil {
+				return nil
+			}
+		}
+
+		return err
+	}
+}
+
+// IsListOf returns a validator for a comma separated list where each (whitespace trimmed) item must pass the
+// supplied validator.
+func IsListOf(validator func(value string) error) func(value string) error {
+	return func(value string) error {
+		for _, item := range strings.Split(value, ",") {
+			err := validator(strings.TrimSpace(item))
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// IsOneOf returns a validator that checks the value is one of the supplied valid values.
+func IsOneOf(valid ...string) func(value string) error {
+	return func(value string) error {
+		if !shared.StringInSlice(value, valid) {
+			return fmt.Errorf("Invalid value: %s (not one of %s)", value, valid)
+		}
+
+		return nil
+	}
+}
+
+// IsAny accepts any value.
+func IsAny(value string) error {
+	return nil
+}
+
+// IsBool validates a boolean value.
+func IsBool(value string) error {
+	if !shared.StringInSlice(strings.ToLower(value), []string{"true", "false", "yes", "no", "1", "0", "on", "off"}) {
+		return fmt.Errorf("Invalid value for a boolean: %s", value)
+	}
+
+	return nil
+}
+
+// IsInt64 validates a signed 64-bit integer.
+func IsInt64(value string) error {
+	_, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("Invalid value for an integer: %s", value)
+	}
+
+	return nil
+}
+
+// IsUint8 validates an unsigned 8-bit integer.
+func IsUint8(value string) error {
+	_, err := strconv.ParseUint(value, 10, 8)
+	if err != nil {
+		return fmt.Errorf("Invalid value for an integer: %s. Must be between 0 and 255", value)
+	}
+
+	return nil
+}
+
+// IsUint32 validates an unsigned 32-bit integer.
+func IsUint32(value string) error {
+	_, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return fmt.Errorf("Invalid value for uint32: %s: %v", value, err)
+	}
+
+	return nil
+}
+
+// IsDNSDomain validates a domain name following the RFC 1035 rules. Each dot separated label must be between 1
+// and 63 characters long, only contain letters, digits and hyphens and not start or end with a hyphen. The whole
+// name must be at most 253 characters long. A trailing dot isn't allowed.
+func IsDNSDomain(value string) error {
+	if len(value) > 253 {
+		return fmt.Errorf("Domain name %q is longer than 253 characters", value)
+	}
+
+	if strings.HasSuffix(value, ".") {
+		return fmt.Errorf("Domain name %q must not end with a dot", value)
+	}
+
+	for _, label := range strings.Split(value, ".") {
+		if len(label) < 1 || len(label) > 63 {
+			return fmt.Errorf("Invalid label %q in domain name %q: Must be between 1 and 63 characters long", label, value)
+		}
+
+		if strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return fmt.Errorf("Invalid label %q in domain name %q: Must not start or end with a hyphen", label, value)
+		}
+
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z') && !(r >= 'A' && r <= 'Z') && !(r >= '0' && r <= '9') && r != '-' {
+				return fmt.Errorf("Invalid label %q in domain name %q: Invalid character %q", label, value, r)
+			}
+		}
+	}
+
+	return nil
+}
+
+// IsNetworkAddress validates an IP (v4 or v6) address.
+func IsNetworkAddress(value string) error {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return fmt.Errorf("Not an IP address: %s", value)
+	}
+
+	return nil
+}
+
+// IsNetworkAddressV4 validates an IPv4 address.
+func IsNetworkAddressV4(value string) error {
+	ip := net.ParseIP(value)
+	if ip == nil || ip.To4() == nil {
+		return fmt.Errorf("Not an IPv4 address: %s", value)
+	}
+
+	return nil
+}
+
+// IsNetworkAddressV6 validates an IPv6 address.
+func IsNetworkAddressV6(value string) error {
+	ip := net.ParseIP(value)
+	if ip == nil || ip.To4() != nil {
+		return fmt.Errorf("Not an IPv6 address: %s", value)
+	}
+
+	return nil
+}
+
+// IsNetworkAddressCIDR validates a usable IP (v4 or v6) address in CIDR format, i.e. not the subnet address.
+func IsNetworkAddressCIDR(value string) error {
+	ip, subnet, err := net.ParseCIDR(value)
+	if err != nil {
+		return err
+	}
+
+	if ip.Equal(subnet.IP) {
+		return fmt.Errorf("Not a usable IP address: %s", value)
+	}
+
+	return nil
+}
+
+// IsNetworkAddressCIDRV4 validates a usable IPv4 address in CIDR format.
+func IsNetworkAddressCIDRV4(value string) error {
+	ip, subnet, err := net.ParseCIDR(value)
+	if err != nil {
+		return err
+	}
+
+	if ip.To4() == nil {
+		return fmt.Errorf("Not an IPv4 address: %s", value)
+	}
+
+	if ip.Equal(subnet.IP) {
+		return fmt.Errorf("Not a usable IPv4 address: %s", value)
+	}
+
+	return nil
+}
+
+// IsNetworkAddressCIDRV6 validates a usable IPv6 address in CIDR format.
+func IsNetworkAddressCIDRV6(value string) error {
+	ip, subnet, err := net.ParseCIDR(value)
+	if err != nil {
+		return err
+	}
+
+	if ip.To4() != nil {
+		return fmt.Errorf("Not an IPv6 address: %s", value)
+	}
+
+	if ip.Equal(subnet.IP) {
+		return fmt.Errorf("Not a usable IPv6 address: %s", value)
+	}
+
+	return nil
+}
+
+// IsNetworkV4 validates an IPv4 subnet in CIDR format, i.e. the subnet address and prefix length.
+func IsNetworkV4(value string) error {
+	ip, subnet, err := net.ParseCIDR(value)
+	if err != nil {
+		return err
+	}
+
+	if ip.To4() == nil {
+		return fmt.Errorf("Not an IPv4 network: %s", value)
+	}
+
+	if !ip.Equal(subnet.IP) {
+		return fmt.Errorf("Not an IPv4 network address: %s", value)
+	}
+
+	return nil
+}
+
+// IsNetworkV6 validates an IPv6 subnet in CIDR format, i.e. the subnet address and prefix length.
+func IsNetworkV6(value string) error {
+	ip, subnet, err := net.ParseCIDR(value)
+	if err != nil {
+		return err
+	}
+
+	if ip.To4() != nil {
+		return fmt.Errorf("Not an IPv6 network: %s", value)
+	}
+
+	if !ip.Equal(subnet.IP) {
+		return fmt.Errorf("Not an IPv6 network address: %s", value)
+	}
+
+	return nil
+}
+
+// IsNetworkVLAN validates a VLAN ID (between 0 and 4094, where 0 means no VLAN).
+func IsNetworkVLAN(value string) error {
+	vlanID, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("Invalid VLAN ID: %s", value)
+	}
+
+	if vlanID < 0 || vlanID > 4094 {
+		return fmt.Errorf("Out of range (0-4094) VLAN ID: %s", value)
+	}
+
+	return nil
+}
+
+// IsNetworkVLANList validates a comma delimited list of VLAN IDs.
+func IsNetworkVLANList(value string) error {
+	for _, vlanID := range strings.Split(value, ",") {
+		err := IsNetworkVLAN(strings.TrimSpace(vlanID))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
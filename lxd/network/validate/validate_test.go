@@ -0,0 +1,101 @@
+package validate
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test Optional and Required
+func TestOptionalRequired(t *testing.T) {
+	assert.NoError(t, Optional(IsBool)(""))
+	assert.NoError(t, Optional(IsBool)("true"))
+	assert.Error(t, Optional(IsBool)("maybe"))
+
+	assert.Error(t, Required(IsBool)(""))
+	assert.NoError(t, Required(IsBool)("off"))
+
+	// Test all validators must pass.
+	assert.Error(t, Optional(IsInt64, IsUint8)("-1"))
+	assert.NoError(t, Optional(IsInt64, IsUint8)("255"))
+}
+
+// Test Or and IsOneOf
+func TestOrIsOneOf(t *testing.T) {
+	validator := Or(IsOneOf("none", "auto"), IsNetworkAddressCIDRV4)
+
+	assert.NoError(t, validator("auto"))
+	assert.NoError(t, validator("10.0.0.1/24"))
+	assert.EqualError(t, validator("10.0.0.0/24"), "Not a usable IPv4 address: 10.0.0.0/24")
+	assert.EqualError(t, IsOneOf("standard", "fan")("bridge"), "Invalid value: bridge (not one of [standard fan])")
+}
+
+// Test IsListOf
+func TestIsListOf(t *testing.T) {
+	validator := IsListOf(IsNetworkV6)
+
+	assert.NoError(t, validator("fd42::/64, fd43::/64"))
+	assert.Error(t, validator("fd42::/64,fd43::1/64"))
+	assert.Error(t, validator("fd42::/64,10.0.0.0/8"))
+}
+
+// Test network address validators
+func TestNetworkAddresses(t *testing.T) {
+	assert.NoError(t, IsNetworkAddress("10.0.0.1"))
+	assert.NoError(t, IsNetworkAddress("fd42::1"))
+	assert.Error(t, IsNetworkAddress(""))
+
+	assert.NoError(t, IsNetworkAddressV4("10.0.0.1"))
+	assert.Error(t, IsNetworkAddressV4("fd42::1"))
+	assert.NoError(t, IsNetworkAddressV6("fd42::1"))
+	assert.Error(t, IsNetworkAddressV6("10.0.0.1"))
+
+	assert.NoError(t, IsNetworkAddressCIDR("10.0.0.1/24"))
+	assert.NoError(t, IsNetworkAddressCIDR("fd42::1/64"))
+	assert.Error(t, IsNetworkAddressCIDR("fd42::/64"))
+	assert.Error(t, IsNetworkAddressCIDR("10.0.0.1"))
+	assert.Error(t, IsNetworkAddressCIDRV6("10.0.0.1/24"))
+
+	assert.NoError(t, IsNetworkV4("10.0.0.0/24"))
+	assert.Error(t, IsNetworkV4("10.0.0.1/24"))
+	assert.Error(t, IsNetworkV4("fd42::/64"))
+}
+
+// Test IsDNSDomain
+func TestIsDNSDomain(t *testing.T) {
+	assert.NoError(t, IsDNSDomain("lxd"))
+	assert.NoError(t, IsDNSDomain("example-1.lxd.Example.com"))
+	assert.NoError(t, IsDNSDomain(strings.Repeat("a", 63)+".com"))
+
+	assert.EqualError(t, IsDNSDomain("my domain"), `Invalid label "my domain" in domain name "my domain": Invalid character ' '`)
+	assert.EqualError(t, IsDNSDomain("example..com"), `Invalid label "" in domain name "example..com": Must be between 1 and 63 characters long`)
+	assert.EqualError(t, IsDNSDomain("-example.com"), `Invalid label "-example" in domain name "-example.com": Must not start or end with a hyphen`)
+	assert.EqualError(t, IsDNSDomain("example.com."), `Domain name "example.com." must not end with a dot`)
+	assert.Error(t, IsDNSDomain(strings.Repeat("a", 64)+".com"))
+	assert.Error(t, IsDNSDomain(strings.Repeat("a.", 127)+"a"))
+	assert.Error(t, IsDNSDomain(""))
+
+	// Test lists of search domains.
+	assert.NoError(t, IsListOf(IsDNSDomain)("lxd, example.com"))
+	assert.Error(t, IsListOf(IsDNSDomain)("lxd,exa_mple.com"))
+}
+
+// Test IsNetworkVLAN
+func TestIsNetworkVLAN(t *testing.T) {
+	assert.NoError(t, IsNetworkVLAN("0"))
+	assert.NoError(t, IsNetworkVLAN("4094"))
+
+	assert.EqualError(t, IsNetworkVLAN("-1"), "Out of range (0-4094) VLAN ID: -1")
+	assert.EqualError(t, IsNetworkVLAN("4095"), "Out of range (0-4094) VLAN ID: 4095")
+	assert.EqualError(t, IsNetworkVLAN("foo"), "Invalid VLAN ID: foo")
+}
+
+// Test IsNetworkVLANList
+func TestIsNetworkVLANList(t *testing.T) {
+	assert.NoError(t, IsNetworkVLANList("10"))
+	assert.NoError(t, IsNetworkVLANList("10, 20,30"))
+
+	assert.EqualError(t, IsNetworkVLANList("10,4095"), "Out of range (0-4094) VLAN ID: 4095")
+	assert.EqualError(t, IsNetworkVLANList("10,,20"), "Invalid VLAN ID: ")
+}
@@ -3,7 +3,6 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
@@ -73,6 +72,25 @@ func networksGet(d *Daemon, r *http.Request) response.Response {
 		return response.InternalError(err)
 	}
 
+	// Restrict the list to managed networks carrying the requested label.
+	label := r.FormValue("label")
+	if label != "" {
+		fields := strings.SplitN(label, "=", 2)
+		if len(fields) != 2 || fields[0] == "" {
+			return response.BadRequest(fmt.Errorf("Invalid label filter %q, expected KEY=VALUE", label))
+		}
+
+		networks, err := network.LoadByLabel(d.State(), fields[0], fields[1])
+		if err != nil {
+			return response.SmartError(err)
+		}
+
+		ifs = make([]string, 0, len(networks))
+		for _, n := range networks {
+			ifs = append(ifs, n.Name())
+		}
+	}
+
 	resultString := []string{}
 	resultMap := []api.Network{}
 	for _, iface := range ifs {
@@ -140,6 +158,20 @@ func networksPost(d *Daemon, r *http.Request) response.Response {
 	url := fmt.Sprintf("/%s/networks/%s", version.APIVersion, req.Name)
 	resp := response.SyncResponseLocation(true, nil, url)
 
+	if req.Source != "" {
+		// Bring an existing host interface under LXD management rather than creating a new one.
+		if len(req.Config) > 0 {
+			return response.BadRequest(fmt.Errorf("Config can't be specified when importing an existing interface"))
+		}
+
+		_, err = network.Import(d.State(), req.Name, req.Type, req.Source)
+		if err != nil {
+			return response.SmartError(err)
+		}
+
+		return resp
+	}
+
 	if isClusterNotification(r) {
 		// This is an internal request which triggers the actual creation of the network across all nodes
 		// after they have been previously defined.
@@ -179,16 +211,17 @@ func networksPost(d *Daemon, r *http.Request) response.Response {
 	}
 
 	if count > 1 {
-		err = networksPostCluster(d, req)
+		err = networksPostCluster(d, projectParam(r), req)
 		if err != nil {
 			return response.SmartError(err)
 		}
 
-		return resp
+		networkCreatedLifecycle(d, req.Name)
+		return response.SyncResponseLocation(true, networkConfigWarnings(d, req.Name, nil), url)
 	}
 
 	// Non-clustered network creation.
-	err = network.FillConfig(&req)
+	err = network.FillConfig(d.State(), projectParam(r), &req)
 	if err != nil {
 		return response.SmartError(err)
 	}
@@ -222,10 +255,20 @@ func networksPost(d *Daemon, r *http.Request) response.Response {
 	}
 
 	revert.Success()
-	return resp
+	networkCreatedLifecycle(d, req.Name)
+	return response.SyncResponseLocation(true, networkConfigWarnings(d, req.Name, nil), url)
+}
+
+// networkCreatedLifecycle sends a network-created lifecycle event. This is only called on the node that received
+// the create request so that cluster members don't each emit their own event.
+func networkCreatedLifecycle(d *Daemon, name string) {
+	d.State().Events.SendLifecycle(project.Default, "network-created", fmt.Sprintf("/%s/networks/%s", version.APIVersion, name), map[string]interface{}{
+		"name":    name,
+		"project": project.Default,
+	})
 }
 
-func networksPostCluster(d *Daemon, req api.NetworksPost) error {
+func networksPostCluster(d *Daemon, projectName string, req api.NetworksPost) error {
 	// Check that no node-specific config key has been defined.
 	for key := range req.Config {
 		if shared.StringInSlice(key, db.NodeSpecificNetworkConfig) {
@@ -245,7 +288,7 @@ func networksPostCluster(d *Daemon, req api.NetworksPost) error {
 	}
 
 	// Add default values.
-	err = network.FillConfig(&req)
+	err = network.FillConfig(d.State(), projectName, &req)
 	if err != nil {
 		return err
 	}
@@ -355,6 +398,11 @@ func doNetworksCreate(d *Daemon, req api.NetworksPost, clusterNotification bool)
 		return err
 	}
 
+	err = network.ValidateSubnets(d.State(), n.Name(), n.Config())
+	if err != nil {
+		return err
+	}
+
 	err = n.Start()
 	if err != nil {
 		n.Delete(clusterNotification)
@@ -499,6 +547,10 @@ func doNetworkGet(d *Daemon, name string) (api.Network, error) {
 	if dbInfo != nil {
 		n.Status = dbInfo.Status
 		n.Locations = dbInfo.Locations
+
+		if n.Status == api.NetworkStatusCreated && shared.IsTrue(dbInfo.Config["volatile.maintenance"]) {
+			n.Status = api.NetworkStatusMaintenance
+		}
 	}
 
 	return n, nil
@@ -532,13 +584,18 @@ func networkDelete(d *Daemon, r *http.Request) response.Response {
 		clusterNotification = true // We just want to delete the network from the system.
 	} else {
 		// Sanity checks
-		inUse, err := n.IsUsed()
+		err = n.ValidateDelete()
+		if err != nil {
+			return response.BadRequest(err)
+		}
+
+		usedBy, err := n.UsedBy()
 		if err != nil {
 			return response.SmartError(err)
 		}
 
-		if inUse {
-			return response.BadRequest(fmt.Errorf("The network is currently in use"))
+		if len(usedBy) > 0 {
+			return response.BadRequest(fmt.Errorf("The network is currently in use by: %s", strings.Join(usedBy, ", ")))
 		}
 
 		// Notify all other nodes. If any node is down, an error will be returned.
@@ -569,27 +626,15 @@ func networkDelete(d *Daemon, r *http.Request) response.Response {
 }
 
 func networkPost(d *Daemon, r *http.Request) response.Response {
-	// FIXME: renaming a network is currently not supported in clustering
-	//        mode. The difficulty is that network.Start() depends on the
-	//        network having already been renamed in the database, which is
-	//        a chicken-and-egg problem for cluster notifications (the
-	//        serving node should typically do the database job, so the
-	//        network is not yet renamed inthe db when the notified node
-	//        runs network.Start).
-	clustered, err := cluster.Enabled(d.db)
-	if err != nil {
-		return response.SmartError(err)
-	}
-	if clustered {
-		return response.BadRequest(fmt.Errorf("Renaming a network not supported in LXD clusters"))
-	}
-
+	// The node serving the request notifies the other nodes before renaming the network in the database, so
+	// that notified nodes can still load it by its old name.
+	clusterNotification := isClusterNotification(r)
 	name := mux.Vars(r)["name"]
 	req := api.NetworkPost{}
 	state := d.State()
 
 	// Parse the request
-	err = json.NewDecoder(r.Body).Decode(&req)
+	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 		return response.BadRequest(err)
 	}
@@ -621,7 +666,7 @@ func networkPost(d *Daemon, r *http.Request) response.Response {
 	}
 
 	// Rename it
-	err = n.Rename(req.Name)
+	err = n.Rename(req.Name, clusterNotification)
 	if err != nil {
 		return response.SmartError(err)
 	}
@@ -692,7 +737,7 @@ func networkPut(d *Daemon, r *http.Request) response.Response {
 		}
 	}
 
-	return doNetworkUpdate(d, name, req, targetNode, isClusterNotification(r))
+	return doNetworkUpdate(d, name, req, targetNode, isClusterNotification(r), r.Method)
 }
 
 func networkPatch(d *Daemon, r *http.Request) response.Response {
@@ -700,8 +745,9 @@ func networkPatch(d *Daemon, r *http.Request) response.Response {
 }
 
 // doNetworkUpdate loads the current local network config, merges with the requested network config, validates
-// and applies the changes. Will also notify other cluster nodes of non-node specific config if needed.
-func doNetworkUpdate(d *Daemon, name string, req api.NetworkPut, targetNode string, clusterNotification bool) response.Response {
+// and applies the changes. Will also notify other cluster nodes of non-node specific config if needed. For a PATCH
+// request only the submitted keys are changed and a key with an empty value is removed.
+func doNetworkUpdate(d *Daemon, name string, req api.NetworkPut, targetNode string, clusterNotification bool, httpMethod string) response.Response {
 	// Load the local node-specific network.
 	n, err := network.LoadByName(d.State(), name)
 	if err != nil {
@@ -712,27 +758,84 @@ func doNetworkUpdate(d *Daemon, name string, req api.NetworkPut, targetNode stri
 		req.Config = map[string]string{}
 	}
 
-	// Merge the current node-specific network config with the submitted config to allow validation.
-	for k, v := range n.Config() {
-		_, ok := req.Config[k]
-		if !ok {
-			req.Config[k] = v
+	// A PATCH request that only changes the description doesn't need the config to be validated or applied.
+	if httpMethod == http.MethodPatch && len(req.Config) == 0 {
+		if req.Description != "" {
+			err = n.SetDescription(req.Description, clusterNotification)
+			if err != nil {
+				return response.SmartError(err)
+			}
+		}
+
+		return response.EmptySyncResponse
+	}
+
+	// Merge the current node-specific network config with the submitted config to allow validation. This isn't
+	// needed for a PATCH request as its keys are validated and merged on top of the current config.
+	if httpMethod != http.MethodPatch {
+		for k, v := range n.Config() {
+			_, ok := req.Config[k]
+			if !ok {
+				req.Config[k] = v
+			}
 		}
 	}
 
 	// Validate the merged configuration.
-	err = network.Validate(name, n.Type(), req.Config)
+	err = n.ValidateUpdate(req, targetNode)
 	if err != nil {
 		return response.BadRequest(err)
 	}
 
+	oldConfig := n.Config()
+
 	// Apply the new configuration (will also notify other cluster nodes if needed).
-	err = n.Update(req, targetNode, clusterNotification)
+	if httpMethod == http.MethodPatch {
+		err = n.Patch(req, targetNode, clusterNotification)
+	} else {
+		err = n.Update(req, targetNode, clusterNotification)
+	}
+
 	if err != nil {
 		return response.SmartError(err)
 	}
 
-	return response.EmptySyncResponse
+	changedKeys := []string{}
+	for _, change := range n.ConfigDiff(api.NetworkPut{Config: oldConfig}) {
+		changedKeys = append(changedKeys, change.Key)
+	}
+
+	return response.SyncResponse(true, networkConfigWarnings(d, name, changedKeys))
+}
+
+// networkConfigWarnings returns the response metadata listing any questionable but valid settings in the network's
+// config on this node, along with any of the changed keys that affect running instances on this node. Each warning
+// is also logged.
+func networkConfigWarnings(d *Daemon, name string, changedKeys []string) map[string]interface{} {
+	metadata := map[string]interface{}{}
+
+	n, err := network.LoadByName(d.State(), name)
+	if err != nil {
+		return metadata
+	}
+
+	warnings, err := n.ValidateWithWarnings(n.Config())
+	if err != nil {
+		warnings = []network.Warning{}
+	}
+
+	warnings = append(warnings, n.DisruptiveChangeWarnings(changedKeys)...)
+	if len(warnings) == 0 {
+		return metadata
+	}
+
+	for _, warning := range warnings {
+		logger.Warn("Network config warning", log.Ctx{"network": name, "key": warning.Key, "warning": warning.Message})
+	}
+
+	metadata["warnings"] = warnings
+
+	return metadata
 }
 
 func networkLeasesGet(d *Daemon, r *http.Request) response.Response {
@@ -817,60 +920,32 @@ func networkLeasesGet(d *Daemon, r *http.Request) response.Response {
 		}
 	}
 
-	// Local server name.
-	var serverName string
-	err = d.cluster.Transaction(func(tx *db.ClusterTx) error {
-		serverName, err = tx.GetLocalNodeName()
-		return err
-	})
+	// Get dynamic leases.
+	dhcpNetwork, err := network.LoadByName(d.State(), name)
 	if err != nil {
 		return response.SmartError(err)
 	}
 
-	// Get dynamic leases.
-	leaseFile := shared.VarPath("networks", name, "dnsmasq.leases")
-	if !shared.PathExists(leaseFile) {
-		return response.SyncResponse(true, leases)
-	}
-
-	content, err := ioutil.ReadFile(leaseFile)
+	dynamicLeases, err := dhcpNetwork.DHCPLeases()
 	if err != nil {
 		return response.SmartError(err)
 	}
 
-	for _, lease := range strings.Split(string(content), "\n") {
-		fields := strings.Fields(lease)
-		if len(fields) >= 5 {
-			// Parse the MAC.
-			mac := network.GetMACSlice(fields[1])
-			macStr := strings.Join(mac, ":")
-
-			if len(macStr) < 17 && fields[4] != "" {
-				macStr = fields[4][len(fields[4])-17:]
-			}
-
-			// Look for an existing static entry.
-			found := false
-			for _, entry := range leases {
-				if entry.Hwaddr == macStr && entry.Address == fields[2] {
-					found = true
-					break
-				}
-			}
-
-			if found {
-				continue
+	for _, lease := range dynamicLeases {
+		// Look for an existing static entry.
+		found := false
+		for _, entry := range leases {
+			if entry.Hwaddr == lease.Hwaddr && entry.Address == lease.Address {
+				found = true
+				break
 			}
+		}
 
-			// Add the lease to the list.
-			leases = append(leases, api.NetworkLease{
-				Hostname: fields[3],
-				Address:  fields[2],
-				Hwaddr:   macStr,
-				Type:     "dynamic",
-				Location: serverName,
-			})
+		if found {
+			continue
 		}
+
+		leases = append(leases, lease)
 	}
 
 	// Collect leases from other servers.
@@ -973,5 +1048,5 @@ func networkStateGet(d *Daemon, r *http.Request) response.Response {
 		return response.NotFound(fmt.Errorf("Interface '%s' not found", name))
 	}
 
-	return response.SyncResponse(true, networkGetState(*osInfo))
+	return response.SyncResponse(true, network.GetState(*osInfo))
 }
@@ -17,6 +17,7 @@ import (
 	deviceConfig "github.com/lxc/lxd/lxd/device/config"
 	"github.com/lxc/lxd/lxd/instance"
 	"github.com/lxc/lxd/lxd/instance/instancetype"
+	"github.com/lxc/lxd/lxd/network"
 	"github.com/lxc/lxd/lxd/project"
 	"github.com/lxc/lxd/lxd/response"
 	"github.com/lxc/lxd/lxd/util"
@@ -147,6 +148,8 @@ func profilesPost(d *Daemon, r *http.Request) response.Response {
 			fmt.Errorf("Error inserting %s into database: %s", req.Name, err))
 	}
 
+	network.InvalidateUsageCache(deviceConfig.NewDevices(req.Devices))
+
 	return response.SyncResponseLocation(true, nil, fmt.Sprintf("/%s/profiles/%s", version.APIVersion, req.Name))
 }
 
@@ -416,6 +419,7 @@ func profileDelete(d *Daemon, r *http.Request) response.Response {
 		return response.Forbidden(errors.New("The 'default' profile cannot be deleted"))
 	}
 
+	var devices map[string]map[string]string
 	err := d.cluster.Transaction(func(tx *db.ClusterTx) error {
 		hasProfiles, err := tx.ProjectHasProfiles(projectName)
 		if err != nil {
@@ -434,11 +438,15 @@ func profileDelete(d *Daemon, r *http.Request) response.Response {
 			return fmt.Errorf("Profile is currently in use")
 		}
 
+		devices = profile.Devices
+
 		return tx.DeleteProfile(projectName, name)
 	})
 	if err != nil {
 		return response.SmartError(err)
 	}
 
+	network.InvalidateUsageCache(deviceConfig.NewDevices(devices))
+
 	return response.EmptySyncResponse
 }
@@ -7,6 +7,7 @@ import (
 	deviceConfig "github.com/lxc/lxd/lxd/device/config"
 	"github.com/lxc/lxd/lxd/instance"
 	"github.com/lxc/lxd/lxd/instance/instancetype"
+	"github.com/lxc/lxd/lxd/network"
 	projecthelpers "github.com/lxc/lxd/lxd/project"
 	"github.com/lxc/lxd/shared"
 	"github.com/lxc/lxd/shared/api"
@@ -89,6 +90,9 @@ func doProfileUpdate(d *Daemon, project, name string, id int64, profile *api.Pro
 		return err
 	}
 
+	network.InvalidateUsageCache(deviceConfig.NewDevices(profile.Devices))
+	network.InvalidateUsageCache(deviceConfig.NewDevices(req.Devices))
+
 	// Update all the containers on this node using the profile. Must be
 	// done after db.TxCommit due to DB lock.
 	nodeName := ""
@@ -122,6 +126,8 @@ func doProfileUpdate(d *Daemon, project, name string, id int64, profile *api.Pro
 // Like doProfileUpdate but does not update the database, since it was already
 // updated by doProfileUpdate itself, called on the notifying node.
 func doProfileUpdateCluster(d *Daemon, project, name string, old api.ProfilePut) error {
+	network.InvalidateUsageCache(deviceConfig.NewDevices(old.Devices))
+
 	nodeName := ""
 	err := d.cluster.Transaction(func(tx *db.ClusterTx) error {
 		var err error
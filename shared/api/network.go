@@ -1,5 +1,9 @@
 package api
 
+import (
+	"time"
+)
+
 // NetworksPost represents the fields of a new LXD network
 //
 // API extension: network
@@ -8,6 +12,9 @@ type NetworksPost struct {
 
 	Name string `json:"name" yaml:"name"`
 	Type string `json:"type" yaml:"type"`
+
+	// API extension: network_import
+	Source string `json:"source" yaml:"source"`
 }
 
 // NetworkPost represents the fields required to rename a LXD network
@@ -39,6 +46,10 @@ const NetworkStatusErrored = "Errored"
 // NetworkStatusUnknown network is in unknown status.
 const NetworkStatusUnknown = "Unknown"
 
+// NetworkStatusMaintenance network is created but not handing out new DHCP leases.
+// API extension: network_maintenance
+const NetworkStatusMaintenance = "Maintenance"
+
 // Network represents a LXD network
 type Network struct {
 	NetworkPut `yaml:",inline"`
@@ -55,6 +66,41 @@ type Network struct {
 	Locations []string `json:"locations" yaml:"locations"`
 }
 
+// NetworkBackup represents a LXD network exported as a portable backup
+//
+// API extension: network_backup
+type NetworkBackup struct {
+	NetworksPost `yaml:",inline"`
+
+	CreatedAt time.Time `json:"created_at" yaml:"created_at"`
+}
+
+// NetworkDNSRecord represents a custom DNS record served by a network's DNS server
+//
+// API extension: network_dns_records
+type NetworkDNSRecord struct {
+	Name  string `json:"name" yaml:"name"`
+	Type  string `json:"type" yaml:"type"`
+	Value string `json:"value" yaml:"value"`
+}
+
+// NetworkStatistics represents the cumulative traffic counters of a network's host interface
+//
+// API extension: network_statistics
+type NetworkStatistics struct {
+	// Name of the host interface the counters were read from (empty if the network has no host interface)
+	Interface string `json:"interface" yaml:"interface"`
+
+	BytesReceived   int64 `json:"bytes_received" yaml:"bytes_received"`
+	BytesSent       int64 `json:"bytes_sent" yaml:"bytes_sent"`
+	PacketsReceived int64 `json:"packets_received" yaml:"packets_received"`
+	PacketsSent     int64 `json:"packets_sent" yaml:"packets_sent"`
+	ErrorsReceived  int64 `json:"errors_received" yaml:"errors_received"`
+	ErrorsSent      int64 `json:"errors_sent" yaml:"errors_sent"`
+	DroppedReceived int64 `json:"dropped_received" yaml:"dropped_received"`
+	DroppedSent     int64 `json:"dropped_sent" yaml:"dropped_sent"`
+}
+
 // Writable converts a full Network struct into a NetworkPut struct (filters read-only fields)
 func (network *Network) Writable() NetworkPut {
 	return network.NetworkPut
@@ -71,6 +117,9 @@ type NetworkLease struct {
 
 	// API extension: network_leases_location
 	Location string `json:"location" yaml:"location"`
+
+	// API extension: network_leases_expiry
+	Expiry time.Time `json:"expiry" yaml:"expiry"`
 }
 
 // NetworkState represents the network state
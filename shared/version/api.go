@@ -222,6 +222,30 @@ var APIExtensions = []string{
 	"projects_limits_disk",
 	"network_type_macvlan",
 	"network_type_sriov",
+	"network_limits",
+	"network_dhcp_reservations",
+	"network_dhcp_exclude",
+	"network_frozen",
+	"network_dhcpv6_pd",
+	"network_static_routes",
+	"network_backup",
+	"network_dns_records",
+	"network_leases_expiry",
+	"network_ipv6_dns",
+	"network_firewall_rules",
+	"network_statistics",
+	"network_maintenance",
+	"network_dhcp_strict",
+	"network_dhcp_options",
+	"network_bridge_vlan",
+	"network_labels",
+	"network_security_filtering",
+	"network_dns_nameservers",
+	"projects_network_defaults",
+	"network_import",
+	"network_config_warnings",
+	"network_allow_unknown_keys",
+	"network_protected_names",
 }
 
 // APIExtensionsCount returns the number of available API extensions.